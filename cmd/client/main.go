@@ -2,13 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"inventoryshop/pkg/inventory"
 )
 
 func main() {
@@ -16,10 +21,23 @@ func main() {
 		usage()
 		os.Exit(1)
 	}
+	inv := inventory.New(envOr("INVENTORY_URL", "http://localhost:8001"))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
 	cmd := os.Args[1]
 	switch cmd {
 	case "list-items":
-		listItems()
+		items, err := inv.ListItems(ctx)
+		printResult(items, err)
+	case "get-item":
+		if len(os.Args) < 3 {
+			fmt.Println("usage: get-item ID")
+			os.Exit(1)
+		}
+		id, _ := strconv.Atoi(os.Args[2])
+		it, err := inv.GetItem(ctx, id)
+		printResult(it, err)
 	case "create-item":
 		if len(os.Args) < 5 {
 			fmt.Println("usage: create-item NAME QUANTITY PRICE")
@@ -27,15 +45,29 @@ func main() {
 		}
 		qty, _ := strconv.Atoi(os.Args[3])
 		price, _ := strconv.ParseFloat(os.Args[4], 64)
-		createItem(os.Args[2], qty, price)
+		it, err := inv.CreateItem(ctx, inventory.NewItem{Name: os.Args[2], Quantity: qty, Price: price})
+		printResult(it, err)
+	case "adjust-item":
+		if len(os.Args) < 4 {
+			fmt.Println("usage: adjust-item ID DELTA [REASON]")
+			os.Exit(1)
+		}
+		id, _ := strconv.Atoi(os.Args[2])
+		delta, _ := strconv.Atoi(os.Args[3])
+		adj := inventory.Adjustment{Delta: delta, Reason: "manual"}
+		if len(os.Args) > 4 {
+			adj.Reason = os.Args[4]
+		}
+		res, err := inv.Adjust(ctx, id, adj)
+		printResult(res, err)
 	case "list-orders":
-		listOrders()
+		listOrders(ctx)
 	case "create-order":
 		if len(os.Args) < 3 {
 			fmt.Println("usage: create-order ITEM_ID:QTY[,ITEM_ID:QTY]")
 			os.Exit(1)
 		}
-		createOrder(os.Args[2])
+		createOrder(ctx, os.Args[2])
 	default:
 		usage()
 		os.Exit(1)
@@ -45,36 +77,58 @@ func main() {
 func usage() {
 	fmt.Println("client commands:")
 	fmt.Println("  list-items")
+	fmt.Println("  get-item ID")
 	fmt.Println("  create-item NAME QUANTITY PRICE")
+	fmt.Println("  adjust-item ID DELTA [REASON]")
 	fmt.Println("  list-orders")
 	fmt.Println("  create-order ITEM_ID:QTY[,ITEM_ID:QTY]")
+	fmt.Println("services are at $INVENTORY_URL and $ORDERS_URL, localhost by default")
+	fmt.Println("orders requests send $ORDERS_TOKEN as bearer token when set")
 }
 
-func listItems() {
-	resp, err := http.Get("http://localhost:8001/items")
-	if err != nil {
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// printResult prints an inventory response as JSON, or what went wrong
+func printResult(v interface{}, err error) {
+	switch {
+	case errors.Is(err, inventory.ErrNotFound):
+		fmt.Println("error: item not found")
+	case errors.Is(err, inventory.ErrInsufficientStock):
+		fmt.Println("error: not enough stock")
+	case err != nil:
 		fmt.Println("error:", err)
-		return
+	default:
+		b, _ := json.MarshalIndent(v, "", "  ")
+		fmt.Println(string(b))
+	}
+	if err != nil {
+		os.Exit(1)
 	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
-	fmt.Println(string(body))
 }
 
-func createItem(name string, qty int, price float64) {
-	b, _ := json.Marshal(map[string]interface{}{"name": name, "quantity": qty, "price": price})
-	resp, err := http.Post("http://localhost:8001/items", "application/json", bytes.NewReader(b))
+// ordersRequest calls the orders service, authenticating with $ORDERS_TOKEN if set
+func ordersRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	url := strings.TrimRight(envOr("ORDERS_URL", "http://localhost:8002"), "/") + path
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
-		fmt.Println("error:", err)
-		return
+		return nil, err
 	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
-	fmt.Println(string(body))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token := os.Getenv("ORDERS_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return http.DefaultClient.Do(req)
 }
 
-func listOrders() {
-	resp, err := http.Get("http://localhost:8002/orders")
+func listOrders(ctx context.Context) {
+	resp, err := ordersRequest(ctx, http.MethodGet, "/orders", nil)
 	if err != nil {
 		fmt.Println("error:", err)
 		return
@@ -84,7 +138,7 @@ func listOrders() {
 	fmt.Println(string(body))
 }
 
-func createOrder(spec string) {
+func createOrder(ctx context.Context, spec string) {
 	parts := strings.Split(spec, ",")
 	items := make([]map[string]int, 0, len(parts))
 	for _, p := range parts {
@@ -98,7 +152,7 @@ func createOrder(spec string) {
 		items = append(items, map[string]int{"id": id, "quantity": q})
 	}
 	b, _ := json.Marshal(map[string]interface{}{"items": items})
-	resp, err := http.Post("http://localhost:8002/orders", "application/json", bytes.NewReader(b))
+	resp, err := ordersRequest(ctx, http.MethodPost, "/orders", bytes.NewReader(b))
 	if err != nil {
 		fmt.Println("error:", err)
 		return
@@ -0,0 +1,202 @@
+// Package events carries the services' domain events from their outboxes to
+// subscribers: the in-process Bus, files and webhooks.
+//
+// Events are written to an outbox in the same transaction as the change they
+// describe and published later by a Relay, at least once. The outbox and the
+// webhook subscriptions live in Postgres (SQLOutbox, SQLWebhooks, see Schema)
+// or, for tests, in memory (MemoryOutbox, MemoryWebhooks).
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event is a domain event. It is published at least once; consumers should
+// skip IDs they have seen.
+//
+// IDs are taken when events are written, so transactions committing out of
+// order publish them out of order. Seq numbers events in the order they are
+// published; replay and resume by it rather than by ID.
+type Event struct {
+	ID          int64           `json:"id"`
+	Seq         int64           `json:"seq,omitempty"`
+	Source      string          `json:"source"`
+	Type        string          `json:"type"`
+	AggregateID int             `json:"aggregate_id"`
+	Payload     json.RawMessage `json:"payload"`
+	Created     int64           `json:"created_unix"`
+}
+
+// New returns an event of the given source and type with payload as JSON
+func New(source, typ string, aggregateID int, payload interface{}) Event {
+	data, _ := json.Marshal(payload)
+	return Event{Source: source, Type: typ, AggregateID: aggregateID, Payload: data, Created: time.Now().Unix()}
+}
+
+// Sink receives published events
+type Sink interface {
+	Publish(ctx context.Context, events []Event) error
+}
+
+// Bus is an in-process sink that fans events out to subscribers. A subscriber
+// that falls behind by more than its buffer is dropped, its channel closed,
+// rather than holding up the relay or silently missing events.
+type Bus struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]chan Event
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]chan Event)}
+}
+
+// Subscribe returns a channel of published events and a function that ends the
+// subscription and closes the channel
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	ch := make(chan Event, buffer)
+	b.subs[id] = ch
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[id]; ok {
+			delete(b.subs, id)
+			close(ch)
+		}
+	}
+}
+
+func (b *Bus) Publish(_ context.Context, events []Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, e := range events {
+		for id, ch := range b.subs {
+			select {
+			case ch <- e:
+			default:
+				log.Printf("event bus: subscriber %d fell behind at event %d, dropping it", id, e.ID)
+				delete(b.subs, id)
+				close(ch)
+			}
+		}
+	}
+	return nil
+}
+
+// FileSink appends events to a file as JSON lines
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+func (f *FileSink) Publish(_ context.Context, events []Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(file)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// MultiSink publishes to each of its sinks in turn, stopping at the first error
+type MultiSink []Sink
+
+func (m MultiSink) Publish(ctx context.Context, events []Event) error {
+	for _, s := range m {
+		if err := s.Publish(ctx, events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Outbox is implemented by stores that keep an outbox
+type Outbox interface {
+	RelayEvents(limit int, publish func([]Event) error) (int, error)
+}
+
+// Log is implemented by stores that keep published events for replay, by Seq
+type Log interface {
+	EventsSince(after int64, limit int) ([]Event, error)
+}
+
+// Relay moves events from the outbox to a sink. Events are published in Seq
+// order; a batch the sink fails is retried whole on the next poll.
+//
+// Live, if set, is sent each batch once it is marked published, so that what
+// its subscribers see can be replayed from the Log. Subscribers that replay
+// after subscribing then miss nothing in between.
+type Relay struct {
+	outbox   Outbox
+	sink     Sink
+	Live     Sink
+	Interval time.Duration
+	Batch    int
+}
+
+func NewRelay(outbox Outbox, sink Sink) *Relay {
+	return &Relay{outbox: outbox, sink: sink, Interval: time.Second, Batch: 100}
+}
+
+// Flush publishes everything pending in the outbox
+func (r *Relay) Flush(ctx context.Context) error {
+	for {
+		var batch []Event
+		n, err := r.outbox.RelayEvents(r.Batch, func(events []Event) error {
+			batch = events
+			return r.sink.Publish(ctx, events)
+		})
+		if err != nil {
+			return err
+		}
+		if n > 0 && r.Live != nil {
+			if err := r.Live.Publish(ctx, batch); err != nil {
+				return err
+			}
+		}
+		if n < r.Batch {
+			return nil
+		}
+	}
+}
+
+// Run flushes the outbox every Interval until ctx is done
+func (r *Relay) Run(ctx context.Context) {
+	t := time.NewTicker(r.Interval)
+	defer t.Stop()
+	for {
+		if err := r.Flush(ctx); err != nil {
+			log.Printf("event relay: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRelay(t *testing.T) {
+	var o MemoryOutbox
+	for i := 1; i <= 3; i++ {
+		o.Record(New("inventory", "StockAdjusted", 7, map[string]int{"delta": -i}))
+	}
+
+	bus := NewBus()
+	ch, cancel := bus.Subscribe(10)
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	relay := NewRelay(&o, MultiSink{bus, NewFileSink(path)})
+	relay.Batch = 2
+	if err := relay.Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		e := <-ch
+		if e.ID != int64(i) || e.Seq != int64(i) || e.Source != "inventory" || e.AggregateID != 7 {
+			t.Fatalf("unexpected event %d: %+v", i, e)
+		}
+	}
+	f, _ := os.Open(path)
+	defer f.Close()
+	lines := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); lines++ {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil || e.ID != int64(lines+1) {
+			t.Fatalf("bad line %q: %v", sc.Text(), err)
+		}
+	}
+	if lines != 3 {
+		t.Fatalf("want 3 lines in the file, got %d", lines)
+	}
+
+	// published events are not sent again but can be replayed
+	if n, _ := o.RelayEvents(10, func([]Event) error { return nil }); n != 0 {
+		t.Fatalf("expected nothing pending, got %d", n)
+	}
+	if missed, _ := o.EventsSince(1, 10); len(missed) != 2 || missed[0].Seq != 2 {
+		t.Fatalf("unexpected replay %+v", missed)
+	}
+}
+
+// sinkFunc is a Sink that calls a function
+type sinkFunc func([]Event) error
+
+func (f sinkFunc) Publish(_ context.Context, events []Event) error { return f(events) }
+
+func TestRelay_LiveAfterPublished(t *testing.T) {
+	var o MemoryOutbox
+	o.Record(New("orders", "OrderCreated", 1, nil))
+	o.Record(New("orders", "OrderStatusChanged", 1, nil))
+	relay := NewRelay(&o, MultiSink{})
+	var live []Event
+	relay.Live = sinkFunc(func(events []Event) error {
+		// a subscriber replaying now must find what it is sent live
+		replay, _ := o.EventsSince(0, 10)
+		if len(replay) != len(events) {
+			t.Errorf("live events %+v not yet replayable, have %+v", events, replay)
+		}
+		live = append(live, events...)
+		return nil
+	})
+	if err := relay.Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(live) != 2 || live[0].Seq != 1 || live[1].Seq != 2 {
+		t.Fatalf("unexpected live events %+v", live)
+	}
+}
+
+func TestRelay_FailedPublishIsRetried(t *testing.T) {
+	var o MemoryOutbox
+	o.Record(New("orders", "OrderCreated", 1, nil))
+	o.Record(New("orders", "OrderStatusChanged", 1, nil))
+	if _, err := o.RelayEvents(10, func([]Event) error { return errors.New("sink down") }); err == nil {
+		t.Fatal("expected publish error")
+	}
+	if missed, _ := o.EventsSince(0, 10); len(missed) != 0 {
+		t.Fatalf("expected nothing to replay before publishing, got %+v", missed)
+	}
+	if n, _ := o.RelayEvents(10, func([]Event) error { return nil }); n != 2 {
+		t.Fatalf("expected both events retried, got %d", n)
+	}
+}
+
+func TestBus_DropsSlowSubscribers(t *testing.T) {
+	bus := NewBus()
+	slow, _ := bus.Subscribe(1)
+	fast, cancel := bus.Subscribe(2)
+	defer cancel()
+	bus.Publish(context.Background(), []Event{{ID: 1}, {ID: 2}})
+	if e := <-slow; e.ID != 1 {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if _, ok := <-slow; ok {
+		t.Fatal("expected the slow subscriber to be dropped")
+	}
+	if a, b := <-fast, <-fast; a.ID != 1 || b.ID != 2 {
+		t.Fatalf("unexpected events %+v %+v", a, b)
+	}
+}
@@ -0,0 +1,5 @@
+module inventoryshop/pkg/events
+
+go 1.20
+
+require github.com/lib/pq v1.10.0
@@ -0,0 +1,205 @@
+package events
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Schema creates the tables SQLOutbox and SQLWebhooks use, if they do not exist
+const Schema = `
+CREATE TABLE IF NOT EXISTS outbox (
+	id BIGSERIAL PRIMARY KEY,
+	type TEXT NOT NULL,
+	aggregate_id INT NOT NULL,
+	payload JSONB NOT NULL,
+	created_unix BIGINT NOT NULL,
+	published_unix BIGINT
+);
+CREATE INDEX IF NOT EXISTS outbox_pending ON outbox (id) WHERE published_unix IS NULL;
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS published_seq BIGINT;
+CREATE UNIQUE INDEX IF NOT EXISTS outbox_published_seq ON outbox (published_seq);
+UPDATE outbox SET published_seq = id WHERE published_unix IS NOT NULL AND published_seq IS NULL;
+CREATE TABLE IF NOT EXISTS webhooks (
+	id SERIAL PRIMARY KEY,
+	url TEXT NOT NULL,
+	event_types TEXT[] NOT NULL DEFAULT '{}',
+	secret TEXT NOT NULL,
+	created_unix BIGINT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id SERIAL PRIMARY KEY,
+	webhook_id INT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+	event_id BIGINT NOT NULL,
+	event_type TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	status TEXT NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_unix BIGINT NOT NULL DEFAULT 0,
+	last_status_code INT NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_unix BIGINT NOT NULL,
+	delivered_unix BIGINT NOT NULL DEFAULT 0,
+	UNIQUE (webhook_id, event_id)
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries (next_attempt_unix) WHERE status = 'pending';
+`
+
+// RecordTx writes an event to the outbox within tx
+func RecordTx(tx *sql.Tx, e Event) error {
+	_, err := tx.Exec("INSERT INTO outbox (type, aggregate_id, payload, created_unix) VALUES ($1,$2,$3,$4)",
+		e.Type, e.AggregateID, []byte(e.Payload), e.Created)
+	return err
+}
+
+// outboxLock is the advisory lock relays take turns with
+const outboxLock = 7236001
+
+// SQLOutbox is the outbox table of one service, whose name events read from it
+// carry as their Source
+type SQLOutbox struct {
+	db     *sql.DB
+	source string
+}
+
+func NewSQLOutbox(db *sql.DB, source string) *SQLOutbox {
+	return &SQLOutbox{db: db, source: source}
+}
+
+// RelayEvents numbers up to limit unpublished events, oldest first, hands them
+// to publish and marks them published if it succeeds. Relays sharing the outbox
+// take turns, so that sequence numbers are committed in order.
+func (o *SQLOutbox) RelayEvents(limit int, publish func([]Event) error) (int, error) {
+	tx, err := o.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", outboxLock); err != nil {
+		return 0, err
+	}
+	var last int64
+	if err := tx.QueryRow("SELECT COALESCE(MAX(published_seq), 0) FROM outbox").Scan(&last); err != nil {
+		return 0, err
+	}
+	rows, err := tx.Query(`SELECT id, type, aggregate_id, payload, created_unix, 0 FROM outbox
+	WHERE published_unix IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`, limit)
+	if err != nil {
+		return 0, err
+	}
+	events, err := o.scanEvents(rows)
+	rows.Close()
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+	ids := make([]int64, len(events))
+	for i := range events {
+		events[i].Seq = last + int64(i) + 1
+		ids[i] = events[i].ID
+	}
+	if err := publish(events); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE outbox SET published_unix = $1, published_seq = $2 + array_position($3::bigint[], id)
+	WHERE id = ANY($3)`, time.Now().Unix(), last, pq.Array(ids)); err != nil {
+		return 0, err
+	}
+	return len(events), tx.Commit()
+}
+
+// EventsSince returns up to limit events published after the given Seq, in
+// the order they were published
+func (o *SQLOutbox) EventsSince(after int64, limit int) ([]Event, error) {
+	rows, err := o.db.Query(`SELECT id, type, aggregate_id, payload, created_unix, published_seq FROM outbox
+	WHERE published_seq > $1 ORDER BY published_seq LIMIT $2`, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return o.scanEvents(rows)
+}
+
+func (o *SQLOutbox) scanEvents(rows *sql.Rows) ([]Event, error) {
+	events := make([]Event, 0)
+	for rows.Next() {
+		e := Event{Source: o.source}
+		var payload []byte
+		if err := rows.Scan(&e.ID, &e.Type, &e.AggregateID, &payload, &e.Created, &e.Seq); err != nil {
+			return nil, err
+		}
+		e.Payload = payload
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// MemoryOutbox is an outbox kept in memory, for tests. The zero value is empty
+// and ready to use.
+type MemoryOutbox struct {
+	mu     sync.Mutex
+	events []Event
+	// published counts the events already relayed
+	published int
+}
+
+// Record appends an event to the outbox, numbering it
+func (o *MemoryOutbox) Record(e Event) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e.ID = int64(len(o.events) + 1)
+	o.events = append(o.events, e)
+}
+
+// Len is how many events were recorded
+func (o *MemoryOutbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.events)
+}
+
+// Truncate forgets events recorded after the first n, as rolling back a change
+// that recorded them would; it must not reach into published events
+func (o *MemoryOutbox) Truncate(n int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = o.events[:n]
+}
+
+func (o *MemoryOutbox) EventsSince(after int64, limit int) ([]Event, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	res := make([]Event, 0)
+	for _, e := range o.events[:o.published] {
+		if e.Seq > after && len(res) < limit {
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
+
+// RelayEvents publishes without holding the lock, since sinks such as the
+// webhook dispatcher may use the store; it assumes a single relay
+func (o *MemoryOutbox) RelayEvents(limit int, publish func([]Event) error) (int, error) {
+	o.mu.Lock()
+	end := o.published + limit
+	if end > len(o.events) {
+		end = len(o.events)
+	}
+	for i := o.published; i < end; i++ {
+		o.events[i].Seq = int64(i + 1)
+	}
+	batch := append([]Event(nil), o.events[o.published:end]...)
+	o.mu.Unlock()
+	if len(batch) == 0 {
+		return 0, nil
+	}
+	if err := publish(batch); err != nil {
+		return 0, err
+	}
+	o.mu.Lock()
+	o.published += len(batch)
+	o.mu.Unlock()
+	return len(batch), nil
+}
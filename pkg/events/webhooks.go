@@ -0,0 +1,697 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Headers of webhook requests. The signature is "t=<unix>,v1=<hex>" where hex is
+// the HMAC-SHA256, keyed with the subscription's secret, of "<unix>.<body>".
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+var (
+	ErrNotFound            = errors.New("not found")
+	ErrInvalidSubscription = errors.New("invalid webhook: url must be http(s) and event types known")
+	ErrDeliveryNotDead     = errors.New("only dead deliveries can be retried")
+)
+
+// Webhook is a subscription to domain events. EventTypes filters the events
+// sent; empty means all. The secret is only shown when the webhook is created.
+type Webhook struct {
+	ID         int      `json:"id"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Secret     string   `json:"secret,omitempty"`
+	Created    int64    `json:"created_unix"`
+}
+
+// Delivery is an attempt to send one event to one webhook. Failed sends are
+// retried with exponential backoff until the dispatcher's MaxAttempts, after
+// which the delivery is dead until retried by hand.
+type Delivery struct {
+	ID          int             `json:"id"`
+	WebhookID   int             `json:"webhook_id"`
+	EventID     int64           `json:"event_id"`
+	EventType   string          `json:"event_type"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt int64           `json:"next_attempt_unix,omitempty"`
+	LastCode    int             `json:"last_status_code,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	Created     int64           `json:"created_unix"`
+	Delivered   int64           `json:"delivered_unix,omitempty"`
+
+	url, secret string
+}
+
+// ValidateWebhook checks a new subscription to some of eventTypes and fills in
+// a secret if none is given
+func ValidateWebhook(wh *Webhook, eventTypes []string) error {
+	u, err := url.Parse(wh.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidSubscription
+	}
+	for _, t := range wh.EventTypes {
+		known := false
+		for _, k := range eventTypes {
+			known = known || t == k
+		}
+		if !known {
+			return ErrInvalidSubscription
+		}
+	}
+	if wh.EventTypes == nil {
+		wh.EventTypes = []string{}
+	}
+	if wh.Secret == "" {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		wh.Secret = "whsec_" + hex.EncodeToString(b)
+	}
+	return nil
+}
+
+// wants reports whether the webhook subscribes to events of type typ
+func (wh *Webhook) wants(typ string) bool {
+	if len(wh.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range wh.EventTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// SignWebhook computes the signature header of a webhook request body, which
+// receivers compare with the header they are sent
+func SignWebhook(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", ts)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// backoff is the wait before the next attempt after a failed one
+func backoff(base, max time.Duration, attempts int) time.Duration {
+	d := base
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// SQLWebhooks keeps webhook subscriptions and their deliveries in Postgres
+type SQLWebhooks struct {
+	db *sql.DB
+}
+
+func NewSQLWebhooks(db *sql.DB) *SQLWebhooks {
+	return &SQLWebhooks{db: db}
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// CreateWebhook stores a subscription
+func (s *SQLWebhooks) CreateWebhook(wh Webhook) (*Webhook, error) {
+	wh.Created = time.Now().Unix()
+	err := s.db.QueryRow("INSERT INTO webhooks (url, event_types, secret, created_unix) VALUES ($1,$2,$3,$4) RETURNING id",
+		wh.URL, pq.Array(wh.EventTypes), wh.Secret, wh.Created).Scan(&wh.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &wh, nil
+}
+
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	var wh Webhook
+	if err := row.Scan(&wh.ID, &wh.URL, pq.Array(&wh.EventTypes), &wh.Secret, &wh.Created); err != nil {
+		return nil, err
+	}
+	if wh.EventTypes == nil {
+		wh.EventTypes = []string{}
+	}
+	return &wh, nil
+}
+
+// Webhooks returns all subscriptions, oldest first
+func (s *SQLWebhooks) Webhooks() ([]*Webhook, error) {
+	rows, err := s.db.Query("SELECT id, url, event_types, secret, created_unix FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]*Webhook, 0)
+	for rows.Next() {
+		wh, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, wh)
+	}
+	return res, rows.Err()
+}
+
+func (s *SQLWebhooks) GetWebhook(id int) (*Webhook, error) {
+	wh, err := scanWebhook(s.db.QueryRow("SELECT id, url, event_types, secret, created_unix FROM webhooks WHERE id=$1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return wh, err
+}
+
+// DeleteWebhook removes a subscription along with its delivery log
+func (s *SQLWebhooks) DeleteWebhook(id int) error {
+	res, err := s.db.Exec("DELETE FROM webhooks WHERE id=$1", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// EnqueueDeliveries queues each event for the webhooks subscribed to it. An
+// event queued before for a webhook is skipped, so events relayed twice are
+// delivered once.
+func (s *SQLWebhooks) EnqueueDeliveries(events []Event) error {
+	hooks, err := s.Webhooks()
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	for _, e := range events {
+		payload, _ := json.Marshal(e)
+		for _, wh := range hooks {
+			if !wh.wants(e.Type) {
+				continue
+			}
+			if _, err := tx.Exec(`INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, status, next_attempt_unix, created_unix)
+			VALUES ($1,$2,$3,$4,$5,$6,$6) ON CONFLICT (webhook_id, event_id) DO NOTHING`,
+				wh.ID, e.ID, e.Type, payload, DeliveryPending, now); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+const deliveryColumns = "d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_unix, " +
+	"d.last_status_code, d.last_error, d.created_unix, d.delivered_unix"
+
+func scanDelivery(row rowScanner, extra ...interface{}) (*Delivery, error) {
+	var d Delivery
+	var payload []byte
+	dest := append([]interface{}{&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &payload, &d.Status, &d.Attempts, &d.NextAttempt,
+		&d.LastCode, &d.LastError, &d.Created, &d.Delivered}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	d.Payload = payload
+	return &d, nil
+}
+
+// ClaimDeliveries takes up to limit pending deliveries that are due and leases
+// them for lease seconds, so that other dispatchers leave them alone meanwhile
+func (s *SQLWebhooks) ClaimDeliveries(now, lease int64, limit int) ([]*Delivery, error) {
+	rows, err := s.db.Query(`UPDATE webhook_deliveries d SET next_attempt_unix = $2
+	FROM webhooks w
+	WHERE w.id = d.webhook_id AND d.id IN (
+		SELECT id FROM webhook_deliveries WHERE status = $3 AND next_attempt_unix <= $1
+		ORDER BY id LIMIT $4 FOR UPDATE SKIP LOCKED)
+	RETURNING `+deliveryColumns+`, w.url, w.secret`, now, now+lease, DeliveryPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]*Delivery, 0)
+	for rows.Next() {
+		var target, secret string
+		d, err := scanDelivery(rows, &target, &secret)
+		if err != nil {
+			return nil, err
+		}
+		d.url, d.secret = target, secret
+		res = append(res, d)
+	}
+	return res, rows.Err()
+}
+
+// FinishDelivery stores the outcome of an attempt
+func (s *SQLWebhooks) FinishDelivery(d *Delivery) error {
+	_, err := s.db.Exec(`UPDATE webhook_deliveries SET status=$1, attempts=$2, next_attempt_unix=$3, last_status_code=$4,
+		last_error=$5, delivered_unix=$6 WHERE id=$7`,
+		d.Status, d.Attempts, d.NextAttempt, d.LastCode, d.LastError, d.Delivered, d.ID)
+	return err
+}
+
+// Deliveries returns the latest deliveries of a webhook, newest first,
+// optionally only those in status
+func (s *SQLWebhooks) Deliveries(webhookID int, status string) ([]*Delivery, error) {
+	rows, err := s.db.Query(`SELECT `+deliveryColumns+` FROM webhook_deliveries d
+	WHERE d.webhook_id = $1 AND ($2 = '' OR d.status = $2) ORDER BY d.id DESC LIMIT 200`, webhookID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]*Delivery, 0)
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, rows.Err()
+}
+
+// RetryDelivery puts a dead delivery back in the queue with fresh attempts
+func (s *SQLWebhooks) RetryDelivery(webhookID, id int) (*Delivery, error) {
+	d, err := scanDelivery(s.db.QueryRow(`UPDATE webhook_deliveries d SET status=$1, attempts=0, next_attempt_unix=$2
+	WHERE d.id=$3 AND d.webhook_id=$4 AND d.status=$5 RETURNING `+deliveryColumns,
+		DeliveryPending, time.Now().Unix(), id, webhookID, DeliveryDead))
+	if errors.Is(err, sql.ErrNoRows) {
+		var status string
+		if err := s.db.QueryRow("SELECT status FROM webhook_deliveries WHERE id=$1 AND webhook_id=$2", id, webhookID).Scan(&status); err != nil {
+			return nil, ErrNotFound
+		}
+		return nil, ErrDeliveryNotDead
+	}
+	return d, err
+}
+
+// webhookQueue is implemented by stores that keep webhook deliveries
+type webhookQueue interface {
+	EnqueueDeliveries(events []Event) error
+	ClaimDeliveries(now, lease int64, limit int) ([]*Delivery, error)
+	FinishDelivery(d *Delivery) error
+}
+
+// Dispatcher sends domain events to webhooks. As a Sink it queues deliveries
+// for the relay's events; Run sends the queued ones.
+type Dispatcher struct {
+	store       webhookQueue
+	client      *http.Client
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Interval    time.Duration
+	Batch       int
+}
+
+func NewDispatcher(store webhookQueue) *Dispatcher {
+	return &Dispatcher{store: store, client: &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 8, BaseDelay: 30 * time.Second, MaxDelay: time.Hour, Interval: 5 * time.Second, Batch: 50}
+}
+
+func (d *Dispatcher) Publish(_ context.Context, events []Event) error {
+	return d.store.EnqueueDeliveries(events)
+}
+
+// DeliverDue sends the deliveries that are due, returning how many it tried
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	// the lease outlasts a request so a slow receiver is not sent the event twice
+	lease := int64((d.client.Timeout + time.Minute) / time.Second)
+	list, err := d.store.ClaimDeliveries(time.Now().Unix(), lease, d.Batch)
+	if err != nil {
+		return 0, err
+	}
+	for _, dl := range list {
+		d.attempt(ctx, dl)
+		if err := d.store.FinishDelivery(dl); err != nil {
+			return 0, err
+		}
+	}
+	return len(list), nil
+}
+
+// attempt sends a delivery once and records the outcome on it
+func (d *Dispatcher) attempt(ctx context.Context, dl *Delivery) {
+	dl.Attempts++
+	now := time.Now()
+	dl.LastCode, dl.LastError = 0, ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.url, bytes.NewReader(dl.Payload))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(WebhookEventHeader, dl.EventType)
+		req.Header.Set(WebhookDeliveryHeader, strconv.Itoa(dl.ID))
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(dl.secret, now.Unix(), dl.Payload))
+		var resp *http.Response
+		if resp, err = d.client.Do(req); err == nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+			resp.Body.Close()
+			dl.LastCode = resp.StatusCode
+			if resp.StatusCode >= 300 {
+				err = fmt.Errorf("receiver responded %d", resp.StatusCode)
+			}
+		}
+	}
+	switch {
+	case err == nil:
+		dl.Status, dl.Delivered, dl.NextAttempt = DeliveryDelivered, now.Unix(), 0
+	case dl.Attempts >= d.MaxAttempts:
+		dl.Status, dl.LastError, dl.NextAttempt = DeliveryDead, err.Error(), 0
+		log.Printf("webhook delivery %d to %s is dead after %d attempts: %v", dl.ID, dl.url, dl.Attempts, err)
+	default:
+		dl.LastError = err.Error()
+		dl.NextAttempt = now.Add(backoff(d.BaseDelay, d.MaxDelay, dl.Attempts)).Unix()
+	}
+}
+
+// Run sends due deliveries every Interval until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	t := time.NewTicker(d.Interval)
+	defer t.Stop()
+	for {
+		for {
+			n, err := d.DeliverDue(ctx)
+			if err != nil {
+				log.Printf("webhook dispatcher: %v", err)
+			}
+			if err != nil || n < d.Batch {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// WebhookStore is implemented by stores that keep webhook subscriptions
+type WebhookStore interface {
+	webhookQueue
+	CreateWebhook(wh Webhook) (*Webhook, error)
+	Webhooks() ([]*Webhook, error)
+	GetWebhook(id int) (*Webhook, error)
+	DeleteWebhook(id int) error
+	Deliveries(webhookID int, status string) ([]*Delivery, error)
+	RetryDelivery(webhookID, id int) (*Delivery, error)
+}
+
+// WebhooksHandler serves /webhooks, /webhooks/{id}, /webhooks/{id}/deliveries
+// and /webhooks/{id}/deliveries/{delivery}/retry. New subscriptions may ask
+// for the given event types.
+func WebhooksHandler(store WebhookStore, eventTypes []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/webhooks"), "/")
+		if path == "" {
+			switch r.Method {
+			case http.MethodGet:
+				list, err := store.Webhooks()
+				if err != nil {
+					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+					return
+				}
+				for _, wh := range list {
+					wh.Secret = ""
+				}
+				writeJSON(w, http.StatusOK, list)
+			case http.MethodPost:
+				var req Webhook
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+					return
+				}
+				if err := ValidateWebhook(&req, eventTypes); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+					return
+				}
+				wh, err := store.CreateWebhook(req)
+				if err != nil {
+					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+					return
+				}
+				writeJSON(w, http.StatusCreated, wh)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
+		parts := strings.Split(path, "/")
+		id, err := strconv.Atoi(parts[0])
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid id"})
+			return
+		}
+		wh, err := store.GetWebhook(id)
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, ErrNotFound) {
+				code = http.StatusNotFound
+			}
+			writeJSON(w, code, map[string]string{"error": err.Error()})
+			return
+		}
+		wh.Secret = ""
+		switch {
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, wh)
+		case len(parts) == 1 && r.Method == http.MethodDelete:
+			if err := store.DeleteWebhook(id); err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case len(parts) == 2 && parts[1] == "deliveries" && r.Method == http.MethodGet:
+			list, err := store.Deliveries(id, r.URL.Query().Get("status"))
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, list)
+		case len(parts) == 4 && parts[1] == "deliveries" && parts[3] == "retry" && r.Method == http.MethodPost:
+			did, err := strconv.Atoi(parts[2])
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid delivery id"})
+				return
+			}
+			d, err := store.RetryDelivery(id, did)
+			if err != nil {
+				code := http.StatusInternalServerError
+				switch {
+				case errors.Is(err, ErrNotFound):
+					code = http.StatusNotFound
+				case errors.Is(err, ErrDeliveryNotDead):
+					code = http.StatusConflict
+				}
+				writeJSON(w, code, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, d)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("json encode error: %v", err)
+	}
+}
+
+// MemoryWebhooks keeps webhook subscriptions and their deliveries in memory,
+// for tests. The zero value is empty and ready to use.
+type MemoryWebhooks struct {
+	mu         sync.Mutex
+	webhooks   []*Webhook
+	deliveries []*Delivery
+}
+
+func (s *MemoryWebhooks) CreateWebhook(wh Webhook) (*Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wh.ID = len(s.webhooks) + 1
+	wh.Created = time.Now().Unix()
+	s.webhooks = append(s.webhooks, &wh)
+	res := wh
+	return &res, nil
+}
+
+// webhookLocked finds a webhook that was not deleted; caller must hold the lock
+func (s *MemoryWebhooks) webhookLocked(id int) *Webhook {
+	for _, wh := range s.webhooks {
+		if wh.ID == id {
+			return wh
+		}
+	}
+	return nil
+}
+
+func (s *MemoryWebhooks) Webhooks() ([]*Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*Webhook, 0, len(s.webhooks))
+	for _, wh := range s.webhooks {
+		c := *wh
+		res = append(res, &c)
+	}
+	return res, nil
+}
+
+func (s *MemoryWebhooks) GetWebhook(id int) (*Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wh := s.webhookLocked(id)
+	if wh == nil {
+		return nil, ErrNotFound
+	}
+	c := *wh
+	return &c, nil
+}
+
+func (s *MemoryWebhooks) DeleteWebhook(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, wh := range s.webhooks {
+		if wh.ID == id {
+			s.webhooks = append(s.webhooks[:i], s.webhooks[i+1:]...)
+			kept := s.deliveries[:0]
+			for _, d := range s.deliveries {
+				if d.WebhookID != id {
+					kept = append(kept, d)
+				}
+			}
+			s.deliveries = kept
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryWebhooks) EnqueueDeliveries(events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Unix()
+	for _, e := range events {
+		payload, _ := json.Marshal(e)
+		for _, wh := range s.webhooks {
+			if !wh.wants(e.Type) {
+				continue
+			}
+			dup := false
+			for _, d := range s.deliveries {
+				dup = dup || (d.WebhookID == wh.ID && d.EventID == e.ID)
+			}
+			if dup {
+				continue
+			}
+			s.deliveries = append(s.deliveries, &Delivery{ID: len(s.deliveries) + 1, WebhookID: wh.ID, EventID: e.ID, EventType: e.Type,
+				Payload: payload, Status: DeliveryPending, NextAttempt: now, Created: now})
+		}
+	}
+	return nil
+}
+
+func (s *MemoryWebhooks) ClaimDeliveries(now, lease int64, limit int) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*Delivery, 0)
+	for _, d := range s.deliveries {
+		if len(res) == limit {
+			break
+		}
+		if d.Status != DeliveryPending || d.NextAttempt > now {
+			continue
+		}
+		d.NextAttempt = now + lease
+		c := *d
+		wh := s.webhookLocked(d.WebhookID)
+		c.url, c.secret = wh.URL, wh.Secret
+		res = append(res, &c)
+	}
+	return res, nil
+}
+
+func (s *MemoryWebhooks) FinishDelivery(d *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cur := range s.deliveries {
+		if cur.ID == d.ID {
+			*cur = *d
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryWebhooks) Deliveries(webhookID int, status string) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*Delivery, 0)
+	for i := len(s.deliveries) - 1; i >= 0; i-- {
+		d := s.deliveries[i]
+		if d.WebhookID == webhookID && (status == "" || d.Status == status) {
+			c := *d
+			res = append(res, &c)
+		}
+	}
+	return res, nil
+}
+
+func (s *MemoryWebhooks) RetryDelivery(webhookID, id int) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.deliveries {
+		if d.ID != id || d.WebhookID != webhookID {
+			continue
+		}
+		if d.Status != DeliveryDead {
+			return nil, ErrDeliveryNotDead
+		}
+		d.Status, d.Attempts, d.NextAttempt = DeliveryPending, 0, time.Now().Unix()
+		c := *d
+		return &c, nil
+	}
+	return nil, ErrNotFound
+}
@@ -0,0 +1,138 @@
+package events
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhooks_DeliverSignedWithRetries(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		sig := r.Header.Get(WebhookSignatureHeader)
+		ts, _ := strconv.ParseInt(strings.TrimPrefix(strings.SplitN(sig, ",", 2)[0], "t="), 10, 64)
+		if sig != SignWebhook("s3cret", ts, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		got = append(got, r.Header.Get(WebhookEventHeader))
+	}))
+	defer srv.Close()
+
+	var s MemoryWebhooks
+	wh := Webhook{URL: srv.URL, EventTypes: []string{"StockAdjusted"}, Secret: "s3cret"}
+	if err := ValidateWebhook(&wh, []string{"ItemCreated", "StockAdjusted"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hook, _ := s.CreateWebhook(wh)
+
+	d := NewDispatcher(&s)
+	d.BaseDelay = 0
+	events := []Event{{ID: 1, Type: "ItemCreated"}, {ID: 2, Type: "StockAdjusted"}}
+	if err := d.Publish(context.Background(), events); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// relaying the same events again queues nothing new
+	d.Publish(context.Background(), events)
+	for i := 0; i < 2; i++ {
+		if _, err := d.DeliverDue(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	list, _ := s.Deliveries(hook.ID, "")
+	if len(list) != 1 || list[0].Status != DeliveryDelivered || list[0].Attempts != 2 || list[0].LastCode != http.StatusOK {
+		t.Fatalf("unexpected deliveries %+v", list)
+	}
+	if len(got) != 1 || got[0] != "StockAdjusted" {
+		t.Fatalf("expected one StockAdjusted webhook, got %v", got)
+	}
+}
+
+func TestWebhooks_DeadLetterAndRetry(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	var s MemoryWebhooks
+	hook, _ := s.CreateWebhook(Webhook{URL: srv.URL, Secret: "x"})
+	d := NewDispatcher(&s)
+	d.BaseDelay, d.MaxAttempts = 0, 2
+	d.Publish(context.Background(), []Event{{ID: 1, Type: "OrderCreated"}})
+	for i := 0; i < 3; i++ {
+		d.DeliverDue(context.Background())
+	}
+
+	dead, _ := s.Deliveries(hook.ID, DeliveryDead)
+	if len(dead) != 1 || dead[0].Attempts != 2 || dead[0].LastCode != http.StatusInternalServerError {
+		t.Fatalf("expected one dead delivery, got %+v", dead)
+	}
+	if _, err := s.RetryDelivery(hook.ID, dead[0].ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.RetryDelivery(hook.ID, dead[0].ID); err != ErrDeliveryNotDead {
+		t.Fatalf("expected ErrDeliveryNotDead, got %v", err)
+	}
+	if n, _ := d.DeliverDue(context.Background()); n != 1 {
+		t.Fatalf("expected the retried delivery to be sent, got %d", n)
+	}
+}
+
+func TestWebhooksHandler(t *testing.T) {
+	var s MemoryWebhooks
+	h := WebhooksHandler(&s, []string{"OrderCreated"})
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/webhooks", `{"url": "https://erp.local/hook", "event_types": ["OrderShipped"]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("want unknown event type refused, got %d", rec.Code)
+	}
+	rec := do(http.MethodPost, "/webhooks", `{"url": "https://erp.local/hook", "event_types": ["OrderCreated"]}`)
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"secret":"whsec_`) {
+		t.Fatalf("want created with a secret, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodGet, "/webhooks/1", ""); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "secret") {
+		t.Fatalf("want webhook without its secret, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPost, "/webhooks/1/deliveries/9/retry", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("want unknown delivery not found, got %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/webhooks/1", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("want deleted, got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/webhooks/1", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("want deleted webhook not found, got %d", rec.Code)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	base, max := 30*time.Second, 10*time.Minute
+	for attempts, want := range map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 3: 2 * time.Minute, 10: max} {
+		if got := backoff(base, max, attempts); got != want {
+			t.Fatalf("backoff after %d attempts: want %v, got %v", attempts, want, got)
+		}
+	}
+	if err := ValidateWebhook(&Webhook{URL: "ftp://erp.local/hook"}, nil); err == nil {
+		t.Fatal("expected non-http url to be refused")
+	}
+}
@@ -0,0 +1,308 @@
+// Package inventory is a client for the inventory service's HTTP API, or its
+// gRPC API with NewGRPC.
+//
+// The module is versioned on its own; see Version. Errors from the service
+// are *APIError, which match ErrNotFound and ErrInsufficientStock with
+// errors.Is; failures to reach the service are returned as they come.
+// Options add retries of reads, a circuit breaker and a limit on concurrent
+// calls, which fail with ErrCircuitOpen and ErrBulkheadFull.
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "inventoryshop/pkg/inventory/inventorypb"
+)
+
+// Version of this client, sent in the User-Agent of its requests
+const Version = "1.3.0"
+
+// Client calls one inventory service. It is safe for concurrent use.
+type Client struct {
+	baseURL string
+	http    *http.Client
+
+	rpc pb.InventoryServiceClient
+
+	retry    RetryPolicy
+	breaker  *Breaker
+	slots    chan struct{}
+	slotWait time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient makes the client send its requests through hc
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// New returns a client for the inventory service at baseURL, e.g.
+// "http://inventory:8001". Without WithHTTPClient requests time out after 5s.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{baseURL: strings.TrimRight(baseURL, "/"), http: &http.Client{Timeout: 5 * time.Second}}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// BaseURL is the address of the service the client calls
+func (c *Client) BaseURL() string { return c.baseURL }
+
+// ListItems returns all items
+func (c *Client) ListItems(ctx context.Context) ([]Item, error) {
+	if c.rpc != nil {
+		return c.grpcListItems(ctx, nil)
+	}
+	var items []Item
+	err := c.do(ctx, http.MethodGet, "/items", nil, &items)
+	return items, err
+}
+
+// GetItem returns one item
+func (c *Client) GetItem(ctx context.Context, id int) (*Item, error) {
+	if c.rpc != nil {
+		return c.grpcGetItem(ctx, id)
+	}
+	var it Item
+	if err := c.do(ctx, http.MethodGet, "/items/"+strconv.Itoa(id), nil, &it); err != nil {
+		return nil, err
+	}
+	return &it, nil
+}
+
+// maxBulkItems is how many ids the service takes in one GET /items?ids=
+const maxBulkItems = 200
+
+// GetItems returns the items with the given ids by id, in as few requests as
+// the service allows. Repeated ids are fetched once; ids that do not exist are
+// missing from the map.
+func (c *Client) GetItems(ctx context.Context, ids []int) (map[int]*Item, error) {
+	seen := make(map[int]bool, len(ids))
+	unique := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	res := make(map[int]*Item, len(unique))
+	for len(unique) > 0 {
+		n := len(unique)
+		if n > maxBulkItems {
+			n = maxBulkItems
+		}
+		items, err := c.listItems(ctx, unique[:n])
+		if err != nil {
+			return nil, err
+		}
+		for i := range items {
+			res[items[i].ID] = &items[i]
+		}
+		unique = unique[n:]
+	}
+	return res, nil
+}
+
+// listItems fetches the items with the given ids
+func (c *Client) listItems(ctx context.Context, ids []int) ([]Item, error) {
+	if c.rpc != nil {
+		return c.grpcListItems(ctx, ids)
+	}
+	q := make([]string, len(ids))
+	for i, id := range ids {
+		q[i] = strconv.Itoa(id)
+	}
+	var items []Item
+	err := c.do(ctx, http.MethodGet, "/items?ids="+strings.Join(q, ","), nil, &items)
+	return items, err
+}
+
+// CreateItem adds an item
+func (c *Client) CreateItem(ctx context.Context, item NewItem) (*Item, error) {
+	if c.rpc != nil {
+		return c.grpcCreateItem(ctx, item)
+	}
+	var it Item
+	if err := c.do(ctx, http.MethodPost, "/items", item, &it); err != nil {
+		return nil, err
+	}
+	return &it, nil
+}
+
+// Adjust changes an item's stock; taking more than there is fails with
+// ErrInsufficientStock
+func (c *Client) Adjust(ctx context.Context, id int, adj Adjustment) (*Adjusted, error) {
+	if c.rpc != nil {
+		return c.grpcAdjust(ctx, id, adj)
+	}
+	var res Adjusted
+	if err := c.do(ctx, http.MethodPost, "/items/"+strconv.Itoa(id)+"/adjust", adj, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// GetSerial looks up a serial number
+func (c *Client) GetSerial(ctx context.Context, serial string) (*Serial, error) {
+	if c.rpc != nil {
+		return c.grpcGetSerial(ctx, serial)
+	}
+	var sn Serial
+	if err := c.do(ctx, http.MethodGet, "/serials/"+url.PathEscape(serial), nil, &sn); err != nil {
+		return nil, err
+	}
+	return &sn, nil
+}
+
+// CreateBackorder asks inventory to accept qty units of an item beyond its
+// stock. The backorder is fulfilled whole, oldest first, once the stock covers
+// it; it comes back fulfilled if the stock already does and nothing older waits.
+func (c *Client) CreateBackorder(ctx context.Context, itemID, qty int) (*Backorder, error) {
+	if c.rpc != nil {
+		return c.grpcCreateBackorder(ctx, itemID, qty)
+	}
+	var b Backorder
+	body := map[string]int{"quantity": qty}
+	if err := c.do(ctx, http.MethodPost, "/items/"+strconv.Itoa(itemID)+"/backorders", body, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// GetBackorder reads the current state of a backorder
+func (c *Client) GetBackorder(ctx context.Context, id int) (*Backorder, error) {
+	if c.rpc != nil {
+		return c.grpcGetBackorder(ctx, id)
+	}
+	var b Backorder
+	if err := c.do(ctx, http.MethodGet, "/backorders/"+strconv.Itoa(id), nil, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// CancelBackorder withdraws a backorder. If it was fulfilled meanwhile it comes
+// back fulfilled and the caller must return its stock.
+func (c *Client) CancelBackorder(ctx context.Context, id int) (*Backorder, error) {
+	if c.rpc != nil {
+		return c.grpcCancelBackorder(ctx, id)
+	}
+	var b Backorder
+	if err := c.do(ctx, http.MethodPost, "/backorders/"+strconv.Itoa(id)+"/cancel", struct{}{}, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// do sends a request with body as JSON, if any, and decodes a 2xx response
+// into out. Reads are retried according to the retry policy.
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	return c.call(ctx, idempotent(method), func(ctx context.Context) error {
+		return c.send(ctx, method, path, payload, out)
+	})
+}
+
+// call makes a call through the bulkhead and breaker, retrying it according
+// to the retry policy if it is safe to repeat
+func (c *Client) call(ctx context.Context, retryable bool, fn func(context.Context) error) error {
+	attempts := 1
+	if retryable && c.retry.MaxAttempts > 1 {
+		attempts = c.retry.MaxAttempts
+	}
+	var err error
+	for i := 1; ; i++ {
+		err = c.attempt(ctx, fn)
+		if i >= attempts || !transient(ctx, err) {
+			return err
+		}
+		t := time.NewTimer(c.retry.delay(i))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+	}
+}
+
+// attempt makes one call through the bulkhead and breaker
+func (c *Client) attempt(ctx context.Context, fn func(context.Context) error) error {
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if c.breaker != nil {
+		if err := c.breaker.allow(); err != nil {
+			return err
+		}
+	}
+	err = fn(ctx)
+	if c.breaker != nil {
+		switch {
+		case err != nil && errors.Is(ctx.Err(), context.Canceled):
+			// the caller went away, which says nothing about the service
+			c.breaker.cancel()
+		case err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded):
+			// a service that does not answer in time is not healthy
+			c.breaker.record(err)
+		case transient(ctx, err):
+			c.breaker.record(err)
+		default:
+			c.breaker.record(nil)
+		}
+	}
+	return err
+}
+
+func (c *Client) send(ctx context.Context, method, path string, payload []byte, out interface{}) error {
+	var r io.Reader
+	if payload != nil {
+		r = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, r)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "inventory-client/"+Version)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newAPIError(resp)
+	}
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("inventory: decode %s %s: %w", method, path, err)
+	}
+	return nil
+}
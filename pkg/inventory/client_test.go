@@ -0,0 +1,109 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientItems(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ua := r.Header.Get("User-Agent"); ua != "inventory-client/"+Version {
+			t.Errorf("user agent %q", ua)
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/items" && r.URL.Query().Get("ids") != "":
+			if ids := r.URL.Query().Get("ids"); ids != "1,9" {
+				t.Errorf("ids %q", ids)
+			}
+			json.NewEncoder(w).Encode([]Item{{ID: 1, Name: "Hoodie", Quantity: 3}})
+		case r.Method == http.MethodGet && r.URL.Path == "/items":
+			json.NewEncoder(w).Encode([]Item{{ID: 1, Name: "Hoodie", Quantity: 3}})
+		case r.Method == http.MethodGet && r.URL.Path == "/items/1":
+			json.NewEncoder(w).Encode(Item{ID: 1, Name: "Hoodie", Quantity: 3})
+		case r.Method == http.MethodPost && r.URL.Path == "/items":
+			var n NewItem
+			json.NewDecoder(r.Body).Decode(&n)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(Item{ID: 2, Name: n.Name, Quantity: n.Quantity, Price: n.Price})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not found"}`))
+		}
+	}))
+	defer srv.Close()
+	c := New(srv.URL + "/")
+	ctx := context.Background()
+
+	items, err := c.ListItems(ctx)
+	if err != nil || len(items) != 1 || items[0].Name != "Hoodie" {
+		t.Fatalf("list: %v %+v", err, items)
+	}
+	it, err := c.GetItem(ctx, 1)
+	if err != nil || it.Quantity != 3 {
+		t.Fatalf("get: %v %+v", err, it)
+	}
+	byID, err := c.GetItems(ctx, []int{1, 9, 1})
+	if err != nil || len(byID) != 1 || byID[1].Name != "Hoodie" || byID[9] != nil {
+		t.Fatalf("get many: %v %+v", err, byID)
+	}
+	created, err := c.CreateItem(ctx, NewItem{Name: "Cap", Quantity: 5, Price: 9})
+	if err != nil || created.ID != 2 || created.Name != "Cap" {
+		t.Fatalf("create: %v %+v", err, created)
+	}
+	_, err = c.GetItem(ctx, 9)
+	if !errors.Is(err, ErrNotFound) || errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("missing item: %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Message != "not found" {
+		t.Fatalf("api error %+v", apiErr)
+	}
+}
+
+func TestClientAdjust(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var adj Adjustment
+		json.NewDecoder(r.Body).Decode(&adj)
+		if adj.Delta < -3 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"insufficient stock","code":"insufficient_stock"}`))
+			return
+		}
+		w.Write([]byte(`{"id":1,"name":"Kit","quantity":2,"components":[{"item_id":4,"quantity":2,"cost":6}],"cost":6}`))
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+
+	res, err := c.Adjust(context.Background(), 1, Adjustment{Delta: -1, Reason: "order"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Quantity != 2 || res.Cost != 6 || len(res.Components) != 1 || res.Allocation().Components[0].ItemID != 4 {
+		t.Fatalf("adjusted %+v", res)
+	}
+	_, err = c.Adjust(context.Background(), 1, Adjustment{Delta: -5})
+	if !errors.Is(err, ErrInsufficientStock) || errors.Is(err, ErrNotFound) {
+		t.Fatalf("want insufficient stock, got %v", err)
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := New(srv.URL).GetBackorder(ctx, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+}
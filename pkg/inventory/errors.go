@@ -0,0 +1,60 @@
+package inventory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+var (
+	ErrNotFound          = errors.New("inventory: not found")
+	ErrInsufficientStock = errors.New("inventory: insufficient stock")
+)
+
+// CodeInsufficientStock is the code of errors for taking more stock than there is
+const CodeInsufficientStock = "insufficient_stock"
+
+// APIError is a response from the inventory service other than success. Code
+// is set for errors callers act on, e.g. CodeInsufficientStock.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("inventory: status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("inventory: %s (status %d)", e.Message, e.StatusCode)
+}
+
+// Is matches the error against ErrNotFound and ErrInsufficientStock
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrInsufficientStock:
+		return e.Code == CodeInsufficientStock
+	}
+	return false
+}
+
+// Temporary reports whether the request may succeed if tried again later
+func (e *APIError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+func newAPIError(resp *http.Response) *APIError {
+	e := &APIError{StatusCode: resp.StatusCode}
+	var body struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	if json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&body) == nil {
+		e.Message, e.Code = body.Error, body.Code
+	}
+	return e
+}
@@ -0,0 +1,227 @@
+package inventory
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "inventoryshop/pkg/inventory/inventorypb"
+)
+
+// NewGRPC returns a client that calls the inventory service's gRPC API over
+// conn; target is the address conn dials, reported by Status. The options
+// apply as they do to New, except WithHTTPClient.
+func NewGRPC(target string, conn grpc.ClientConnInterface, opts ...Option) *Client {
+	c := New(target, opts...)
+	c.rpc = pb.NewInventoryServiceClient(conn)
+	return c
+}
+
+// grpcCodes are the HTTP statuses the REST API answers with for gRPC codes,
+// so errors match the same way whichever API is used
+var grpcCodes = map[codes.Code]int{
+	codes.NotFound:           http.StatusNotFound,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.Aborted:            http.StatusConflict,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.Unimplemented:      http.StatusNotImplemented,
+}
+
+// rpcError turns a gRPC status into an *APIError. Calls the caller gave up on
+// fail with the context's error, as they do over HTTP.
+func rpcError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	code, ok := grpcCodes[st.Code()]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	e := &APIError{StatusCode: code, Message: st.Message()}
+	// the service names the reason in an ErrorInfo, as it does with the code
+	// field of REST error bodies
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			e.Code = info.Reason
+		}
+	}
+	return e
+}
+
+func (c *Client) grpcListItems(ctx context.Context, ids []int) ([]Item, error) {
+	req := &pb.ListItemsRequest{Ids: make([]int64, len(ids))}
+	for i, id := range ids {
+		req.Ids[i] = int64(id)
+	}
+	var items []Item
+	err := c.call(ctx, true, func(ctx context.Context) error {
+		resp, err := c.rpc.ListItems(ctx, req)
+		if err != nil {
+			return rpcError(ctx, err)
+		}
+		items = make([]Item, len(resp.Items))
+		for i, it := range resp.Items {
+			items[i] = *itemFromPB(it)
+		}
+		return nil
+	})
+	return items, err
+}
+
+func (c *Client) grpcGetItem(ctx context.Context, id int) (*Item, error) {
+	var it *Item
+	err := c.call(ctx, true, func(ctx context.Context) error {
+		resp, err := c.rpc.GetItem(ctx, &pb.GetItemRequest{Id: int64(id)})
+		if err != nil {
+			return rpcError(ctx, err)
+		}
+		it = itemFromPB(resp)
+		return nil
+	})
+	return it, err
+}
+
+func (c *Client) grpcCreateItem(ctx context.Context, n NewItem) (*Item, error) {
+	req := &pb.CreateItemRequest{Name: n.Name, Quantity: int64(n.Quantity), Price: n.Price, UnitCost: n.UnitCost,
+		SerialTracked: n.SerialTracked, CostingMethod: n.CostingMethod, BackorderPolicy: n.BackorderPolicy,
+		AvailableAt: n.AvailableAt, Category: n.Category,
+		WeightKg: n.WeightKg, LengthCm: n.LengthCm, WidthCm: n.WidthCm, HeightCm: n.HeightCm}
+	for _, comp := range n.Components {
+		req.Components = append(req.Components, &pb.Component{ItemId: int64(comp.ItemID), Quantity: int64(comp.Quantity)})
+	}
+	var it *Item
+	err := c.call(ctx, false, func(ctx context.Context) error {
+		resp, err := c.rpc.CreateItem(ctx, req)
+		if err != nil {
+			return rpcError(ctx, err)
+		}
+		it = itemFromPB(resp)
+		return nil
+	})
+	return it, err
+}
+
+func (c *Client) grpcAdjust(ctx context.Context, id int, adj Adjustment) (*Adjusted, error) {
+	req := &pb.AdjustStockRequest{ItemId: int64(id), Delta: int64(adj.Delta), Reason: adj.Reason, Reference: adj.Reference,
+		UnitCost: adj.UnitCost, Returned: allocationToPB(Allocation{Lots: adj.Lots, Serials: adj.Serials, Components: adj.Components})}
+	var res *Adjusted
+	err := c.call(ctx, false, func(ctx context.Context) error {
+		resp, err := c.rpc.AdjustStock(ctx, req)
+		if err != nil {
+			return rpcError(ctx, err)
+		}
+		a := allocationFromPB(resp.Allocation)
+		res = &Adjusted{Lots: a.Lots, Serials: a.Serials, Components: a.Components, Cost: a.Cost}
+		if resp.Item != nil {
+			res.Item = *itemFromPB(resp.Item)
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (c *Client) grpcGetSerial(ctx context.Context, serial string) (*Serial, error) {
+	var sn *Serial
+	err := c.call(ctx, true, func(ctx context.Context) error {
+		resp, err := c.rpc.GetSerial(ctx, &pb.GetSerialRequest{Serial: serial})
+		if err != nil {
+			return rpcError(ctx, err)
+		}
+		sn = &Serial{Serial: resp.Serial, ItemID: int(resp.ItemId), Status: resp.Status, Received: resp.ReceivedUnix}
+		return nil
+	})
+	return sn, err
+}
+
+func (c *Client) grpcCreateBackorder(ctx context.Context, itemID, qty int) (*Backorder, error) {
+	return c.grpcBackorder(ctx, false, func(ctx context.Context) (*pb.Backorder, error) {
+		return c.rpc.CreateBackorder(ctx, &pb.CreateBackorderRequest{ItemId: int64(itemID), Quantity: int64(qty)})
+	})
+}
+
+func (c *Client) grpcGetBackorder(ctx context.Context, id int) (*Backorder, error) {
+	return c.grpcBackorder(ctx, true, func(ctx context.Context) (*pb.Backorder, error) {
+		return c.rpc.GetBackorder(ctx, &pb.GetBackorderRequest{Id: int64(id)})
+	})
+}
+
+func (c *Client) grpcCancelBackorder(ctx context.Context, id int) (*Backorder, error) {
+	return c.grpcBackorder(ctx, false, func(ctx context.Context) (*pb.Backorder, error) {
+		return c.rpc.CancelBackorder(ctx, &pb.CancelBackorderRequest{Id: int64(id)})
+	})
+}
+
+func (c *Client) grpcBackorder(ctx context.Context, retryable bool, fn func(context.Context) (*pb.Backorder, error)) (*Backorder, error) {
+	var b *Backorder
+	err := c.call(ctx, retryable, func(ctx context.Context) error {
+		resp, err := fn(ctx)
+		if err != nil {
+			return rpcError(ctx, err)
+		}
+		b = &Backorder{ID: int(resp.Id), ItemID: int(resp.ItemId), Quantity: int(resp.Quantity), Kind: resp.Kind,
+			Reference: resp.Reference, Status: resp.Status, ExpectedAt: resp.ExpectedAt, Created: resp.CreatedUnix,
+			Fulfilled: resp.FulfilledUnix, Allocation: allocationFromPB(resp.Allocation)}
+		return nil
+	})
+	return b, err
+}
+
+func itemFromPB(p *pb.Item) *Item {
+	it := &Item{ID: int(p.Id), Name: p.Name, Quantity: int(p.Quantity), Available: int(p.Available), Price: p.Price, SerialTracked: p.SerialTracked,
+		CostingMethod: p.CostingMethod, StockValue: p.StockValue, UnitCost: p.UnitCost, BackorderPolicy: p.BackorderPolicy,
+		AvailableAt: p.AvailableAt, Category: p.Category,
+		WeightKg: p.WeightKg, LengthCm: p.LengthCm, WidthCm: p.WidthCm, HeightCm: p.HeightCm}
+	for _, comp := range p.Components {
+		it.Components = append(it.Components, Component{ItemID: int(comp.ItemId), Quantity: int(comp.Quantity)})
+	}
+	return it
+}
+
+func lotsFromPB(lots []*pb.LotAllocation) []LotAllocation {
+	var res []LotAllocation
+	for _, l := range lots {
+		res = append(res, LotAllocation{LotID: int(l.LotId), LotNumber: l.LotNumber, ExpiresAt: l.ExpiresAt, Quantity: int(l.Quantity)})
+	}
+	return res
+}
+
+func lotsToPB(lots []LotAllocation) []*pb.LotAllocation {
+	var res []*pb.LotAllocation
+	for _, l := range lots {
+		res = append(res, &pb.LotAllocation{LotId: int64(l.LotID), LotNumber: l.LotNumber, ExpiresAt: l.ExpiresAt, Quantity: int64(l.Quantity)})
+	}
+	return res
+}
+
+func allocationFromPB(p *pb.Allocation) Allocation {
+	if p == nil {
+		return Allocation{}
+	}
+	a := Allocation{Lots: lotsFromPB(p.Lots), Serials: p.Serials, Cost: p.Cost}
+	for _, c := range p.Components {
+		a.Components = append(a.Components, ComponentAllocation{ItemID: int(c.ItemId), Quantity: int(c.Quantity),
+			Lots: lotsFromPB(c.Lots), Serials: c.Serials, Cost: c.Cost})
+	}
+	return a
+}
+
+func allocationToPB(a Allocation) *pb.Allocation {
+	p := &pb.Allocation{Lots: lotsToPB(a.Lots), Serials: a.Serials, Cost: a.Cost}
+	for _, c := range a.Components {
+		p.Components = append(p.Components, &pb.ComponentAllocation{ItemId: int64(c.ItemID), Quantity: int64(c.Quantity),
+			Lots: lotsToPB(c.Lots), Serials: c.Serials, Cost: c.Cost})
+	}
+	return p
+}
@@ -0,0 +1,88 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "inventoryshop/pkg/inventory/inventorypb"
+)
+
+type fakeInventoryServer struct {
+	pb.UnimplementedInventoryServiceServer
+	stock int64
+}
+
+func (s *fakeInventoryServer) GetItem(ctx context.Context, req *pb.GetItemRequest) (*pb.Item, error) {
+	if req.Id != 1 {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	return &pb.Item{Id: 1, Name: "Hoodie", Quantity: s.stock}, nil
+}
+
+func (s *fakeInventoryServer) ListItems(ctx context.Context, req *pb.ListItemsRequest) (*pb.ListItemsResponse, error) {
+	it, _ := s.GetItem(ctx, &pb.GetItemRequest{Id: 1})
+	return &pb.ListItemsResponse{Items: []*pb.Item{it}}, nil
+}
+
+func (s *fakeInventoryServer) AdjustStock(ctx context.Context, req *pb.AdjustStockRequest) (*pb.AdjustStockResponse, error) {
+	if s.stock+req.Delta < 0 {
+		st, _ := status.New(codes.FailedPrecondition, "insufficient stock").
+			WithDetails(&errdetails.ErrorInfo{Reason: CodeInsufficientStock, Domain: "inventory"})
+		return nil, st.Err()
+	}
+	s.stock += req.Delta
+	it, _ := s.GetItem(ctx, &pb.GetItemRequest{Id: req.ItemId})
+	return &pb.AdjustStockResponse{Item: it, Allocation: &pb.Allocation{Serials: []string{"SN1"}, Cost: 4}}, nil
+}
+
+func dialFake(t *testing.T, srv pb.InventoryServiceServer) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	pb.RegisterInventoryServiceServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCClient(t *testing.T) {
+	c := NewGRPC("bufnet", dialFake(t, &fakeInventoryServer{stock: 3}))
+	ctx := context.Background()
+
+	if st := c.Status(); st.Transport != "grpc" {
+		t.Fatalf("status %+v", st)
+	}
+	items, err := c.GetItems(ctx, []int{1, 2})
+	if err != nil || len(items) != 1 || items[1].Name != "Hoodie" {
+		t.Fatalf("get items: %v %+v", err, items)
+	}
+	if _, err := c.GetItem(ctx, 2); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want not found, got %v", err)
+	}
+	res, err := c.Adjust(ctx, 1, Adjustment{Delta: -1})
+	if err != nil || res.Quantity != 2 || res.Cost != 4 || len(res.Serials) != 1 {
+		t.Fatalf("adjust: %v %+v", err, res)
+	}
+	if _, err := c.Adjust(ctx, 1, Adjustment{Delta: -5}); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("want insufficient stock, got %v", err)
+	}
+	if _, err := c.GetSerial(ctx, "SN1"); err == nil || Unavailable(err) {
+		t.Fatalf("want unimplemented error, got %v", err)
+	}
+}
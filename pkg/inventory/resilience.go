@@ -0,0 +1,246 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCircuitOpen is returned without calling the service while the breaker
+	// considers it unhealthy
+	ErrCircuitOpen = errors.New("inventory: circuit open")
+	// ErrBulkheadFull is returned when the client already has as many calls in
+	// flight as it allows and none finished in time
+	ErrBulkheadFull = errors.New("inventory: too many concurrent calls")
+)
+
+// Unavailable reports whether err means inventory was not called because it is
+// unhealthy or overloaded, so the caller should answer 503
+func Unavailable(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrBulkheadFull)
+}
+
+// RetryPolicy retries idempotent calls (reads) that failed to reach the service
+// or got a 5xx or 429, waiting a jittered exponential backoff in between
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// delay is how long to wait before the given retry (1 for the first), with full
+// jitter so clients that failed together do not retry together
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// WithRetry retries idempotent calls according to p
+func WithRetry(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+// WithBreaker guards every call with b; share one breaker between clients of
+// the same service
+func WithBreaker(b *Breaker) Option {
+	return func(c *Client) { c.breaker = b }
+}
+
+// WithBulkhead allows at most max calls in flight at once. A call waits up to
+// wait for a slot, then fails with ErrBulkheadFull.
+func WithBulkhead(max int, wait time.Duration) Option {
+	return func(c *Client) {
+		if max > 0 {
+			c.slots = make(chan struct{}, max)
+			c.slotWait = wait
+		}
+	}
+}
+
+// Breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// BreakerConfig controls a Breaker. After Failures consecutive failures it opens
+// for OpenFor; then one trial call is let through, and closes it again if it
+// succeeds.
+type BreakerConfig struct {
+	Failures int
+	OpenFor  time.Duration
+}
+
+// Breaker is a circuit breaker. Failures are calls that could not reach the
+// service or got a 5xx or 429; other errors show the service is up.
+type Breaker struct {
+	cfg BreakerConfig
+	now func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	trial    bool
+	lastErr  string
+}
+
+// NewBreaker returns a closed breaker
+func NewBreaker(cfg BreakerConfig) *Breaker {
+	if cfg.Failures <= 0 {
+		cfg.Failures = 5
+	}
+	if cfg.OpenFor <= 0 {
+		cfg.OpenFor = 30 * time.Second
+	}
+	return &Breaker{cfg: cfg, now: time.Now, state: BreakerClosed}
+}
+
+// allow reports whether a call may go ahead. While half open only one trial
+// call is in flight at a time.
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cfg.OpenFor {
+		b.state = BreakerHalfOpen
+	}
+	switch b.state {
+	case BreakerOpen:
+		return ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.trial {
+			return ErrCircuitOpen
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+// record notes the outcome of a call let through by allow
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.trial = false
+	}
+	if err == nil {
+		b.state, b.failures, b.lastErr = BreakerClosed, 0, ""
+		return
+	}
+	b.failures++
+	b.lastErr = err.Error()
+	if b.state == BreakerHalfOpen || b.failures >= b.cfg.Failures {
+		b.state, b.openedAt = BreakerOpen, b.now()
+	}
+}
+
+// cancel notes that a call let through by allow was abandoned by its caller,
+// without counting it either way
+func (b *Breaker) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.trial = false
+	}
+}
+
+// BreakerStatus is a snapshot of a breaker
+type BreakerStatus struct {
+	State     string `json:"state"`
+	Failures  int    `json:"consecutive_failures"`
+	OpenedAt  int64  `json:"opened_unix,omitempty"`
+	RetryAt   int64  `json:"retry_unix,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Status reports the breaker's state
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := BreakerStatus{State: b.state, Failures: b.failures, LastError: b.lastErr}
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cfg.OpenFor {
+		s.State = BreakerHalfOpen
+	}
+	if b.state != BreakerClosed {
+		s.OpenedAt = b.openedAt.Unix()
+		s.RetryAt = b.openedAt.Add(b.cfg.OpenFor).Unix()
+	}
+	return s
+}
+
+// Status is a snapshot of the client's protections
+type Status struct {
+	URL           string         `json:"url"`
+	Transport     string         `json:"transport"`
+	Breaker       *BreakerStatus `json:"breaker,omitempty"`
+	InFlight      int            `json:"in_flight"`
+	MaxConcurrent int            `json:"max_concurrent,omitempty"`
+}
+
+// Status reports the client's breaker and how many calls it has in flight
+func (c *Client) Status() Status {
+	s := Status{URL: c.baseURL, Transport: "http", InFlight: len(c.slots), MaxConcurrent: cap(c.slots)}
+	if c.rpc != nil {
+		s.Transport = "grpc"
+	}
+	if c.breaker != nil {
+		bs := c.breaker.Status()
+		s.Breaker = &bs
+	}
+	return s
+}
+
+// acquire takes a bulkhead slot; the returned func gives it back
+func (c *Client) acquire(ctx context.Context) (func(), error) {
+	if c.slots == nil {
+		return func() {}, nil
+	}
+	release := func() { <-c.slots }
+	select {
+	case c.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	t := time.NewTimer(c.slotWait)
+	defer t.Stop()
+	select {
+	case c.slots <- struct{}{}:
+		return release, nil
+	case <-t.C:
+		return nil, ErrBulkheadFull
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// transient reports whether a call failed in a way that says the service is
+// unhealthy, rather than that the request was wrong or the caller gave up
+func transient(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	return true
+}
+
+// idempotent reports whether a request may be sent again without effect
+func idempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
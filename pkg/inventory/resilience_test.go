@@ -0,0 +1,164 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryReads(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"id":1,"name":"Hoodie","quantity":3}`))
+	}))
+	defer srv.Close()
+	c := New(srv.URL, WithRetry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}))
+
+	it, err := c.GetItem(context.Background(), 1)
+	if err != nil || it.Name != "Hoodie" || calls != 3 {
+		t.Fatalf("want success on third attempt, got %v after %d calls", err, calls)
+	}
+
+	// adjustments are not idempotent and go once
+	atomic.StoreInt32(&calls, 0)
+	if _, err := c.Adjust(context.Background(), 1, Adjustment{Delta: -1}); err == nil || calls != 1 {
+		t.Fatalf("want one failed adjust, got %v after %d calls", err, calls)
+	}
+}
+
+func TestRetryNotOnClientErrors(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	c := New(srv.URL, WithRetry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+	if _, err := c.GetItem(context.Background(), 1); !errors.Is(err, ErrNotFound) || calls != 1 {
+		t.Fatalf("want not found without retries, got %v after %d calls", err, calls)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	var down int32 = 1
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+	b := NewBreaker(BreakerConfig{Failures: 2, OpenFor: time.Minute})
+	now := time.Unix(1000, 0)
+	b.now = func() time.Time { return now }
+	c := New(srv.URL, WithBreaker(b))
+	ctx := context.Background()
+
+	c.ListItems(ctx)
+	c.ListItems(ctx)
+	if st := c.Status().Breaker; st.State != BreakerOpen || st.Failures != 2 {
+		t.Fatalf("want open breaker, got %+v", st)
+	}
+	if _, err := c.ListItems(ctx); !errors.Is(err, ErrCircuitOpen) || !Unavailable(err) || calls != 2 {
+		t.Fatalf("want fail fast, got %v after %d calls", err, calls)
+	}
+
+	// after OpenFor one trial goes through; failing it opens the breaker again
+	now = now.Add(time.Minute)
+	if st := b.Status(); st.State != BreakerHalfOpen {
+		t.Fatalf("want half open, got %+v", st)
+	}
+	if _, err := c.ListItems(ctx); errors.Is(err, ErrCircuitOpen) || calls != 3 {
+		t.Fatalf("want trial call, got %v after %d calls", err, calls)
+	}
+	if _, err := c.ListItems(ctx); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("want open again after failed trial, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	atomic.StoreInt32(&down, 0)
+	if _, err := c.ListItems(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if st := b.Status(); st.State != BreakerClosed || st.Failures != 0 {
+		t.Fatalf("want closed after successful trial, got %+v", st)
+	}
+}
+
+func TestBreakerTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	b := NewBreaker(BreakerConfig{Failures: 2, OpenFor: time.Minute})
+	c := New(srv.URL, WithBreaker(b))
+
+	// a caller giving up is not the service's fault
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := c.ListItems(ctx); err == nil {
+		t.Fatal("want cancelled call to fail")
+	}
+	if st := b.Status(); st.State != BreakerClosed || st.Failures != 0 {
+		t.Fatalf("want cancellation not counted, got %+v", st)
+	}
+
+	// calls that hang until their deadline are failures
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := c.ListItems(ctx)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("want deadline exceeded, got %v", err)
+		}
+	}
+	if st := b.Status(); st.State != BreakerOpen || st.Failures != 2 {
+		t.Fatalf("want breaker opened by timeouts, got %+v", st)
+	}
+}
+
+func TestBulkhead(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+	defer close(release)
+	c := New(srv.URL, WithBulkhead(1, 10*time.Millisecond))
+
+	done := make(chan error)
+	go func() {
+		_, err := c.ListItems(context.Background())
+		done <- err
+	}()
+	for c.Status().InFlight == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := c.ListItems(context.Background()); !errors.Is(err, ErrBulkheadFull) {
+		t.Fatalf("want bulkhead full, got %v", err)
+	}
+	release <- struct{}{}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if st := c.Status(); st.InFlight != 0 || st.MaxConcurrent != 1 {
+		t.Fatalf("status %+v", st)
+	}
+}
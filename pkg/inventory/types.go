@@ -0,0 +1,137 @@
+package inventory
+
+// Item is a product in inventory. Bundles have Components and no stock of
+// their own; their Quantity is how many can be assembled. Available is how much
+// of Quantity can be sold, leaving out units in expired lots.
+type Item struct {
+	ID            int         `json:"id"`
+	Name          string      `json:"name"`
+	Quantity      int         `json:"quantity"`
+	Available     int         `json:"available"`
+	Price         float64     `json:"price"`
+	SerialTracked bool        `json:"serial_tracked"`
+	CostingMethod string      `json:"costing_method,omitempty"`
+	StockValue    float64     `json:"stock_value"`
+	UnitCost      float64     `json:"unit_cost"`
+	Components    []Component `json:"components,omitempty"`
+	// BackorderPolicy is "backorder" or "preorder" if orders beyond stock are
+	// accepted; AvailableAt is when stock is expected (YYYY-MM-DD)
+	BackorderPolicy string `json:"backorder_policy,omitempty"`
+	AvailableAt     string `json:"available_at,omitempty"`
+	Category        string `json:"category,omitempty"`
+
+	WeightKg float64 `json:"weight_kg,omitempty"`
+	LengthCm float64 `json:"length_cm,omitempty"`
+	WidthCm  float64 `json:"width_cm,omitempty"`
+	HeightCm float64 `json:"height_cm,omitempty"`
+}
+
+// Component is one line of a bundle's bill of components
+type Component struct {
+	ItemID   int `json:"item_id"`
+	Quantity int `json:"quantity"`
+}
+
+// NewItem is an item to create; Quantity is received at UnitCost
+type NewItem struct {
+	Name            string      `json:"name"`
+	Quantity        int         `json:"quantity"`
+	Price           float64     `json:"price"`
+	UnitCost        float64     `json:"unit_cost,omitempty"`
+	SerialTracked   bool        `json:"serial_tracked,omitempty"`
+	CostingMethod   string      `json:"costing_method,omitempty"`
+	Components      []Component `json:"components,omitempty"`
+	BackorderPolicy string      `json:"backorder_policy,omitempty"`
+	AvailableAt     string      `json:"available_at,omitempty"`
+	Category        string      `json:"category,omitempty"`
+	WeightKg        float64     `json:"weight_kg,omitempty"`
+	LengthCm        float64     `json:"length_cm,omitempty"`
+	WidthCm         float64     `json:"width_cm,omitempty"`
+	HeightCm        float64     `json:"height_cm,omitempty"`
+}
+
+// Adjustment changes an item's stock by Delta. Stock coming back may name the
+// lots, serials and bundle components it returns to and the unit cost it
+// comes back at.
+type Adjustment struct {
+	Delta      int                   `json:"delta"`
+	Reason     string                `json:"reason,omitempty"`
+	Reference  string                `json:"reference,omitempty"`
+	UnitCost   *float64              `json:"unit_cost,omitempty"`
+	Lots       []LotAllocation       `json:"lots,omitempty"`
+	Serials    []string              `json:"serials,omitempty"`
+	Components []ComponentAllocation `json:"components,omitempty"`
+}
+
+// Allocation is what an adjustment took from or returned to stock: lots,
+// serials, bundle components, and their total cost
+type Allocation struct {
+	Lots       []LotAllocation       `json:"lots,omitempty"`
+	Serials    []string              `json:"serials,omitempty"`
+	Components []ComponentAllocation `json:"components,omitempty"`
+	Cost       float64               `json:"cost"`
+}
+
+// Adjusted is an item after an adjustment, with what the adjustment took from
+// or returned to stock. Components are the allocation's, not the bundle's bill
+// of components.
+type Adjusted struct {
+	Item
+	Lots       []LotAllocation       `json:"lots,omitempty"`
+	Serials    []string              `json:"serials,omitempty"`
+	Components []ComponentAllocation `json:"components,omitempty"`
+	Cost       float64               `json:"cost"`
+}
+
+// Allocation is the part of the adjustment that concerns stock
+func (a *Adjusted) Allocation() Allocation {
+	return Allocation{Lots: a.Lots, Serials: a.Serials, Components: a.Components, Cost: a.Cost}
+}
+
+// LotAllocation is a quantity taken from or returned to one lot
+type LotAllocation struct {
+	LotID     int    `json:"lot_id"`
+	LotNumber string `json:"lot_number"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	Quantity  int    `json:"quantity"`
+}
+
+// ComponentAllocation is what an adjustment of a bundle took from or returned
+// to one of its components
+type ComponentAllocation struct {
+	ItemID   int             `json:"item_id"`
+	Quantity int             `json:"quantity"`
+	Lots     []LotAllocation `json:"lots,omitempty"`
+	Serials  []string        `json:"serials,omitempty"`
+	Cost     float64         `json:"cost"`
+}
+
+// Serial is a serial number known to inventory
+type Serial struct {
+	Serial   string `json:"serial"`
+	ItemID   int    `json:"item_id"`
+	Status   string `json:"status"`
+	Received int64  `json:"received_unix"`
+}
+
+// Backorder statuses
+const (
+	BackorderOpen      = "open"
+	BackorderFulfilled = "fulfilled"
+	BackorderCancelled = "cancelled"
+)
+
+// Backorder is stock promised beyond what is on hand. Kind is "backorder" or
+// "preorder"; once fulfilled it carries what it took from stock.
+type Backorder struct {
+	ID         int    `json:"id"`
+	ItemID     int    `json:"item_id"`
+	Quantity   int    `json:"quantity"`
+	Kind       string `json:"kind"`
+	Reference  string `json:"reference,omitempty"`
+	Status     string `json:"status"`
+	ExpectedAt string `json:"expected_at,omitempty"`
+	Created    int64  `json:"created_unix"`
+	Fulfilled  int64  `json:"fulfilled_unix,omitempty"`
+	Allocation
+}
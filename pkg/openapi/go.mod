@@ -0,0 +1,3 @@
+module inventoryshop/pkg/openapi
+
+go 1.20
@@ -0,0 +1,303 @@
+// Package openapi serves an OpenAPI 3.0 document and validates requests
+// against it.
+//
+// Only what the services' documents use is understood: path, query and JSON
+// body parameters described by schemas with types, formats, enums, bounds,
+// required properties and $refs to components. Requests for paths or methods
+// the document does not describe are passed on untouched, so the handlers
+// still answer them with 404 or 405.
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// FieldError is one way a request does not match the document. In is where
+// the field is: "path", "query" or "body". Field is empty for the body as a
+// whole, or a path into it like "items[0].quantity".
+type FieldError struct {
+	In      string `json:"in"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	if e.Field == "" {
+		return e.In + ": " + e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// Spec is a loaded OpenAPI document
+type Spec struct {
+	raw        []byte
+	routes     []route
+	schemas    map[string]*Schema
+	parameters map[string]*Parameter
+}
+
+// Parameter is a path or query parameter of an operation
+type Parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool `json:"required"`
+	Content  map[string]struct {
+		Schema *Schema `json:"schema"`
+	} `json:"content"`
+}
+
+type operation struct {
+	Parameters  []*Parameter `json:"parameters"`
+	RequestBody *requestBody `json:"requestBody"`
+}
+
+// route is one path of the document with the operations on it
+type route struct {
+	segments []string
+	literals int
+	ops      map[string]*operation
+}
+
+var methods = []string{"get", "put", "post", "delete", "patch", "head", "options"}
+
+// MaxBodyBytes is the largest request body Validate reads
+const MaxBodyBytes = 1 << 20
+
+// Load parses a document and checks that every $ref in it resolves
+func Load(doc []byte) (*Spec, error) {
+	var d struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas    map[string]*Schema    `json:"schemas"`
+			Parameters map[string]*Parameter `json:"parameters"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	if !strings.HasPrefix(d.OpenAPI, "3.0") {
+		return nil, fmt.Errorf("openapi: unsupported version %q", d.OpenAPI)
+	}
+	s := &Spec{raw: doc, schemas: d.Components.Schemas, parameters: d.Components.Parameters}
+	for path, item := range d.Paths {
+		var shared []*Parameter
+		if p, ok := item["parameters"]; ok {
+			if err := json.Unmarshal(p, &shared); err != nil {
+				return nil, fmt.Errorf("openapi: %s: %w", path, err)
+			}
+		}
+		r := route{segments: strings.Split(strings.Trim(path, "/"), "/"), ops: make(map[string]*operation)}
+		for _, seg := range r.segments {
+			if !isParam(seg) {
+				r.literals++
+			}
+		}
+		for _, m := range methods {
+			raw, ok := item[m]
+			if !ok {
+				continue
+			}
+			op := new(operation)
+			if err := json.Unmarshal(raw, op); err != nil {
+				return nil, fmt.Errorf("openapi: %s %s: %w", strings.ToUpper(m), path, err)
+			}
+			op.Parameters = append(append([]*Parameter(nil), shared...), op.Parameters...)
+			r.ops[strings.ToUpper(m)] = op
+		}
+		s.routes = append(s.routes, r)
+	}
+	// the most specific path wins, so /items/stream is not taken for /items/{id}
+	sort.Slice(s.routes, func(i, j int) bool { return s.routes[i].literals > s.routes[j].literals })
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MustLoad is like Load but panics if the document cannot be loaded
+func MustLoad(doc []byte) *Spec {
+	s, err := Load(doc)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// check resolves every reference and compiles patterns up front
+func (s *Spec) check() error {
+	for _, r := range s.routes {
+		for m, op := range r.ops {
+			where := m + " /" + strings.Join(r.segments, "/")
+			for i, p := range op.Parameters {
+				p, err := s.parameter(p)
+				if err != nil {
+					return fmt.Errorf("openapi: %s: %w", where, err)
+				}
+				op.Parameters[i] = p
+				if err := s.prepare(p.Schema, make(map[*Schema]bool)); err != nil {
+					return fmt.Errorf("openapi: %s: parameter %s: %w", where, p.Name, err)
+				}
+			}
+			if op.RequestBody != nil {
+				if err := s.prepare(op.RequestBody.schema(), make(map[*Schema]bool)); err != nil {
+					return fmt.Errorf("openapi: %s: request body: %w", where, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Spec) parameter(p *Parameter) (*Parameter, error) {
+	if p.Ref == "" {
+		return p, nil
+	}
+	name := strings.TrimPrefix(p.Ref, "#/components/parameters/")
+	if q, ok := s.parameters[name]; ok && q.Ref == "" {
+		return q, nil
+	}
+	return nil, fmt.Errorf("unresolved $ref %q", p.Ref)
+}
+
+// schema is the JSON schema of a request body, if it takes JSON
+func (b *requestBody) schema() *Schema {
+	if c, ok := b.Content["application/json"]; ok {
+		return c.Schema
+	}
+	return nil
+}
+
+// ServeHTTP serves the document
+func (s *Spec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(s.raw)
+}
+
+// Validate checks a request against the operation the document describes for
+// it, if any. A JSON body of up to MaxBodyBytes is read and put back for the
+// handler.
+func (s *Spec) Validate(r *http.Request) []FieldError {
+	rt, params := s.match(r.URL.Path)
+	if rt == nil {
+		return nil
+	}
+	op := rt.ops[r.Method]
+	if op == nil {
+		return nil
+	}
+	var errs []FieldError
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		var v string
+		switch p.In {
+		case "path":
+			v = params[p.Name]
+		case "query":
+			v = query.Get(p.Name)
+		default:
+			continue
+		}
+		if v == "" {
+			if p.Required {
+				errs = append(errs, FieldError{In: p.In, Field: p.Name, Message: "is required"})
+			}
+			continue
+		}
+		errs = append(errs, s.validateParam(p, v)...)
+	}
+	if op.RequestBody == nil || op.RequestBody.schema() == nil || r.Body == nil {
+		return errs
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, MaxBodyBytes))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return append(errs, FieldError{In: "body", Message: "is too large"})
+	}
+	if err != nil {
+		return append(errs, FieldError{In: "body", Message: "could not be read"})
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			errs = append(errs, FieldError{In: "body", Message: "is required"})
+		}
+		return errs
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return append(errs, FieldError{In: "body", Message: "is not valid JSON"})
+	}
+	return append(errs, s.validate(op.RequestBody.schema(), v, "body", "")...)
+}
+
+// Middleware answers requests that do not match the document with 400 and
+// {"error": "invalid request", "details": [...]} listing every problem found
+func (s *Spec) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errs := s.Validate(r)
+		if len(errs) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		err := json.NewEncoder(w).Encode(struct {
+			Error   string       `json:"error"`
+			Details []FieldError `json:"details"`
+		}{"invalid request", errs})
+		if err != nil {
+			log.Printf("json encode error: %v", err)
+		}
+	})
+}
+
+// match finds the route of a request path and the values of its path parameters
+func (s *Spec) match(path string) (*route, map[string]string) {
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	for i := range s.routes {
+		r := &s.routes[i]
+		if len(r.segments) != len(segs) {
+			continue
+		}
+		params := make(map[string]string)
+		ok := true
+		for j, seg := range r.segments {
+			if isParam(seg) {
+				params[seg[1:len(seg)-1]] = segs[j]
+			} else if seg != segs[j] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return r, params
+		}
+	}
+	return nil, nil
+}
+
+func isParam(seg string) bool {
+	return strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}")
+}
@@ -0,0 +1,175 @@
+package openapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testDoc = `{
+  "openapi": "3.0.3",
+  "info": {"title": "test", "version": "1"},
+  "paths": {
+    "/items": {
+      "get": {
+        "parameters": [{"name": "ids", "in": "query", "schema": {"type": "array", "maxItems": 3, "items": {"type": "integer", "minimum": 1}}}],
+        "responses": {"200": {"description": "ok"}}
+      },
+      "post": {
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/NewItem"}}}},
+        "responses": {"201": {"description": "ok"}}
+      }
+    },
+    "/items/stream": {"get": {"responses": {"200": {"description": "ok"}}}},
+    "/items/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/ID"}],
+      "get": {"responses": {"200": {"description": "ok"}}}
+    }
+  },
+  "components": {
+    "parameters": {"ID": {"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}}},
+    "schemas": {
+      "NewItem": {
+        "type": "object",
+        "required": ["name", "components"],
+        "properties": {
+          "name": {"type": "string", "minLength": 1},
+          "price": {"type": "number", "minimum": 0},
+          "available_at": {"type": "string", "format": "date"},
+          "policy": {"type": "string", "enum": ["backorder", "preorder"]},
+          "unit_cost": {"type": "number", "nullable": true},
+          "components": {"type": "array", "items": {"$ref": "#/components/schemas/Component"}}
+        }
+      },
+      "Component": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["item_id", "quantity"],
+        "properties": {"item_id": {"type": "integer"}, "quantity": {"type": "integer", "minimum": 1}}
+      }
+    }
+  }
+}`
+
+func serve(t *testing.T, method, target, body string) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	var got string
+	h := MustLoad([]byte(testDoc)).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec, got
+}
+
+func details(t *testing.T, rec *httptest.ResponseRecorder) []FieldError {
+	t.Helper()
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("want 400, got %d: %s", rec.Code, rec.Body)
+	}
+	var res struct {
+		Error   string       `json:"error"`
+		Details []FieldError `json:"details"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil || res.Error != "invalid request" {
+		t.Fatalf("bad error response: %v %+v", err, res)
+	}
+	return res.Details
+}
+
+// invalid sends a request that must be refused and returns the problems found
+func invalid(t *testing.T, method, target, body string) []FieldError {
+	t.Helper()
+	rec, _ := serve(t, method, target, body)
+	return details(t, rec)
+}
+
+func TestValidBodyIsPassedOn(t *testing.T) {
+	body := `{"name": "Hat", "price": 9.5, "available_at": "2024-09-01", "unit_cost": null, "components": [{"item_id": 1, "quantity": 2}], "extra": 1}`
+	rec, got := serve(t, http.MethodPost, "/items", body)
+	if rec.Code != http.StatusNoContent || got != body {
+		t.Fatalf("want request passed on with its body, got %d %q", rec.Code, got)
+	}
+}
+
+func TestInvalidBody(t *testing.T) {
+	rec, _ := serve(t, http.MethodPost, "/items", `{"name": "", "price": "1", "available_at": "soon", "policy": "later", "components": [{"item_id": 1.5, "quantity": 0, "x": 1}]}`)
+	want := map[string]string{
+		"name":                   "must not be empty",
+		"price":                  "must be a number",
+		"available_at":           "must be a date, YYYY-MM-DD",
+		"policy":                 "must be one of backorder, preorder",
+		"components[0].item_id":  "must be an integer",
+		"components[0].quantity": "must be at least 1",
+		"components[0].x":        "is not allowed",
+	}
+	got := details(t, rec)
+	if len(got) != len(want) {
+		t.Fatalf("want %d errors, got %+v", len(want), got)
+	}
+	for _, e := range got {
+		if e.In != "body" || want[e.Field] != e.Message {
+			t.Errorf("unexpected error %+v", e)
+		}
+	}
+
+	if got := invalid(t, http.MethodPost, "/items", `{"price": 1}`); len(got) != 2 || got[0].Field != "name" || got[0].Message != "is required" {
+		t.Errorf("want missing name and components, got %+v", got)
+	}
+	if got := invalid(t, http.MethodPost, "/items", `{"name": `); got[0].Message != "is not valid JSON" {
+		t.Errorf("want invalid JSON, got %+v", got)
+	}
+	if got := invalid(t, http.MethodPost, "/items", ``); got[0].Message != "is required" {
+		t.Errorf("want missing body, got %+v", got)
+	}
+	if got := invalid(t, http.MethodPost, "/items", `{"name": "`+strings.Repeat("x", MaxBodyBytes)+`"}`); got[0].Message != "is too large" {
+		t.Errorf("want body too large, got %+v", got)
+	}
+}
+
+func TestParameters(t *testing.T) {
+	if rec, _ := serve(t, http.MethodGet, "/items?ids=1,%202", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("want ids accepted, got %d", rec.Code)
+	}
+	if got := invalid(t, http.MethodGet, "/items?ids=1,x", ""); got[0].Field != "ids[1]" || got[0].In != "query" {
+		t.Errorf("unexpected errors %+v", got)
+	}
+	if got := invalid(t, http.MethodGet, "/items?ids=1,2,3,4", ""); got[0].Message != "must have at most 3 items" {
+		t.Errorf("unexpected errors %+v", got)
+	}
+	if got := invalid(t, http.MethodGet, "/items/abc", ""); got[0].In != "path" || got[0].Field != "id" {
+		t.Errorf("unexpected errors %+v", got)
+	}
+	// literal paths win over parameters, and unknown paths and methods are passed on
+	for _, target := range []string{"/items/stream", "/other/1"} {
+		if rec, _ := serve(t, http.MethodGet, target, ""); rec.Code != http.StatusNoContent {
+			t.Errorf("%s: want passed on, got %d", target, rec.Code)
+		}
+	}
+	if rec, _ := serve(t, http.MethodDelete, "/items/abc", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("want unknown method passed on, got %d", rec.Code)
+	}
+}
+
+func TestLoadChecksRefs(t *testing.T) {
+	doc := strings.Replace(testDoc, "#/components/schemas/Component", "#/components/schemas/Missing", 1)
+	if _, err := Load([]byte(doc)); err == nil || !strings.Contains(err.Error(), "Missing") {
+		t.Fatalf("want unresolved ref error, got %v", err)
+	}
+	if _, err := Load([]byte(`{"openapi": "2.0"}`)); err == nil {
+		t.Fatal("want unsupported version error")
+	}
+}
+
+func TestServeDocument(t *testing.T) {
+	rec := httptest.NewRecorder()
+	MustLoad([]byte(testDoc)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" || rec.Body.String() != testDoc {
+		t.Fatalf("unexpected response %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,327 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Schema is the part of an OpenAPI 3.0 schema object requests are checked
+// against. Keywords it does not list, like descriptions, are ignored.
+type Schema struct {
+	Ref              string             `json:"$ref"`
+	Type             string             `json:"type"`
+	Format           string             `json:"format"`
+	Nullable         bool               `json:"nullable"`
+	Enum             []interface{}      `json:"enum"`
+	Minimum          *float64           `json:"minimum"`
+	Maximum          *float64           `json:"maximum"`
+	ExclusiveMinimum bool               `json:"exclusiveMinimum"`
+	ExclusiveMaximum bool               `json:"exclusiveMaximum"`
+	MinLength        *int               `json:"minLength"`
+	MaxLength        *int               `json:"maxLength"`
+	Pattern          string             `json:"pattern"`
+	Items            *Schema            `json:"items"`
+	MinItems         *int               `json:"minItems"`
+	MaxItems         *int               `json:"maxItems"`
+	Properties       map[string]*Schema `json:"properties"`
+	Required         []string           `json:"required"`
+	AllOf            []*Schema          `json:"allOf"`
+	// AdditionalProperties is false to refuse properties not listed, or a
+	// schema the values of other properties must match
+	AdditionalProperties json.RawMessage `json:"additionalProperties"`
+
+	target  *Schema
+	pattern *regexp.Regexp
+	closed  bool
+	extra   *Schema
+}
+
+// prepare resolves the schema's references and compiles its patterns
+func (s *Spec) prepare(sc *Schema, seen map[*Schema]bool) error {
+	if sc == nil || seen[sc] {
+		return nil
+	}
+	seen[sc] = true
+	if sc.Ref != "" {
+		t, ok := s.schemas[strings.TrimPrefix(sc.Ref, "#/components/schemas/")]
+		if !ok || !strings.HasPrefix(sc.Ref, "#/components/schemas/") {
+			return fmt.Errorf("unresolved $ref %q", sc.Ref)
+		}
+		sc.target = t
+		return s.prepare(t, seen)
+	}
+	if sc.Pattern != "" {
+		re, err := regexp.Compile(sc.Pattern)
+		if err != nil {
+			return err
+		}
+		sc.pattern = re
+	}
+	if len(sc.AdditionalProperties) > 0 {
+		if string(sc.AdditionalProperties) == "false" {
+			sc.closed = true
+		} else if string(sc.AdditionalProperties) != "true" {
+			sc.extra = new(Schema)
+			if err := json.Unmarshal(sc.AdditionalProperties, sc.extra); err != nil {
+				return fmt.Errorf("additionalProperties: %w", err)
+			}
+		}
+	}
+	children := append([]*Schema{sc.Items, sc.extra}, sc.AllOf...)
+	for _, p := range sc.Properties {
+		children = append(children, p)
+	}
+	for _, c := range children {
+		if err := s.prepare(c, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve follows a schema's references
+func resolve(sc *Schema) *Schema {
+	for sc != nil && sc.target != nil {
+		sc = sc.target
+	}
+	return sc
+}
+
+// validateParam checks a path or query parameter. Arrays are given as comma
+// separated values, e.g. ?ids=1,2,3.
+func (s *Spec) validateParam(p *Parameter, v string) []FieldError {
+	sc := resolve(p.Schema)
+	if sc == nil {
+		return nil
+	}
+	if sc.Type != "array" {
+		val, ok := parseValue(sc, v)
+		if !ok {
+			return []FieldError{{In: p.In, Field: p.Name, Message: typeMessage(sc.Type)}}
+		}
+		return s.validate(sc, val, p.In, p.Name)
+	}
+	items := resolve(sc.Items)
+	parts := strings.Split(v, ",")
+	list := make([]interface{}, len(parts))
+	for i, part := range parts {
+		val, ok := parseValue(items, strings.TrimSpace(part))
+		if !ok {
+			return []FieldError{{In: p.In, Field: fmt.Sprintf("%s[%d]", p.Name, i), Message: typeMessage(items.Type)}}
+		}
+		list[i] = val
+	}
+	return s.validate(sc, list, p.In, p.Name)
+}
+
+// parseValue reads a parameter value as the type of its schema
+func parseValue(sc *Schema, v string) (interface{}, bool) {
+	if sc == nil {
+		return v, true
+	}
+	switch sc.Type {
+	case "integer":
+		_, err := strconv.ParseInt(v, 10, 64)
+		return json.Number(v), err == nil
+	case "number":
+		_, err := strconv.ParseFloat(v, 64)
+		return json.Number(v), err == nil
+	case "boolean":
+		b, err := strconv.ParseBool(v)
+		return b, err == nil
+	}
+	return v, true
+}
+
+// validate checks a value decoded from JSON, with numbers as json.Number,
+// against a schema
+func (s *Spec) validate(sc *Schema, v interface{}, in, field string) []FieldError {
+	sc = resolve(sc)
+	if sc == nil {
+		return nil
+	}
+	fail := func(format string, args ...interface{}) []FieldError {
+		return []FieldError{{In: in, Field: field, Message: fmt.Sprintf(format, args...)}}
+	}
+	var errs []FieldError
+	for _, sub := range sc.AllOf {
+		errs = append(errs, s.validate(sub, v, in, field)...)
+	}
+	if v == nil {
+		if sc.Nullable || sc.Type == "" {
+			return errs
+		}
+		return append(errs, fail("must not be null")...)
+	}
+	if !hasType(sc.Type, v) {
+		return append(errs, fail(typeMessage(sc.Type))...)
+	}
+	if len(sc.Enum) > 0 && !inEnum(sc.Enum, v) {
+		vals := make([]string, len(sc.Enum))
+		for i, e := range sc.Enum {
+			vals[i] = fmt.Sprint(e)
+		}
+		return append(errs, fail("must be one of %s", strings.Join(vals, ", "))...)
+	}
+
+	switch v := v.(type) {
+	case json.Number:
+		n, _ := v.Float64()
+		if m := sc.Minimum; m != nil && (n < *m || sc.ExclusiveMinimum && n == *m) {
+			if sc.ExclusiveMinimum {
+				return append(errs, fail("must be greater than %v", *m)...)
+			}
+			return append(errs, fail("must be at least %v", *m)...)
+		}
+		if m := sc.Maximum; m != nil && (n > *m || sc.ExclusiveMaximum && n == *m) {
+			if sc.ExclusiveMaximum {
+				return append(errs, fail("must be less than %v", *m)...)
+			}
+			return append(errs, fail("must be at most %v", *m)...)
+		}
+	case string:
+		if n := utf8.RuneCountInString(v); sc.MinLength != nil && n < *sc.MinLength {
+			if *sc.MinLength == 1 {
+				return append(errs, fail("must not be empty")...)
+			}
+			return append(errs, fail("must be at least %d characters long", *sc.MinLength)...)
+		} else if sc.MaxLength != nil && n > *sc.MaxLength {
+			return append(errs, fail("must be at most %d characters long", *sc.MaxLength)...)
+		}
+		if sc.pattern != nil && !sc.pattern.MatchString(v) {
+			return append(errs, fail("must match %s", sc.Pattern)...)
+		}
+		if msg := checkFormat(sc.Format, v); msg != "" {
+			return append(errs, fail(msg)...)
+		}
+	case []interface{}:
+		if sc.MinItems != nil && len(v) < *sc.MinItems {
+			if *sc.MinItems == 1 {
+				return append(errs, fail("must not be empty")...)
+			}
+			return append(errs, fail("must have at least %d items", *sc.MinItems)...)
+		}
+		if sc.MaxItems != nil && len(v) > *sc.MaxItems {
+			return append(errs, fail("must have at most %d items", *sc.MaxItems)...)
+		}
+		for i, e := range v {
+			errs = append(errs, s.validate(sc.Items, e, in, fmt.Sprintf("%s[%d]", field, i))...)
+		}
+	case map[string]interface{}:
+		for _, name := range sc.Required {
+			if _, ok := v[name]; !ok {
+				errs = append(errs, FieldError{In: in, Field: join(field, name), Message: "is required"})
+			}
+		}
+		for _, name := range sortedKeys(v) {
+			if p, ok := sc.Properties[name]; ok {
+				errs = append(errs, s.validate(p, v[name], in, join(field, name))...)
+			} else if sc.extra != nil {
+				errs = append(errs, s.validate(sc.extra, v[name], in, join(field, name))...)
+			} else if sc.closed {
+				errs = append(errs, FieldError{In: in, Field: join(field, name), Message: "is not allowed"})
+			}
+		}
+	}
+	return errs
+}
+
+func hasType(typ string, v interface{}) bool {
+	switch typ {
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := n.Int64()
+		return err == nil
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	}
+	return true
+}
+
+func typeMessage(typ string) string {
+	switch typ {
+	case "integer", "array", "object":
+		return "must be an " + typ
+	}
+	return "must be a " + typ
+}
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		switch e := e.(type) {
+		case float64:
+			if n, ok := v.(json.Number); ok {
+				if f, err := n.Float64(); err == nil && f == e {
+					return true
+				}
+			}
+		default:
+			if e == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkFormat returns what is wrong with a string of the given format, if anything
+func checkFormat(format, v string) string {
+	switch format {
+	case "date":
+		if _, err := time.Parse("2006-01-02", v); err != nil {
+			return "must be a date, YYYY-MM-DD"
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			return "must be an RFC 3339 time"
+		}
+	case "email":
+		if i := strings.Index(v, "@"); i <= 0 || i == len(v)-1 {
+			return "must be an email address"
+		}
+	case "uri":
+		if u, err := url.Parse(v); err != nil || u.Scheme == "" || u.Host == "" {
+			return "must be an absolute URL"
+		}
+	}
+	return ""
+}
+
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -4,29 +4,40 @@ import { InventoryList } from './components/InventoryList.tsx';
 import { AddItemForm } from './components/AddItemForm.tsx';
 import { CreateOrderForm } from './components/CreateOrderForm.tsx';
 import { OrdersList } from './components/OrdersList.tsx';
+import { TokenForm } from './components/TokenForm.tsx';
+import { getOrdersToken, setOrdersToken } from './services/api.ts';
 
 type Tab = 'inventory' | 'orders';
 
 function App() {
   const [activeTab, setActiveTab] = useState<Tab>('inventory');
   const [refreshTrigger, setRefreshTrigger] = useState(0);
+  const [ordersToken, setToken] = useState(getOrdersToken);
 
   const handleItemChange = () => {
     setRefreshTrigger((prev) => prev + 1);
   };
 
+  const handleTokenChange = (token: string) => {
+    setOrdersToken(token);
+    setToken(token);
+  };
+
   return (
     <div className="min-h-screen flex flex-col bg-gradient-to-br from-gray-50 to-gray-100">
       <header className="bg-white border-b border-gray-200 shadow-sm">
         <div className="max-w-7xl mx-auto px-4 sm:px-6 lg:px-8 py-6">
-          <div className="flex items-center gap-3">
-            <div className="bg-blue-600 p-2.5 rounded-lg">
-              <Store className="w-6 h-6 text-white" />
-            </div>
-            <div>
-              <h1 className="text-2xl font-bold text-gray-900">Store Manager</h1>
-              <p className="text-sm text-gray-600">Inventory & Order Management System</p>
+          <div className="flex items-center justify-between gap-3">
+            <div className="flex items-center gap-3">
+              <div className="bg-blue-600 p-2.5 rounded-lg">
+                <Store className="w-6 h-6 text-white" />
+              </div>
+              <div>
+                <h1 className="text-2xl font-bold text-gray-900">Store Manager</h1>
+                <p className="text-sm text-gray-600">Inventory & Order Management System</p>
+              </div>
             </div>
+            <TokenForm token={ordersToken} onChange={handleTokenChange} />
           </div>
         </div>
       </header>
@@ -72,8 +83,14 @@ function App() {
           </div>
         )}
 
-        {activeTab === 'orders' && (
-          <div className="grid lg:grid-cols-3 gap-8">
+        {activeTab === 'orders' && !ordersToken && (
+          <div className="bg-blue-50 border border-blue-200 rounded-lg p-4">
+            <p className="text-blue-800">Sign in with an orders token to see and place orders.</p>
+          </div>
+        )}
+
+        {activeTab === 'orders' && ordersToken && (
+          <div key={ordersToken} className="grid lg:grid-cols-3 gap-8">
             <div className="lg:col-span-2">
               <OrdersList refreshTrigger={refreshTrigger} />
             </div>
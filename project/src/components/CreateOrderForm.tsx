@@ -1,6 +1,6 @@
 import { useState, useEffect } from 'react';
 import { ShoppingCart, Plus, Trash2, Loader2 } from 'lucide-react';
-import type { Item } from '../types';
+import type { Item, OrderQuote } from '../types';
 import { api } from '../services/api.ts';
 
 interface OrderItemInput {
@@ -15,6 +15,9 @@ interface CreateOrderFormProps {
 export function CreateOrderForm({ onOrderCreated }: CreateOrderFormProps) {
   const [items, setItems] = useState<Item[]>([]);
   const [orderItems, setOrderItems] = useState<OrderItemInput[]>([{ id: 0, quantity: 1 }]);
+  const [promoCode, setPromoCode] = useState('');
+  const [quote, setQuote] = useState<OrderQuote | null>(null);
+  const [quoteError, setQuoteError] = useState<string | null>(null);
   const [loading, setLoading] = useState(false);
   const [loadingItems, setLoadingItems] = useState(true);
   const [error, setError] = useState<string | null>(null);
@@ -27,7 +30,7 @@ export function CreateOrderForm({ onOrderCreated }: CreateOrderFormProps) {
     try {
       setLoadingItems(true);
       const data = await api.getItems();
-      setItems(data.filter((item) => item.quantity > 0));
+      setItems(data.filter((item) => item.available > 0));
     } catch (err) {
       setError('Ошибка при загрузке доступных товаров');
     } finally {
@@ -35,6 +38,35 @@ export function CreateOrderForm({ onOrderCreated }: CreateOrderFormProps) {
     }
   };
 
+  // ask the server for an accurate preview once the form settles
+  useEffect(() => {
+    const validItems = orderItems.filter((item) => item.id > 0 && item.quantity > 0);
+    if (validItems.length === 0) {
+      setQuote(null);
+      setQuoteError(null);
+      return;
+    }
+    let cancelled = false;
+    const timer = setTimeout(async () => {
+      try {
+        const data = await api.quoteOrder({ items: validItems, promo_code: promoCode.trim() || undefined });
+        if (!cancelled) {
+          setQuote(data);
+          setQuoteError(null);
+        }
+      } catch (err) {
+        if (!cancelled) {
+          setQuote(null);
+          setQuoteError(err instanceof Error ? err.message : null);
+        }
+      }
+    }, 300);
+    return () => {
+      cancelled = true;
+      clearTimeout(timer);
+    };
+  }, [orderItems, promoCode]);
+
   const addOrderItem = () => {
     setOrderItems([...orderItems, { id: 0, quantity: 1 }]);
   };
@@ -72,8 +104,9 @@ export function CreateOrderForm({ onOrderCreated }: CreateOrderFormProps) {
     try {
       setLoading(true);
       setError(null);
-      await api.createOrder({ items: validItems });
+      await api.createOrder({ items: validItems, promo_code: promoCode.trim() || undefined });
       setOrderItems([{ id: 0, quantity: 1 }]);
+      setPromoCode('');
       onOrderCreated();
     } catch (err) {
       setError(err instanceof Error ? err.message : 'Не удалось создать заказ');
@@ -130,7 +163,7 @@ export function CreateOrderForm({ onOrderCreated }: CreateOrderFormProps) {
                 <option value={0}>Select item...</option>
                 {items.map((item) => (
                   <option key={item.id} value={item.id}>
-                    {item.name} - ${item.price.toFixed(2)} ({item.quantity} available)
+                    {item.name} - ${item.price.toFixed(2)} ({item.available} available)
                   </option>
                 ))}
               </select>
@@ -142,7 +175,7 @@ export function CreateOrderForm({ onOrderCreated }: CreateOrderFormProps) {
                 value={orderItem.quantity}
                 onChange={(e) => updateOrderItem(index, 'quantity', parseInt(e.target.value, 10) || 0)}
                 min={1}
-                max={items.find((i) => i.id === orderItem.id)?.quantity || 1}
+                max={items.find((i) => i.id === orderItem.id)?.available || 1}
                 className="w-full h-12 px-3 py-2 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-blue-500 outline-none transition text-center"
                 disabled={loading}
               />
@@ -173,17 +206,38 @@ export function CreateOrderForm({ onOrderCreated }: CreateOrderFormProps) {
         Добавить ещё товар
       </button>
 
+      <input
+        type="text"
+        value={promoCode}
+        onChange={(e) => setPromoCode(e.target.value)}
+        placeholder="Промокод"
+        className="w-full h-12 px-4 mb-4 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-blue-500 outline-none transition"
+        disabled={loading}
+      />
+
       <div className="border-t border-gray-200 pt-4 mb-4">
         <div className="flex justify-between items-center">
           <span className="text-lg font-semibold text-gray-900">Предполагаемая сумма:</span>
-          <span className="text-2xl font-bold text-blue-600">${calculateTotal().toFixed(2)}</span>
+          <span className="text-2xl font-bold text-blue-600">${(quote ? quote.total : calculateTotal()).toFixed(2)}</span>
         </div>
+        {quote && quote.tax > 0 && (
+          <p className="text-xs text-gray-500 mt-1">Налог: ${quote.tax.toFixed(2)}</p>
+        )}
+        {quote?.problems.map((problem, index) => (
+          <p
+            key={index}
+            className={`text-sm mt-1 ${problem.code === 'backorder' ? 'text-amber-700' : 'text-red-700'}`}
+          >
+            {problem.message}
+          </p>
+        ))}
+        {quoteError && <p className="text-sm text-red-700 mt-1">{quoteError}</p>}
         <p className="text-xs text-gray-500 mt-1">Окончательная сумма будет рассчитана при подтверждении заказа</p>
       </div>
 
       <button
         type="submit"
-        disabled={loading}
+        disabled={loading || (quote !== null && !quote.ok)}
         className="w-full bg-blue-600 hover:bg-blue-700 disabled:opacity-50 disabled:cursor-not-allowed text-white font-medium py-2.5 px-4 rounded-lg transition-colors flex items-center justify-center gap-2"
       >
         {loading ? (
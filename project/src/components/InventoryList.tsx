@@ -1,4 +1,4 @@
-import { useState, useEffect } from 'react';
+import { useState, useEffect, useRef } from 'react';
 import { Package, Plus, Minus, RefreshCw, Loader2 } from 'lucide-react';
 import type { Item } from '../types';
 import { api } from '../services/api.ts';
@@ -31,6 +31,25 @@ export function InventoryList({ onItemsChange }: InventoryListProps) {
     loadItems();
   }, []);
 
+  // live stock levels; bundles are refetched since their stock follows their components
+  const itemsRef = useRef<Item[]>([]);
+  itemsRef.current = items;
+  useEffect(() => {
+    return api.streamItems({
+      onCreated: (item) => setItems((prev) => (prev.some((it) => it.id === item.id) ? prev : [...prev, item])),
+      onAdjusted: (change) => {
+        setItems((prev) => prev.map((it) => (it.id === change.item_id ? { ...it, quantity: change.quantity } : it)));
+        itemsRef.current
+          .filter((it) => it.components?.some((c) => c.item_id === change.item_id))
+          .forEach((bundle) => {
+            api.getItem(bundle.id)
+              .then((fresh) => setItems((prev) => prev.map((it) => (it.id === fresh.id ? fresh : it))))
+              .catch(() => {});
+          });
+      },
+    });
+  }, []);
+
   const handleAdjust = async (id: number, delta: number) => {
     try {
       setAdjusting(id);
@@ -1,5 +1,5 @@
 import { useState, useEffect } from 'react';
-import { Receipt, RefreshCw, Loader2, Calendar, Package } from 'lucide-react';
+import { Receipt, RefreshCw, Loader2, Calendar, Package, FileText } from 'lucide-react';
 import type { Order } from '../types';
 import { api } from '../services/api.ts';
 
@@ -29,6 +29,25 @@ export function OrdersList({ refreshTrigger }: OrdersListProps) {
     loadOrders();
   }, [refreshTrigger]);
 
+  // new orders and status changes show up as they happen, without a spinner
+  useEffect(() => {
+    const refresh = () => {
+      api.getOrders()
+        .then((data) => setOrders(data.sort((a, b) => b.created_unix - a.created_unix)))
+        .catch(() => {});
+    };
+    return api.orderFeed(refresh, refresh);
+  }, []);
+
+  const openInvoice = async (id: number) => {
+    try {
+      const blob = await api.getInvoice(id);
+      window.open(URL.createObjectURL(blob), '_blank');
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Failed to load invoice');
+    }
+  };
+
   const formatDate = (unixTimestamp: number) => {
     const date = new Date(unixTimestamp * 1000);
     return date.toLocaleString('en-US', {
@@ -104,6 +123,21 @@ export function OrdersList({ refreshTrigger }: OrdersListProps) {
               <div className="text-right">
                 <div className="text-2xl font-bold text-blue-600">${order.total.toFixed(2)}</div>
                 <div className="text-sm text-gray-600">{order.items.length} item(s)</div>
+                {order.status === 'pending_payment' && (
+                  <div className="text-xs text-amber-700">Awaiting payment</div>
+                )}
+                {order.payment && order.status !== 'pending_payment' && (
+                  <div className="text-xs text-gray-500">Payment: {order.payment.status}</div>
+                )}
+                {order.status === 'created' && (
+                  <button
+                    onClick={() => openInvoice(order.id)}
+                    className="mt-1 text-xs text-blue-600 hover:text-blue-700 inline-flex items-center gap-1"
+                  >
+                    <FileText className="w-3 h-3" />
+                    Invoice
+                  </button>
+                )}
               </div>
             </div>
 
@@ -116,6 +150,12 @@ export function OrdersList({ refreshTrigger }: OrdersListProps) {
                   <div className="flex items-center gap-2">
                     <Package className="w-4 h-4 text-gray-400" />
                     <span className="text-gray-700">{item.name || `Item #${item.item_id}`}</span>
+                    {item.status && item.status !== 'reserved' && (
+                      <span className="text-xs px-2 py-0.5 rounded-full bg-amber-100 text-amber-700">
+                        {item.status === 'preordered' ? 'Pre-order' : 'Backordered'}
+                        {item.expected_at && ` · expected ${item.expected_at}`}
+                      </span>
+                    )}
                   </div>
                   <div className="flex items-center gap-3">
                     <span className="text-gray-600">Qty: {item.quantity}</span>
@@ -125,6 +165,38 @@ export function OrdersList({ refreshTrigger }: OrdersListProps) {
                   </div>
                 </div>
               ))}
+              {order.items.flatMap((item) => item.adjustments ?? []).map((adj, index) => (
+                <div key={`adj-${index}`} className="flex justify-between items-center text-sm text-green-700">
+                  <span>{adj.name}</span>
+                  <span>{adj.amount < 0 ? '-' : '+'}${Math.abs(adj.amount).toFixed(2)}</span>
+                </div>
+              ))}
+              {order.charges?.map((charge, index) => (
+                <div key={`charge-${index}`} className="flex justify-between items-center text-sm text-gray-600">
+                  <span>Shipping: {charge.name}</span>
+                  <span>{charge.amount > 0 ? `$${charge.amount.toFixed(2)}` : 'Free'}</span>
+                </div>
+              ))}
+              {order.tax > 0 && (
+                <div className="flex justify-between items-center text-sm text-gray-600">
+                  <span>{order.items.some((item) => item.tax?.inclusive) ? 'Incl. tax' : 'Tax'}</span>
+                  <span>${order.tax.toFixed(2)}</span>
+                </div>
+              )}
+              {order.refunds?.map((refund) => (
+                <div key={`refund-${refund.id}`} className="flex justify-between items-center text-sm text-red-700">
+                  <span>Refund (return #{refund.return_id})</span>
+                  <span>-${refund.amount.toFixed(2)}</span>
+                </div>
+              ))}
+              {order.shipping_address && (
+                <div className="text-xs text-gray-500">
+                  Ships to: {order.shipping_address.line1}, {order.shipping_address.city}, {order.shipping_address.country}
+                </div>
+              )}
+              {order.promo_code && (
+                <div className="text-xs text-gray-500">Promo code: {order.promo_code}</div>
+              )}
             </div>
           </div>
         ))}
@@ -0,0 +1,56 @@
+import { useState } from 'react';
+import { KeyRound, LogOut } from 'lucide-react';
+
+interface TokenFormProps {
+  token: string;
+  onChange: (token: string) => void;
+}
+
+// TokenForm sets the token orders are fetched with: the staff admin token
+// (ORDERS_ADMIN_TOKEN, dev-admin-token under docker compose) or a customer's token
+export function TokenForm({ token, onChange }: TokenFormProps) {
+  const [value, setValue] = useState('');
+
+  const handleSubmit = (e: React.FormEvent) => {
+    e.preventDefault();
+    if (!value.trim()) return;
+    onChange(value.trim());
+    setValue('');
+  };
+
+  if (token) {
+    return (
+      <div className="flex items-center gap-3 text-sm text-gray-600">
+        <KeyRound className="w-4 h-4 text-blue-600" />
+        <span>Orders token set</span>
+        <button
+          onClick={() => onChange('')}
+          className="flex items-center gap-1 text-gray-600 hover:text-gray-900 font-medium"
+        >
+          <LogOut className="w-4 h-4" />
+          Sign out
+        </button>
+      </div>
+    );
+  }
+
+  return (
+    <form onSubmit={handleSubmit} className="flex items-center gap-2">
+      <input
+        type="password"
+        value={value}
+        onChange={(e) => setValue(e.target.value)}
+        placeholder="Orders token"
+        aria-label="Orders token"
+        className="px-3 py-1.5 text-sm border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-blue-500 outline-none transition"
+      />
+      <button
+        type="submit"
+        className="bg-blue-600 hover:bg-blue-700 text-white text-sm font-medium py-1.5 px-3 rounded-lg transition-colors flex items-center gap-1"
+      >
+        <KeyRound className="w-4 h-4" />
+        Sign in
+      </button>
+    </form>
+  );
+}
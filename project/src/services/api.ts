@@ -1,6 +1,14 @@
 import type {
   Item,
+  StockAdjusted,
   Order,
+  OrderFeedEvent,
+  OrderQuote,
+  Return,
+  ReturnLine,
+  Cart,
+  ShippingMethod,
+  ShippingQuote,
   CreateItemRequest,
   AdjustQuantityRequest,
   CreateOrderRequest,
@@ -10,6 +18,25 @@ import type {
 const INVENTORY_API_URL = import.meta.env.VITE_INVENTORY_API_URL || 'http://localhost:8001';
 const ORDERS_API_URL = import.meta.env.VITE_ORDERS_API_URL || 'http://localhost:8002';
 
+// The orders API wants a token: the staff admin token (ORDERS_ADMIN_TOKEN), or a
+// customer's token from signing up, which scopes orders to that customer
+export function getOrdersToken(): string {
+  return localStorage.getItem('ordersToken') || '';
+}
+
+export function setOrdersToken(token: string) {
+  if (token) localStorage.setItem('ordersToken', token);
+  else localStorage.removeItem('ordersToken');
+}
+
+function ordersHeaders(json = false): HeadersInit {
+  const headers: Record<string, string> = {};
+  if (json) headers['Content-Type'] = 'application/json';
+  const token = getOrdersToken();
+  if (token) headers['Authorization'] = `Bearer ${token}`;
+  return headers;
+}
+
 class ApiService {
   private async handleResponse<T>(response: Response): Promise<T> {
     if (!response.ok) {
@@ -29,6 +56,18 @@ class ApiService {
     return this.handleResponse<Item>(response);
   }
 
+  // streamItems follows item changes as they happen; the browser resumes the
+  // stream by itself after a dropped connection. Returns a function to stop.
+  streamItems(handlers: {
+    onCreated?: (item: Item) => void;
+    onAdjusted?: (change: StockAdjusted) => void;
+  }): () => void {
+    const source = new EventSource(`${INVENTORY_API_URL}/items/stream`);
+    source.addEventListener('ItemCreated', (e) => handlers.onCreated?.(JSON.parse((e as MessageEvent).data)));
+    source.addEventListener('StockAdjusted', (e) => handlers.onAdjusted?.(JSON.parse((e as MessageEvent).data)));
+    return () => source.close();
+  }
+
   async createItem(data: CreateItemRequest): Promise<Item> {
     const response = await fetch(`${INVENTORY_API_URL}/items`, {
       method: 'POST',
@@ -48,23 +87,155 @@ class ApiService {
   }
 
   async getOrders(): Promise<Order[]> {
-    const response = await fetch(`${ORDERS_API_URL}/orders`);
+    const response = await fetch(`${ORDERS_API_URL}/orders`, { headers: ordersHeaders() });
     return this.handleResponse<Order[]>(response);
   }
 
   async getOrder(id: number): Promise<Order> {
-    const response = await fetch(`${ORDERS_API_URL}/orders/${id}`);
+    const response = await fetch(`${ORDERS_API_URL}/orders/${id}`, { headers: ordersHeaders() });
     return this.handleResponse<Order>(response);
   }
 
+  async getInvoice(id: number, format: 'pdf' | 'html' = 'pdf'): Promise<Blob> {
+    const response = await fetch(`${ORDERS_API_URL}/orders/${id}/invoice?format=${format}`, { headers: ordersHeaders() });
+    if (!response.ok) {
+      const error: ApiError = await response.json().catch(() => ({ error: 'Unknown error' }));
+      throw new Error(error.error || `HTTP ${response.status}`);
+    }
+    return response.blob();
+  }
+
+  // orderFeed follows order events over a WebSocket, all of them for staff and
+  // those of their own orders for customers, reconnecting with the seq of the
+  // last event seen so that nothing is missed. Returns a function to stop.
+  orderFeed(onEvent: (event: OrderFeedEvent) => void, onResync?: () => void): () => void {
+    let socket: WebSocket | null = null;
+    let lastSeq = 0;
+    let stopped = false;
+    const connect = () => {
+      const url = new URL(`${ORDERS_API_URL.replace(/^http/, 'ws')}/orders/feed`);
+      const token = getOrdersToken();
+      if (token) url.searchParams.set('access_token', token);
+      if (lastSeq > 0) url.searchParams.set('since', String(lastSeq));
+      socket = new WebSocket(url);
+      socket.onmessage = (msg) => {
+        const data = JSON.parse(msg.data);
+        if (data.type === 'resync') {
+          lastSeq = 0;
+          onResync?.();
+          return;
+        }
+        lastSeq = data.event.seq;
+        onEvent(data.event);
+      };
+      socket.onclose = () => {
+        if (!stopped) setTimeout(connect, 3000);
+      };
+    };
+    connect();
+    return () => {
+      stopped = true;
+      socket?.close();
+    };
+  }
+
   async createOrder(data: CreateOrderRequest): Promise<Order> {
     const response = await fetch(`${ORDERS_API_URL}/orders`, {
       method: 'POST',
-      headers: { 'Content-Type': 'application/json' },
+      headers: ordersHeaders(true),
       body: JSON.stringify(data),
     });
     return this.handleResponse<Order>(response);
   }
+
+  // prices an order without placing it or touching stock
+  async quoteOrder(data: CreateOrderRequest): Promise<OrderQuote> {
+    const response = await fetch(`${ORDERS_API_URL}/orders/quote`, {
+      method: 'POST',
+      headers: ordersHeaders(true),
+      body: JSON.stringify(data),
+    });
+    return this.handleResponse<OrderQuote>(response);
+  }
+
+  async getOrderReturns(orderId: number): Promise<Return[]> {
+    const response = await fetch(`${ORDERS_API_URL}/orders/${orderId}/returns`, {
+      headers: ordersHeaders(),
+    });
+    return this.handleResponse<Return[]>(response);
+  }
+
+  async requestReturn(orderId: number, lines: ReturnLine[], note?: string): Promise<Return> {
+    const response = await fetch(`${ORDERS_API_URL}/orders/${orderId}/returns`, {
+      method: 'POST',
+      headers: ordersHeaders(true),
+      body: JSON.stringify({ lines, note }),
+    });
+    return this.handleResponse<Return>(response);
+  }
+
+  async createCart(): Promise<Cart> {
+    const response = await fetch(`${ORDERS_API_URL}/carts`, { method: 'POST', headers: ordersHeaders() });
+    return this.handleResponse<Cart>(response);
+  }
+
+  async getCart(id: string): Promise<Cart> {
+    const response = await fetch(`${ORDERS_API_URL}/carts/${id}`, { headers: ordersHeaders() });
+    return this.handleResponse<Cart>(response);
+  }
+
+  async addCartLine(id: string, itemId: number, quantity: number): Promise<Cart> {
+    const response = await fetch(`${ORDERS_API_URL}/carts/${id}/lines`, {
+      method: 'POST',
+      headers: ordersHeaders(true),
+      body: JSON.stringify({ item_id: itemId, quantity }),
+    });
+    return this.handleResponse<Cart>(response);
+  }
+
+  async setCartLine(id: string, itemId: number, quantity: number): Promise<Cart> {
+    const response = await fetch(`${ORDERS_API_URL}/carts/${id}/lines/${itemId}`, {
+      method: 'PUT',
+      headers: ordersHeaders(true),
+      body: JSON.stringify({ quantity }),
+    });
+    return this.handleResponse<Cart>(response);
+  }
+
+  async removeCartLine(id: string, itemId: number): Promise<Cart> {
+    const response = await fetch(`${ORDERS_API_URL}/carts/${id}/lines/${itemId}`, {
+      method: 'DELETE',
+      headers: ordersHeaders(),
+    });
+    return this.handleResponse<Cart>(response);
+  }
+
+  // a 409 means the cart changed; fetch it again to show the warnings
+  async checkoutCart(id: string, options: Omit<CreateOrderRequest, 'items'> = {}): Promise<Order> {
+    const response = await fetch(`${ORDERS_API_URL}/carts/${id}/checkout`, {
+      method: 'POST',
+      headers: ordersHeaders(true),
+      body: JSON.stringify(options),
+    });
+    return this.handleResponse<Order>(response);
+  }
+
+  async getShippingMethods(): Promise<ShippingMethod[]> {
+    const response = await fetch(`${ORDERS_API_URL}/shipping/methods`, {
+      headers: ordersHeaders(),
+    });
+    return this.handleResponse<ShippingMethod[]>(response);
+  }
+
+  // prices every method that can deliver the items to the address or region
+  async quoteShipping(request: CreateOrderRequest): Promise<ShippingQuote[]> {
+    const response = await fetch(`${ORDERS_API_URL}/shipping/quote`, {
+      method: 'POST',
+      headers: ordersHeaders(true),
+      body: JSON.stringify(request),
+    });
+    return this.handleResponse<ShippingQuote[]>(response);
+  }
 }
 
 export const api = new ApiService();
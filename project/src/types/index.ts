@@ -2,7 +2,60 @@ export interface Item {
   id: number;
   name: string;
   quantity: number;
+  // how much of quantity can be sold, leaving out expired lots
+  available: number;
   price: number;
+  serial_tracked: boolean;
+  costing_method: 'fifo' | 'average';
+  stock_value: number;
+  unit_cost: number;
+  components?: Component[];
+  backorder_policy?: 'backorder' | 'preorder';
+  available_at?: string;
+  category?: string;
+  weight_kg?: number;
+  length_cm?: number;
+  width_cm?: number;
+  height_cm?: number;
+}
+
+// StockAdjusted event from the inventory item stream
+export interface StockAdjusted {
+  item_id: number;
+  delta: number;
+  quantity: number;
+  reason: string;
+  reference?: string;
+}
+
+export interface Component {
+  item_id: number;
+  quantity: number;
+}
+
+export interface ComponentAllocation {
+  item_id: number;
+  quantity: number;
+  lots?: LotAllocation[];
+  serials?: string[];
+  cost: number;
+}
+
+export interface Lot {
+  id: number;
+  item_id: number;
+  item_name?: string;
+  lot_number: string;
+  quantity: number;
+  expires_at?: string;
+  received_unix: number;
+}
+
+export interface LotAllocation {
+  lot_id: number;
+  lot_number: string;
+  expires_at?: string;
+  quantity: number;
 }
 
 export interface OrderItem {
@@ -10,19 +63,241 @@ export interface OrderItem {
   name: string;
   quantity: number;
   price: number;
+  cost: number;
+  lots?: LotAllocation[];
+  serials?: string[];
+  components?: ComponentAllocation[];
+  status: 'reserved' | 'backordered' | 'preordered';
+  backorder_id?: number;
+  expected_at?: string;
+  adjustments?: Adjustment[];
+  tax?: LineTax;
+  restock_owed?: boolean;
+}
+
+export interface LineTax {
+  name?: string;
+  rate: number;
+  amount: number;
+  inclusive: boolean;
+}
+
+export interface Adjustment {
+  rule_id: number;
+  name: string;
+  kind: PricingRule['kind'];
+  amount: number;
+}
+
+export interface PriceTier {
+  min_quantity: number;
+  price: number;
+}
+
+export interface PricingRule {
+  id: number;
+  name: string;
+  kind: 'percentage' | 'fixed' | 'buy_x_get_y' | 'tiered';
+  item_id?: number;
+  percent?: number;
+  amount?: number;
+  buy_quantity?: number;
+  get_quantity?: number;
+  tiers?: PriceTier[];
+  code?: string;
+  usage_limit?: number;
+  used: number;
+  starts_unix?: number;
+  expires_unix?: number;
+  active: boolean;
 }
 
 export interface Order {
   id: number;
+  customer_id?: number;
   items: OrderItem[];
+  promo_code?: string;
+  tax_region?: string;
+  shipping_address?: Address;
+  charges?: Charge[];
+  refunds?: Refund[];
+  payment?: Payment;
+  tax: number;
   total: number;
+  status: 'created' | 'cancelled' | 'pending_payment';
+  created_unix: number;
+}
+
+export type ReturnReason =
+  | 'damaged'
+  | 'defective'
+  | 'wrong_item'
+  | 'not_as_described'
+  | 'no_longer_needed'
+  | 'other';
+
+export interface ReturnLine {
+  item_id: number;
+  quantity: number;
+  reason: ReturnReason;
+  serials?: string[];
+  outcome?: 'restock' | 'write_off';
+  refund?: number;
+  restock_owed?: boolean;
+}
+
+export interface Return {
+  id: number;
+  order_id: number;
+  status: 'requested' | 'approved' | 'rejected';
+  lines: ReturnLine[];
+  note?: string;
+  refund: number;
+  refund_owed?: boolean;
+  created_unix: number;
+  updated_unix: number;
+}
+
+export interface Payment {
+  provider: string;
+  reference: string;
+  status: 'pending' | 'authorized' | 'captured' | 'voided' | 'refunded' | 'declined';
+  amount: number;
+  captured: number;
+  refunded: number;
+  message?: string;
+  updated_unix: number;
+}
+
+export interface Refund {
+  id: number;
+  return_id: number;
+  amount: number;
+  created_unix: number;
+}
+
+// event from the orders feed; payload describes the order
+export interface OrderFeedEvent {
+  id: number;
+  seq: number;
+  type: 'OrderCreated' | 'OrderCancelled' | 'OrderStatusChanged';
+  aggregate_id: number;
+  payload: {
+    order_id: number;
+    customer_id?: number;
+    status: string;
+    previous_status?: string;
+    total: number;
+    items: { item_id: number; quantity: number }[];
+  };
+  created_unix: number;
+}
+
+export interface OrderQuote {
+  items: OrderItem[];
+  charges?: Charge[];
+  tax_region?: string;
+  tax: number;
+  total: number;
+  problems: Array<Omit<CartWarning, 'code'> & { code: CartWarning['code'] | 'shipping_unavailable' }>;
+  ok: boolean;
+}
+
+export interface Charge {
+  kind: 'shipping';
+  name: string;
+  method_id?: number;
+  amount: number;
+  tax?: LineTax;
+}
+
+export interface WeightRate {
+  up_to_kg: number;
+  price: number;
+}
+
+export interface ShippingMethod {
+  id: number;
+  name: string;
+  kind: 'flat' | 'weight';
+  rate?: number;
+  weight_rates?: WeightRate[];
+  free_over?: number;
+  regions?: string[];
+  active: boolean;
+}
+
+export interface ShippingQuote {
+  method_id: number;
+  name: string;
+  price: number;
+  weight_kg: number;
+}
+
+export interface CartLine {
+  item_id: number;
+  name: string;
+  quantity: number;
+  price: number;
+  available: number;
+  subtotal: number;
+}
+
+export interface CartWarning {
+  item_id: number;
+  code: 'price_changed' | 'insufficient_stock' | 'out_of_stock' | 'unavailable' | 'backorder';
+  message: string;
+}
+
+export interface Cart {
+  id: string;
+  customer_id?: number;
+  status: 'open' | 'checking_out' | 'checked_out';
+  order_id?: number;
+  lines: CartLine[];
+  total: number;
+  warnings: CartWarning[];
+  created_unix: number;
+  updated_unix: number;
+}
+
+export interface Address {
+  id: number;
+  label?: string;
+  line1: string;
+  line2?: string;
+  city: string;
+  region?: string;
+  postal_code: string;
+  country: string;
+}
+
+export interface Customer {
+  id: number;
+  name: string;
+  email: string;
+  phone?: string;
+  addresses: Address[];
   created_unix: number;
+  // only returned when the customer is created
+  token?: string;
 }
 
 export interface CreateItemRequest {
   name: string;
   quantity: number;
   price: number;
+  serial_tracked?: boolean;
+  unit_cost?: number;
+  costing_method?: 'fifo' | 'average';
+  components?: Component[];
+  backorder_policy?: 'backorder' | 'preorder';
+  available_at?: string;
+  category?: string;
+  weight_kg?: number;
+  length_cm?: number;
+  width_cm?: number;
+  height_cm?: number;
 }
 
 export interface AdjustQuantityRequest {
@@ -34,6 +309,12 @@ export interface CreateOrderRequest {
     id: number;
     quantity: number;
   }>;
+  promo_code?: string;
+  region?: string;
+  shipping_method_id?: number;
+  address_id?: number;
+  shipping_address?: Omit<Address, 'id'>;
+  payment_token?: string;
 }
 
 export interface ApiError {
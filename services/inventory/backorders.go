@@ -0,0 +1,229 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Backorder policies of an item. With no policy orders beyond stock are refused.
+const (
+	PolicyBackorder = "backorder"
+	PolicyPreorder  = "preorder"
+)
+
+// Backorder statuses
+const (
+	BackorderOpen      = "open"
+	BackorderFulfilled = "fulfilled"
+	BackorderCancelled = "cancelled"
+)
+
+var (
+	ErrBackorderNotAllowed = &customError{"item does not accept backorders"}
+	ErrInvalidBackorder    = &customError{"invalid backorder"}
+	ErrBackorderClosed     = &customError{"backorder is not open"}
+)
+
+// Backorder is demand accepted for an item beyond its stock. Open backorders are
+// fulfilled oldest first as stock comes in; the allocation is what they took.
+// ExpectedAt is the item's current expected availability date.
+type Backorder struct {
+	ID         int    `json:"id"`
+	ItemID     int    `json:"item_id"`
+	Quantity   int    `json:"quantity"`
+	Kind       string `json:"kind"`
+	Reference  string `json:"reference,omitempty"`
+	Status     string `json:"status"`
+	ExpectedAt string `json:"expected_at,omitempty"`
+	Created    int64  `json:"created_unix"`
+	Fulfilled  int64  `json:"fulfilled_unix,omitempty"`
+	Allocation
+}
+
+func validBackorderPolicy(p string) bool {
+	return p == "" || p == PolicyBackorder || p == PolicyPreorder
+}
+
+// backorderReference identifies a backorder in the stock movement log when the
+// caller gave no reference of its own
+func backorderReference(b *Backorder) string {
+	if b.Reference != "" {
+		return b.Reference
+	}
+	return fmt.Sprintf("backorder %d", b.ID)
+}
+
+// SetAvailability sets an item's backorder policy and expected availability date
+func (s *Inventory) SetAvailability(itemID int, policy, availableAt string) (*Item, error) {
+	if !validBackorderPolicy(policy) {
+		return nil, ErrInvalidBackorder
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	st, err := lockItemTx(tx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if st.bundle && policy != "" {
+		return nil, ErrBundleStock
+	}
+	var avail interface{}
+	if availableAt != "" {
+		avail = availableAt
+	}
+	if _, err := tx.Exec("UPDATE items SET backorder_policy = $1, available_at = $2 WHERE id = $3", policy, avail, itemID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.Get(itemID)
+}
+
+// CreateBackorder accepts qty units of an item beyond its stock. It is fulfilled
+// right away if the stock allows and no older backorder is waiting.
+func (s *Inventory) CreateBackorder(itemID, qty int, reference string) (*Backorder, error) {
+	if qty <= 0 {
+		return nil, ErrInvalidBackorder
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	st, err := lockItemTx(tx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if st.bundle {
+		return nil, ErrBundleStock
+	}
+	if st.policy == "" {
+		return nil, ErrBackorderNotAllowed
+	}
+	var id int
+	if err := tx.QueryRow(`INSERT INTO backorders (item_id, quantity, kind, reference, status, created_unix)
+	VALUES ($1,$2,$3,$4,$5,$6) RETURNING id`, itemID, qty, st.policy, reference, BackorderOpen, time.Now().Unix()).Scan(&id); err != nil {
+		return nil, err
+	}
+	if err := fulfillBackordersTx(tx, itemID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetBackorder(id)
+}
+
+const backorderColumns = "b.id, b.item_id, b.quantity, b.kind, b.reference, b.status, i.available_at, b.created_unix, b.fulfilled_unix, b.allocation"
+
+func scanBackorder(row rowScanner) (*Backorder, error) {
+	var b Backorder
+	var exp sql.NullTime
+	var alloc []byte
+	if err := row.Scan(&b.ID, &b.ItemID, &b.Quantity, &b.Kind, &b.Reference, &b.Status, &exp, &b.Created, &b.Fulfilled, &alloc); err != nil {
+		return nil, err
+	}
+	if exp.Valid {
+		b.ExpectedAt = exp.Time.Format(dateLayout)
+	}
+	if len(alloc) > 0 {
+		if err := json.Unmarshal(alloc, &b.Allocation); err != nil {
+			return nil, err
+		}
+	}
+	return &b, nil
+}
+
+// GetBackorder looks up a single backorder
+func (s *Inventory) GetBackorder(id int) (*Backorder, error) {
+	b, err := scanBackorder(s.db.QueryRow(`SELECT `+backorderColumns+` FROM backorders b JOIN items i ON i.id = b.item_id
+	WHERE b.id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+// Backorders lists the backorders of an item oldest first, optionally filtered by status
+func (s *Inventory) Backorders(itemID int, status string) ([]*Backorder, error) {
+	rows, err := s.db.Query(`SELECT `+backorderColumns+` FROM backorders b JOIN items i ON i.id = b.item_id
+	WHERE b.item_id = $1 AND ($2 = '' OR b.status = $2) ORDER BY b.id`, itemID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]*Backorder, 0)
+	for rows.Next() {
+		b, err := scanBackorder(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, b)
+	}
+	return res, rows.Err()
+}
+
+// CancelBackorder withdraws an open backorder. A backorder that was fulfilled in
+// the meantime is returned unchanged, so the caller can restock what it took.
+func (s *Inventory) CancelBackorder(id int) (*Backorder, error) {
+	var status string
+	err := s.db.QueryRow("UPDATE backorders SET status = $1 WHERE id = $2 AND status = $3 RETURNING status",
+		BackorderCancelled, id, BackorderOpen).Scan(&status)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	b, err := s.GetBackorder(id)
+	if err != nil {
+		return nil, err
+	}
+	if status == "" && b.Status == BackorderCancelled {
+		return nil, ErrBackorderClosed
+	}
+	return b, nil
+}
+
+// fulfillBackordersTx hands an item's stock to its open backorders, oldest first,
+// stopping at the first one the stock cannot cover so that none is overtaken.
+func fulfillBackordersTx(tx *sql.Tx, itemID int) error {
+	rows, err := tx.Query("SELECT id, quantity, reference FROM backorders WHERE item_id = $1 AND status = $2 ORDER BY id FOR UPDATE",
+		itemID, BackorderOpen)
+	if err != nil {
+		return err
+	}
+	open := make([]*Backorder, 0)
+	for rows.Next() {
+		var b Backorder
+		if err := rows.Scan(&b.ID, &b.Quantity, &b.Reference); err != nil {
+			rows.Close()
+			return err
+		}
+		open = append(open, &b)
+	}
+	rows.Close()
+	for _, b := range open {
+		adj := Adjustment{Delta: -b.Quantity, Reason: ReasonBackorder, Reference: backorderReference(b)}
+		_, alloc, err := adjustTx(tx, itemID, adj)
+		if errors.Is(err, ErrInsufficientStock) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(alloc)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE backorders SET status = $1, fulfilled_unix = $2, allocation = $3 WHERE id = $4",
+			BackorderFulfilled, time.Now().Unix(), data, b.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInventory_Backorders(t *testing.T) {
+	s := NewInventoryInMemory()
+	it := s.CreateItem(Item{Name: "hoodie", Quantity: 1, Price: 20, UnitCost: 5})
+	if _, err := s.CreateBackorder(it.ID, 2, ""); !errors.Is(err, ErrBackorderNotAllowed) {
+		t.Fatalf("expected ErrBackorderNotAllowed, got %v", err)
+	}
+	s.SetAvailability(it.ID, PolicyBackorder, "2030-01-15")
+
+	first, _ := s.CreateBackorder(it.ID, 3, "order 1")
+	second, _ := s.CreateBackorder(it.ID, 1, "")
+	if first.Status != BackorderOpen || first.ExpectedAt != "2030-01-15" {
+		t.Fatalf("expected open backorder expected 2030-01-15, got %+v", first)
+	}
+	// one unit is on hand but the older backorder needs three, so nothing is overtaken
+	if second.Status != BackorderOpen {
+		t.Fatalf("expected second backorder to wait for the first, got %s", second.Status)
+	}
+
+	// receiving stock fulfils the oldest backorders it covers
+	if _, err := s.ReceiveLot(it.ID, "B2", 3, "", costPtr(6)); err != nil {
+		t.Fatalf("unexpected error from ReceiveLot: %v", err)
+	}
+	first, _ = s.GetBackorder(first.ID)
+	second, _ = s.GetBackorder(second.ID)
+	if first.Status != BackorderFulfilled || second.Status != BackorderFulfilled {
+		t.Fatalf("expected both backorders fulfilled, got %s and %s", first.Status, second.Status)
+	}
+	if !almostEqual(first.Cost, 17) || len(first.Lots) != 1 {
+		t.Fatalf("expected first backorder to cost 5+6+6 from one lot, got %+v", first.Allocation)
+	}
+	got, _ := s.Get(it.ID)
+	if got.Quantity != 0 {
+		t.Fatalf("expected all stock handed to backorders, got %d", got.Quantity)
+	}
+	moves, _ := s.Movements(it.ID)
+	if last := moves[len(moves)-1]; last.Reason != ReasonBackorder || last.Reference != "backorder 2" {
+		t.Fatalf("expected last movement for backorder 2, got %+v", last)
+	}
+
+	// a fulfilled backorder is returned as is on cancel, an open one is withdrawn
+	if b, _ := s.CancelBackorder(first.ID); b.Status != BackorderFulfilled {
+		t.Fatalf("expected fulfilled backorder unchanged, got %s", b.Status)
+	}
+	third, _ := s.CreateBackorder(it.ID, 1, "")
+	if b, _ := s.CancelBackorder(third.ID); b.Status != BackorderCancelled {
+		t.Fatalf("expected cancelled backorder, got %s", b.Status)
+	}
+	if _, err := s.CancelBackorder(third.ID); !errors.Is(err, ErrBackorderClosed) {
+		t.Fatalf("expected ErrBackorderClosed, got %v", err)
+	}
+}
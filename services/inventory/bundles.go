@@ -0,0 +1,168 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+// Component is one line of a bundle's bill of components
+type Component struct {
+	ItemID   int `json:"item_id"`
+	Quantity int `json:"quantity"`
+}
+
+// ComponentAllocation is what an adjustment of a bundle took from (or returned
+// to) one of its components
+type ComponentAllocation struct {
+	ItemID   int `json:"item_id"`
+	Quantity int `json:"quantity"`
+	Allocation
+}
+
+var (
+	ErrInvalidBundle = &customError{"invalid bundle components"}
+	ErrBundleStock   = &customError{"bundle stock is derived from its components"}
+)
+
+// bundleQuantity is how many bundles can be assembled from the components in stock
+func bundleQuantity(components []Component, stock map[int]int) int {
+	qty := -1
+	for _, c := range components {
+		n := stock[c.ItemID] / c.Quantity
+		if qty < 0 || n < qty {
+			qty = n
+		}
+	}
+	if qty < 0 {
+		return 0
+	}
+	return qty
+}
+
+// validateComponents checks a bill of components against the items it refers to.
+// Bundles cannot contain other bundles.
+func validateComponents(components []Component, lookup func(id int) (*Item, error)) error {
+	seen := make(map[int]bool)
+	for _, c := range components {
+		if c.Quantity <= 0 || seen[c.ItemID] {
+			return ErrInvalidBundle
+		}
+		seen[c.ItemID] = true
+		it, err := lookup(c.ItemID)
+		if err != nil || len(it.Components) > 0 {
+			return ErrInvalidBundle
+		}
+	}
+	return nil
+}
+
+// componentReturn finds what is being returned to one component of a bundle
+func componentReturn(ret []ComponentAllocation, itemID int) (Allocation, *float64) {
+	for _, c := range ret {
+		if c.ItemID == itemID {
+			if c.Quantity > 0 {
+				unit := c.Cost / float64(c.Quantity)
+				return c.Allocation, &unit
+			}
+			return c.Allocation, nil
+		}
+	}
+	return Allocation{}, nil
+}
+
+// fillBundles loads the components of the given items and derives the quantity
+// of bundles, and how many can be sold, from their components' stock
+func fillBundles(q queryer, items []*Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(items))
+	byID := make(map[int]*Item, len(items))
+	for _, it := range items {
+		ids = append(ids, int64(it.ID))
+		byID[it.ID] = it
+	}
+	rows, err := q.Query(`SELECT bc.bundle_id, bc.component_id, bc.quantity, items.quantity, items.quantity - `+expiredStock+`
+	FROM bundle_components bc JOIN items ON items.id = bc.component_id
+	WHERE bc.bundle_id = ANY($1) ORDER BY bc.bundle_id, bc.component_id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	stock, sellable := make(map[int]int), make(map[int]int)
+	for rows.Next() {
+		var bundleID, onHand, available int
+		var c Component
+		if err := rows.Scan(&bundleID, &c.ItemID, &c.Quantity, &onHand, &available); err != nil {
+			return err
+		}
+		it := byID[bundleID]
+		it.Components = append(it.Components, c)
+		stock[c.ItemID], sellable[c.ItemID] = onHand, available
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, it := range items {
+		if len(it.Components) > 0 {
+			it.Quantity = bundleQuantity(it.Components, stock)
+			it.Available = bundleQuantity(it.Components, sellable)
+		}
+	}
+	return nil
+}
+
+func loadComponentsTx(tx *sql.Tx, bundleID int) ([]Component, error) {
+	rows, err := tx.Query("SELECT component_id, quantity FROM bundle_components WHERE bundle_id = $1 ORDER BY component_id", bundleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]Component, 0)
+	for rows.Next() {
+		var c Component
+		if err := rows.Scan(&c.ItemID, &c.Quantity); err != nil {
+			return nil, err
+		}
+		res = append(res, c)
+	}
+	return res, rows.Err()
+}
+
+// adjustBundleTx adjusts every component of a bundle by delta times its quantity
+// within the caller's transaction, so a bundle is reserved all or nothing.
+// Components are locked in id order to avoid deadlocks between bundles.
+func adjustBundleTx(tx *sql.Tx, id int, components []Component, adj Adjustment) (*Item, Allocation, error) {
+	var out Allocation
+	sort.Slice(components, func(i, j int) bool { return components[i].ItemID < components[j].ItemID })
+	ref := adj.Reference
+	if ref == "" {
+		ref = fmt.Sprintf("bundle %d", id)
+	}
+	for _, c := range components {
+		ret, unitCost := componentReturn(adj.Components, c.ItemID)
+		compAdj := Adjustment{Delta: adj.Delta * c.Quantity, Reason: adj.Reason, Reference: ref, UnitCost: unitCost, Allocation: ret}
+		_, alloc, err := adjustTx(tx, c.ItemID, compAdj)
+		if err != nil {
+			return nil, out, fmt.Errorf("component %d: %w", c.ItemID, err)
+		}
+		qty := compAdj.Delta
+		if qty < 0 {
+			qty = -qty
+		}
+		out.Components = append(out.Components, ComponentAllocation{ItemID: c.ItemID, Quantity: qty, Allocation: alloc})
+		out.Cost += alloc.Cost
+	}
+	out.Cost = roundCost(out.Cost)
+	it, err := scanItem(tx.QueryRow("SELECT "+itemColumns+" FROM items WHERE id = $1", id))
+	if err != nil {
+		return nil, out, err
+	}
+	if err := fillBundles(tx, []*Item{it}); err != nil {
+		return nil, out, err
+	}
+	return it, out, nil
+}
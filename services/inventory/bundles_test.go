@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBundleQuantity(t *testing.T) {
+	components := []Component{{ItemID: 1, Quantity: 1}, {ItemID: 2, Quantity: 2}}
+	if got := bundleQuantity(components, map[int]int{1: 5, 2: 7}); got != 3 {
+		t.Fatalf("expected 3 bundles, got %d", got)
+	}
+	if got := bundleQuantity(components, map[int]int{1: 5}); got != 0 {
+		t.Fatalf("expected 0 bundles without the second component, got %d", got)
+	}
+}
+
+func TestInventory_Bundle(t *testing.T) {
+	s := NewInventoryInMemory()
+	hoodie := s.CreateItem(Item{Name: "hoodie", Quantity: 5, Price: 40, UnitCost: 20})
+	tee := s.CreateItem(Item{Name: "tee", Quantity: 3, Price: 15, UnitCost: 5})
+	bundle := s.CreateItem(Item{Name: "hoodie + tee", Price: 50, Components: []Component{
+		{ItemID: hoodie.ID, Quantity: 1}, {ItemID: tee.ID, Quantity: 1},
+	}})
+	if bundle.Quantity != 3 {
+		t.Fatalf("expected 3 bundles available, got %d", bundle.Quantity)
+	}
+	if err := validateComponents([]Component{{ItemID: bundle.ID, Quantity: 1}}, s.Get); !errors.Is(err, ErrInvalidBundle) {
+		t.Fatalf("expected nested bundle to be rejected, got %v", err)
+	}
+
+	it, out, err := s.Adjust(bundle.ID, Adjustment{Delta: -2, Reason: "order"})
+	if err != nil {
+		t.Fatalf("unexpected error from Adjust: %v", err)
+	}
+	if it.Quantity != 1 || len(out.Components) != 2 || !almostEqual(out.Cost, 50) {
+		t.Fatalf("expected 1 bundle left and cost 50 over 2 components, got %d, %+v", it.Quantity, out)
+	}
+
+	// not enough tees for two more: nothing is taken from the hoodies either
+	if _, _, err := s.Adjust(bundle.ID, Adjustment{Delta: -2}); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("expected ErrInsufficientStock, got %v", err)
+	}
+	h, _ := s.Get(hoodie.ID)
+	if h.Quantity != 3 {
+		t.Fatalf("expected hoodie stock untouched at 3, got %d", h.Quantity)
+	}
+
+	// returning a bundle restocks its components at the cost they left with
+	if _, _, err := s.Adjust(bundle.ID, Adjustment{Delta: 2, Allocation: out}); err != nil {
+		t.Fatalf("unexpected error returning bundle: %v", err)
+	}
+	h, _ = s.Get(hoodie.ID)
+	if h.Quantity != 5 || !almostEqual(h.StockValue, 100) {
+		t.Fatalf("expected 5 hoodies worth 100, got %d worth %v", h.Quantity, h.StockValue)
+	}
+
+	if _, err := s.ReceiveLot(bundle.ID, "B1", 1, "", nil); !errors.Is(err, ErrBundleStock) {
+		t.Fatalf("expected ErrBundleStock, got %v", err)
+	}
+}
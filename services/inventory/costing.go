@@ -0,0 +1,240 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"inventoryshop/pkg/events"
+)
+
+// Costing methods
+const (
+	CostFIFO    = "fifo"
+	CostAverage = "average"
+)
+
+var ErrInvalidCost = &customError{"invalid unit cost or costing method"}
+
+// costLayer is the remaining quantity of one receipt of a FIFO-costed item
+type costLayer struct {
+	ID        int
+	Remaining int
+	UnitCost  float64
+}
+
+// itemState is what a stock change needs to know about the item it changes
+type itemState struct {
+	quantity int
+	tracked  bool
+	method   string
+	value    float64
+	bundle   bool
+	policy   string
+}
+
+func validCostingMethod(m string) bool { return m == CostFIFO || m == CostAverage }
+
+// roundCost rounds money to cents so that stored values do not drift
+func roundCost(v float64) float64 { return math.Round(v*100) / 100 }
+
+// averageCost is the unit cost of stock holding the given quantity and value
+func averageCost(qty int, value float64) float64 {
+	if qty <= 0 {
+		return 0
+	}
+	return value / float64(qty)
+}
+
+// consumeLayers takes qty units from FIFO layers, oldest first. It returns how
+// much was taken from each layer and the total cost. Stock not covered by any
+// layer, e.g. received before costing was tracked, costs nothing.
+func consumeLayers(layers []costLayer, qty int) ([]costLayer, float64) {
+	taken := make([]costLayer, 0)
+	cost := 0.0
+	for _, l := range layers {
+		if qty == 0 {
+			break
+		}
+		if l.Remaining <= 0 {
+			continue
+		}
+		n := l.Remaining
+		if n > qty {
+			n = qty
+		}
+		taken = append(taken, costLayer{ID: l.ID, Remaining: n, UnitCost: l.UnitCost})
+		cost += float64(n) * l.UnitCost
+		qty -= n
+	}
+	return taken, cost
+}
+
+// outflowValue is the value change when -delta units leave average-costed stock.
+// Emptying the stock takes all of its value so rounding leftovers do not linger.
+func outflowValue(st itemState, delta int) float64 {
+	if st.quantity+delta == 0 {
+		return -st.value
+	}
+	return roundCost(averageCost(st.quantity, st.value) * float64(delta))
+}
+
+func lockItemTx(tx *sql.Tx, id int) (itemState, error) {
+	var st itemState
+	err := tx.QueryRow(`SELECT quantity, serial_tracked, costing_method, stock_value,
+		EXISTS (SELECT 1 FROM bundle_components WHERE bundle_id = items.id), backorder_policy
+	FROM items WHERE id = $1 FOR UPDATE`, id).
+		Scan(&st.quantity, &st.tracked, &st.method, &st.value, &st.bundle, &st.policy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return st, ErrNotFound
+	}
+	return st, err
+}
+
+// costTx works out the unit cost and value change of a stock change and keeps the
+// FIFO layers in step. Inbound stock without an explicit cost comes in at the
+// current cost: the average for average-costed items, the latest receipt for FIFO.
+func costTx(tx *sql.Tx, id int, st itemState, delta int, unitCost *float64) (float64, float64, error) {
+	switch {
+	case delta < 0 && st.method == CostFIFO:
+		rows, err := tx.Query("SELECT id, remaining, unit_cost FROM cost_layers WHERE item_id = $1 AND remaining > 0 ORDER BY id FOR UPDATE", id)
+		if err != nil {
+			return 0, 0, err
+		}
+		layers := make([]costLayer, 0)
+		for rows.Next() {
+			var l costLayer
+			if err := rows.Scan(&l.ID, &l.Remaining, &l.UnitCost); err != nil {
+				rows.Close()
+				return 0, 0, err
+			}
+			layers = append(layers, l)
+		}
+		rows.Close()
+		taken, cost := consumeLayers(layers, -delta)
+		for _, l := range taken {
+			if _, err := tx.Exec("UPDATE cost_layers SET remaining = remaining - $1 WHERE id = $2", l.Remaining, l.ID); err != nil {
+				return 0, 0, err
+			}
+		}
+		cost = roundCost(cost)
+		return cost / float64(-delta), -cost, nil
+	case delta < 0:
+		v := outflowValue(st, delta)
+		return v / float64(delta), v, nil
+	case delta > 0:
+		var unit float64
+		switch {
+		case unitCost != nil:
+			unit = *unitCost
+		case st.method == CostFIFO:
+			err := tx.QueryRow("SELECT unit_cost FROM cost_layers WHERE item_id = $1 ORDER BY id DESC LIMIT 1", id).Scan(&unit)
+			if errors.Is(err, sql.ErrNoRows) {
+				unit = averageCost(st.quantity, st.value)
+			} else if err != nil {
+				return 0, 0, err
+			}
+		default:
+			unit = averageCost(st.quantity, st.value)
+		}
+		if unit < 0 {
+			return 0, 0, ErrInvalidCost
+		}
+		if st.method == CostFIFO {
+			if _, err := tx.Exec("INSERT INTO cost_layers (item_id, remaining, unit_cost, created_unix) VALUES ($1,$2,$3,$4)",
+				id, delta, unit, time.Now().Unix()); err != nil {
+				return 0, 0, err
+			}
+		}
+		return unit, roundCost(unit * float64(delta)), nil
+	}
+	return 0, 0, nil
+}
+
+// changeStockTx applies a quantity change to a locked item, keeping its stock
+// value in step, and records the movement. The returned movement carries the cost.
+func changeStockTx(tx *sql.Tx, id int, st itemState, m Movement, unitCost *float64) (*Item, *Movement, error) {
+	unit, value, err := costTx(tx, id, st, m.Delta, unitCost)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.ItemID, m.UnitCost, m.Value = id, unit, value
+	it, err := scanItem(tx.QueryRow(`UPDATE items SET quantity = quantity + $1, stock_value = stock_value + $2 WHERE id = $3
+	RETURNING `+itemColumns, m.Delta, value, id))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := recordMovementTx(tx, &m); err != nil {
+		return nil, nil, err
+	}
+	if m.Delta != 0 {
+		if err := events.RecordTx(tx, newEvent(EventStockAdjusted, id, stockAdjusted(it, m))); err != nil {
+			return nil, nil, err
+		}
+	}
+	return it, &m, nil
+}
+
+// ValuationLine is the quantity and value of one item at a point in time
+type ValuationLine struct {
+	ItemID        int     `json:"item_id"`
+	Name          string  `json:"name"`
+	CostingMethod string  `json:"costing_method"`
+	Quantity      int     `json:"quantity"`
+	Value         float64 `json:"value"`
+	UnitCost      float64 `json:"unit_cost"`
+}
+
+// Valuation is the value of all stock at a point in time
+type Valuation struct {
+	At         int64           `json:"at_unix"`
+	Items      []ValuationLine `json:"items"`
+	TotalValue float64         `json:"total_value"`
+}
+
+// newValuation fills unit costs and the total, dropping items that held nothing
+func newValuation(at int64, lines []ValuationLine) *Valuation {
+	v := &Valuation{At: at, Items: make([]ValuationLine, 0, len(lines))}
+	for _, l := range lines {
+		if l.Quantity == 0 && l.Value == 0 {
+			continue
+		}
+		l.Value = roundCost(l.Value)
+		l.UnitCost = averageCost(l.Quantity, l.Value)
+		v.Items = append(v.Items, l)
+		v.TotalValue += l.Value
+	}
+	sort.Slice(v.Items, func(i, j int) bool { return v.Items[i].ItemID < v.Items[j].ItemID })
+	v.TotalValue = roundCost(v.TotalValue)
+	return v
+}
+
+// Valuation returns stock quantities and values as they were at the given time,
+// by rolling current values back over the movements logged since.
+func (s *Inventory) Valuation(at int64) (*Valuation, error) {
+	rows, err := s.db.Query(`
+	SELECT i.id, i.name, i.costing_method,
+		i.quantity - COALESCE(SUM(m.delta) FILTER (WHERE m.created_unix > $1), 0),
+		i.stock_value - COALESCE(SUM(m.value) FILTER (WHERE m.created_unix > $1), 0)
+	FROM items i LEFT JOIN stock_movements m ON m.item_id = i.id
+	GROUP BY i.id
+	`, at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	lines := make([]ValuationLine, 0)
+	for rows.Next() {
+		var l ValuationLine
+		if err := rows.Scan(&l.ItemID, &l.Name, &l.CostingMethod, &l.Quantity, &l.Value); err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return newValuation(at, lines), nil
+}
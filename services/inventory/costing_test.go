@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func costPtr(v float64) *float64 { return &v }
+
+func TestInventory_FIFOCost(t *testing.T) {
+	s := NewInventoryInMemory()
+	it := s.CreateItem(Item{Name: "hoodie", Quantity: 10, Price: 20, UnitCost: 5})
+	s.ReceiveLot(it.ID, "B2", 10, "", costPtr(8))
+
+	// 12 units: 10 at 5 and 2 at 8
+	_, out, err := s.Adjust(it.ID, Adjustment{Delta: -12, Reason: "order"})
+	if err != nil {
+		t.Fatalf("unexpected error from Adjust: %v", err)
+	}
+	if !almostEqual(out.Cost, 66) {
+		t.Fatalf("expected cost 66, got %v", out.Cost)
+	}
+	got, _ := s.Get(it.ID)
+	if got.Quantity != 8 || !almostEqual(got.StockValue, 64) {
+		t.Fatalf("expected 8 units worth 64, got %d worth %v", got.Quantity, got.StockValue)
+	}
+
+	// returned stock comes back at the cost it left with
+	_, out, _ = s.Adjust(it.ID, Adjustment{Delta: 2, UnitCost: costPtr(5.5)})
+	if !almostEqual(out.Cost, 11) {
+		t.Fatalf("expected restock cost 11, got %v", out.Cost)
+	}
+}
+
+func TestInventory_AverageCost(t *testing.T) {
+	s := NewInventoryInMemory()
+	it := s.CreateItem(Item{Name: "tee", Quantity: 10, Price: 10, UnitCost: 4, CostingMethod: CostAverage})
+	s.ReceiveLot(it.ID, "B2", 10, "", costPtr(6))
+
+	_, out, _ := s.Adjust(it.ID, Adjustment{Delta: -5})
+	if !almostEqual(out.Cost, 25) {
+		t.Fatalf("expected cost 25 at average 5, got %v", out.Cost)
+	}
+	// emptying the stock leaves no value behind
+	it, _, _ = s.Adjust(it.ID, Adjustment{Delta: -15})
+	if it.Quantity != 0 || it.StockValue != 0 {
+		t.Fatalf("expected empty stock with no value, got %d worth %v", it.Quantity, it.StockValue)
+	}
+}
+
+func TestInventory_ValuationAt(t *testing.T) {
+	s := NewInventoryInMemory()
+	it := s.CreateItem(Item{Name: "hoodie", Quantity: 4, Price: 20, UnitCost: 5})
+	s.Adjust(it.ID, Adjustment{Delta: -1})
+
+	// pretend the receipt happened an hour ago and the sale just now
+	hourAgo := time.Now().Add(-time.Hour).Unix()
+	s.movements[0].Created = hourAgo
+
+	v, _ := s.Valuation(hourAgo)
+	if len(v.Items) != 1 || v.Items[0].Quantity != 4 || !almostEqual(v.TotalValue, 20) {
+		t.Fatalf("unexpected past valuation: %+v", v)
+	}
+	v, _ = s.Valuation(time.Now().Unix())
+	if v.Items[0].Quantity != 3 || !almostEqual(v.TotalValue, 15) {
+		t.Fatalf("unexpected current valuation: %+v", v)
+	}
+	v, _ = s.Valuation(hourAgo - 1)
+	if len(v.Items) != 0 {
+		t.Fatalf("expected nothing in stock before the receipt, got %+v", v.Items)
+	}
+}
+
+func almostEqual(a, b float64) bool {
+	d := a - b
+	return d < 1e-9 && d > -1e-9
+}
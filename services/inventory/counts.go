@@ -0,0 +1,262 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Count session statuses
+const (
+	CountOpen     = "open"
+	CountApproved = "approved"
+)
+
+var (
+	ErrCountClosed    = &customError{"count session is not open"}
+	ErrItemNotInCount = &customError{"item is not part of the count session"}
+	ErrInvalidCount   = &customError{"invalid counted quantity"}
+)
+
+// CountSession is a physical stock count. Expected quantities are snapshotted
+// when the session starts; approving it posts the variances as adjustments.
+type CountSession struct {
+	ID       int         `json:"id"`
+	Status   string      `json:"status"`
+	Created  int64       `json:"created_unix"`
+	Approved int64       `json:"approved_unix,omitempty"`
+	Lines    []CountLine `json:"lines"`
+}
+
+// CountLine is the expected and counted quantity of one item in a session.
+// Counted and Variance stay nil until the item has been counted.
+type CountLine struct {
+	ItemID   int    `json:"item_id"`
+	Name     string `json:"name"`
+	Expected int    `json:"expected"`
+	Counted  *int   `json:"counted"`
+	Variance *int   `json:"variance"`
+}
+
+// setCounted records a counted quantity, replacing the previous one or adding to it
+func (l *CountLine) setCounted(qty int, add bool) {
+	if add && l.Counted != nil {
+		qty += *l.Counted
+	}
+	v := qty - l.Expected
+	l.Counted, l.Variance = &qty, &v
+}
+
+// countReference identifies a session in the stock movement log
+func countReference(id int) string { return fmt.Sprintf("count %d", id) }
+
+// validateCounts checks quantities before they are applied to a session
+func validateCounts(counts map[int]int, add bool) error {
+	for _, q := range counts {
+		if q < 0 || (add && q == 0) {
+			return ErrInvalidCount
+		}
+	}
+	return nil
+}
+
+// StartCount opens a count session for the given items, or for all items if none are given
+func (s *Inventory) StartCount(itemIDs []int) (*CountSession, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	cs := &CountSession{Status: CountOpen, Created: time.Now().Unix(), Lines: make([]CountLine, 0)}
+	if err := tx.QueryRow("INSERT INTO count_sessions (status, created_unix) VALUES ($1,$2) RETURNING id",
+		cs.Status, cs.Created).Scan(&cs.ID); err != nil {
+		return nil, err
+	}
+	var rows *sql.Rows
+	if len(itemIDs) == 0 {
+		// bundles have no stock of their own to count
+		rows, err = tx.Query(`SELECT id, name, quantity FROM items
+		WHERE id NOT IN (SELECT bundle_id FROM bundle_components) ORDER BY id`)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var l CountLine
+			if err := rows.Scan(&l.ItemID, &l.Name, &l.Expected); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			cs.Lines = append(cs.Lines, l)
+		}
+		rows.Close()
+	} else {
+		seen := make(map[int]bool)
+		for _, id := range itemIDs {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			l := CountLine{ItemID: id}
+			var bundle bool
+			if err := tx.QueryRow(`SELECT name, quantity, EXISTS (SELECT 1 FROM bundle_components WHERE bundle_id = items.id)
+			FROM items WHERE id = $1`, id).Scan(&l.Name, &l.Expected, &bundle); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, ErrNotFound
+				}
+				return nil, err
+			}
+			if bundle {
+				return nil, ErrBundleStock
+			}
+			cs.Lines = append(cs.Lines, l)
+		}
+	}
+	for _, l := range cs.Lines {
+		if _, err := tx.Exec("INSERT INTO count_lines (session_id, item_id, name, expected) VALUES ($1,$2,$3,$4)",
+			cs.ID, l.ItemID, l.Name, l.Expected); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+func (s *Inventory) loadCount(q queryer, id int, lock bool) (*CountSession, error) {
+	var cs CountSession
+	stmt := "SELECT id, status, created_unix, approved_unix FROM count_sessions WHERE id = $1"
+	if lock {
+		stmt += " FOR UPDATE"
+	}
+	if err := q.QueryRow(stmt, id).Scan(&cs.ID, &cs.Status, &cs.Created, &cs.Approved); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	rows, err := q.Query("SELECT item_id, name, expected, counted FROM count_lines WHERE session_id = $1 ORDER BY item_id", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cs.Lines = make([]CountLine, 0)
+	for rows.Next() {
+		var l CountLine
+		var counted sql.NullInt64
+		if err := rows.Scan(&l.ItemID, &l.Name, &l.Expected, &counted); err != nil {
+			return nil, err
+		}
+		if counted.Valid {
+			l.setCounted(int(counted.Int64), false)
+		}
+		cs.Lines = append(cs.Lines, l)
+	}
+	return &cs, rows.Err()
+}
+
+// GetCount returns a count session with its lines and variances
+func (s *Inventory) GetCount(id int) (*CountSession, error) {
+	return s.loadCount(s.db, id, false)
+}
+
+// ListCounts returns all count sessions, newest first
+func (s *Inventory) ListCounts() ([]*CountSession, error) {
+	rows, err := s.db.Query("SELECT id FROM count_sessions ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	res := make([]*CountSession, 0, len(ids))
+	for _, id := range ids {
+		cs, err := s.GetCount(id)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, cs)
+	}
+	return res, nil
+}
+
+// RecordCounts stores counted quantities per item. With add set the quantities
+// are added to what was counted so far, e.g. for batches of scanned barcodes.
+func (s *Inventory) RecordCounts(id int, counts map[int]int, add bool) (*CountSession, error) {
+	if err := validateCounts(counts, add); err != nil {
+		return nil, err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	cs, err := s.loadCount(tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	if cs.Status != CountOpen {
+		return nil, ErrCountClosed
+	}
+	for itemID, qty := range counts {
+		stmt := "UPDATE count_lines SET counted = $1 WHERE session_id = $2 AND item_id = $3"
+		if add {
+			stmt = "UPDATE count_lines SET counted = COALESCE(counted, 0) + $1 WHERE session_id = $2 AND item_id = $3"
+		}
+		res, err := tx.Exec(stmt, qty, id, itemID)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil, ErrItemNotInCount
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetCount(id)
+}
+
+// ApproveCount closes a session and posts every non-zero variance as an
+// adjustment with reason "count". Items that were never counted are left alone.
+func (s *Inventory) ApproveCount(id int) (*CountSession, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	cs, err := s.loadCount(tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	if cs.Status != CountOpen {
+		return nil, ErrCountClosed
+	}
+	for _, l := range cs.Lines {
+		if l.Variance == nil || *l.Variance == 0 {
+			continue
+		}
+		adj := Adjustment{Delta: *l.Variance, Reason: ReasonCount, Reference: countReference(id)}
+		if _, _, err := adjustTx(tx, l.ItemID, adj); err != nil {
+			return nil, fmt.Errorf("item %d: %w", l.ItemID, err)
+		}
+	}
+	cs.Status, cs.Approved = CountApproved, time.Now().Unix()
+	if _, err := tx.Exec("UPDATE count_sessions SET status = $1, approved_unix = $2 WHERE id = $3",
+		cs.Status, cs.Approved, id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestInventory_CountSession(t *testing.T) {
+	s := NewInventoryInMemory()
+	a := s.Create("apple", 10, 1)
+	b := s.Create("banana", 5, 1)
+	c := s.Create("cherry", 3, 1)
+
+	cs, err := s.StartCount(nil)
+	if err != nil {
+		t.Fatalf("unexpected error from StartCount: %v", err)
+	}
+	if len(cs.Lines) != 3 || cs.Lines[0].Expected != 10 {
+		t.Fatalf("unexpected snapshot: %+v", cs.Lines)
+	}
+
+	if _, err := s.RecordCounts(cs.ID, map[int]int{a.ID: 8}, false); err != nil {
+		t.Fatalf("unexpected error from RecordCounts: %v", err)
+	}
+	// two scanned batches for banana
+	s.RecordCounts(cs.ID, map[int]int{b.ID: 4}, true)
+	cs, _ = s.RecordCounts(cs.ID, map[int]int{b.ID: 3}, true)
+	if *cs.Lines[0].Variance != -2 || *cs.Lines[1].Counted != 7 || *cs.Lines[1].Variance != 2 {
+		t.Fatalf("unexpected variances: %+v", cs.Lines)
+	}
+	if cs.Lines[2].Counted != nil {
+		t.Fatalf("expected cherry uncounted, got %v", *cs.Lines[2].Counted)
+	}
+	if _, err := s.RecordCounts(cs.ID, map[int]int{999: 1}, false); err != ErrItemNotInCount {
+		t.Fatalf("expected ErrItemNotInCount, got %v", err)
+	}
+
+	if _, err := s.ApproveCount(cs.ID); err != nil {
+		t.Fatalf("unexpected error from ApproveCount: %v", err)
+	}
+	if got, _ := s.Get(a.ID); got.Quantity != 8 {
+		t.Fatalf("expected apple quantity 8, got %d", got.Quantity)
+	}
+	if got, _ := s.Get(b.ID); got.Quantity != 7 {
+		t.Fatalf("expected banana quantity 7, got %d", got.Quantity)
+	}
+	if got, _ := s.Get(c.ID); got.Quantity != 3 {
+		t.Fatalf("expected cherry untouched, got %d", got.Quantity)
+	}
+	// initial stock receipt, then the count adjustment
+	moves, _ := s.Movements(a.ID)
+	if len(moves) != 2 || moves[1].Reason != ReasonCount || moves[1].Delta != -2 {
+		t.Fatalf("unexpected movements: %+v", moves)
+	}
+
+	if _, err := s.ApproveCount(cs.ID); err != ErrCountClosed {
+		t.Fatalf("expected ErrCountClosed, got %v", err)
+	}
+	if _, err := s.RecordCounts(cs.ID, map[int]int{a.ID: 1}, false); err != ErrCountClosed {
+		t.Fatalf("expected ErrCountClosed, got %v", err)
+	}
+}
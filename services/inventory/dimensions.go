@@ -0,0 +1,49 @@
+package main
+
+// Dimensions are an item's shipping weight and package size, used by the orders
+// service to quote shipping
+type Dimensions struct {
+	WeightKg float64 `json:"weight_kg,omitempty"`
+	LengthCm float64 `json:"length_cm,omitempty"`
+	WidthCm  float64 `json:"width_cm,omitempty"`
+	HeightCm float64 `json:"height_cm,omitempty"`
+}
+
+var ErrInvalidDimensions = &customError{"invalid weight or dimensions"}
+
+func validateDimensions(d Dimensions) error {
+	if d.WeightKg < 0 || d.LengthCm < 0 || d.WidthCm < 0 || d.HeightCm < 0 {
+		return ErrInvalidDimensions
+	}
+	return nil
+}
+
+// SetDimensions records an item's shipping weight and package size
+func (s *Inventory) SetDimensions(itemID int, d Dimensions) (*Item, error) {
+	if err := validateDimensions(d); err != nil {
+		return nil, err
+	}
+	res, err := s.db.Exec("UPDATE items SET weight_kg = $1, length_cm = $2, width_cm = $3, height_cm = $4 WHERE id = $5",
+		d.WeightKg, d.LengthCm, d.WidthCm, d.HeightCm, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrNotFound
+	}
+	return s.Get(itemID)
+}
+
+func (s *InMemoryInventory) SetDimensions(itemID int, d Dimensions) (*Item, error) {
+	if err := validateDimensions(d); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, ok := s.items[itemID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	it.Dimensions = d
+	return it, nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestInventory_Dimensions(t *testing.T) {
+	s := NewInventoryInMemory()
+	it := s.CreateItem(Item{Name: "box", Price: 5, Dimensions: Dimensions{WeightKg: 0.5}})
+	if it.WeightKg != 0.5 {
+		t.Fatalf("expected weight from creation, got %+v", it.Dimensions)
+	}
+	it, err := s.SetDimensions(it.ID, Dimensions{WeightKg: 1.2, LengthCm: 30, WidthCm: 20, HeightCm: 10})
+	if err != nil || it.HeightCm != 10 {
+		t.Fatalf("expected dimensions updated, got %+v, %v", it, err)
+	}
+	if _, err := s.SetDimensions(it.ID, Dimensions{WeightKg: -1}); err != ErrInvalidDimensions {
+		t.Fatalf("expected ErrInvalidDimensions, got %v", err)
+	}
+	if _, err := s.SetDimensions(99, Dimensions{}); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "inventoryshop/pkg/inventory/inventorypb"
+)
+
+// itemStore is what the gRPC API needs of a store
+type itemStore interface {
+	List() []*Item
+	Get(id int) (*Item, error)
+	GetMany(ids []int) ([]*Item, error)
+	CreateItem(it Item) *Item
+	Adjust(id int, adj Adjustment) (*Item, Allocation, error)
+	GetSerial(serial string) (*Serial, error)
+	CreateBackorder(itemID, qty int, reference string) (*Backorder, error)
+	GetBackorder(id int) (*Backorder, error)
+	CancelBackorder(id int) (*Backorder, error)
+}
+
+// grpcServer serves the inventory gRPC API from the same store as the REST API
+type grpcServer struct {
+	pb.UnimplementedInventoryServiceServer
+	store itemStore
+}
+
+// NewGRPCServer returns a gRPC server with the inventory service registered
+func NewGRPCServer(store itemStore) *grpc.Server {
+	s := grpc.NewServer()
+	pb.RegisterInventoryServiceServer(s, &grpcServer{store: store})
+	return s
+}
+
+// grpcError maps store errors to gRPC codes the way the REST API maps them to
+// HTTP statuses
+func grpcError(err error) error {
+	var ce *customError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrInsufficientStock), errors.Is(err, ErrBackorderClosed), errors.Is(err, ErrBackorderNotAllowed):
+		rpcCode := codes.FailedPrecondition
+		if errors.Is(err, ErrBackorderClosed) {
+			// a conflict over REST, so it must come back as one through the client
+			rpcCode = codes.Aborted
+		}
+		st := status.New(rpcCode, err.Error())
+		if code := errorCode(err); code != "" {
+			if withInfo, err := st.WithDetails(&errdetails.ErrorInfo{Reason: code, Domain: "inventory"}); err == nil {
+				st = withInfo
+			}
+		}
+		return st.Err()
+	case errors.As(err, &ce):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (s *grpcServer) ListItems(ctx context.Context, req *pb.ListItemsRequest) (*pb.ListItemsResponse, error) {
+	var items []*Item
+	if len(req.Ids) == 0 {
+		items = s.store.List()
+	} else {
+		ids := make([]int, len(req.Ids))
+		for i, id := range req.Ids {
+			ids[i] = int(id)
+		}
+		var err error
+		if items, err = s.store.GetMany(ids); err != nil {
+			return nil, grpcError(err)
+		}
+	}
+	resp := &pb.ListItemsResponse{Items: make([]*pb.Item, len(items))}
+	for i, it := range items {
+		resp.Items[i] = itemToPB(it)
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) GetItem(ctx context.Context, req *pb.GetItemRequest) (*pb.Item, error) {
+	it, err := s.store.Get(int(req.Id))
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	return itemToPB(it), nil
+}
+
+func (s *grpcServer) CreateItem(ctx context.Context, req *pb.CreateItemRequest) (*pb.Item, error) {
+	it := Item{Name: req.Name, Quantity: int(req.Quantity), Price: req.Price, UnitCost: req.UnitCost,
+		SerialTracked: req.SerialTracked, CostingMethod: req.CostingMethod, BackorderPolicy: req.BackorderPolicy,
+		AvailableAt: req.AvailableAt, Category: req.Category,
+		Dimensions: Dimensions{WeightKg: req.WeightKg, LengthCm: req.LengthCm, WidthCm: req.WidthCm, HeightCm: req.HeightCm}}
+	for _, c := range req.Components {
+		it.Components = append(it.Components, Component{ItemID: int(c.ItemId), Quantity: int(c.Quantity)})
+	}
+	if err := validateNewItem(&it, s.store.Get); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return itemToPB(s.store.CreateItem(it)), nil
+}
+
+func (s *grpcServer) AdjustStock(ctx context.Context, req *pb.AdjustStockRequest) (*pb.AdjustStockResponse, error) {
+	adj := Adjustment{Delta: int(req.Delta), Reason: req.Reason, Reference: req.Reference, UnitCost: req.UnitCost,
+		Allocation: allocationFromPB(req.Returned)}
+	it, alloc, err := s.store.Adjust(int(req.ItemId), adj)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.AdjustStockResponse{Item: itemToPB(it), Allocation: allocationToPB(alloc)}, nil
+}
+
+func (s *grpcServer) GetSerial(ctx context.Context, req *pb.GetSerialRequest) (*pb.Serial, error) {
+	sn, err := s.store.GetSerial(req.Serial)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	return &pb.Serial{Serial: sn.Serial, ItemId: int64(sn.ItemID), Status: sn.Status, ReceivedUnix: sn.Received}, nil
+}
+
+func (s *grpcServer) CreateBackorder(ctx context.Context, req *pb.CreateBackorderRequest) (*pb.Backorder, error) {
+	return backorderToPB(s.store.CreateBackorder(int(req.ItemId), int(req.Quantity), req.Reference))
+}
+
+func (s *grpcServer) GetBackorder(ctx context.Context, req *pb.GetBackorderRequest) (*pb.Backorder, error) {
+	return backorderToPB(s.store.GetBackorder(int(req.Id)))
+}
+
+func (s *grpcServer) CancelBackorder(ctx context.Context, req *pb.CancelBackorderRequest) (*pb.Backorder, error) {
+	return backorderToPB(s.store.CancelBackorder(int(req.Id)))
+}
+
+func itemToPB(it *Item) *pb.Item {
+	p := &pb.Item{Id: int64(it.ID), Name: it.Name, Quantity: int64(it.Quantity), Available: int64(it.Available), Price: it.Price,
+		SerialTracked: it.SerialTracked, CostingMethod: it.CostingMethod, StockValue: it.StockValue, UnitCost: it.UnitCost,
+		BackorderPolicy: it.BackorderPolicy, AvailableAt: it.AvailableAt, Category: it.Category,
+		WeightKg: it.WeightKg, LengthCm: it.LengthCm, WidthCm: it.WidthCm, HeightCm: it.HeightCm}
+	for _, c := range it.Components {
+		p.Components = append(p.Components, &pb.Component{ItemId: int64(c.ItemID), Quantity: int64(c.Quantity)})
+	}
+	return p
+}
+
+func backorderToPB(b *Backorder, err error) (*pb.Backorder, error) {
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.Backorder{Id: int64(b.ID), ItemId: int64(b.ItemID), Quantity: int64(b.Quantity), Kind: b.Kind,
+		Reference: b.Reference, Status: b.Status, ExpectedAt: b.ExpectedAt, CreatedUnix: b.Created,
+		FulfilledUnix: b.Fulfilled, Allocation: allocationToPB(b.Allocation)}, nil
+}
+
+func lotsToPB(lots []LotAllocation) []*pb.LotAllocation {
+	var res []*pb.LotAllocation
+	for _, l := range lots {
+		res = append(res, &pb.LotAllocation{LotId: int64(l.LotID), LotNumber: l.LotNumber, ExpiresAt: l.ExpiresAt, Quantity: int64(l.Quantity)})
+	}
+	return res
+}
+
+func lotsFromPB(lots []*pb.LotAllocation) []LotAllocation {
+	var res []LotAllocation
+	for _, l := range lots {
+		res = append(res, LotAllocation{LotID: int(l.LotId), LotNumber: l.LotNumber, ExpiresAt: l.ExpiresAt, Quantity: int(l.Quantity)})
+	}
+	return res
+}
+
+func allocationToPB(a Allocation) *pb.Allocation {
+	p := &pb.Allocation{Lots: lotsToPB(a.Lots), Serials: a.Serials, Cost: a.Cost}
+	for _, c := range a.Components {
+		p.Components = append(p.Components, &pb.ComponentAllocation{ItemId: int64(c.ItemID), Quantity: int64(c.Quantity),
+			Lots: lotsToPB(c.Lots), Serials: c.Serials, Cost: c.Cost})
+	}
+	return p
+}
+
+func allocationFromPB(p *pb.Allocation) Allocation {
+	if p == nil {
+		return Allocation{}
+	}
+	a := Allocation{Lots: lotsFromPB(p.Lots), Serials: p.Serials, Cost: p.Cost}
+	for _, c := range p.Components {
+		a.Components = append(a.Components, ComponentAllocation{ItemID: int(c.ItemId), Quantity: int(c.Quantity),
+			Allocation: Allocation{Lots: lotsFromPB(c.Lots), Serials: c.Serials, Cost: c.Cost}})
+	}
+	return a
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"inventoryshop/pkg/inventory"
+)
+
+func TestGRPCServer(t *testing.T) {
+	s := NewInventoryInMemory()
+	tee := s.Create("Футболка", 5, 10)
+	pre := s.CreateItem(Item{Name: "Кепка", Price: 8, BackorderPolicy: PolicyPreorder})
+
+	lis := bufconn.Listen(1 << 20)
+	srv := NewGRPCServer(s)
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := inventory.NewGRPC("bufnet", conn)
+	ctx := context.Background()
+
+	items, err := c.GetItems(ctx, []int{tee.ID, 999})
+	if err != nil || len(items) != 1 || items[tee.ID].Name != "Футболка" {
+		t.Fatalf("get items: %v %+v", err, items)
+	}
+	if _, err := c.GetItem(ctx, 999); !errors.Is(err, inventory.ErrNotFound) {
+		t.Fatalf("want not found, got %v", err)
+	}
+	res, err := c.Adjust(ctx, tee.ID, inventory.Adjustment{Delta: -2, Reason: "order"})
+	if err != nil || res.Quantity != 3 {
+		t.Fatalf("adjust: %v %+v", err, res)
+	}
+	if _, err := c.Adjust(ctx, tee.ID, inventory.Adjustment{Delta: -4}); !errors.Is(err, inventory.ErrInsufficientStock) {
+		t.Fatalf("want insufficient stock, got %v", err)
+	}
+
+	var apiErr *inventory.APIError
+	if _, err := c.CreateItem(ctx, inventory.NewItem{Name: "Плохой", CostingMethod: "lifo"}); !errors.As(err, &apiErr) || apiErr.StatusCode != 400 {
+		t.Fatalf("want invalid argument, got %v", err)
+	}
+	created, err := c.CreateItem(ctx, inventory.NewItem{Name: "Шарф", Quantity: 2, Price: 5, Category: " Clothes "})
+	if err != nil || created.CostingMethod != CostFIFO || created.Category != "clothes" {
+		t.Fatalf("create: %v %+v", err, created)
+	}
+
+	b, err := c.CreateBackorder(ctx, pre.ID, 3)
+	if err != nil || b.Status != inventory.BackorderOpen || b.Kind != PolicyPreorder {
+		t.Fatalf("backorder: %v %+v", err, b)
+	}
+	if b, err = c.CancelBackorder(ctx, b.ID); err != nil || b.Status != inventory.BackorderCancelled {
+		t.Fatalf("cancel: %v %+v", err, b)
+	}
+	// a conflict, as over REST
+	if _, err := c.CancelBackorder(ctx, b.ID); !errors.As(err, &apiErr) || apiErr.StatusCode != 409 {
+		t.Fatalf("want closed backorder conflict, got %v", err)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	// REST bodies carry the same code as gRPC's ErrorInfo, so clients match
+	// errors the same way over either API
+	body := errorBody(fmt.Errorf("lot 3: %w", ErrInsufficientStock))
+	if body["code"] != inventory.CodeInsufficientStock || body["error"] != "lot 3: insufficient stock" {
+		t.Fatalf("unexpected body %v", body)
+	}
+	if _, ok := errorBody(ErrNotFound)["code"]; ok {
+		t.Fatal("want no code for not found, which the status says")
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+// dateLayout is the format of lot expiry dates in the API
+const dateLayout = "2006-01-02"
+
+// Lot is a batch of an item received together, optionally with an expiry date
+type Lot struct {
+	ID        int    `json:"id"`
+	ItemID    int    `json:"item_id"`
+	ItemName  string `json:"item_name,omitempty"`
+	LotNumber string `json:"lot_number"`
+	Quantity  int    `json:"quantity"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	Received  int64  `json:"received_unix"`
+}
+
+// LotAllocation is the quantity taken from (or returned to) a single lot
+type LotAllocation struct {
+	LotID     int    `json:"lot_id"`
+	LotNumber string `json:"lot_number"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	Quantity  int    `json:"quantity"`
+}
+
+var ErrInvalidLot = &customError{"invalid lot"}
+
+func today() string { return time.Now().Format(dateLayout) }
+
+func expired(l *Lot, day string) bool {
+	return l.ExpiresAt != "" && l.ExpiresAt < day
+}
+
+// sortFEFO orders lots first-expired-first-out; lots without expiry go last
+func sortFEFO(lots []*Lot) {
+	sort.SliceStable(lots, func(i, j int) bool {
+		a, b := lots[i], lots[j]
+		if a.ExpiresAt != b.ExpiresAt {
+			if a.ExpiresAt == "" {
+				return false
+			}
+			if b.ExpiresAt == "" {
+				return true
+			}
+			return a.ExpiresAt < b.ExpiresAt
+		}
+		return a.ID < b.ID
+	})
+}
+
+// planAllocation picks lots for taking qty units of an item holding total units.
+// Stock not covered by any lot is treated as untracked and used after the lots;
+// expired lots are never allocated.
+func planAllocation(total int, lots []*Lot, qty int, day string) ([]LotAllocation, error) {
+	untracked := total
+	for _, l := range lots {
+		untracked -= l.Quantity
+	}
+	sorted := append([]*Lot(nil), lots...)
+	sortFEFO(sorted)
+
+	allocs := make([]LotAllocation, 0)
+	remaining := qty
+	for _, l := range sorted {
+		if remaining == 0 {
+			break
+		}
+		if l.Quantity <= 0 || expired(l, day) {
+			continue
+		}
+		take := l.Quantity
+		if take > remaining {
+			take = remaining
+		}
+		allocs = append(allocs, LotAllocation{LotID: l.ID, LotNumber: l.LotNumber, ExpiresAt: l.ExpiresAt, Quantity: take})
+		remaining -= take
+	}
+	if remaining > untracked {
+		return nil, ErrInsufficientStock
+	}
+	return allocs, nil
+}
+
+// validateReturn checks that lots being restocked belong to the item and do not
+// exceed the quantity being added.
+func validateReturn(lots map[int]*Lot, delta int, returned []LotAllocation) error {
+	sum := 0
+	for _, a := range returned {
+		if _, ok := lots[a.LotID]; !ok || a.Quantity <= 0 {
+			return ErrInvalidLot
+		}
+		sum += a.Quantity
+	}
+	if sum > delta {
+		return ErrInvalidLot
+	}
+	return nil
+}
+
+// ReceiveLot records a new lot and adds its quantity to the item's stock. A nil
+// unitCost receives the stock at the item's current cost.
+func (s *Inventory) ReceiveLot(itemID int, lotNumber string, qty int, expiresAt string, unitCost *float64) (*Lot, error) {
+	if lotNumber == "" || qty <= 0 {
+		return nil, ErrInvalidLot
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	st, err := lockItemTx(tx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if st.bundle {
+		return nil, ErrBundleStock
+	}
+	// serial-tracked units are received one by one through ReceiveSerials
+	if st.tracked {
+		return nil, ErrInvalidLot
+	}
+	if _, _, err := changeStockTx(tx, itemID, st, Movement{Delta: qty, Reason: ReasonReceipt, Reference: "lot " + lotNumber}, unitCost); err != nil {
+		return nil, err
+	}
+	l := &Lot{ItemID: itemID, LotNumber: lotNumber, Quantity: qty, ExpiresAt: expiresAt, Received: time.Now().Unix()}
+	var exp interface{}
+	if expiresAt != "" {
+		exp = expiresAt
+	}
+	err = tx.QueryRow(
+		"INSERT INTO lots (item_id, lot_number, quantity, expires_at, received_unix) VALUES ($1,$2,$3,$4,$5) RETURNING id",
+		itemID, lotNumber, qty, exp, l.Received,
+	).Scan(&l.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := fulfillBackordersTx(tx, itemID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+const lotColumns = "l.id, l.item_id, i.name, l.lot_number, l.quantity, l.expires_at, l.received_unix"
+
+func scanLot(row rowScanner) (*Lot, error) {
+	var l Lot
+	var exp sql.NullTime
+	if err := row.Scan(&l.ID, &l.ItemID, &l.ItemName, &l.LotNumber, &l.Quantity, &exp, &l.Received); err != nil {
+		return nil, err
+	}
+	if exp.Valid {
+		l.ExpiresAt = exp.Time.Format(dateLayout)
+	}
+	return &l, nil
+}
+
+func (s *Inventory) queryLots(q string, args ...interface{}) ([]*Lot, error) {
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]*Lot, 0)
+	for rows.Next() {
+		l, err := scanLot(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, l)
+	}
+	return res, rows.Err()
+}
+
+// Lots returns the lots of an item that still hold stock, in FEFO order
+func (s *Inventory) Lots(itemID int) ([]*Lot, error) {
+	return s.queryLots(`SELECT `+lotColumns+` FROM lots l JOIN items i ON i.id = l.item_id
+	WHERE l.item_id = $1 AND l.quantity > 0
+	ORDER BY l.expires_at NULLS LAST, l.id`, itemID)
+}
+
+// ExpiringLots returns lots with stock that expire within the given number of days,
+// including lots that have already expired.
+func (s *Inventory) ExpiringLots(days int) ([]*Lot, error) {
+	until := time.Now().AddDate(0, 0, days).Format(dateLayout)
+	return s.queryLots(`SELECT `+lotColumns+` FROM lots l JOIN items i ON i.id = l.item_id
+	WHERE l.quantity > 0 AND l.expires_at IS NOT NULL AND l.expires_at <= $1
+	ORDER BY l.expires_at, l.id`, until)
+}
+
+// adjustLotsTx applies a quantity change of an item holding total units to its lots.
+// Negative deltas are allocated in FEFO order; positive deltas go back to the
+// returned lots, if any.
+func adjustLotsTx(tx *sql.Tx, id, total, delta int, returned []LotAllocation) ([]LotAllocation, error) {
+	var allocs []LotAllocation
+	switch {
+	case delta < 0:
+		rows, err := tx.Query(`SELECT `+lotColumns+` FROM lots l JOIN items i ON i.id = l.item_id
+		WHERE l.item_id = $1 AND l.quantity > 0 FOR UPDATE OF l`, id)
+		if err != nil {
+			return nil, err
+		}
+		lots := make([]*Lot, 0)
+		for rows.Next() {
+			l, err := scanLot(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			lots = append(lots, l)
+		}
+		rows.Close()
+		allocs, err = planAllocation(total, lots, -delta, today())
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range allocs {
+			if _, err := tx.Exec("UPDATE lots SET quantity = quantity - $1 WHERE id = $2", a.Quantity, a.LotID); err != nil {
+				return nil, err
+			}
+		}
+	case delta > 0 && len(returned) > 0:
+		// returned lots may be empty by now, so look them up regardless of quantity
+		byID := make(map[int]*Lot)
+		for _, a := range returned {
+			l, err := scanLot(tx.QueryRow(`SELECT `+lotColumns+` FROM lots l JOIN items i ON i.id = l.item_id
+			WHERE l.id = $1 AND l.item_id = $2 FOR UPDATE OF l`, a.LotID, id))
+			if err == nil {
+				byID[l.ID] = l
+			}
+		}
+		if err := validateReturn(byID, delta, returned); err != nil {
+			return nil, err
+		}
+		for _, a := range returned {
+			if _, err := tx.Exec("UPDATE lots SET quantity = quantity + $1 WHERE id = $2", a.Quantity, a.LotID); err != nil {
+				return nil, err
+			}
+			l := byID[a.LotID]
+			allocs = append(allocs, LotAllocation{LotID: l.ID, LotNumber: l.LotNumber, ExpiresAt: l.ExpiresAt, Quantity: a.Quantity})
+		}
+	}
+	return allocs, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInventory_LotsFEFO(t *testing.T) {
+	s := NewInventoryInMemory()
+	it := s.Create("milk", 5, 1.0) // 5 untracked units
+	soon := time.Now().AddDate(0, 0, 3).Format(dateLayout)
+	later := time.Now().AddDate(0, 0, 20).Format(dateLayout)
+	past := time.Now().AddDate(0, 0, -1).Format(dateLayout)
+
+	lLater, err := s.ReceiveLot(it.ID, "L-LATER", 10, later, nil)
+	if err != nil {
+		t.Fatalf("unexpected error from ReceiveLot: %v", err)
+	}
+	lSoon, _ := s.ReceiveLot(it.ID, "L-SOON", 4, soon, nil)
+	lPast, _ := s.ReceiveLot(it.ID, "L-PAST", 7, past, nil)
+	if got, _ := s.Get(it.ID); got.Quantity != 26 || got.Available != 19 {
+		t.Fatalf("expected quantity 26 with 19 sellable after receipts, got %d and %d", got.Quantity, got.Available)
+	}
+
+	// 6 units: all of the soonest lot, then 2 from the later one; expired lot skipped
+	_, out, err := s.Adjust(it.ID, Adjustment{Delta: -6})
+	if err != nil {
+		t.Fatalf("unexpected error from Adjust: %v", err)
+	}
+	if len(out.Lots) != 2 || out.Lots[0].LotID != lSoon.ID || out.Lots[0].Quantity != 4 ||
+		out.Lots[1].LotID != lLater.ID || out.Lots[1].Quantity != 2 {
+		t.Fatalf("unexpected allocations: %+v", out.Lots)
+	}
+
+	// 8 lot units and 5 untracked units left that can be sold; 7 are expired
+	if got, _ := s.Get(it.ID); got.Available != 13 {
+		t.Fatalf("expected 13 sellable, got %d", got.Available)
+	}
+	if _, _, err := s.Adjust(it.ID, Adjustment{Delta: -14}); err == nil {
+		t.Fatalf("expected insufficient stock when only expired lots remain")
+	}
+	_, out, err = s.Adjust(it.ID, Adjustment{Delta: -13})
+	if err != nil {
+		t.Fatalf("unexpected error from Adjust: %v", err)
+	}
+	if len(out.Lots) != 1 || out.Lots[0].Quantity != 8 {
+		t.Fatalf("expected 8 units from the later lot, got %+v", out.Lots)
+	}
+
+	// restock to a named lot
+	_, out, err = s.Adjust(it.ID, Adjustment{Delta: 3, Allocation: Allocation{Lots: []LotAllocation{{LotID: lSoon.ID, Quantity: 3}}}})
+	if err != nil || len(out.Lots) != 1 || out.Lots[0].LotNumber != "L-SOON" {
+		t.Fatalf("unexpected restock result: %+v, %v", out.Lots, err)
+	}
+	if _, _, err := s.Adjust(it.ID, Adjustment{Delta: 1, Allocation: Allocation{Lots: []LotAllocation{{LotID: lSoon.ID, Quantity: 2}}}}); err == nil {
+		t.Fatalf("expected error returning more to lots than delta")
+	}
+
+	expiring, _ := s.ExpiringLots(7)
+	if len(expiring) != 2 || expiring[0].ID != lPast.ID || expiring[1].ID != lSoon.ID {
+		t.Fatalf("unexpected expiring lots: %+v", expiring)
+	}
+}
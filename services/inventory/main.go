@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
+	"net"
 	"net/http"
 	"os"
 
 	_ "github.com/lib/pq"
+
+	"inventoryshop/pkg/events"
 )
 
 func main() {
@@ -39,7 +43,38 @@ func main() {
 		store.Create("Футболка", 50, 7.5)
 	}
 
-	router := NewRouter(store)
+	// domain events go from the outbox to webhook subscribers and, if
+	// INVENTORY_EVENTS_FILE is set, to a JSON lines file; once published, to
+	// the in-process bus
+	bus := events.NewBus()
+	hooks := events.NewDispatcher(store)
+	sink := events.MultiSink{hooks}
+	if path := os.Getenv("INVENTORY_EVENTS_FILE"); path != "" {
+		sink = append(sink, events.NewFileSink(path))
+	}
+	relay := events.NewRelay(store, sink)
+	relay.Live = bus
+	go relay.Run(context.Background())
+	go hooks.Run(context.Background())
+
+	// the gRPC API serves other services from the same store as REST
+	grpcPort := "9001"
+	if p := os.Getenv("INVENTORY_GRPC_PORT"); p != "" {
+		grpcPort = p
+	}
+	lis, err := net.Listen("tcp", ":"+grpcPort)
+	if err != nil {
+		log.Fatalf("failed to listen for gRPC: %v", err)
+	}
+	log.Printf("Inventory gRPC listening on :%s", grpcPort)
+	go func() { log.Fatal(NewGRPCServer(store).Serve(lis)) }()
+
+	// staff authenticate with the admin token; without one nobody is staff
+	adminToken := os.Getenv("INVENTORY_ADMIN_TOKEN")
+	if adminToken == "" {
+		log.Printf("WARNING: INVENTORY_ADMIN_TOKEN is not set, staff endpoints are unreachable until it is")
+	}
+	router := NewRouter(store, bus, adminToken)
 	log.Printf("Inventory service listening on :%s", port)
 	log.Fatal(http.ListenAndServe(":"+port, router))
 }
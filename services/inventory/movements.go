@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Reasons recorded in the stock movement log
+const (
+	ReasonAdjust    = "adjust"
+	ReasonReceipt   = "receipt"
+	ReasonCount     = "count"
+	ReasonBackorder = "backorder"
+)
+
+// Movement is an entry in the stock movement log of an item. Value is the signed
+// change of stock value; for stock leaving it is the cost of goods taken.
+type Movement struct {
+	ID        int     `json:"id"`
+	ItemID    int     `json:"item_id"`
+	Delta     int     `json:"delta"`
+	Reason    string  `json:"reason"`
+	Reference string  `json:"reference,omitempty"`
+	UnitCost  float64 `json:"unit_cost"`
+	Value     float64 `json:"value"`
+	Created   int64   `json:"created_unix"`
+}
+
+// recordMovementTx logs a stock change, filling in the reason and time
+func recordMovementTx(tx *sql.Tx, m *Movement) error {
+	if m.Delta == 0 {
+		return nil
+	}
+	if m.Reason == "" {
+		m.Reason = ReasonAdjust
+	}
+	m.Created = time.Now().Unix()
+	return tx.QueryRow(
+		"INSERT INTO stock_movements (item_id, delta, reason, reference, unit_cost, value, created_unix) VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING id",
+		m.ItemID, m.Delta, m.Reason, m.Reference, m.UnitCost, m.Value, m.Created,
+	).Scan(&m.ID)
+}
+
+// Movements returns the stock movement log of an item, oldest first
+func (s *Inventory) Movements(itemID int) ([]*Movement, error) {
+	rows, err := s.db.Query(`SELECT id, item_id, delta, reason, reference, unit_cost, value, created_unix
+	FROM stock_movements WHERE item_id = $1 ORDER BY id`, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]*Movement, 0)
+	for rows.Next() {
+		var m Movement
+		if err := rows.Scan(&m.ID, &m.ItemID, &m.Delta, &m.Reason, &m.Reference, &m.UnitCost, &m.Value, &m.Created); err != nil {
+			return nil, err
+		}
+		res = append(res, &m)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	_ "embed"
+
+	"inventoryshop/pkg/openapi"
+)
+
+// openapiDoc describes the REST API. Requests are validated against it and it is
+// served at /openapi.json; keep it in step with the handlers.
+//
+//go:embed openapi.json
+var openapiDoc []byte
+
+var apiSpec = openapi.MustLoad(openapiDoc)
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"inventoryshop/pkg/events"
+)
+
+func TestOpenAPI_Served(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewRouter(nil, events.NewBus(), "").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	var doc struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("get document: %d %v", rec.Code, err)
+	}
+	for _, p := range []string{"/items", "/items/{id}/adjust", "/counts/{id}/scans", "/lots/expiring"} {
+		if _, ok := doc.Paths[p]; !ok {
+			t.Errorf("%s not described", p)
+		}
+	}
+}
+
+func TestOpenAPI_ValidatesRequests(t *testing.T) {
+	// invalid requests never reach the handlers, so no store is needed
+	router := NewRouter(nil, events.NewBus(), "")
+	cases := []struct {
+		method, target, body string
+		fields               []string
+	}{
+		{http.MethodPost, "/items", `{"name": "", "quantity": -1, "available_at": "tomorrow", "costing_method": "lifo"}`,
+			[]string{"available_at", "costing_method", "name", "quantity"}},
+		{http.MethodPost, "/items", `{"price": 5}`, []string{"name"}},
+		{http.MethodGet, "/items?ids=1,x", "", []string{"ids[1]"}},
+		{http.MethodPost, "/items/abc/adjust", `{"delta": 1}`, []string{"id"}},
+		{http.MethodPost, "/items/1/adjust", `{"delta": "1", "lots": [{"lot_id": 2, "quantity": 0}]}`, []string{"delta", "lots[0].quantity"}},
+		{http.MethodPost, "/items/1/backorders", `{}`, []string{"quantity"}},
+		{http.MethodPost, "/webhooks", `{"url": "not a url", "event_types": ["OrderCreated"]}`, []string{"event_types[0]", "url"}},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(c.method, c.target, strings.NewReader(c.body)))
+		var res struct {
+			Error   string `json:"error"`
+			Details []struct {
+				Field string `json:"field"`
+			} `json:"details"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil || rec.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: want 400, got %d %v", c.method, c.target, rec.Code, err)
+			continue
+		}
+		var fields []string
+		for _, d := range res.Details {
+			fields = append(fields, d.Field)
+		}
+		if strings.Join(fields, " ") != strings.Join(c.fields, " ") {
+			t.Errorf("%s %s: want errors for %v, got %v", c.method, c.target, c.fields, fields)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"inventoryshop/pkg/events"
+)
+
+// Domain event types written to the outbox
+const (
+	EventItemCreated   = "ItemCreated"
+	EventStockAdjusted = "StockAdjusted"
+)
+
+// eventTypes are all the event types this service publishes
+var eventTypes = []string{EventItemCreated, EventStockAdjusted}
+
+// eventSource names this service in published events
+const eventSource = "inventory"
+
+// StockAdjustedEvent is the payload of StockAdjusted
+type StockAdjustedEvent struct {
+	ItemID    int    `json:"item_id"`
+	Delta     int    `json:"delta"`
+	Quantity  int    `json:"quantity"`
+	Reason    string `json:"reason"`
+	Reference string `json:"reference,omitempty"`
+}
+
+func stockAdjusted(it *Item, m Movement) StockAdjustedEvent {
+	return StockAdjustedEvent{ItemID: it.ID, Delta: m.Delta, Quantity: it.Quantity, Reason: m.Reason, Reference: m.Reference}
+}
+
+func newEvent(typ string, aggregateID int, payload interface{}) events.Event {
+	return events.New(eventSource, typ, aggregateID, payload)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"inventoryshop/pkg/events"
+)
+
+func TestOutbox_Relay(t *testing.T) {
+	s := NewInventoryInMemory()
+	it := s.Create("Толстовка", 5, 10)
+	if _, err := s.UpdateQuantity(it.ID, -2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.UpdateQuantity(it.ID, -10); err == nil {
+		t.Fatal("expected insufficient stock")
+	}
+
+	bus := events.NewBus()
+	ch, cancel := bus.Subscribe(10)
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	relay := events.NewRelay(s, events.MultiSink{bus, events.NewFileSink(path)})
+	if err := relay.Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{EventItemCreated, EventStockAdjusted, EventStockAdjusted}
+	for i, typ := range want {
+		e := <-ch
+		if e.Type != typ || e.ID != int64(i+1) || e.AggregateID != it.ID || e.Source != eventSource {
+			t.Fatalf("unexpected event %d: %+v", i, e)
+		}
+	}
+	var last StockAdjustedEvent
+	f, _ := os.Open(path)
+	defer f.Close()
+	lines := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); lines++ {
+		var e events.Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("bad line %q: %v", sc.Text(), err)
+		}
+		json.Unmarshal(e.Payload, &last)
+	}
+	if lines != 3 || last.Delta != -2 || last.Quantity != 3 {
+		t.Fatalf("unexpected file contents: %d lines, last %+v", lines, last)
+	}
+
+	// published events are not sent again
+	if n, _ := s.RelayEvents(10, func([]events.Event) error { return nil }); n != 0 {
+		t.Fatalf("expected nothing pending, got %d", n)
+	}
+}
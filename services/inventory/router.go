@@ -1,37 +1,98 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
+
+	"inventoryshop/pkg/events"
 )
 
-func NewRouter(store *Inventory) http.Handler {
+// maxBulkItems is how many items GET /items?ids= returns at most
+const maxBulkItems = 200
+
+// NewRouter serves the REST API. adminToken is the bearer token that staff-only
+// endpoints, such as webhook subscriptions, are called with.
+func NewRouter(store *Inventory, bus *events.Bus, adminToken string) http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/items", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
+			// ?ids=1,2,3 returns just those items, leaving out ids that do not exist
+			if v := r.URL.Query().Get("ids"); v != "" {
+				filter, err := parseItemFilter(v)
+				if err == nil && len(filter) > maxBulkItems {
+					err = fmt.Errorf("at most %d ids at a time", maxBulkItems)
+				}
+				if err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+					return
+				}
+				ids := make([]int, 0, len(filter))
+				for id := range filter {
+					ids = append(ids, id)
+				}
+				list, err := store.GetMany(ids)
+				if err != nil {
+					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+					return
+				}
+				writeJSON(w, http.StatusOK, list)
+				return
+			}
 			list := store.List()
 			writeJSON(w, http.StatusOK, list)
 		case http.MethodPost:
 			var req struct {
-				Name     string  `json:"name"`
-				Quantity int     `json:"quantity"`
-				Price    float64 `json:"price"`
+				Name          string      `json:"name"`
+				Quantity      int         `json:"quantity"`
+				Price         float64     `json:"price"`
+				SerialTracked bool        `json:"serial_tracked"`
+				UnitCost      float64     `json:"unit_cost"`
+				CostingMethod string      `json:"costing_method"`
+				Components    []Component `json:"components"`
+				// "backorder" or "preorder" to accept orders beyond stock
+				BackorderPolicy string `json:"backorder_policy"`
+				AvailableAt     string `json:"available_at"`
+				Category        string `json:"category"`
+				Dimensions
 			}
 			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
 				return
 			}
-			it := store.Create(req.Name, req.Quantity, req.Price)
-			writeJSON(w, http.StatusCreated, it)
+			it := Item{
+				Name:            req.Name,
+				Quantity:        req.Quantity,
+				Price:           req.Price,
+				SerialTracked:   req.SerialTracked,
+				CostingMethod:   req.CostingMethod,
+				UnitCost:        req.UnitCost,
+				Components:      req.Components,
+				BackorderPolicy: req.BackorderPolicy,
+				AvailableAt:     req.AvailableAt,
+				Category:        req.Category,
+				Dimensions:      req.Dimensions,
+			}
+			if err := validateNewItem(&it, store.Get); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusCreated, store.CreateItem(it))
 		default:
 			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
 	})
 
+	mux.HandleFunc("/items/stream", streamHandler(store, bus))
+
 	mux.HandleFunc("/items/", func(w http.ResponseWriter, r *http.Request) {
 		// expected: /items/{id} or /items/{id}/adjust
 		path := strings.TrimPrefix(r.URL.Path, "/items/")
@@ -64,27 +125,489 @@ func NewRouter(store *Inventory) http.Handler {
 
 		// path like {id}/adjust
 		if parts[1] == "adjust" && r.Method == http.MethodPost {
-			// read delta from JSON body {"delta": -2}
+			// read delta from JSON body {"delta": -2, "reason": "damaged"}; restocking may
+			// name lots and serials {"delta": 2, "lots": [{"lot_id": 1, "quantity": 2}], "serials": ["SN1", "SN2"]},
+			// or for a bundle the components returned {"delta": 1, "components": [{"item_id": 3, "quantity": 2, "lots": [...]}]}
+			var req Adjustment
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+				return
+			}
+			it, alloc, err := store.Adjust(id, req)
+			if err != nil {
+				code := http.StatusBadRequest
+				if errors.Is(err, ErrNotFound) {
+					code = http.StatusNotFound
+				}
+				writeJSON(w, code, errorBody(err))
+				return
+			}
+			// the allocation's components take the place of the bundle's own
+			writeJSON(w, http.StatusOK, struct {
+				*Item
+				Allocation
+				Components []ComponentAllocation `json:"components,omitempty"`
+			}{it, alloc, alloc.Components})
+			return
+		}
+
+		// path like {id}/movements
+		if parts[1] == "movements" && r.Method == http.MethodGet {
+			list, err := store.Movements(id)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, list)
+			return
+		}
+
+		// path like {id}/serials
+		if parts[1] == "serials" {
+			switch r.Method {
+			case http.MethodGet:
+				list, err := store.Serials(id, r.URL.Query().Get("status"))
+				if err != nil {
+					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+					return
+				}
+				writeJSON(w, http.StatusOK, list)
+			case http.MethodPost:
+				var req struct {
+					Serials  []string `json:"serials"`
+					UnitCost *float64 `json:"unit_cost"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+					return
+				}
+				list, err := store.ReceiveSerials(id, req.Serials, req.UnitCost)
+				if err != nil {
+					code := http.StatusBadRequest
+					switch {
+					case errors.Is(err, ErrNotFound):
+						code = http.StatusNotFound
+					case errors.Is(err, ErrDuplicateSerial):
+						code = http.StatusConflict
+					}
+					writeJSON(w, code, map[string]string{"error": err.Error()})
+					return
+				}
+				writeJSON(w, http.StatusCreated, list)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
+		// path like {id}/availability: {"backorder_policy": "preorder", "available_at": "2024-09-01"}
+		if parts[1] == "availability" && r.Method == http.MethodPost {
 			var req struct {
-				Delta int `json:"delta"`
+				BackorderPolicy string `json:"backorder_policy"`
+				AvailableAt     string `json:"available_at"`
 			}
 			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
 				return
 			}
-			it, err := store.UpdateQuantity(id, req.Delta)
-			if err != nil {
+			if err := validateAvailability(req.BackorderPolicy, req.AvailableAt); err != nil {
 				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 				return
 			}
+			it, err := store.SetAvailability(id, req.BackorderPolicy, req.AvailableAt)
+			if err != nil {
+				writeJSON(w, backorderErrorStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, it)
+			return
+		}
+
+		// path like {id}/dimensions: {"weight_kg": 1.2, "length_cm": 30, "width_cm": 20, "height_cm": 10}
+		if parts[1] == "dimensions" && r.Method == http.MethodPost {
+			var req Dimensions
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+				return
+			}
+			it, err := store.SetDimensions(id, req)
+			if err != nil {
+				code := http.StatusInternalServerError
+				switch {
+				case errors.Is(err, ErrNotFound):
+					code = http.StatusNotFound
+				case errors.Is(err, ErrInvalidDimensions):
+					code = http.StatusBadRequest
+				}
+				writeJSON(w, code, map[string]string{"error": err.Error()})
+				return
+			}
 			writeJSON(w, http.StatusOK, it)
 			return
 		}
 
+		// path like {id}/backorders
+		if parts[1] == "backorders" {
+			switch r.Method {
+			case http.MethodGet:
+				list, err := store.Backorders(id, r.URL.Query().Get("status"))
+				if err != nil {
+					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+					return
+				}
+				writeJSON(w, http.StatusOK, list)
+			case http.MethodPost:
+				var req struct {
+					Quantity  int    `json:"quantity"`
+					Reference string `json:"reference"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+					return
+				}
+				b, err := store.CreateBackorder(id, req.Quantity, req.Reference)
+				if err != nil {
+					writeJSON(w, backorderErrorStatus(err), map[string]string{"error": err.Error()})
+					return
+				}
+				writeJSON(w, http.StatusCreated, b)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
+		// path like {id}/lots
+		if parts[1] == "lots" {
+			switch r.Method {
+			case http.MethodGet:
+				lots, err := store.Lots(id)
+				if err != nil {
+					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+					return
+				}
+				writeJSON(w, http.StatusOK, lots)
+			case http.MethodPost:
+				var req struct {
+					LotNumber string   `json:"lot_number"`
+					Quantity  int      `json:"quantity"`
+					ExpiresAt string   `json:"expires_at"`
+					UnitCost  *float64 `json:"unit_cost"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+					return
+				}
+				if req.ExpiresAt != "" {
+					if _, err := time.Parse(dateLayout, req.ExpiresAt); err != nil {
+						writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid expires_at, expected YYYY-MM-DD"})
+						return
+					}
+				}
+				lot, err := store.ReceiveLot(id, req.LotNumber, req.Quantity, req.ExpiresAt, req.UnitCost)
+				if err != nil {
+					code := http.StatusBadRequest
+					if errors.Is(err, ErrNotFound) {
+						code = http.StatusNotFound
+					}
+					writeJSON(w, code, map[string]string{"error": err.Error()})
+					return
+				}
+				writeJSON(w, http.StatusCreated, lot)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
 		w.WriteHeader(http.StatusNotFound)
 	})
 
-	return loggingMiddleware(corsMiddleware(mux))
+	mux.HandleFunc("/counts", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			list, err := store.ListCounts()
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, list)
+		case http.MethodPost:
+			// {"item_ids": [1, 2]} counts those items; an empty body counts everything
+			var req struct {
+				ItemIDs []int `json:"item_ids"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+				return
+			}
+			cs, err := store.StartCount(req.ItemIDs)
+			if err != nil {
+				writeJSON(w, countErrorStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusCreated, cs)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/counts/", func(w http.ResponseWriter, r *http.Request) {
+		// expected: /counts/{id}, /counts/{id}/lines, /counts/{id}/scans or /counts/{id}/approve
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/counts/"), "/")
+		id, err := strconv.Atoi(parts[0])
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid id"})
+			return
+		}
+
+		if len(parts) == 1 {
+			if r.Method != http.MethodGet {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			cs, err := store.GetCount(id)
+			if err != nil {
+				writeJSON(w, countErrorStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, cs)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var cs *CountSession
+		switch parts[1] {
+		case "lines":
+			// counted quantities {"lines": [{"item_id": 1, "counted": 7}]} replace earlier counts
+			var req struct {
+				Lines []struct {
+					ItemID  int `json:"item_id"`
+					Counted int `json:"counted"`
+				} `json:"lines"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+				return
+			}
+			counts := make(map[int]int, len(req.Lines))
+			for _, l := range req.Lines {
+				counts[l.ItemID] = l.Counted
+			}
+			cs, err = store.RecordCounts(id, counts, false)
+		case "scans":
+			// a batch of scanned item ids {"item_ids": [1, 1, 2]}, one unit per scan
+			var req struct {
+				ItemIDs []int `json:"item_ids"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+				return
+			}
+			counts := make(map[int]int)
+			for _, itemID := range req.ItemIDs {
+				counts[itemID]++
+			}
+			cs, err = store.RecordCounts(id, counts, true)
+		case "approve":
+			cs, err = store.ApproveCount(id)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeJSON(w, countErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, cs)
+	})
+
+	// webhooks make the service call out to any URL, so only staff manage them
+	mux.HandleFunc("/webhooks", adminOnly(adminToken, events.WebhooksHandler(store, eventTypes)))
+	mux.HandleFunc("/webhooks/", adminOnly(adminToken, events.WebhooksHandler(store, eventTypes)))
+
+	mux.HandleFunc("/backorders/", func(w http.ResponseWriter, r *http.Request) {
+		// expected: /backorders/{id} or /backorders/{id}/cancel
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/backorders/"), "/")
+		id, err := strconv.Atoi(parts[0])
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid id"})
+			return
+		}
+		var b *Backorder
+		switch {
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			b, err = store.GetBackorder(id)
+		case len(parts) == 2 && parts[1] == "cancel" && r.Method == http.MethodPost:
+			b, err = store.CancelBackorder(id)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeJSON(w, backorderErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, b)
+	})
+
+	// stock value at a point in time: /reports/valuation?at=2024-05-01T00:00:00Z
+	mux.HandleFunc("/reports/valuation", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		at, err := parseTime(r.URL.Query().Get("at"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid at, expected RFC 3339, YYYY-MM-DD or unix seconds"})
+			return
+		}
+		v, err := store.Valuation(at)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	})
+
+	// serial lookup: /serials/{serial}
+	mux.HandleFunc("/serials/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		serial := strings.TrimPrefix(r.URL.Path, "/serials/")
+		if serial == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sn, err := store.GetSerial(serial)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, sn)
+	})
+
+	// lots expiring within N days: /lots/expiring?days=30
+	mux.HandleFunc("/lots/expiring", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		days := 30
+		if v := r.URL.Query().Get("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid days"})
+				return
+			}
+			days = n
+		}
+		lots, err := store.ExpiringLots(days)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, lots)
+	})
+
+	// the API description, which requests are also validated against
+	mux.Handle("/openapi.json", apiSpec)
+
+	return loggingMiddleware(corsMiddleware(apiSpec.Middleware(mux)))
+}
+
+// parseTime reads a point in time as unix seconds. An empty value means now and a
+// bare date means the end of that day.
+func parseTime(v string) (int64, error) {
+	if v == "" {
+		return time.Now().Unix(), nil
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.Unix(), nil
+	}
+	t, err := time.Parse(dateLayout, v)
+	if err != nil {
+		return 0, err
+	}
+	return t.AddDate(0, 0, 1).Unix() - 1, nil
+}
+
+// validateAvailability checks a backorder policy and expected availability date
+func validateAvailability(policy, availableAt string) error {
+	if !validBackorderPolicy(policy) {
+		return ErrInvalidBackorder
+	}
+	if availableAt != "" {
+		if _, err := time.Parse(dateLayout, availableAt); err != nil {
+			return errors.New("invalid available_at, expected YYYY-MM-DD")
+		}
+	}
+	return nil
+}
+
+// errorBody is the body of an error response. Errors clients act on carry a
+// machine-readable code besides the message, as ErrorInfo reasons do over gRPC.
+func errorBody(err error) map[string]string {
+	body := map[string]string{"error": err.Error()}
+	if code := errorCode(err); code != "" {
+		body["code"] = code
+	}
+	return body
+}
+
+// errorCode is the machine-readable code of an error, if it has one
+func errorCode(err error) string {
+	if errors.Is(err, ErrInsufficientStock) {
+		return "insufficient_stock"
+	}
+	return ""
+}
+
+func backorderErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrBackorderClosed):
+		return http.StatusConflict
+	case errors.Is(err, ErrBackorderNotAllowed), errors.Is(err, ErrInvalidBackorder), errors.Is(err, ErrBundleStock):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func countErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrCountClosed):
+		return http.StatusConflict
+	case errors.Is(err, ErrItemNotInCount), errors.Is(err, ErrInvalidCount),
+		errors.Is(err, ErrInsufficientStock), errors.Is(err, ErrSerialsRequired), errors.Is(err, ErrBundleStock):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// adminOnly guards endpoints for staff, who send the admin token as a bearer
+// token. With no admin token configured nobody gets through.
+func adminOnly(adminToken string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := r.Header.Get("Authorization")
+		token := strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+		if adminToken == "" || !strings.HasPrefix(h, "Bearer ") || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next(w, r)
+	}
 }
 
 func loggingMiddleware(next http.Handler) http.Handler {
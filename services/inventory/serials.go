@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Serial statuses
+const (
+	SerialInStock   = "in_stock"
+	SerialAllocated = "allocated"
+)
+
+// Serial is a single unit of a serial-tracked item
+type Serial struct {
+	Serial   string `json:"serial"`
+	ItemID   int    `json:"item_id"`
+	Status   string `json:"status"`
+	Received int64  `json:"received_unix"`
+}
+
+var (
+	ErrNotSerialTracked = &customError{"item is not serial tracked"}
+	ErrSerialsRequired  = &customError{"serials required for serial tracked item"}
+	ErrInvalidSerial    = &customError{"invalid serial"}
+	ErrDuplicateSerial  = &customError{"serial already registered"}
+	// serial-tracked stock only arrives through serial receipts
+	ErrSerialQuantity = &customError{"serial tracked items must be created with zero quantity"}
+)
+
+// validateNewSerials rejects empty and repeated serial numbers in a receipt
+func validateNewSerials(serials []string) error {
+	if len(serials) == 0 {
+		return ErrSerialsRequired
+	}
+	seen := make(map[string]bool, len(serials))
+	for _, sn := range serials {
+		if sn == "" {
+			return ErrInvalidSerial
+		}
+		if seen[sn] {
+			return ErrDuplicateSerial
+		}
+		seen[sn] = true
+	}
+	return nil
+}
+
+// sortSerials orders serials oldest receipt first, so stock is allocated FIFO
+func sortSerials(serials []*Serial) {
+	sort.Slice(serials, func(i, j int) bool {
+		if serials[i].Received != serials[j].Received {
+			return serials[i].Received < serials[j].Received
+		}
+		return serials[i].Serial < serials[j].Serial
+	})
+}
+
+// ReceiveSerials registers received units of a serial-tracked item and adds them
+// to stock. A nil unitCost receives the stock at the item's current cost.
+func (s *Inventory) ReceiveSerials(itemID int, serials []string, unitCost *float64) ([]*Serial, error) {
+	if err := validateNewSerials(serials); err != nil {
+		return nil, err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	st, err := lockItemTx(tx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if st.bundle {
+		return nil, ErrBundleStock
+	}
+	if !st.tracked {
+		return nil, ErrNotSerialTracked
+	}
+	now := time.Now().Unix()
+	res := make([]*Serial, 0, len(serials))
+	for _, sn := range serials {
+		r, err := tx.Exec(`INSERT INTO serials (serial, item_id, status, received_unix) VALUES ($1,$2,$3,$4)
+		ON CONFLICT (serial) DO NOTHING`, sn, itemID, SerialInStock, now)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := r.RowsAffected(); n == 0 {
+			return nil, ErrDuplicateSerial
+		}
+		res = append(res, &Serial{Serial: sn, ItemID: itemID, Status: SerialInStock, Received: now})
+	}
+	if _, _, err := changeStockTx(tx, itemID, st, Movement{Delta: len(serials), Reason: ReasonReceipt, Reference: "serials"}, unitCost); err != nil {
+		return nil, err
+	}
+	if err := fulfillBackordersTx(tx, itemID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// adjustSerialsTx allocates serials for a negative delta or returns the given
+// serials to stock for a positive one. Every unit of a serial-tracked item must
+// be accounted for, so a positive delta needs exactly delta serials.
+func adjustSerialsTx(tx *sql.Tx, id, delta int, returned []string) ([]string, error) {
+	switch {
+	case delta < 0:
+		rows, err := tx.Query(`SELECT serial FROM serials WHERE item_id = $1 AND status = $2
+		ORDER BY received_unix, serial LIMIT $3 FOR UPDATE`, id, SerialInStock, -delta)
+		if err != nil {
+			return nil, err
+		}
+		picked := make([]string, 0, -delta)
+		for rows.Next() {
+			var sn string
+			if err := rows.Scan(&sn); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			picked = append(picked, sn)
+		}
+		rows.Close()
+		if len(picked) < -delta {
+			return nil, ErrInsufficientStock
+		}
+		for _, sn := range picked {
+			if _, err := tx.Exec("UPDATE serials SET status = $1 WHERE serial = $2", SerialAllocated, sn); err != nil {
+				return nil, err
+			}
+		}
+		return picked, nil
+	case delta > 0:
+		if len(returned) != delta {
+			return nil, ErrSerialsRequired
+		}
+		for _, sn := range returned {
+			r, err := tx.Exec("UPDATE serials SET status = $1 WHERE serial = $2 AND item_id = $3 AND status = $4",
+				SerialInStock, sn, id, SerialAllocated)
+			if err != nil {
+				return nil, err
+			}
+			if n, _ := r.RowsAffected(); n == 0 {
+				return nil, ErrInvalidSerial
+			}
+		}
+		return returned, nil
+	}
+	return nil, nil
+}
+
+// GetSerial looks up a single serial number
+func (s *Inventory) GetSerial(serial string) (*Serial, error) {
+	var sn Serial
+	row := s.db.QueryRow("SELECT serial, item_id, status, received_unix FROM serials WHERE serial = $1", serial)
+	if err := row.Scan(&sn.Serial, &sn.ItemID, &sn.Status, &sn.Received); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &sn, nil
+}
+
+// Serials lists the serials of an item, optionally filtered by status
+func (s *Inventory) Serials(itemID int, status string) ([]*Serial, error) {
+	rows, err := s.db.Query(`SELECT serial, item_id, status, received_unix FROM serials
+	WHERE item_id = $1 AND ($2 = '' OR status = $2)
+	ORDER BY received_unix, serial`, itemID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]*Serial, 0)
+	for rows.Next() {
+		var sn Serial
+		if err := rows.Scan(&sn.Serial, &sn.ItemID, &sn.Status, &sn.Received); err != nil {
+			return nil, err
+		}
+		res = append(res, &sn)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestInventory_Serials(t *testing.T) {
+	s := NewInventoryInMemory()
+	plain := s.Create("cable", 10, 1.0)
+	if _, err := s.ReceiveSerials(plain.ID, []string{"X"}, nil); err != ErrNotSerialTracked {
+		t.Fatalf("expected ErrNotSerialTracked, got %v", err)
+	}
+
+	phone := s.CreateItem(Item{Name: "phone", Price: 500, SerialTracked: true})
+	if _, err := s.ReceiveSerials(phone.ID, []string{"SN1", "SN1"}, nil); err != ErrDuplicateSerial {
+		t.Fatalf("expected ErrDuplicateSerial, got %v", err)
+	}
+	if _, err := s.ReceiveSerials(phone.ID, []string{"SN1", "SN2", "SN3"}, nil); err != nil {
+		t.Fatalf("unexpected error from ReceiveSerials: %v", err)
+	}
+	if got, _ := s.Get(phone.ID); got.Quantity != 3 {
+		t.Fatalf("expected quantity 3, got %d", got.Quantity)
+	}
+
+	_, out, err := s.Adjust(phone.ID, Adjustment{Delta: -2})
+	if err != nil {
+		t.Fatalf("unexpected error from Adjust: %v", err)
+	}
+	if len(out.Serials) != 2 {
+		t.Fatalf("expected 2 allocated serials, got %v", out.Serials)
+	}
+	sn, _ := s.GetSerial(out.Serials[0])
+	if sn.Status != SerialAllocated {
+		t.Fatalf("expected serial allocated, got %s", sn.Status)
+	}
+	if _, _, err := s.Adjust(phone.ID, Adjustment{Delta: -2}); err == nil {
+		t.Fatalf("expected insufficient stock with one serial left")
+	}
+
+	// restocking must name exactly the allocated serials
+	if _, _, err := s.Adjust(phone.ID, Adjustment{Delta: 1}); err != ErrSerialsRequired {
+		t.Fatalf("expected ErrSerialsRequired, got %v", err)
+	}
+	inStock, _ := s.Serials(phone.ID, SerialInStock)
+	if _, _, err := s.Adjust(phone.ID, Adjustment{Delta: 1, Allocation: Allocation{Serials: []string{inStock[0].Serial}}}); err != ErrInvalidSerial {
+		t.Fatalf("expected ErrInvalidSerial for an in-stock serial, got %v", err)
+	}
+	it, _, err := s.Adjust(phone.ID, Adjustment{Delta: 2, Allocation: Allocation{Serials: out.Serials}})
+	if err != nil {
+		t.Fatalf("unexpected error returning serials: %v", err)
+	}
+	if it.Quantity != 3 {
+		t.Fatalf("expected quantity 3 after return, got %d", it.Quantity)
+	}
+	if list, _ := s.Serials(phone.ID, SerialInStock); len(list) != 3 {
+		t.Fatalf("expected all serials in stock, got %d", len(list))
+	}
+}
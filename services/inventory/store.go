@@ -3,20 +3,62 @@ package main
 import (
 	"database/sql"
 	"errors"
-	_ "github.com/lib/pq"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"inventoryshop/pkg/events"
 )
 
 // Item represents a product in inventory
 type Item struct {
-	ID       int     `json:"id"`
-	Name     string  `json:"name"`
-	Quantity int     `json:"quantity"`
-	Price    float64 `json:"price"`
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+	// Available is how much of Quantity can be sold, leaving out expired lots
+	Available     int         `json:"available"`
+	Price         float64     `json:"price"`
+	SerialTracked bool        `json:"serial_tracked"`
+	CostingMethod string      `json:"costing_method"`
+	StockValue    float64     `json:"stock_value"`
+	UnitCost      float64     `json:"unit_cost"`
+	Components    []Component `json:"components,omitempty"`
+	// BackorderPolicy lets orders beyond stock be accepted as backorders or
+	// pre-orders; AvailableAt is when stock is expected (YYYY-MM-DD)
+	BackorderPolicy string `json:"backorder_policy,omitempty"`
+	AvailableAt     string `json:"available_at,omitempty"`
+	// Category groups items for tax rates, e.g. "food" or "books"
+	Category string `json:"category,omitempty"`
+	Dimensions
+}
+
+// Adjustment is a requested change of an item's stock. Reason and Reference end
+// up in the stock movement log; restocking may name the lots and serials returned
+// and the unit cost the stock comes back at.
+type Adjustment struct {
+	Delta     int      `json:"delta"`
+	Reason    string   `json:"reason,omitempty"`
+	Reference string   `json:"reference,omitempty"`
+	UnitCost  *float64 `json:"unit_cost,omitempty"`
+	Allocation
+}
+
+// Allocation describes the tracked units taken from or returned to stock by an
+// adjustment, and their total cost
+type Allocation struct {
+	Lots       []LotAllocation       `json:"lots,omitempty"`
+	Serials    []string              `json:"serials,omitempty"`
+	Components []ComponentAllocation `json:"components,omitempty"`
+	Cost       float64               `json:"cost"`
 }
 
 // Inventory is a Postgres-backed store
 type Inventory struct {
 	db *sql.DB
+	*events.SQLOutbox
+	*events.SQLWebhooks
 }
 
 // NewInventory initializes store and ensures table exists
@@ -28,65 +70,332 @@ func NewInventory(db *sql.DB) *Inventory {
 		name TEXT NOT NULL,
 		quantity INT NOT NULL,
 		price NUMERIC NOT NULL
-	)
+	);
+	CREATE TABLE IF NOT EXISTS lots (
+		id SERIAL PRIMARY KEY,
+		item_id INT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+		lot_number TEXT NOT NULL,
+		quantity INT NOT NULL CHECK (quantity >= 0),
+		expires_at DATE,
+		received_unix BIGINT NOT NULL
+	);
+	ALTER TABLE items ADD COLUMN IF NOT EXISTS serial_tracked BOOLEAN NOT NULL DEFAULT FALSE;
+	CREATE TABLE IF NOT EXISTS serials (
+		serial TEXT PRIMARY KEY,
+		item_id INT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+		status TEXT NOT NULL,
+		received_unix BIGINT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS stock_movements (
+		id SERIAL PRIMARY KEY,
+		item_id INT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+		delta INT NOT NULL,
+		reason TEXT NOT NULL,
+		reference TEXT NOT NULL DEFAULT '',
+		created_unix BIGINT NOT NULL
+	);
+	ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS unit_cost NUMERIC NOT NULL DEFAULT 0;
+	ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS value NUMERIC NOT NULL DEFAULT 0;
+	ALTER TABLE items ADD COLUMN IF NOT EXISTS costing_method TEXT NOT NULL DEFAULT 'fifo';
+	ALTER TABLE items ADD COLUMN IF NOT EXISTS stock_value NUMERIC NOT NULL DEFAULT 0;
+	CREATE TABLE IF NOT EXISTS cost_layers (
+		id SERIAL PRIMARY KEY,
+		item_id INT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+		remaining INT NOT NULL,
+		unit_cost NUMERIC NOT NULL,
+		created_unix BIGINT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS bundle_components (
+		bundle_id INT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+		component_id INT NOT NULL REFERENCES items(id),
+		quantity INT NOT NULL CHECK (quantity > 0),
+		PRIMARY KEY (bundle_id, component_id)
+	);
+	CREATE TABLE IF NOT EXISTS count_sessions (
+		id SERIAL PRIMARY KEY,
+		status TEXT NOT NULL,
+		created_unix BIGINT NOT NULL,
+		approved_unix BIGINT NOT NULL DEFAULT 0
+	);
+	CREATE TABLE IF NOT EXISTS count_lines (
+		session_id INT NOT NULL REFERENCES count_sessions(id) ON DELETE CASCADE,
+		item_id INT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		expected INT NOT NULL,
+		counted INT,
+		PRIMARY KEY (session_id, item_id)
+	);
+	ALTER TABLE items ADD COLUMN IF NOT EXISTS backorder_policy TEXT NOT NULL DEFAULT '';
+	ALTER TABLE items ADD COLUMN IF NOT EXISTS available_at DATE;
+	CREATE TABLE IF NOT EXISTS backorders (
+		id SERIAL PRIMARY KEY,
+		item_id INT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+		quantity INT NOT NULL CHECK (quantity > 0),
+		kind TEXT NOT NULL,
+		reference TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		created_unix BIGINT NOT NULL,
+		fulfilled_unix BIGINT NOT NULL DEFAULT 0,
+		allocation JSONB
+	);
+	CREATE INDEX IF NOT EXISTS backorders_open ON backorders (item_id, id) WHERE status = 'open';
+	ALTER TABLE items ADD COLUMN IF NOT EXISTS category TEXT NOT NULL DEFAULT '';
+	ALTER TABLE items ADD COLUMN IF NOT EXISTS weight_kg NUMERIC NOT NULL DEFAULT 0;
+	ALTER TABLE items ADD COLUMN IF NOT EXISTS length_cm NUMERIC NOT NULL DEFAULT 0;
+	ALTER TABLE items ADD COLUMN IF NOT EXISTS width_cm NUMERIC NOT NULL DEFAULT 0;
+	ALTER TABLE items ADD COLUMN IF NOT EXISTS height_cm NUMERIC NOT NULL DEFAULT 0;
 	`)
 	if err != nil {
 		panic(err)
 	}
-	return &Inventory{db: db}
+	if _, err := db.Exec(events.Schema); err != nil {
+		panic(err)
+	}
+	return &Inventory{db: db, SQLOutbox: events.NewSQLOutbox(db, eventSource), SQLWebhooks: events.NewSQLWebhooks(db)}
+}
+
+const itemColumns = "id, name, quantity, price, serial_tracked, costing_method, stock_value, backorder_policy, available_at, category, " +
+	"weight_kg, length_cm, width_cm, height_cm, quantity - " + expiredStock
+
+// expiredStock is how many units of the items row sit in expired lots, which
+// are never allocated
+const expiredStock = "COALESCE((SELECT SUM(l.quantity) FROM lots l WHERE l.item_id = items.id AND l.expires_at < CURRENT_DATE), 0)"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func scanItem(row rowScanner) (*Item, error) {
+	var it Item
+	var avail sql.NullTime
+	if err := row.Scan(&it.ID, &it.Name, &it.Quantity, &it.Price, &it.SerialTracked, &it.CostingMethod, &it.StockValue,
+		&it.BackorderPolicy, &avail, &it.Category, &it.WeightKg, &it.LengthCm, &it.WidthCm, &it.HeightCm, &it.Available); err != nil {
+		return nil, err
+	}
+	if avail.Valid {
+		it.AvailableAt = avail.Time.Format(dateLayout)
+	}
+	it.UnitCost = averageCost(it.Quantity, it.StockValue)
+	return &it, nil
 }
 
 func (s *Inventory) List() []*Item {
-	rows, err := s.db.Query("SELECT id, name, quantity, price FROM items")
+	rows, err := s.db.Query("SELECT " + itemColumns + " FROM items")
 	if err != nil {
 		return []*Item{}
 	}
 	defer rows.Close()
 	res := make([]*Item, 0)
 	for rows.Next() {
-		var it Item
-		if err := rows.Scan(&it.ID, &it.Name, &it.Quantity, &it.Price); err != nil {
+		it, err := scanItem(rows)
+		if err != nil {
 			continue
 		}
-		res = append(res, &it)
+		res = append(res, it)
+	}
+	rows.Close()
+	if err := fillBundles(s.db, res); err != nil {
+		log.Printf("failed to load bundle components: %v", err)
 	}
 	return res
 }
 
 func (s *Inventory) Get(id int) (*Item, error) {
-	var it Item
-	row := s.db.QueryRow("SELECT id, name, quantity, price FROM items WHERE id=$1", id)
-	if err := row.Scan(&it.ID, &it.Name, &it.Quantity, &it.Price); err != nil {
+	it, err := scanItem(s.db.QueryRow("SELECT "+itemColumns+" FROM items WHERE id=$1", id))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errors.New("not found")
 		}
 		return nil, err
 	}
-	return &it, nil
+	if err := fillBundles(s.db, []*Item{it}); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// GetMany returns the items with the given ids in id order; ids that do not
+// exist are left out
+func (s *Inventory) GetMany(ids []int) ([]*Item, error) {
+	rows, err := s.db.Query("SELECT "+itemColumns+" FROM items WHERE id = ANY($1) ORDER BY id", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]*Item, 0, len(ids))
+	for rows.Next() {
+		it, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, it)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err := fillBundles(s.db, res); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
 func (s *Inventory) Create(name string, qty int, price float64) *Item {
+	return s.CreateItem(Item{Name: name, Quantity: qty, Price: price})
+}
+
+// validateNewItem checks an item about to be created, filling in the default
+// costing method and normalizing its category. Components are looked up with get.
+func validateNewItem(it *Item, get func(id int) (*Item, error)) error {
+	if it.CostingMethod == "" {
+		it.CostingMethod = CostFIFO
+	}
+	if !validCostingMethod(it.CostingMethod) || it.UnitCost < 0 {
+		return ErrInvalidCost
+	}
+	if it.SerialTracked && it.Quantity != 0 {
+		return ErrSerialQuantity
+	}
+	if err := validateAvailability(it.BackorderPolicy, it.AvailableAt); err != nil {
+		return err
+	}
+	if err := validateDimensions(it.Dimensions); err != nil {
+		return err
+	}
+	// a bundle's stock is whatever its components allow
+	if len(it.Components) > 0 {
+		if it.Quantity != 0 || it.SerialTracked || it.BackorderPolicy != "" {
+			return ErrBundleStock
+		}
+		if err := validateComponents(it.Components, get); err != nil {
+			return err
+		}
+	}
+	it.Category = strings.ToLower(strings.TrimSpace(it.Category))
+	return nil
+}
+
+// CreateItem inserts an item with all of its attributes; the ID is assigned by the
+// store. Initial stock is logged as a receipt at it.UnitCost. Bundles, i.e. items
+// with components, hold no stock of their own.
+func (s *Inventory) CreateItem(it Item) *Item {
+	if it.CostingMethod == "" {
+		it.CostingMethod = CostFIFO
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
 	var id int
-	err := s.db.QueryRow("INSERT INTO items (name, quantity, price) VALUES ($1,$2,$3) RETURNING id", name, qty, price).Scan(&id)
+	var avail interface{}
+	if it.AvailableAt != "" {
+		avail = it.AvailableAt
+	}
+	err = tx.QueryRow(
+		`INSERT INTO items (name, quantity, price, serial_tracked, costing_method, backorder_policy, available_at, category, weight_kg, length_cm, width_cm, height_cm)
+		VALUES ($1,0,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING id`,
+		it.Name, it.Price, it.SerialTracked, it.CostingMethod, it.BackorderPolicy, avail, it.Category, it.WeightKg, it.LengthCm, it.WidthCm, it.HeightCm,
+	).Scan(&id)
 	if err != nil {
 		panic(err)
 	}
-	return &Item{ID: id, Name: name, Quantity: qty, Price: price}
+	for _, c := range it.Components {
+		if _, err := tx.Exec("INSERT INTO bundle_components (bundle_id, component_id, quantity) VALUES ($1,$2,$3)", id, c.ItemID, c.Quantity); err != nil {
+			panic(err)
+		}
+	}
+	res, err := scanItem(tx.QueryRow("SELECT "+itemColumns+" FROM items WHERE id = $1", id))
+	if err != nil {
+		panic(err)
+	}
+	if err := fillBundles(tx, []*Item{res}); err != nil {
+		panic(err)
+	}
+	if err := events.RecordTx(tx, newEvent(EventItemCreated, id, res)); err != nil {
+		panic(err)
+	}
+	if it.Quantity > 0 && len(it.Components) == 0 {
+		st := itemState{method: it.CostingMethod, tracked: it.SerialTracked}
+		res, _, err = changeStockTx(tx, id, st, Movement{Delta: it.Quantity, Reason: ReasonReceipt, Reference: "initial stock"}, &it.UnitCost)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+	return res
 }
 
 func (s *Inventory) UpdateQuantity(id, delta int) (*Item, error) {
-	// Try to update only when resulting quantity >= 0 and return the row
-	var it Item
-	row := s.db.QueryRow(`
-	UPDATE items SET quantity = quantity + $1
-	WHERE id = $2 AND (quantity + $1) >= 0
-	RETURNING id, name, quantity, price
-	`, delta, id)
-	if err := row.Scan(&it.ID, &it.Name, &it.Quantity, &it.Price); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.New("not found or insufficient stock")
+	it, _, err := s.Adjust(id, Adjustment{Delta: delta})
+	return it, err
+}
+
+// Adjust changes an item's quantity by adj.Delta and logs the stock movement.
+// Negative deltas take stock from lots in FEFO order and, for serial-tracked
+// items, allocate serials; both are returned. Positive deltas may name the lots
+// and serials the stock goes back to, e.g. when an order is rolled back or cancelled;
+// stock coming in goes to open backorders first.
+func (s *Inventory) Adjust(id int, adj Adjustment) (*Item, Allocation, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, Allocation{}, err
+	}
+	defer tx.Rollback()
+	it, out, err := adjustTx(tx, id, adj)
+	if err != nil {
+		return nil, out, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, out, err
+	}
+	return it, out, nil
+}
+
+func adjustTx(tx *sql.Tx, id int, adj Adjustment) (*Item, Allocation, error) {
+	var out Allocation
+	st, err := lockItemTx(tx, id)
+	if err != nil {
+		return nil, out, err
+	}
+	if st.bundle {
+		components, err := loadComponentsTx(tx, id)
+		if err != nil {
+			return nil, out, err
 		}
-		return nil, err
+		return adjustBundleTx(tx, id, components, adj)
 	}
-	return &it, nil
+	if st.quantity+adj.Delta < 0 {
+		return nil, out, ErrInsufficientStock
+	}
+	if out.Lots, err = adjustLotsTx(tx, id, st.quantity, adj.Delta, adj.Lots); err != nil {
+		return nil, out, err
+	}
+	if st.tracked {
+		if out.Serials, err = adjustSerialsTx(tx, id, adj.Delta, adj.Serials); err != nil {
+			return nil, out, err
+		}
+	}
+	it, m, err := changeStockTx(tx, id, st, Movement{Delta: adj.Delta, Reason: adj.Reason, Reference: adj.Reference}, adj.UnitCost)
+	if err != nil {
+		return nil, out, err
+	}
+	out.Cost = math.Abs(m.Value)
+	if adj.Delta > 0 {
+		if err := fulfillBackordersTx(tx, id); err != nil {
+			return nil, out, err
+		}
+		if it, err = scanItem(tx.QueryRow("SELECT "+itemColumns+" FROM items WHERE id = $1", id)); err != nil {
+			return nil, out, err
+		}
+	}
+	return it, out, nil
 }
@@ -1,18 +1,37 @@
 package main
 
-import "sync"
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"inventoryshop/pkg/events"
+)
 
 // ...existing code...
 
 // InMemoryInventory — простая in-memory реализация, используется в тестах
 type InMemoryInventory struct {
-	mu     sync.Mutex
-	items  map[int]*Item
-	nextID int
+	mu        sync.Mutex
+	items     map[int]*Item
+	nextID    int
+	lots      map[int]*Lot
+	nextLotID int
+	serials   map[string]*Serial
+	movements []*Movement
+	counts    map[int]*CountSession
+	layers    map[int][]costLayer
+	// backorders in creation order
+	backorders []*Backorder
+	// outbox of domain events, and the webhooks they are sent to
+	events.MemoryOutbox
+	events.MemoryWebhooks
 }
 
 func NewInventoryInMemory() *InMemoryInventory {
-	return &InMemoryInventory{items: make(map[int]*Item), nextID: 1}
+	return &InMemoryInventory{items: make(map[int]*Item), nextID: 1, lots: make(map[int]*Lot), nextLotID: 1, serials: make(map[string]*Serial), counts: make(map[int]*CountSession), layers: make(map[int][]costLayer)}
 }
 
 func (s *InMemoryInventory) List() []*Item {
@@ -20,6 +39,7 @@ func (s *InMemoryInventory) List() []*Item {
 	defer s.mu.Unlock()
 	res := make([]*Item, 0, len(s.items))
 	for _, v := range s.items {
+		s.fillItemLocked(v)
 		res = append(res, v)
 	}
 	return res
@@ -32,33 +52,683 @@ func (s *InMemoryInventory) Get(id int) (*Item, error) {
 	if !ok {
 		return nil, ErrNotFound
 	}
+	s.fillItemLocked(it)
 	return it, nil
 }
 
+func (s *InMemoryInventory) GetMany(ids []int) ([]*Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*Item, 0, len(ids))
+	for _, id := range ids {
+		if it, ok := s.items[id]; ok {
+			s.fillItemLocked(it)
+			res = append(res, it)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+// fillItemLocked works out how much of an item can be sold and derives the
+// quantity of a bundle from its components' stock; caller must hold the lock
+func (s *InMemoryInventory) fillItemLocked(it *Item) {
+	if len(it.Components) == 0 {
+		it.Available = s.availableLocked(it)
+		return
+	}
+	stock, sellable := make(map[int]int, len(it.Components)), make(map[int]int, len(it.Components))
+	for _, c := range it.Components {
+		if comp, ok := s.items[c.ItemID]; ok {
+			stock[c.ItemID], sellable[c.ItemID] = comp.Quantity, s.availableLocked(comp)
+		}
+	}
+	it.Quantity = bundleQuantity(it.Components, stock)
+	it.Available = bundleQuantity(it.Components, sellable)
+}
+
+// availableLocked is an item's stock less what sits in expired lots; caller
+// must hold the lock
+func (s *InMemoryInventory) availableLocked(it *Item) int {
+	n, day := it.Quantity, today()
+	for _, l := range s.itemLots(it.ID) {
+		if expired(l, day) {
+			n -= l.Quantity
+		}
+	}
+	return n
+}
+
 func (s *InMemoryInventory) Create(name string, qty int, price float64) *Item {
+	return s.CreateItem(Item{Name: name, Quantity: qty, Price: price})
+}
+
+func (s *InMemoryInventory) CreateItem(it Item) *Item {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	id := s.nextID
+	if it.CostingMethod == "" {
+		it.CostingMethod = CostFIFO
+	}
+	qty, unitCost := it.Quantity, it.UnitCost
+	it.ID = s.nextID
 	s.nextID++
-	it := &Item{ID: id, Name: name, Quantity: qty, Price: price}
-	s.items[id] = it
-	return it
+	it.Quantity, it.StockValue, it.UnitCost = 0, 0, 0
+	s.items[it.ID] = &it
+	if len(it.Components) > 0 {
+		s.fillItemLocked(&it)
+	}
+	s.MemoryOutbox.Record(newEvent(EventItemCreated, it.ID, it))
+	if len(it.Components) > 0 {
+		return &it
+	}
+	if qty > 0 {
+		s.changeStockLocked(&it, Movement{Delta: qty, Reason: ReasonReceipt, Reference: "initial stock"}, &unitCost)
+	}
+	s.fillItemLocked(&it)
+	return &it
+}
+
+// changeStockLocked applies a quantity change to an item, keeping its stock value
+// and FIFO layers in step, and logs the movement; caller must hold the lock
+func (s *InMemoryInventory) changeStockLocked(it *Item, m Movement, unitCost *float64) *Movement {
+	st := itemState{quantity: it.Quantity, method: it.CostingMethod, value: it.StockValue}
+	switch {
+	case m.Delta < 0 && st.method == CostFIFO:
+		layers := s.layers[it.ID]
+		taken, cost := consumeLayers(layers, -m.Delta)
+		for _, t := range taken {
+			for i := range layers {
+				if layers[i].ID == t.ID {
+					layers[i].Remaining -= t.Remaining
+				}
+			}
+		}
+		m.Value = -roundCost(cost)
+		m.UnitCost = -m.Value / float64(-m.Delta)
+	case m.Delta < 0:
+		m.Value = outflowValue(st, m.Delta)
+		m.UnitCost = m.Value / float64(m.Delta)
+	case m.Delta > 0:
+		layers := s.layers[it.ID]
+		switch {
+		case unitCost != nil:
+			m.UnitCost = *unitCost
+		case st.method == CostFIFO && len(layers) > 0:
+			m.UnitCost = layers[len(layers)-1].UnitCost
+		default:
+			m.UnitCost = averageCost(st.quantity, st.value)
+		}
+		if st.method == CostFIFO {
+			s.layers[it.ID] = append(layers, costLayer{ID: len(layers) + 1, Remaining: m.Delta, UnitCost: m.UnitCost})
+		}
+		m.Value = roundCost(m.UnitCost * float64(m.Delta))
+	}
+	it.Quantity += m.Delta
+	it.StockValue = roundCost(it.StockValue + m.Value)
+	it.UnitCost = averageCost(it.Quantity, it.StockValue)
+	m.ItemID = it.ID
+	s.recordMovement(&m)
+	if m.Delta != 0 {
+		s.MemoryOutbox.Record(newEvent(EventStockAdjusted, it.ID, stockAdjusted(it, m)))
+	}
+	return &m
 }
 
 func (s *InMemoryInventory) UpdateQuantity(id, delta int) (*Item, error) {
+	it, _, err := s.Adjust(id, Adjustment{Delta: delta})
+	return it, err
+}
+
+// recordMovement appends to the movement log; caller must hold the lock
+func (s *InMemoryInventory) recordMovement(m *Movement) {
+	if m.Delta == 0 {
+		return
+	}
+	if m.Reason == "" {
+		m.Reason = ReasonAdjust
+	}
+	m.ID = len(s.movements) + 1
+	m.Created = time.Now().Unix()
+	s.movements = append(s.movements, m)
+}
+
+func (s *InMemoryInventory) Valuation(at int64) (*Valuation, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	it, ok := s.items[id]
+	lines := make(map[int]*ValuationLine, len(s.items))
+	for _, it := range s.items {
+		lines[it.ID] = &ValuationLine{ItemID: it.ID, Name: it.Name, CostingMethod: it.CostingMethod, Quantity: it.Quantity, Value: it.StockValue}
+	}
+	for _, m := range s.movements {
+		if l := lines[m.ItemID]; l != nil && m.Created > at {
+			l.Quantity -= m.Delta
+			l.Value -= m.Value
+		}
+	}
+	res := make([]ValuationLine, 0, len(lines))
+	for _, l := range lines {
+		res = append(res, *l)
+	}
+	return newValuation(at, res), nil
+}
+
+func (s *InMemoryInventory) Movements(itemID int) ([]*Movement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*Movement, 0)
+	for _, m := range s.movements {
+		if m.ItemID == itemID {
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+func (s *InMemoryInventory) ReceiveLot(itemID int, lotNumber string, qty int, expiresAt string, unitCost *float64) (*Lot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lotNumber == "" || qty <= 0 {
+		return nil, ErrInvalidLot
+	}
+	if unitCost != nil && *unitCost < 0 {
+		return nil, ErrInvalidCost
+	}
+	it, ok := s.items[itemID]
 	if !ok {
 		return nil, ErrNotFound
 	}
+	if len(it.Components) > 0 {
+		return nil, ErrBundleStock
+	}
+	if it.SerialTracked {
+		return nil, ErrInvalidLot
+	}
+	l := &Lot{ID: s.nextLotID, ItemID: itemID, ItemName: it.Name, LotNumber: lotNumber, Quantity: qty, ExpiresAt: expiresAt, Received: time.Now().Unix()}
+	s.nextLotID++
+	s.lots[l.ID] = l
+	s.changeStockLocked(it, Movement{Delta: qty, Reason: ReasonReceipt, Reference: "lot " + lotNumber}, unitCost)
+	s.fulfillBackordersLocked(itemID)
+	return l, nil
+}
+
+// itemLots returns the lots of an item; caller must hold the lock
+func (s *InMemoryInventory) itemLots(itemID int) []*Lot {
+	res := make([]*Lot, 0)
+	for _, l := range s.lots {
+		if l.ItemID == itemID && l.Quantity > 0 {
+			res = append(res, l)
+		}
+	}
+	sortFEFO(res)
+	return res
+}
+
+func (s *InMemoryInventory) Lots(itemID int) ([]*Lot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.itemLots(itemID), nil
+}
+
+func (s *InMemoryInventory) ExpiringLots(days int) ([]*Lot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until := time.Now().AddDate(0, 0, days).Format(dateLayout)
+	res := make([]*Lot, 0)
+	for _, l := range s.lots {
+		if l.Quantity > 0 && l.ExpiresAt != "" && l.ExpiresAt <= until {
+			res = append(res, l)
+		}
+	}
+	sortFEFO(res)
+	return res, nil
+}
+
+func (s *InMemoryInventory) Adjust(id int, adj Adjustment) (*Item, Allocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.adjustLocked(id, adj)
+}
+
+// adjustLocked applies an adjustment; caller must hold the lock
+func (s *InMemoryInventory) adjustLocked(id int, adj Adjustment) (*Item, Allocation, error) {
+	delta, ret := adj.Delta, adj.Allocation
+	var out Allocation
+	it, ok := s.items[id]
+	if !ok {
+		return nil, out, ErrNotFound
+	}
+	if len(it.Components) > 0 {
+		return s.adjustBundleLocked(it, adj)
+	}
 	if it.Quantity+delta < 0 {
-		return nil, ErrInsufficientStock
+		return nil, out, ErrInsufficientStock
+	}
+	if adj.UnitCost != nil && *adj.UnitCost < 0 {
+		return nil, out, ErrInvalidCost
+	}
+
+	// validate everything before touching stock
+	var lotDelta []LotAllocation
+	switch {
+	case delta < 0:
+		allocs, err := planAllocation(it.Quantity, s.itemLots(id), -delta, today())
+		if err != nil {
+			return nil, out, err
+		}
+		for _, a := range allocs {
+			a.Quantity = -a.Quantity
+			lotDelta = append(lotDelta, a)
+		}
+		out.Lots = allocs
+	case delta > 0 && len(ret.Lots) > 0:
+		byID := make(map[int]*Lot)
+		for _, a := range ret.Lots {
+			if l, ok := s.lots[a.LotID]; ok && l.ItemID == id {
+				byID[l.ID] = l
+			}
+		}
+		if err := validateReturn(byID, delta, ret.Lots); err != nil {
+			return nil, out, err
+		}
+		for _, a := range ret.Lots {
+			l := byID[a.LotID]
+			a = LotAllocation{LotID: l.ID, LotNumber: l.LotNumber, ExpiresAt: l.ExpiresAt, Quantity: a.Quantity}
+			lotDelta = append(lotDelta, a)
+			out.Lots = append(out.Lots, a)
+		}
+	}
+	var serialStatus string
+	if it.SerialTracked {
+		switch {
+		case delta < 0:
+			avail := make([]*Serial, 0)
+			for _, sn := range s.serials {
+				if sn.ItemID == id && sn.Status == SerialInStock {
+					avail = append(avail, sn)
+				}
+			}
+			if len(avail) < -delta {
+				return nil, out, ErrInsufficientStock
+			}
+			sortSerials(avail)
+			for _, sn := range avail[:-delta] {
+				out.Serials = append(out.Serials, sn.Serial)
+			}
+			serialStatus = SerialAllocated
+		case delta > 0:
+			if len(ret.Serials) != delta {
+				return nil, out, ErrSerialsRequired
+			}
+			seen := make(map[string]bool)
+			for _, v := range ret.Serials {
+				sn, ok := s.serials[v]
+				if !ok || sn.ItemID != id || sn.Status != SerialAllocated || seen[v] {
+					return nil, out, ErrInvalidSerial
+				}
+				seen[v] = true
+			}
+			out.Serials = ret.Serials
+			serialStatus = SerialInStock
+		}
+	}
+
+	for _, a := range lotDelta {
+		s.lots[a.LotID].Quantity += a.Quantity
+	}
+	for _, v := range out.Serials {
+		s.serials[v].Status = serialStatus
+	}
+	m := s.changeStockLocked(it, Movement{Delta: delta, Reason: adj.Reason, Reference: adj.Reference}, adj.UnitCost)
+	out.Cost = math.Abs(m.Value)
+	if delta > 0 {
+		s.fulfillBackordersLocked(id)
+	}
+	s.fillItemLocked(it)
+	return it, out, nil
+}
+
+// adjustBundleLocked adjusts every component of a bundle, restoring the previous
+// state if any of them fails; caller must hold the lock
+func (s *InMemoryInventory) adjustBundleLocked(it *Item, adj Adjustment) (*Item, Allocation, error) {
+	var out Allocation
+	snap := s.snapshotLocked()
+	ref := adj.Reference
+	if ref == "" {
+		ref = fmt.Sprintf("bundle %d", it.ID)
+	}
+	components := append([]Component(nil), it.Components...)
+	sort.Slice(components, func(i, j int) bool { return components[i].ItemID < components[j].ItemID })
+	for _, c := range components {
+		ret, unitCost := componentReturn(adj.Components, c.ItemID)
+		compAdj := Adjustment{Delta: adj.Delta * c.Quantity, Reason: adj.Reason, Reference: ref, UnitCost: unitCost, Allocation: ret}
+		_, alloc, err := s.adjustLocked(c.ItemID, compAdj)
+		if err != nil {
+			s.restoreLocked(snap)
+			return nil, Allocation{}, fmt.Errorf("component %d: %w", c.ItemID, err)
+		}
+		qty := compAdj.Delta
+		if qty < 0 {
+			qty = -qty
+		}
+		out.Components = append(out.Components, ComponentAllocation{ItemID: c.ItemID, Quantity: qty, Allocation: alloc})
+		out.Cost += alloc.Cost
+	}
+	out.Cost = roundCost(out.Cost)
+	s.fillItemLocked(it)
+	return it, out, nil
+}
+
+// memorySnapshot is a copy of the stock state a bundle adjustment may change
+type memorySnapshot struct {
+	items      map[int]Item
+	lots       map[int]int
+	serials    map[string]string
+	layers     map[int][]costLayer
+	movements  int
+	events     int
+	backorders []Backorder
+}
+
+func (s *InMemoryInventory) snapshotLocked() memorySnapshot {
+	snap := memorySnapshot{items: make(map[int]Item), lots: make(map[int]int), serials: make(map[string]string),
+		layers: make(map[int][]costLayer), movements: len(s.movements), events: s.MemoryOutbox.Len()}
+	for id, it := range s.items {
+		snap.items[id] = *it
+	}
+	for id, l := range s.lots {
+		snap.lots[id] = l.Quantity
+	}
+	for v, sn := range s.serials {
+		snap.serials[v] = sn.Status
+	}
+	for id, l := range s.layers {
+		snap.layers[id] = append([]costLayer(nil), l...)
+	}
+	for _, b := range s.backorders {
+		snap.backorders = append(snap.backorders, *b)
+	}
+	return snap
+}
+
+func (s *InMemoryInventory) restoreLocked(snap memorySnapshot) {
+	for id, it := range snap.items {
+		*s.items[id] = it
+	}
+	for id, q := range snap.lots {
+		s.lots[id].Quantity = q
+	}
+	for v, st := range snap.serials {
+		s.serials[v].Status = st
+	}
+	s.layers = snap.layers
+	s.movements = s.movements[:snap.movements]
+	s.MemoryOutbox.Truncate(snap.events)
+	for i, b := range snap.backorders {
+		*s.backorders[i] = b
+	}
+}
+
+func (s *InMemoryInventory) ReceiveSerials(itemID int, serials []string, unitCost *float64) ([]*Serial, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := validateNewSerials(serials); err != nil {
+		return nil, err
 	}
-	it.Quantity += delta
+	if unitCost != nil && *unitCost < 0 {
+		return nil, ErrInvalidCost
+	}
+	it, ok := s.items[itemID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if len(it.Components) > 0 {
+		return nil, ErrBundleStock
+	}
+	if !it.SerialTracked {
+		return nil, ErrNotSerialTracked
+	}
+	for _, sn := range serials {
+		if _, ok := s.serials[sn]; ok {
+			return nil, ErrDuplicateSerial
+		}
+	}
+	now := time.Now().Unix()
+	res := make([]*Serial, 0, len(serials))
+	for _, v := range serials {
+		sn := &Serial{Serial: v, ItemID: itemID, Status: SerialInStock, Received: now}
+		s.serials[v] = sn
+		res = append(res, sn)
+	}
+	s.changeStockLocked(it, Movement{Delta: len(serials), Reason: ReasonReceipt, Reference: "serials"}, unitCost)
+	s.fulfillBackordersLocked(itemID)
+	return res, nil
+}
+
+func (s *InMemoryInventory) GetSerial(serial string) (*Serial, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sn, ok := s.serials[serial]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return sn, nil
+}
+
+func (s *InMemoryInventory) Serials(itemID int, status string) ([]*Serial, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*Serial, 0)
+	for _, sn := range s.serials {
+		if sn.ItemID == itemID && (status == "" || sn.Status == status) {
+			res = append(res, sn)
+		}
+	}
+	sortSerials(res)
+	return res, nil
+}
+
+func (s *InMemoryInventory) StartCount(itemIDs []int) (*CountSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(itemIDs) == 0 {
+		for id, it := range s.items {
+			if len(it.Components) == 0 {
+				itemIDs = append(itemIDs, id)
+			}
+		}
+		sort.Ints(itemIDs)
+	}
+	cs := &CountSession{ID: len(s.counts) + 1, Status: CountOpen, Created: time.Now().Unix(), Lines: make([]CountLine, 0)}
+	seen := make(map[int]bool)
+	for _, id := range itemIDs {
+		it, ok := s.items[id]
+		if !ok {
+			return nil, ErrNotFound
+		}
+		if len(it.Components) > 0 {
+			return nil, ErrBundleStock
+		}
+		if !seen[id] {
+			seen[id] = true
+			cs.Lines = append(cs.Lines, CountLine{ItemID: id, Name: it.Name, Expected: it.Quantity})
+		}
+	}
+	s.counts[cs.ID] = cs
+	return cs, nil
+}
+
+func (s *InMemoryInventory) GetCount(id int) (*CountSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs, ok := s.counts[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return cs, nil
+}
+
+func (s *InMemoryInventory) ListCounts() ([]*CountSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*CountSession, 0, len(s.counts))
+	for id := len(s.counts); id > 0; id-- {
+		res = append(res, s.counts[id])
+	}
+	return res, nil
+}
+
+func (s *InMemoryInventory) RecordCounts(id int, counts map[int]int, add bool) (*CountSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := validateCounts(counts, add); err != nil {
+		return nil, err
+	}
+	cs, ok := s.counts[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if cs.Status != CountOpen {
+		return nil, ErrCountClosed
+	}
+	lines := make(map[int]*CountLine)
+	for i := range cs.Lines {
+		lines[cs.Lines[i].ItemID] = &cs.Lines[i]
+	}
+	for itemID := range counts {
+		if lines[itemID] == nil {
+			return nil, ErrItemNotInCount
+		}
+	}
+	for itemID, qty := range counts {
+		lines[itemID].setCounted(qty, add)
+	}
+	return cs, nil
+}
+
+func (s *InMemoryInventory) ApproveCount(id int) (*CountSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs, ok := s.counts[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if cs.Status != CountOpen {
+		return nil, ErrCountClosed
+	}
+	for _, l := range cs.Lines {
+		if l.Variance == nil || *l.Variance == 0 {
+			continue
+		}
+		adj := Adjustment{Delta: *l.Variance, Reason: ReasonCount, Reference: countReference(id)}
+		if _, _, err := s.adjustLocked(l.ItemID, adj); err != nil {
+			return nil, fmt.Errorf("item %d: %w", l.ItemID, err)
+		}
+	}
+	cs.Status, cs.Approved = CountApproved, time.Now().Unix()
+	return cs, nil
+}
+
+func (s *InMemoryInventory) SetAvailability(itemID int, policy, availableAt string) (*Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !validBackorderPolicy(policy) {
+		return nil, ErrInvalidBackorder
+	}
+	it, ok := s.items[itemID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if len(it.Components) > 0 && policy != "" {
+		return nil, ErrBundleStock
+	}
+	it.BackorderPolicy, it.AvailableAt = policy, availableAt
 	return it, nil
 }
 
+func (s *InMemoryInventory) CreateBackorder(itemID, qty int, reference string) (*Backorder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if qty <= 0 {
+		return nil, ErrInvalidBackorder
+	}
+	it, ok := s.items[itemID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if len(it.Components) > 0 {
+		return nil, ErrBundleStock
+	}
+	if it.BackorderPolicy == "" {
+		return nil, ErrBackorderNotAllowed
+	}
+	b := &Backorder{ID: len(s.backorders) + 1, ItemID: itemID, Quantity: qty, Kind: it.BackorderPolicy,
+		Reference: reference, Status: BackorderOpen, Created: time.Now().Unix()}
+	s.backorders = append(s.backorders, b)
+	s.fulfillBackordersLocked(itemID)
+	return s.backorderLocked(b), nil
+}
+
+// backorderLocked fills in the expected date from the item; caller must hold the lock
+func (s *InMemoryInventory) backorderLocked(b *Backorder) *Backorder {
+	if it, ok := s.items[b.ItemID]; ok {
+		b.ExpectedAt = it.AvailableAt
+	}
+	return b
+}
+
+func (s *InMemoryInventory) GetBackorder(id int) (*Backorder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id <= 0 || id > len(s.backorders) {
+		return nil, ErrNotFound
+	}
+	return s.backorderLocked(s.backorders[id-1]), nil
+}
+
+func (s *InMemoryInventory) Backorders(itemID int, status string) ([]*Backorder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*Backorder, 0)
+	for _, b := range s.backorders {
+		if b.ItemID == itemID && (status == "" || b.Status == status) {
+			res = append(res, s.backorderLocked(b))
+		}
+	}
+	return res, nil
+}
+
+func (s *InMemoryInventory) CancelBackorder(id int) (*Backorder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id <= 0 || id > len(s.backorders) {
+		return nil, ErrNotFound
+	}
+	b := s.backorders[id-1]
+	switch b.Status {
+	case BackorderCancelled:
+		return nil, ErrBackorderClosed
+	case BackorderOpen:
+		b.Status = BackorderCancelled
+	}
+	return s.backorderLocked(b), nil
+}
+
+// fulfillBackordersLocked hands an item's stock to its open backorders, oldest
+// first; caller must hold the lock
+func (s *InMemoryInventory) fulfillBackordersLocked(itemID int) {
+	for _, b := range s.backorders {
+		if b.ItemID != itemID || b.Status != BackorderOpen {
+			continue
+		}
+		adj := Adjustment{Delta: -b.Quantity, Reason: ReasonBackorder, Reference: backorderReference(b)}
+		_, alloc, err := s.adjustLocked(itemID, adj)
+		if err != nil {
+			return
+		}
+		b.Status, b.Fulfilled, b.Allocation = BackorderFulfilled, time.Now().Unix(), alloc
+	}
+}
+
 var (
 	ErrNotFound          = &customError{"not found"}
 	ErrInsufficientStock = &customError{"insufficient stock"}
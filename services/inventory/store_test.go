@@ -30,6 +30,12 @@ func TestInventory_CreateGetList_UpdateQuantity(t *testing.T) {
 		t.Fatalf("expected list length 2, got %d", len(list))
 	}
 
+	// GetMany leaves out unknown ids and orders by id
+	many, err := s.GetMany([]int{it2.ID, 9999, it1.ID})
+	if err != nil || len(many) != 2 || many[0].ID != it1.ID || many[1].ID != it2.ID {
+		t.Fatalf("unexpected GetMany result: %v %+v", err, many)
+	}
+
 	// UpdateQuantity success
 	updated, err := s.UpdateQuantity(it1.ID, -3)
 	if err != nil {
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"inventoryshop/pkg/events"
+)
+
+// streamHeartbeat keeps idle streams from being closed by proxies
+const streamHeartbeat = 15 * time.Second
+
+// parseItemFilter reads ?ids=1,2,3; nil means every item
+func parseItemFilter(v string) (map[int]bool, error) {
+	if v == "" {
+		return nil, nil
+	}
+	ids := make(map[int]bool)
+	for _, p := range strings.Split(v, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid item id %q", p)
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// streamHandler serves GET /items/stream, a Server-Sent Events stream of item
+// events as they are published, optionally only for ?ids=. Event ids are the
+// events' publish sequence numbers, so a client resuming with Last-Event-ID (or
+// ?last_event_id=) is first sent exactly what it missed. A client too slow to
+// keep up is disconnected and catches up when it resumes.
+func streamHandler(store events.Log, bus *events.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming unsupported"})
+			return
+		}
+		ids, err := parseItemFilter(r.URL.Query().Get("ids"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		resume := r.Header.Get("Last-Event-ID")
+		if resume == "" {
+			resume = r.URL.Query().Get("last_event_id")
+		}
+		var last int64
+		if resume != "" {
+			if last, err = strconv.ParseInt(resume, 10, 64); err != nil || last < 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid last event id"})
+				return
+			}
+		}
+
+		// subscribe before replaying so nothing published meanwhile is missed
+		live, cancel := bus.Subscribe(256)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "retry: 3000\n\n")
+
+		send := func(e events.Event) {
+			last = e.Seq
+			if ids != nil && !ids[e.AggregateID] {
+				return
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, e.Payload)
+		}
+		if resume != "" {
+			for {
+				missed, err := store.EventsSince(last, 500)
+				if err != nil {
+					return
+				}
+				for _, e := range missed {
+					send(e)
+				}
+				if len(missed) < 500 {
+					break
+				}
+			}
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e, ok := <-live:
+				if !ok {
+					return
+				}
+				if e.Seq <= last {
+					continue
+				}
+				send(e)
+				flusher.Flush()
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+				flusher.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"inventoryshop/pkg/events"
+)
+
+// readEvent reads the next event of an SSE stream, skipping comments
+func readEvent(t *testing.T, r *bufio.Reader) map[string]string {
+	t.Helper()
+	ev := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && ev["id"] != "":
+			return ev
+		case line == "" || strings.HasPrefix(line, ":") || strings.HasPrefix(line, "retry:"):
+		default:
+			kv := strings.SplitN(line, ": ", 2)
+			ev[kv[0]] = kv[1]
+		}
+	}
+}
+
+func TestItemStream_ResumeAndFilter(t *testing.T) {
+	s := NewInventoryInMemory()
+	bus := events.NewBus()
+	relay := events.NewRelay(s, events.MultiSink{})
+	relay.Live = bus
+	a := s.Create("Толстовка", 5, 10) // events 1, 2
+	b := s.Create("Футболка", 5, 10)  // events 3, 4
+	s.UpdateQuantity(a.ID, -1)        // event 5
+	relay.Flush(context.Background())
+
+	srv := httptest.NewServer(streamHandler(s, bus))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"?ids="+strconv.Itoa(a.ID), nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+
+	// missed events of the item are replayed, the other item's are not
+	ev := readEvent(t, r)
+	if ev["id"] != "2" || ev["event"] != EventStockAdjusted {
+		t.Fatalf("unexpected replayed event %v", ev)
+	}
+	if ev = readEvent(t, r); ev["id"] != "5" || !strings.Contains(ev["data"], `"quantity":4`) {
+		t.Fatalf("unexpected replayed event %v", ev)
+	}
+
+	// then live changes as they are published
+	s.UpdateQuantity(b.ID, -1)
+	s.UpdateQuantity(a.ID, -2)
+	relay.Flush(context.Background())
+	if ev = readEvent(t, r); ev["id"] != "7" || !strings.Contains(ev["data"], `"quantity":2`) {
+		t.Fatalf("unexpected live event %v", ev)
+	}
+}
+
+func TestItemStream_BadFilter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	streamHandler(NewInventoryInMemory(), events.NewBus())(rec, httptest.NewRequest(http.MethodGet, "/items/stream?ids=1,x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"inventoryshop/pkg/events"
+)
+
+func TestWebhooks_StockEvents(t *testing.T) {
+	var got []events.Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var e events.Event
+		json.Unmarshal(body, &e)
+		got = append(got, e)
+	}))
+	defer srv.Close()
+
+	s := NewInventoryInMemory()
+	rec := httptest.NewRecorder()
+	events.WebhooksHandler(s, eventTypes)(rec, httptest.NewRequest(http.MethodPost, "/webhooks",
+		strings.NewReader(`{"url": "`+srv.URL+`", "event_types": ["StockAdjusted"]}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("want webhook created, got %d %s", rec.Code, rec.Body)
+	}
+	it := s.Create("Толстовка", 5, 10)
+	s.UpdateQuantity(it.ID, -2)
+
+	d := events.NewDispatcher(s)
+	if err := events.NewRelay(s, d).Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n, err := d.DeliverDue(context.Background()); n != 2 || err != nil {
+		t.Fatalf("want two deliveries, got %d %v", n, err)
+	}
+	var last StockAdjustedEvent
+	json.Unmarshal(got[len(got)-1].Payload, &last)
+	if len(got) != 2 || got[0].Type != EventStockAdjusted || got[0].Source != eventSource || last.Delta != -2 || last.Quantity != 3 {
+		t.Fatalf("unexpected webhooks %+v", got)
+	}
+}
+
+func TestWebhooks_AdminOnly(t *testing.T) {
+	handler := adminOnly("secret", events.WebhooksHandler(NewInventoryInMemory(), eventTypes))
+	for _, auth := range []string{"", "Bearer wrong", "secret", "Bearer secret"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/webhooks", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		handler(rec, req)
+		want := http.StatusUnauthorized
+		if auth == "Bearer secret" {
+			want = http.StatusOK
+		}
+		if rec.Code != want {
+			t.Errorf("%q: want %d, got %d", auth, want, rec.Code)
+		}
+	}
+	// without an admin token configured nobody manages webhooks
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/webhooks", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	adminOnly("", events.WebhooksHandler(NewInventoryInMemory(), eventTypes))(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("want 401 with no admin token, got %d", rec.Code)
+	}
+}
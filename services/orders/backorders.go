@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"inventoryshop/pkg/inventory"
+)
+
+// Order line statuses. Backordered and pre-ordered lines wait for stock in
+// inventory and become reserved once their backorder is fulfilled.
+const (
+	LineReserved    = "reserved"
+	LineBackordered = "backordered"
+	LinePreordered  = "preordered"
+)
+
+// pending reports whether a line is still waiting for stock
+func (it OrderItem) pending() bool {
+	return it.BackorderID != 0 && it.Status != LineReserved
+}
+
+// lineStatus is the status of a line waiting on a backorder of the given kind
+func lineStatus(kind string) string {
+	if kind == "preorder" {
+		return LinePreordered
+	}
+	return LineBackordered
+}
+
+// applyBackorder brings a waiting line up to date with its backorder in inventory.
+// It reports whether the line changed.
+func applyBackorder(line *OrderItem, b *inventory.Backorder) bool {
+	changed := line.ExpectedAt != b.ExpectedAt
+	line.ExpectedAt = b.ExpectedAt
+	if b.Status == inventory.BackorderFulfilled {
+		line.Status = LineReserved
+		line.Lots, line.Serials, line.Cost = b.Lots, b.Serials, b.Cost
+		changed = true
+	}
+	return changed
+}
+
+// syncBackorders refreshes the waiting lines of an order from inventory and stores
+// what changed
+func syncBackorders(ctx context.Context, inv *inventory.Client, store *OrderStore, ord *Order) error {
+	for i := range ord.Items {
+		line := &ord.Items[i]
+		if !line.pending() {
+			continue
+		}
+		b, err := inv.GetBackorder(ctx, line.BackorderID)
+		if err != nil {
+			return err
+		}
+		if applyBackorder(line, b) {
+			if err := store.UpdateLine(ord.ID, *line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// watchBackorders periodically picks up backorders fulfilled in inventory, so
+// orders move on without anyone looking at them
+func watchBackorders(ctx context.Context, inv *inventory.Client, store *OrderStore, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		ids, err := store.PendingOrders()
+		if err != nil {
+			log.Printf("backorder sync: %v", err)
+			continue
+		}
+		for _, id := range ids {
+			ord, err := store.Get(id)
+			if err != nil {
+				continue
+			}
+			reqCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			if err := syncBackorders(reqCtx, inv, store, ord); err != nil {
+				log.Printf("backorder sync for order %d: %v", id, err)
+			}
+			cancel()
+		}
+	}
+}
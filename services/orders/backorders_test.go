@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"inventoryshop/pkg/inventory"
+)
+
+func TestApplyBackorder(t *testing.T) {
+	line := OrderItem{ItemID: 1, Quantity: 2, Status: lineStatus("preorder"), BackorderID: 7}
+	if line.Status != LinePreordered {
+		t.Fatalf("expected preordered line, got %s", line.Status)
+	}
+	if !applyBackorder(&line, &inventory.Backorder{ID: 7, Status: inventory.BackorderOpen, ExpectedAt: "2030-01-15"}) {
+		t.Fatalf("expected new expected date to change the line")
+	}
+	if applyBackorder(&line, &inventory.Backorder{ID: 7, Status: inventory.BackorderOpen, ExpectedAt: "2030-01-15"}) {
+		t.Fatalf("expected unchanged backorder to leave the line alone")
+	}
+	if !line.pending() {
+		t.Fatalf("expected line to be waiting")
+	}
+
+	b := &inventory.Backorder{ID: 7, Status: inventory.BackorderFulfilled, ExpectedAt: "2030-01-15", Allocation: inventory.Allocation{Serials: []string{"SN1", "SN2"}, Cost: 12}}
+	applyBackorder(&line, b)
+	if line.pending() || line.Status != LineReserved || len(line.Serials) != 2 || !almostEqualFloat(line.Cost, 12) {
+		t.Fatalf("expected reserved line with the backorder's allocation, got %+v", line)
+	}
+}
+
+func TestOrderStore_PendingLines(t *testing.T) {
+	s := NewOrderStoreInMemory()
+	s.Create([]OrderItem{{ItemID: 1, Quantity: 1, Price: 5}}, 5)
+	ord := s.Create([]OrderItem{
+		{ItemID: 1, Quantity: 1, Price: 5},
+		{ItemID: 2, Quantity: 3, Price: 2, Status: LineBackordered, BackorderID: 4},
+	}, 11)
+	if ids, _ := s.PendingOrders(); len(ids) != 1 || ids[0] != ord.ID {
+		t.Fatalf("expected order %d pending, got %v", ord.ID, ids)
+	}
+	if ord.Items[0].Status != LineReserved {
+		t.Fatalf("expected plain lines to be reserved, got %s", ord.Items[0].Status)
+	}
+
+	line := ord.Items[1]
+	applyBackorder(&line, &inventory.Backorder{ID: 4, Status: inventory.BackorderFulfilled, Allocation: inventory.Allocation{Cost: 3}})
+	if err := s.UpdateLine(ord.ID, line); err != nil {
+		t.Fatalf("unexpected error from UpdateLine: %v", err)
+	}
+	if ids, _ := s.PendingOrders(); len(ids) != 0 {
+		t.Fatalf("expected no pending orders, got %v", ids)
+	}
+	if err := s.UpdateLine(ord.ID, line); err != ErrNotFound {
+		t.Fatalf("expected reserved line to be left alone, got %v", err)
+	}
+}
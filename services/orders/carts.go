@@ -0,0 +1,510 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"inventoryshop/pkg/inventory"
+)
+
+// Cart statuses. A cart is checking_out while its order is being placed, so it
+// cannot be checked out twice.
+const (
+	CartOpen        = "open"
+	CartCheckingOut = "checking_out"
+	CartCheckedOut  = "checked_out"
+)
+
+// Cart warning codes
+const (
+	WarnPriceChanged      = "price_changed"
+	WarnInsufficientStock = "insufficient_stock"
+	WarnOutOfStock        = "out_of_stock"
+	WarnUnavailable       = "unavailable"
+	// WarnBackorder is informational: the shortfall will be backordered at checkout
+	WarnBackorder = "backorder"
+)
+
+var (
+	ErrCartClosed = errors.New("cart is already checked out")
+	ErrCartEmpty  = errors.New("cart is empty")
+)
+
+// Cart is a server-side shopping cart. Carts of a customer belong to them; a cart
+// without a customer is shared by whoever holds its id. Total, Warnings and each
+// line's Available and Subtotal are worked out against inventory on every read.
+type Cart struct {
+	ID         string        `json:"id"`
+	CustomerID int           `json:"customer_id,omitempty"`
+	Status     string        `json:"status"`
+	OrderID    int           `json:"order_id,omitempty"`
+	Lines      []CartLine    `json:"lines"`
+	Total      float64       `json:"total"`
+	Warnings   []CartWarning `json:"warnings"`
+	Created    int64         `json:"created_unix"`
+	Updated    int64         `json:"updated_unix"`
+}
+
+// CartLine is a quantity of an item in a cart. The stored price is the one the
+// customer last saw, so a change since can be pointed out.
+type CartLine struct {
+	ItemID    int     `json:"item_id"`
+	Name      string  `json:"name"`
+	Quantity  int     `json:"quantity"`
+	Price     float64 `json:"price"`
+	Available int     `json:"available"`
+	Subtotal  float64 `json:"subtotal"`
+}
+
+// CartWarning tells the customer about a change to a cart line since they last saw it
+type CartWarning struct {
+	ItemID  int    `json:"item_id"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// canSeeCart reports whether the caller may see and change a cart
+func (c caller) canSeeCart(cart *Cart) bool {
+	return c.staff || cart.CustomerID == 0 || cart.CustomerID == c.customerID
+}
+
+// priceCart prices a cart against current inventory items keyed by id; items
+// missing from the map are no longer available. It returns the new prices of
+// lines whose price changed, to be stored as seen.
+func priceCart(c *Cart, items map[int]*inventory.Item) map[int]float64 {
+	changed := make(map[int]float64)
+	c.Total = 0
+	c.Warnings = make([]CartWarning, 0)
+	warn := func(l *CartLine, code, format string, args ...interface{}) {
+		c.Warnings = append(c.Warnings, CartWarning{ItemID: l.ItemID, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+	for i := range c.Lines {
+		l := &c.Lines[i]
+		it, ok := items[l.ItemID]
+		if !ok {
+			l.Available, l.Subtotal = 0, 0
+			warn(l, WarnUnavailable, "%s is no longer available; remove it to check out", l.Name)
+			continue
+		}
+		if it.Price != l.Price {
+			warn(l, WarnPriceChanged, "price of %s changed from %.2f to %.2f", it.Name, l.Price, it.Price)
+			changed[l.ItemID] = it.Price
+		}
+		l.Name, l.Price, l.Available = it.Name, it.Price, it.Quantity
+		l.Subtotal = float64(l.Quantity) * l.Price
+		c.Total += l.Subtotal
+
+		if w := stockWarning(it, l.Quantity); w != nil {
+			c.Warnings = append(c.Warnings, *w)
+		}
+	}
+	return changed
+}
+
+// stockWarning says how an item's stock falls short of a quantity, nil if it doesn't
+func stockWarning(it *inventory.Item, quantity int) *CartWarning {
+	w := &CartWarning{ItemID: it.ID}
+	switch {
+	case it.Quantity >= quantity:
+		return nil
+	case it.BackorderPolicy != "":
+		w.Code, w.Message = WarnBackorder, fmt.Sprintf("only %d of %s in stock; the rest will be backordered", it.Quantity, it.Name)
+		if it.AvailableAt != "" {
+			w.Message += ", expected " + it.AvailableAt
+		}
+	case it.Quantity <= 0:
+		w.Code, w.Message = WarnOutOfStock, fmt.Sprintf("%s is out of stock", it.Name)
+	default:
+		w.Code, w.Message = WarnInsufficientStock, fmt.Sprintf("only %d of %s in stock", it.Quantity, it.Name)
+	}
+	return w
+}
+
+// blocked reports whether the cart's warnings must be dealt with before checkout.
+// A price change only blocks the checkout that discovers it.
+func (c *Cart) blocked() bool {
+	for _, w := range c.Warnings {
+		if w.Code != WarnBackorder {
+			return true
+		}
+	}
+	return false
+}
+
+// newCartID returns a random, unguessable cart id
+func newCartID() string {
+	return newToken()[:32]
+}
+
+func (s *OrderStore) CreateCart(c Cart) (*Cart, error) {
+	c.Status = CartOpen
+	c.Created = nowUnix()
+	c.Updated = c.Created
+	_, err := s.db.Exec("INSERT INTO carts (id, customer_id, status, created_unix, updated_unix) VALUES ($1,$2,$3,$4,$5)",
+		c.ID, c.CustomerID, c.Status, c.Created, c.Updated)
+	if err != nil {
+		return nil, err
+	}
+	c.Lines = make([]CartLine, 0)
+	return &c, nil
+}
+
+const cartColumns = "id, customer_id, status, order_id, created_unix, updated_unix"
+
+func (s *OrderStore) loadCart(row rowScanner) (*Cart, error) {
+	var c Cart
+	if err := row.Scan(&c.ID, &c.CustomerID, &c.Status, &c.OrderID, &c.Created, &c.Updated); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	rows, err := s.db.Query("SELECT item_id, name, quantity, price FROM cart_lines WHERE cart_id=$1 ORDER BY id", c.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	c.Lines = make([]CartLine, 0)
+	for rows.Next() {
+		var l CartLine
+		if err := rows.Scan(&l.ItemID, &l.Name, &l.Quantity, &l.Price); err != nil {
+			return nil, err
+		}
+		c.Lines = append(c.Lines, l)
+	}
+	return &c, rows.Err()
+}
+
+func (s *OrderStore) GetCart(id string) (*Cart, error) {
+	return s.loadCart(s.db.QueryRow("SELECT "+cartColumns+" FROM carts WHERE id=$1", id))
+}
+
+// CartsByCustomer returns a customer's open carts, most recently changed first
+func (s *OrderStore) CartsByCustomer(customerID int) ([]*Cart, error) {
+	rows, err := s.db.Query("SELECT id FROM carts WHERE customer_id=$1 AND status=$2 ORDER BY updated_unix DESC", customerID, CartOpen)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	res := make([]*Cart, 0, len(ids))
+	for _, id := range ids {
+		c, err := s.GetCart(id)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, c)
+	}
+	return res, nil
+}
+
+// SetCartLine stores a line of an open cart, replacing any line for the same
+// item; a quantity of zero removes the line
+func (s *OrderStore) SetCartLine(cartID string, l CartLine) (*Cart, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var status string
+	err = tx.QueryRow("SELECT status FROM carts WHERE id=$1 FOR UPDATE", cartID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != CartOpen {
+		return nil, ErrCartClosed
+	}
+	if l.Quantity <= 0 {
+		_, err = tx.Exec("DELETE FROM cart_lines WHERE cart_id=$1 AND item_id=$2", cartID, l.ItemID)
+	} else {
+		_, err = tx.Exec(`INSERT INTO cart_lines (cart_id, item_id, name, quantity, price) VALUES ($1,$2,$3,$4,$5)
+		ON CONFLICT (cart_id, item_id) DO UPDATE SET name=EXCLUDED.name, quantity=EXCLUDED.quantity, price=EXCLUDED.price`,
+			cartID, l.ItemID, l.Name, l.Quantity, l.Price)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE carts SET updated_unix=$1 WHERE id=$2", nowUnix(), cartID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetCart(cartID)
+}
+
+// SetCartPrices records the prices a cart's items were last shown at
+func (s *OrderStore) SetCartPrices(cartID string, prices map[int]float64) error {
+	for itemID, price := range prices {
+		if _, err := s.db.Exec("UPDATE cart_lines SET price=$1 WHERE cart_id=$2 AND item_id=$3", price, cartID, itemID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetCartStatus moves a cart from one status to another, recording the order it
+// became if any; ErrCartClosed if the cart is not in the from status
+func (s *OrderStore) SetCartStatus(id, from, to string, orderID int) error {
+	res, err := s.db.Exec("UPDATE carts SET status=$1, order_id=$2, updated_unix=$3 WHERE id=$4 AND status=$5",
+		to, orderID, nowUnix(), id, from)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := s.GetCart(id); err != nil {
+			return err
+		}
+		return ErrCartClosed
+	}
+	return nil
+}
+
+// repriceCart prices a cart against inventory and records the prices shown
+func repriceCart(ctx context.Context, inv *inventory.Client, store *OrderStore, c *Cart) error {
+	ids := make([]int, len(c.Lines))
+	for i, l := range c.Lines {
+		ids[i] = l.ItemID
+	}
+	items, err := inv.GetItems(ctx, ids)
+	if err != nil {
+		return err
+	}
+	if changed := priceCart(c, items); len(changed) > 0 {
+		return store.SetCartPrices(c.ID, changed)
+	}
+	return nil
+}
+
+func cartErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrCartClosed):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// cartsHandler serves /carts, /carts/{id}, /carts/{id}/lines[/{item_id}] and
+// /carts/{id}/checkout. Guests without a token may keep a cart, but checking
+// out needs a customer (or staff) token like any other order.
+func cartsHandler(store *OrderStore, inv *inventory.Client, adminToken string, tax *TaxConfig, pay PaymentProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		who, ok := identify(store, adminToken, r)
+		if !ok && bearerToken(r) != "" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+		defer cancel()
+
+		// writeCart responds with a cart priced against current inventory
+		writeCart := func(code int, c *Cart) {
+			if err := repriceCart(ctx, inv, store, c); err != nil {
+				writeInventoryError(w, err)
+				return
+			}
+			writeJSON(w, code, c)
+		}
+
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/carts"), "/")
+		if path == "" {
+			switch r.Method {
+			case http.MethodGet:
+				// a customer's open carts, to pick up on another device
+				customerID := who.customerID
+				if who.staff {
+					customerID, _ = strconv.Atoi(r.URL.Query().Get("customer_id"))
+				}
+				if customerID == 0 {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "carts are listed per customer"})
+					return
+				}
+				list, err := store.CartsByCustomer(customerID)
+				if err != nil {
+					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+					return
+				}
+				for _, c := range list {
+					if err := repriceCart(ctx, inv, store, c); err != nil {
+						writeInventoryError(w, err)
+						return
+					}
+				}
+				writeJSON(w, http.StatusOK, list)
+			case http.MethodPost:
+				c, err := store.CreateCart(Cart{ID: newCartID(), CustomerID: who.customerID})
+				if err != nil {
+					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+					return
+				}
+				writeCart(http.StatusCreated, c)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
+		parts := strings.Split(path, "/")
+		c, err := store.GetCart(parts[0])
+		// other customers' carts are reported as missing rather than forbidden
+		if err != nil || !who.canSeeCart(c) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+			return
+		}
+
+		switch {
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			writeCart(http.StatusOK, c)
+
+		case len(parts) == 2 && parts[1] == "lines" && r.Method == http.MethodPost:
+			// adds to the quantity already in the cart
+			var req struct {
+				ItemID   int `json:"item_id"`
+				Quantity int `json:"quantity"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ItemID <= 0 || req.Quantity <= 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "item_id and a positive quantity are required"})
+				return
+			}
+			it, err := inv.GetItem(ctx, req.ItemID)
+			if err != nil {
+				if errors.Is(err, inventory.ErrNotFound) {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "item not found in inventory"})
+					return
+				}
+				writeInventoryError(w, err)
+				return
+			}
+			line := CartLine{ItemID: it.ID, Name: it.Name, Quantity: req.Quantity, Price: it.Price}
+			for _, l := range c.Lines {
+				if l.ItemID == it.ID {
+					line.Quantity += l.Quantity
+				}
+			}
+			c, err = store.SetCartLine(c.ID, line)
+			if err != nil {
+				writeJSON(w, cartErrorStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			writeCart(http.StatusOK, c)
+
+		case len(parts) == 3 && parts[1] == "lines" && (r.Method == http.MethodPut || r.Method == http.MethodDelete):
+			// PUT sets a line's quantity, zero removing it; DELETE removes the line
+			itemID, err := strconv.Atoi(parts[2])
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid item id"})
+				return
+			}
+			var line *CartLine
+			for i := range c.Lines {
+				if c.Lines[i].ItemID == itemID {
+					line = &c.Lines[i]
+				}
+			}
+			if line == nil {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "item is not in the cart"})
+				return
+			}
+			update := *line
+			update.Quantity = 0
+			if r.Method == http.MethodPut {
+				var req struct {
+					Quantity int `json:"quantity"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Quantity < 0 {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "quantity must not be negative"})
+					return
+				}
+				update.Quantity = req.Quantity
+			}
+			c, err = store.SetCartLine(c.ID, update)
+			if err != nil {
+				writeJSON(w, cartErrorStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			writeCart(http.StatusOK, c)
+
+		case len(parts) == 2 && parts[1] == "checkout" && r.Method == http.MethodPost:
+			if !ok {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "sign in to check out"})
+				return
+			}
+			if c.Status != CartOpen {
+				writeJSON(w, http.StatusConflict, map[string]string{"error": ErrCartClosed.Error()})
+				return
+			}
+			// the body picks promo code, shipping method and address; items come from the cart
+			var order orderRequest
+			if err := json.NewDecoder(r.Body).Decode(&order); err != nil && err != io.EOF {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+				return
+			}
+			if len(c.Lines) == 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": ErrCartEmpty.Error()})
+				return
+			}
+			if err := repriceCart(ctx, inv, store, c); err != nil {
+				writeInventoryError(w, err)
+				return
+			}
+			// the customer gets to see what changed before the order is placed
+			if c.blocked() {
+				writeJSON(w, http.StatusConflict, map[string]interface{}{"error": "cart changed; review it before checking out", "cart": c})
+				return
+			}
+			if err := store.SetCartStatus(c.ID, CartOpen, CartCheckingOut, 0); err != nil {
+				writeJSON(w, cartErrorStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			order.CustomerID, order.Items = c.CustomerID, nil
+			if order.CustomerID == 0 {
+				order.CustomerID = who.customerID
+			}
+			for _, l := range c.Lines {
+				order.Items = append(order.Items, orderLine{ID: l.ItemID, Quantity: l.Quantity})
+			}
+			// the order is placed even if the caller goes away, as for /orders,
+			// with its own time budget rather than what repricing left
+			pctx, pcancel := context.WithTimeout(context.Background(), 8*time.Second)
+			defer pcancel()
+			ord, code, err := placeOrder(pctx, inv, store, tax, pay, order)
+			if err != nil {
+				if err := store.SetCartStatus(c.ID, CartCheckingOut, CartOpen, 0); err != nil {
+					log.Printf("reopen cart %s: %v", c.ID, err)
+				}
+				writeJSON(w, code, map[string]string{"error": err.Error()})
+				return
+			}
+			if err := store.SetCartStatus(c.ID, CartCheckingOut, CartCheckedOut, ord.ID); err != nil {
+				log.Printf("close cart %s after order %d: %v", c.ID, ord.ID, err)
+			}
+			writeJSON(w, http.StatusCreated, ord)
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
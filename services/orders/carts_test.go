@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"inventoryshop/pkg/inventory"
+)
+
+func TestPriceCart(t *testing.T) {
+	c := &Cart{Lines: []CartLine{
+		{ItemID: 1, Name: "Widget", Quantity: 2, Price: 10},
+		{ItemID: 2, Name: "Gadget", Quantity: 5, Price: 3},
+		{ItemID: 3, Name: "Gone", Quantity: 1, Price: 7},
+		{ItemID: 4, Name: "Preorder", Quantity: 4, Price: 20},
+	}}
+	items := map[int]*inventory.Item{
+		1: {ID: 1, Name: "Widget", Quantity: 10, Price: 12},
+		2: {ID: 2, Name: "Gadget", Quantity: 3, Price: 3},
+		4: {ID: 4, Name: "Preorder", Quantity: 0, Price: 20, BackorderPolicy: "preorder", AvailableAt: "2030-01-15"},
+	}
+	changed := priceCart(c, items)
+	if len(changed) != 1 || changed[1] != 12 {
+		t.Fatalf("expected only item 1 repriced to 12, got %v", changed)
+	}
+	if !almostEqualFloat(c.Total, 2*12+5*3+4*20) {
+		t.Fatalf("expected unavailable line left out of the total, got %v", c.Total)
+	}
+	codes := make(map[int]string)
+	for _, w := range c.Warnings {
+		codes[w.ItemID] = w.Code
+	}
+	want := map[int]string{1: WarnPriceChanged, 2: WarnInsufficientStock, 3: WarnUnavailable, 4: WarnBackorder}
+	for id, code := range want {
+		if codes[id] != code {
+			t.Fatalf("expected %s warning for item %d, got %v", code, id, c.Warnings)
+		}
+	}
+	if !c.blocked() {
+		t.Fatalf("expected cart with stock problems to block checkout")
+	}
+
+	// once seen, the new price no longer warns and a backorder alone does not block
+	c = &Cart{Lines: []CartLine{{ItemID: 1, Quantity: 2, Price: 12}, {ItemID: 4, Quantity: 4, Price: 20}}}
+	priceCart(c, items)
+	if c.blocked() || len(c.Warnings) != 1 {
+		t.Fatalf("expected only the backorder notice, got %v", c.Warnings)
+	}
+}
+
+func TestOrderStore_Cart(t *testing.T) {
+	s := NewOrderStoreInMemory()
+	c, _ := s.CreateCart(Cart{ID: newCartID(), CustomerID: 3})
+	if len(c.ID) != 32 || c.Status != CartOpen {
+		t.Fatalf("expected open cart with a random id, got %+v", c)
+	}
+	s.SetCartLine(c.ID, CartLine{ItemID: 1, Name: "Widget", Quantity: 2, Price: 10})
+	s.SetCartLine(c.ID, CartLine{ItemID: 2, Name: "Gadget", Quantity: 1, Price: 3})
+	c, _ = s.SetCartLine(c.ID, CartLine{ItemID: 1, Name: "Widget", Quantity: 5, Price: 10})
+	if len(c.Lines) != 2 || c.Lines[0].Quantity != 5 {
+		t.Fatalf("expected line 1 updated in place, got %+v", c.Lines)
+	}
+	c, _ = s.SetCartLine(c.ID, CartLine{ItemID: 2})
+	if len(c.Lines) != 1 {
+		t.Fatalf("expected zero quantity to remove the line, got %+v", c.Lines)
+	}
+	if mine, _ := s.CartsByCustomer(3); len(mine) != 1 {
+		t.Fatalf("expected the customer's open cart, got %v", mine)
+	}
+
+	if err := s.SetCartStatus(c.ID, CartOpen, CartCheckingOut, 0); err != nil {
+		t.Fatalf("unexpected error starting checkout: %v", err)
+	}
+	if err := s.SetCartStatus(c.ID, CartOpen, CartCheckingOut, 0); err != ErrCartClosed {
+		t.Fatalf("expected a second checkout to be refused, got %v", err)
+	}
+	s.SetCartStatus(c.ID, CartCheckingOut, CartCheckedOut, 9)
+	if _, err := s.SetCartLine(c.ID, CartLine{ItemID: 1, Quantity: 1}); err != ErrCartClosed {
+		t.Fatalf("expected checked out cart to be closed, got %v", err)
+	}
+	if c, _ = s.GetCart(c.ID); c.OrderID != 9 {
+		t.Fatalf("expected cart to record its order, got %+v", c)
+	}
+	if mine, _ := s.CartsByCustomer(3); len(mine) != 0 {
+		t.Fatalf("expected no open carts, got %v", mine)
+	}
+}
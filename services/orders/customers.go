@@ -0,0 +1,396 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Customer is a shop customer. Token is only set in the response that creates
+// the customer; afterwards only its hash is kept.
+type Customer struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Phone     string    `json:"phone,omitempty"`
+	Addresses []Address `json:"addresses"`
+	Created   int64     `json:"created_unix"`
+	Token     string    `json:"token,omitempty"`
+}
+
+// Address is a postal address of a customer
+type Address struct {
+	ID         int    `json:"id"`
+	Label      string `json:"label,omitempty"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city"`
+	Region     string `json:"region,omitempty"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+var (
+	ErrInvalidCustomer = errors.New("invalid customer")
+	ErrInvalidAddress  = errors.New("invalid address")
+	ErrCustomerExists  = errors.New("customer with this email already exists")
+)
+
+// caller is who a request comes from: a customer, or staff who may see everything
+type caller struct {
+	customerID int
+	staff      bool
+}
+
+// canSee reports whether the caller may see an order
+func (c caller) canSee(o *Order) bool {
+	return c.staff || (c.customerID != 0 && o.CustomerID == c.customerID)
+}
+
+// canSeeCustomer reports whether the caller may see a customer's account and orders
+func (c caller) canSeeCustomer(id int) bool {
+	return c.staff || c.customerID == id
+}
+
+func validateCustomer(c Customer) error {
+	if strings.TrimSpace(c.Name) == "" || !strings.Contains(c.Email, "@") {
+		return ErrInvalidCustomer
+	}
+	for _, a := range c.Addresses {
+		if err := validateAddress(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateAddress(a Address) error {
+	if a.Line1 == "" || a.City == "" || a.PostalCode == "" || a.Country == "" {
+		return ErrInvalidAddress
+	}
+	return nil
+}
+
+// newToken returns a random API token for a customer
+func newToken() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// hashToken is how tokens are stored, so a leaked database holds no usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerToken reads the token from an "Authorization: Bearer ..." header
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+}
+
+// identify works out the caller of a request. The admin token makes the caller
+// staff, a customer token that customer. Callers without a token are refused,
+// and with no admin token configured nobody is staff.
+func identify(store *OrderStore, adminToken string, r *http.Request) (caller, bool) {
+	return identifyToken(store, adminToken, bearerToken(r))
+}
+
+// identifyToken works out the caller presenting a bearer token, as identify does
+func identifyToken(store *OrderStore, adminToken, token string) (caller, bool) {
+	if token == "" {
+		return caller{}, false
+	}
+	if adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1 {
+		return caller{staff: true}, true
+	}
+	c, err := store.CustomerByToken(hashToken(token))
+	if err != nil {
+		return caller{}, false
+	}
+	return caller{customerID: c.ID}, true
+}
+
+// staffOnly guards endpoints that expose data across customers
+func staffOnly(store *OrderStore, adminToken string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		who, ok := identify(store, adminToken, r)
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		if !who.staff {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// CreateCustomer stores a customer with their addresses under the given token hash
+func (s *OrderStore) CreateCustomer(c Customer, tokenHash string) (*Customer, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	c.Created = time.Now().Unix()
+	err = tx.QueryRow(`INSERT INTO customers (name, email, phone, token_hash, created_unix) VALUES ($1,$2,$3,$4,$5)
+	ON CONFLICT (email) DO NOTHING RETURNING id`, c.Name, c.Email, c.Phone, tokenHash, c.Created).Scan(&c.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCustomerExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range c.Addresses {
+		if err := insertAddressTx(tx, c.ID, &c.Addresses[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if c.Addresses == nil {
+		c.Addresses = make([]Address, 0)
+	}
+	return &c, nil
+}
+
+func insertAddressTx(tx *sql.Tx, customerID int, a *Address) error {
+	return tx.QueryRow(`INSERT INTO customer_addresses (customer_id, label, line1, line2, city, region, postal_code, country)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING id`,
+		customerID, a.Label, a.Line1, a.Line2, a.City, a.Region, a.PostalCode, a.Country).Scan(&a.ID)
+}
+
+// AddAddress adds an address to a customer
+func (s *OrderStore) AddAddress(customerID int, a Address) (*Customer, error) {
+	if _, err := s.GetCustomer(customerID); err != nil {
+		return nil, err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if err := insertAddressTx(tx, customerID, &a); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetCustomer(customerID)
+}
+
+const customerColumns = "id, name, email, phone, created_unix"
+
+func (s *OrderStore) loadCustomer(row rowScanner) (*Customer, error) {
+	var c Customer
+	if err := row.Scan(&c.ID, &c.Name, &c.Email, &c.Phone, &c.Created); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	rows, err := s.db.Query(`SELECT id, label, line1, line2, city, region, postal_code, country
+	FROM customer_addresses WHERE customer_id=$1 ORDER BY id`, c.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	c.Addresses = make([]Address, 0)
+	for rows.Next() {
+		var a Address
+		if err := rows.Scan(&a.ID, &a.Label, &a.Line1, &a.Line2, &a.City, &a.Region, &a.PostalCode, &a.Country); err != nil {
+			return nil, err
+		}
+		c.Addresses = append(c.Addresses, a)
+	}
+	return &c, rows.Err()
+}
+
+func (s *OrderStore) GetCustomer(id int) (*Customer, error) {
+	return s.loadCustomer(s.db.QueryRow("SELECT "+customerColumns+" FROM customers WHERE id=$1", id))
+}
+
+// CustomerByToken finds the customer a token hash belongs to
+func (s *OrderStore) CustomerByToken(tokenHash string) (*Customer, error) {
+	return s.loadCustomer(s.db.QueryRow("SELECT "+customerColumns+" FROM customers WHERE token_hash=$1", tokenHash))
+}
+
+func (s *OrderStore) ListCustomers() ([]*Customer, error) {
+	rows, err := s.db.Query("SELECT id FROM customers ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	res := make([]*Customer, 0, len(ids))
+	for _, id := range ids {
+		c, err := s.GetCustomer(id)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, c)
+	}
+	return res, nil
+}
+
+// OrdersByCustomer returns a customer's orders, newest first
+func (s *OrderStore) OrdersByCustomer(customerID int) ([]*Order, error) {
+	rows, err := s.db.Query("SELECT "+orderColumns+" FROM orders WHERE customer_id=$1 ORDER BY id DESC", customerID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*Order, 0)
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		res = append(res, o)
+	}
+	rows.Close()
+	for _, o := range res {
+		if err := s.loadDetails(o); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// customersHandler serves /customers and /customers/{id}[/orders|/addresses]
+func customersHandler(store *OrderStore, adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		who, ok := identify(store, adminToken, r)
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/customers"), "/")
+
+		if path == "" {
+			switch r.Method {
+			case http.MethodGet:
+				if !ok {
+					writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+					return
+				}
+				if !who.staff {
+					writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+					return
+				}
+				list, err := store.ListCustomers()
+				if err != nil {
+					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+					return
+				}
+				writeJSON(w, http.StatusOK, list)
+			case http.MethodPost:
+				// sign-up is open to anyone; the response carries the customer's token
+				var req Customer
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+					return
+				}
+				if err := validateCustomer(req); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+					return
+				}
+				token := newToken()
+				c, err := store.CreateCustomer(req, hashToken(token))
+				if err != nil {
+					code := http.StatusInternalServerError
+					if errors.Is(err, ErrCustomerExists) {
+						code = http.StatusConflict
+					}
+					writeJSON(w, code, map[string]string{"error": err.Error()})
+					return
+				}
+				c.Token = token
+				writeJSON(w, http.StatusCreated, c)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		parts := strings.Split(path, "/")
+		id, err := strconv.Atoi(parts[0])
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid id"})
+			return
+		}
+		// other customers are reported as missing rather than forbidden
+		if !who.canSeeCustomer(id) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+			return
+		}
+
+		switch {
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			c, err := store.GetCustomer(id)
+			if err != nil {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+				return
+			}
+			writeJSON(w, http.StatusOK, c)
+		case len(parts) == 2 && parts[1] == "orders" && r.Method == http.MethodGet:
+			if _, err := store.GetCustomer(id); err != nil {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+				return
+			}
+			list, err := store.OrdersByCustomer(id)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, list)
+		case len(parts) == 2 && parts[1] == "addresses" && r.Method == http.MethodPost:
+			var a Address
+			if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+				return
+			}
+			if err := validateAddress(a); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			c, err := store.AddAddress(id, a)
+			if err != nil {
+				code := http.StatusInternalServerError
+				if errors.Is(err, ErrNotFound) {
+					code = http.StatusNotFound
+				}
+				writeJSON(w, code, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusCreated, c)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
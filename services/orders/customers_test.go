@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCaller_CanSee(t *testing.T) {
+	mine := &Order{ID: 1, CustomerID: 7}
+	anonymous := &Order{ID: 2}
+	if !(caller{customerID: 7}).canSee(mine) {
+		t.Fatalf("expected customer to see their own order")
+	}
+	if (caller{customerID: 8}).canSee(mine) || (caller{customerID: 8}).canSee(anonymous) {
+		t.Fatalf("expected customer not to see other orders")
+	}
+	if !(caller{staff: true}).canSee(anonymous) {
+		t.Fatalf("expected staff to see every order")
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	r := httptest.NewRequest("GET", "/orders", nil)
+	r.Header.Set("Authorization", "Bearer abc")
+	if got := bearerToken(r); got != "abc" {
+		t.Fatalf("expected token abc, got %q", got)
+	}
+	r.Header.Set("Authorization", "Basic abc")
+	if got := bearerToken(r); got != "" {
+		t.Fatalf("expected no token for basic auth, got %q", got)
+	}
+	if hashToken("abc") == "abc" || hashToken("abc") != hashToken("abc") {
+		t.Fatalf("expected a stable hash that differs from the token")
+	}
+}
+
+func TestOrderStore_Customers(t *testing.T) {
+	s := NewOrderStoreInMemory()
+	if err := validateCustomer(Customer{Name: "Anna", Email: "anna"}); err != ErrInvalidCustomer {
+		t.Fatalf("expected ErrInvalidCustomer, got %v", err)
+	}
+	addr := Address{Line1: "Lenina 1", City: "Moscow", PostalCode: "101000", Country: "RU"}
+	c, err := s.CreateCustomer(Customer{Name: "Anna", Email: "anna@example.com", Addresses: []Address{addr}}, hashToken("t1"))
+	if err != nil {
+		t.Fatalf("unexpected error from CreateCustomer: %v", err)
+	}
+	if _, err := s.CreateCustomer(Customer{Name: "Anna 2", Email: "anna@example.com"}, hashToken("t2")); err != ErrCustomerExists {
+		t.Fatalf("expected ErrCustomerExists, got %v", err)
+	}
+	if got, err := s.CustomerByToken(hashToken("t1")); err != nil || got.ID != c.ID {
+		t.Fatalf("expected token to resolve to customer %d, got %v, %v", c.ID, got, err)
+	}
+	c, _ = s.AddAddress(c.ID, Address{Label: "work", Line1: "Tverskaya 2", City: "Moscow", PostalCode: "125009", Country: "RU"})
+	if len(c.Addresses) != 2 || c.Addresses[1].ID != 2 {
+		t.Fatalf("expected two addresses, got %+v", c.Addresses)
+	}
+
+	s.CreateOrder(Order{CustomerID: c.ID, Items: []OrderItem{{ItemID: 1, Quantity: 1, Price: 5}}, Total: 5})
+	s.CreateOrder(Order{Items: []OrderItem{{ItemID: 1, Quantity: 2, Price: 5}}, Total: 10})
+	mine, _ := s.OrdersByCustomer(c.ID)
+	if len(mine) != 1 || mine[0].CustomerID != c.ID {
+		t.Fatalf("expected one order for the customer, got %+v", mine)
+	}
+}
+
+func TestIdentifyToken_Staff(t *testing.T) {
+	if who, ok := identifyToken(nil, "secret", "secret"); !ok || !who.staff {
+		t.Fatalf("expected the admin token to make staff, got %+v %v", who, ok)
+	}
+	// anonymous callers are never staff, even with no admin token configured
+	for _, admin := range []string{"", "secret"} {
+		if who, ok := identifyToken(nil, admin, ""); ok || who.staff {
+			t.Fatalf("expected anonymous caller refused with admin token %q, got %+v", admin, who)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Payment tokens FakeProvider treats specially; any other token is authorized at once
+const (
+	FakeTokenDecline      = "tok_decline"
+	FakeTokenAsync        = "tok_async"
+	FakeTokenAsyncDecline = "tok_async_decline"
+)
+
+// fakeSignatureHeader carries the HMAC-SHA256 of a fake webhook's body
+const fakeSignatureHeader = "X-Fake-Signature"
+
+// FakeProvider is an in-process payment provider for development and tests.
+// Latency delays every call. Payments with an async token stay pending and are
+// settled AsyncDelay later by a signed callback to WebhookURL.
+type FakeProvider struct {
+	Latency    time.Duration
+	AsyncDelay time.Duration
+	WebhookURL string
+	secret     []byte
+	client     *http.Client
+
+	mu       sync.Mutex
+	next     int
+	payments map[string]*fakePayment
+}
+
+type fakePayment struct {
+	status                     string
+	amount, captured, refunded float64
+}
+
+func NewFakeProvider(webhookURL, secret string) *FakeProvider {
+	return &FakeProvider{AsyncDelay: 2 * time.Second, WebhookURL: webhookURL, secret: []byte(secret),
+		client: &http.Client{Timeout: 5 * time.Second}, payments: make(map[string]*fakePayment)}
+}
+
+func (f *FakeProvider) Name() string { return "fake" }
+
+func (f *FakeProvider) wait(ctx context.Context) error {
+	if f.Latency <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(f.Latency):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *FakeProvider) Authorize(ctx context.Context, orderID int, amount float64, token string) (PaymentResult, error) {
+	if err := f.wait(ctx); err != nil {
+		return PaymentResult{}, err
+	}
+	f.mu.Lock()
+	f.next++
+	ref := fmt.Sprintf("fake_%d_%d", orderID, f.next)
+	p := &fakePayment{status: PaymentAuthorized, amount: amount}
+	f.payments[ref] = p
+	switch token {
+	case FakeTokenDecline:
+		p.status = PaymentDeclined
+	case FakeTokenAsync, FakeTokenAsyncDecline:
+		p.status = PaymentPending
+		outcome := PaymentAuthorized
+		if token == FakeTokenAsyncDecline {
+			outcome = PaymentDeclined
+		}
+		time.AfterFunc(f.AsyncDelay, func() { f.settle(ref, outcome) })
+	}
+	res := PaymentResult{Reference: ref, Status: p.status}
+	f.mu.Unlock()
+	if res.Status == PaymentDeclined {
+		res.Message = "card declined"
+	}
+	return res, nil
+}
+
+// settle decides a pending payment and tells the webhook
+func (f *FakeProvider) settle(ref, status string) {
+	f.mu.Lock()
+	p := f.payments[ref]
+	if p.status == PaymentPending {
+		p.status = status
+	}
+	res := PaymentResult{Reference: ref, Status: p.status}
+	f.mu.Unlock()
+	if f.WebhookURL == "" {
+		return
+	}
+	body, _ := json.Marshal(res)
+	req, _ := http.NewRequest(http.MethodPost, f.WebhookURL, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(fakeSignatureHeader, f.sign(body))
+	resp, err := f.client.Do(req)
+	if err != nil {
+		log.Printf("fake payment webhook for %s: %v", ref, err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("fake payment webhook for %s: status %d", ref, resp.StatusCode)
+	}
+}
+
+func (f *FakeProvider) sign(body []byte) string {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// update applies a change to a payment in one of the given statuses
+func (f *FakeProvider) update(ctx context.Context, ref string, change func(p *fakePayment) error, from ...string) (PaymentResult, error) {
+	if err := f.wait(ctx); err != nil {
+		return PaymentResult{}, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[ref]
+	if !ok {
+		return PaymentResult{}, ErrNotFound
+	}
+	allowed := false
+	for _, s := range from {
+		allowed = allowed || p.status == s
+	}
+	if !allowed {
+		return PaymentResult{}, ErrPaymentState
+	}
+	if err := change(p); err != nil {
+		return PaymentResult{}, err
+	}
+	return PaymentResult{Reference: ref, Status: p.status}, nil
+}
+
+func (f *FakeProvider) Capture(ctx context.Context, ref string, amount float64) (PaymentResult, error) {
+	return f.update(ctx, ref, func(p *fakePayment) error {
+		if amount > p.amount {
+			return ErrPaymentState
+		}
+		p.status, p.captured = PaymentCaptured, amount
+		return nil
+	}, PaymentAuthorized)
+}
+
+func (f *FakeProvider) Void(ctx context.Context, ref string) (PaymentResult, error) {
+	return f.update(ctx, ref, func(p *fakePayment) error {
+		p.status = PaymentVoided
+		return nil
+	}, PaymentPending, PaymentAuthorized)
+}
+
+func (f *FakeProvider) Refund(ctx context.Context, ref string, amount float64) (PaymentResult, error) {
+	return f.update(ctx, ref, func(p *fakePayment) error {
+		if roundCents(p.refunded+amount) > p.captured {
+			return ErrPaymentState
+		}
+		p.refunded = roundCents(p.refunded + amount)
+		if p.refunded == p.captured {
+			p.status = PaymentRefunded
+		}
+		return nil
+	}, PaymentCaptured)
+}
+
+func (f *FakeProvider) ParseWebhook(r *http.Request) (PaymentResult, error) {
+	var res PaymentResult
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		return res, ErrInvalidWebhook
+	}
+	if !hmac.Equal([]byte(f.sign(body)), []byte(r.Header.Get(fakeSignatureHeader))) {
+		return res, ErrInvalidWebhook
+	}
+	if err := json.Unmarshal(body, &res); err != nil || res.Reference == "" {
+		return res, ErrInvalidWebhook
+	}
+	return res, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"inventoryshop/pkg/events"
+)
+
+// Limits of the order feed
+const (
+	feedBuffer      = 256
+	feedBacklog     = 1000
+	feedWriteWait   = 10 * time.Second
+	feedPongWait    = 60 * time.Second
+	feedPingEvery   = 45 * time.Second
+	feedResyncClose = "backlog too large, reload orders and reconnect"
+)
+
+// feedMessage is what the order feed sends: an order event, or a request to
+// reload everything when the backlog cannot be replayed
+type feedMessage struct {
+	Type  string        `json:"type"`
+	Event *events.Event `json:"event,omitempty"`
+}
+
+// Feed message types
+const (
+	feedEvent  = "event"
+	feedResync = "resync"
+)
+
+var feedUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	// callers authenticate with a token rather than cookies, so any origin may connect
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// feedHandler serves /orders/feed, a WebSocket of order events: OrderCreated,
+// OrderCancelled and OrderStatusChanged, as JSON feedMessages. It takes the same
+// tokens as /orders; staff are sent every event, customers those of their own
+// orders. Browsers, which cannot set headers on WebSockets, may pass the token
+// as ?access_token=. A client reconnecting with ?since=<seq of the last event> is
+// sent what it missed first; if that is more than the backlog allows it is told
+// to resync. Events are resumed by seq rather than id, which transactions
+// committing out of order would leave in the wrong order.
+// A client that cannot keep up is disconnected and catches up when it resumes.
+func feedHandler(store *OrderStore, adminToken string, history events.Log, bus *events.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if t := r.URL.Query().Get("access_token"); t != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+t)
+		}
+		who, ok := identify(store, adminToken, r)
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		serveFeed(w, r, who, history, bus)
+	}
+}
+
+// feedVisible reports whether the caller may be sent an order event
+func feedVisible(who caller, e events.Event) bool {
+	if who.staff {
+		return true
+	}
+	var p OrderEvent
+	return json.Unmarshal(e.Payload, &p) == nil && who.canSee(&Order{CustomerID: p.CustomerID})
+}
+
+func serveFeed(w http.ResponseWriter, r *http.Request, who caller, history events.Log, bus *events.Bus) {
+	var since int64
+	resume := r.URL.Query().Get("since")
+	if resume != "" {
+		var err error
+		if since, err = strconv.ParseInt(resume, 10, 64); err != nil || since < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid since"})
+			return
+		}
+	}
+	// subscribe before replaying so nothing published meanwhile is missed
+	live, cancel := bus.Subscribe(feedBuffer)
+	defer cancel()
+	conn, err := feedUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// the reader only handles control frames and notices the client leaving
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(feedPongWait))
+		conn.SetPongHandler(func(string) error { return conn.SetReadDeadline(time.Now().Add(feedPongWait)) })
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(m feedMessage) bool {
+		conn.SetWriteDeadline(time.Now().Add(feedWriteWait))
+		return conn.WriteJSON(m) == nil
+	}
+	closeWith := func(code int, reason string) {
+		msg := websocket.FormatCloseMessage(code, reason)
+		conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(feedWriteWait))
+	}
+
+	last := since
+	if resume != "" {
+		missed, err := history.EventsSince(since, feedBacklog+1)
+		if err != nil {
+			closeWith(websocket.CloseInternalServerErr, "failed to load backlog")
+			return
+		}
+		if len(missed) > feedBacklog {
+			send(feedMessage{Type: feedResync})
+			closeWith(websocket.CloseNormalClosure, feedResyncClose)
+			return
+		}
+		for i := range missed {
+			last = missed[i].Seq
+			if feedVisible(who, missed[i]) && !send(feedMessage{Type: feedEvent, Event: &missed[i]}) {
+				return
+			}
+		}
+	}
+
+	ping := time.NewTicker(feedPingEvery)
+	defer ping.Stop()
+	for {
+		select {
+		case <-gone:
+			return
+		case e, ok := <-live:
+			if !ok {
+				// dropped by the bus for falling behind
+				closeWith(websocket.CloseTryAgainLater, "too slow, reconnect with since")
+				return
+			}
+			if e.Seq <= last {
+				continue
+			}
+			last = e.Seq
+			if feedVisible(who, e) && !send(feedMessage{Type: feedEvent, Event: &e}) {
+				log.Printf("order feed: client %s too slow, disconnecting", r.RemoteAddr)
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(feedWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
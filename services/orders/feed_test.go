@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"inventoryshop/pkg/events"
+)
+
+func TestOrderFeed(t *testing.T) {
+	s := NewOrderStoreInMemory()
+	bus := events.NewBus()
+	relay := events.NewRelay(s, events.MultiSink{})
+	relay.Live = bus
+	first := s.CreateOrder(Order{Total: 10, Items: []OrderItem{{ItemID: 1, Quantity: 1, Price: 10}}}) // event 1
+	s.SetStatus(first.ID, OrderStatusCreated, OrderStatusCancelled)                                   // events 2, 3
+	relay.Flush(context.Background())
+
+	srv := httptest.NewServer(feedHandler(nil, "admin", s, bus))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected unauthenticated client to be refused, got %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?access_token=admin&since=1", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	read := func() feedMessage {
+		var m feedMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return m
+	}
+
+	// the backlog after the last event seen, then live events
+	if m := read(); m.Type != feedEvent || m.Event.Seq != 2 || m.Event.Type != EventOrderStatusChanged {
+		t.Fatalf("unexpected message %+v", m)
+	}
+	if m := read(); m.Event.Seq != 3 || m.Event.Type != EventOrderCancelled {
+		t.Fatalf("unexpected message %+v", m)
+	}
+	second := s.CreateOrder(Order{Total: 5})
+	relay.Flush(context.Background())
+	if m := read(); m.Event.Seq != 4 || m.Event.Type != EventOrderCreated || m.Event.AggregateID != second.ID {
+		t.Fatalf("unexpected message %+v", m)
+	}
+}
+
+// fixedLog is an events.Log that always returns the same events
+type fixedLog []events.Event
+
+func (l fixedLog) EventsSince(after int64, limit int) ([]events.Event, error) {
+	return l, nil
+}
+
+func TestOrderFeed_OutOfOrderCommits(t *testing.T) {
+	// events 2 and 3 were published before event 1, whose transaction
+	// committed last
+	bus := events.NewBus()
+	backlog := fixedLog{{ID: 2, Seq: 1, Type: EventOrderCreated}, {ID: 3, Seq: 2, Type: EventOrderCreated}}
+	srv := httptest.NewServer(feedHandler(nil, "admin", backlog, bus))
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?access_token=admin&since=0", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var m feedMessage
+	for i := 0; i < 2; i++ {
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	bus.Publish(context.Background(), []events.Event{{ID: 1, Seq: 3, Type: EventOrderCreated}})
+	if err := conn.ReadJSON(&m); err != nil || m.Event.ID != 1 {
+		t.Fatalf("expected the late event, got %+v %v", m, err)
+	}
+}
+
+func TestFeedVisible(t *testing.T) {
+	e := newEvent(EventOrderCreated, 1, OrderEvent{OrderID: 1, CustomerID: 7})
+	anonymous := newEvent(EventOrderCreated, 2, OrderEvent{OrderID: 2})
+	if !feedVisible(caller{staff: true}, e) || !feedVisible(caller{staff: true}, anonymous) {
+		t.Fatal("expected staff to see every event")
+	}
+	if !feedVisible(caller{customerID: 7}, e) || feedVisible(caller{customerID: 8}, e) || feedVisible(caller{customerID: 7}, anonymous) {
+		t.Fatal("expected customers to see only events of their own orders")
+	}
+}
+
+func TestOrderFeed_SlowClientIsDropped(t *testing.T) {
+	bus := events.NewBus()
+	ch, _ := bus.Subscribe(1)
+	bus.Publish(context.Background(), []events.Event{{ID: 1}, {ID: 2}})
+	<-ch
+	if _, ok := <-ch; ok {
+		t.Fatal("expected the subscription of a subscriber that fell behind to be closed")
+	}
+}
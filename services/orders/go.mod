@@ -3,3 +3,29 @@ module orders
 go 1.20
 
 require github.com/lib/pq v1.10.0
+
+require github.com/go-pdf/fpdf v0.9.0
+
+require github.com/gorilla/websocket v1.5.3
+
+require (
+	google.golang.org/grpc v1.58.3
+	google.golang.org/protobuf v1.31.0
+	inventoryshop/pkg/events v1.0.0
+	inventoryshop/pkg/inventory v1.3.0
+	inventoryshop/pkg/openapi v1.0.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/net v0.12.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
+	golang.org/x/text v0.11.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230711160842-782d3b101e98 // indirect
+)
+
+replace inventoryshop/pkg/events => ../../pkg/events
+
+replace inventoryshop/pkg/inventory => ../../pkg/inventory
+
+replace inventoryshop/pkg/openapi => ../../pkg/openapi
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"inventoryshop/pkg/inventory"
+	pb "orders/orderspb"
+)
+
+// grpcServer serves the orders gRPC API from the same store and inventory
+// client as the REST API. Callers authenticate as they do over REST, with an
+// "authorization: Bearer <token>" metadata entry.
+type grpcServer struct {
+	pb.UnimplementedOrdersServiceServer
+	store      *OrderStore
+	inv        *inventory.Client
+	adminToken string
+	tax        *TaxConfig
+	pay        PaymentProvider
+}
+
+// NewGRPCServer returns a gRPC server with the orders service registered
+func NewGRPCServer(store *OrderStore, inv *inventory.Client, adminToken string, tax *TaxConfig, pay PaymentProvider) *grpc.Server {
+	s := grpc.NewServer()
+	pb.RegisterOrdersServiceServer(s, &grpcServer{store: store, inv: inv, adminToken: adminToken, tax: tax, pay: pay})
+	return s
+}
+
+// grpcCodes maps the HTTP statuses the order logic reports to gRPC codes
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:         codes.InvalidArgument,
+	http.StatusUnauthorized:       codes.Unauthenticated,
+	http.StatusForbidden:          codes.PermissionDenied,
+	http.StatusNotFound:           codes.NotFound,
+	http.StatusConflict:           codes.FailedPrecondition,
+	http.StatusPaymentRequired:    codes.FailedPrecondition,
+	http.StatusBadGateway:         codes.Unavailable,
+	http.StatusServiceUnavailable: codes.Unavailable,
+	http.StatusGatewayTimeout:     codes.DeadlineExceeded,
+}
+
+// grpcError turns an error and the HTTP status it would be reported with into
+// a gRPC status error
+func grpcError(code int, err error) error {
+	c, ok := grpcCodes[code]
+	if !ok {
+		c = codes.Internal
+	}
+	return status.Error(c, err.Error())
+}
+
+// identify works out the caller of an RPC from its metadata
+func (s *grpcServer) identify(ctx context.Context) (caller, error) {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, v := range md.Get("authorization") {
+			if strings.HasPrefix(v, "Bearer ") {
+				token = strings.TrimSpace(strings.TrimPrefix(v, "Bearer "))
+			}
+		}
+	}
+	who, ok := identifyToken(s.store, s.adminToken, token)
+	if !ok {
+		return caller{}, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return who, nil
+}
+
+func (s *grpcServer) ListOrders(ctx context.Context, req *pb.ListOrdersRequest) (*pb.ListOrdersResponse, error) {
+	who, err := s.identify(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// customers only ever see their own orders
+	var list []*Order
+	if who.staff {
+		list = s.store.List()
+	} else if list, err = s.store.OrdersByCustomer(who.customerID); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &pb.ListOrdersResponse{Orders: make([]*pb.Order, len(list))}
+	for i, o := range list {
+		resp.Orders[i] = orderToPB(o)
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) GetOrder(ctx context.Context, req *pb.GetOrderRequest) (*pb.Order, error) {
+	who, err := s.identify(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// other customers' orders are reported as missing rather than forbidden
+	ord, err := s.store.Get(int(req.Id))
+	if err != nil || !who.canSee(ord) {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	if ord.Status != OrderStatusCancelled {
+		sctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		if err := syncBackorders(sctx, s.inv, s.store, ord); err != nil {
+			log.Printf("backorder sync for order %d: %v", ord.ID, err)
+		}
+		cancel()
+	}
+	return orderToPB(ord), nil
+}
+
+func (s *grpcServer) CreateOrder(ctx context.Context, req *pb.CreateOrderRequest) (*pb.Order, error) {
+	who, err := s.identify(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r := orderRequest{
+		CustomerID:       int(req.CustomerId),
+		Items:            make([]orderLine, len(req.Items)),
+		PromoCode:        req.PromoCode,
+		Region:           req.Region,
+		ShippingMethodID: int(req.ShippingMethodId),
+		AddressID:        int(req.AddressId),
+		PaymentToken:     req.PaymentToken,
+	}
+	for i, l := range req.Items {
+		r.Items[i] = orderLine{ID: int(l.ItemId), Quantity: int(l.Quantity)}
+	}
+	if a := req.ShippingAddress; a != nil {
+		r.ShippingAddress = &Address{Label: a.Label, Line1: a.Line1, Line2: a.Line2, City: a.City,
+			Region: a.Region, PostalCode: a.PostalCode, Country: a.Country}
+	}
+	if err := scopeOrderRequest(s.store, who, &r); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// the order is placed even if the caller goes away, as over REST
+	pctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	ord, code, err := placeOrder(pctx, s.inv, s.store, s.tax, s.pay, r)
+	if err != nil {
+		return nil, grpcError(code, err)
+	}
+	return orderToPB(ord), nil
+}
+
+func (s *grpcServer) CancelOrder(ctx context.Context, req *pb.CancelOrderRequest) (*pb.Order, error) {
+	who, err := s.identify(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ord, err := s.store.Get(int(req.Id)); err == nil && !who.canSee(ord) {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	cctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	ord, code, err := cancelOrder(cctx, s.inv, s.store, s.pay, int(req.Id))
+	if err != nil {
+		return nil, grpcError(code, err)
+	}
+	return orderToPB(ord), nil
+}
+
+func orderToPB(o *Order) *pb.Order {
+	p := &pb.Order{
+		Id:          int64(o.ID),
+		CustomerId:  int64(o.CustomerID),
+		Items:       make([]*pb.OrderItem, len(o.Items)),
+		PromoCode:   o.PromoCode,
+		TaxRegion:   o.TaxRegion,
+		Charges:     make([]*pb.Charge, len(o.Charges)),
+		Tax:         o.Tax,
+		Total:       o.Total,
+		Status:      o.Status,
+		CreatedUnix: o.Created,
+	}
+	for i, l := range o.Items {
+		p.Items[i] = &pb.OrderItem{
+			ItemId:      int64(l.ItemID),
+			Name:        l.Name,
+			Quantity:    int64(l.Quantity),
+			Price:       l.Price,
+			Status:      l.Status,
+			BackorderId: int64(l.BackorderID),
+			ExpectedAt:  l.ExpectedAt,
+			Serials:     l.Serials,
+			Cost:        l.Cost,
+			Lots:        lotsToPB(l.Lots),
+		}
+		for _, c := range l.Components {
+			p.Items[i].Components = append(p.Items[i].Components, &pb.ComponentAllocation{
+				ItemId: int64(c.ItemID), Quantity: int64(c.Quantity), Lots: lotsToPB(c.Lots), Serials: c.Serials, Cost: c.Cost,
+			})
+		}
+		for _, a := range l.Adjustments {
+			p.Items[i].Adjustments = append(p.Items[i].Adjustments, &pb.Adjustment{
+				RuleId: int64(a.RuleID), Name: a.Name, Kind: a.Kind, Amount: a.Amount,
+			})
+		}
+		if t := l.Tax; t != nil {
+			p.Items[i].Tax = &pb.LineTax{Name: t.Name, Rate: t.Rate, Amount: t.Amount, Inclusive: t.Inclusive}
+		}
+	}
+	for i, c := range o.Charges {
+		p.Charges[i] = &pb.Charge{Kind: c.Kind, Name: c.Name, Amount: c.Amount}
+	}
+	if a := o.ShippingAddress; a != nil {
+		p.ShippingAddress = &pb.Address{Label: a.Label, Line1: a.Line1, Line2: a.Line2, City: a.City,
+			Region: a.Region, PostalCode: a.PostalCode, Country: a.Country}
+	}
+	for _, r := range o.Refunds {
+		p.Refunds = append(p.Refunds, &pb.Refund{Id: int64(r.ID), ReturnId: int64(r.ReturnID), Amount: r.Amount, CreatedUnix: r.Created})
+	}
+	if pay := o.Payment; pay != nil {
+		p.Payment = &pb.Payment{Provider: pay.Provider, Reference: pay.Reference, Status: pay.Status, Amount: pay.Amount,
+			Captured: pay.Captured, Refunded: pay.Refunded, Message: pay.Message, UpdatedUnix: pay.Updated}
+	}
+	return p
+}
+
+func lotsToPB(lots []LotAllocation) []*pb.LotAllocation {
+	var out []*pb.LotAllocation
+	for _, l := range lots {
+		out = append(out, &pb.LotAllocation{LotId: int64(l.LotID), LotNumber: l.LotNumber, ExpiresAt: l.ExpiresAt, Quantity: int64(l.Quantity)})
+	}
+	return out
+}
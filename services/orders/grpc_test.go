@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "orders/orderspb"
+)
+
+func TestGRPCRequiresToken(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	srv := NewGRPCServer(nil, nil, "secret", nil, nil)
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := pb.NewOrdersServiceClient(conn)
+
+	if _, err := c.ListOrders(context.Background(), &pb.ListOrdersRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("want unauthenticated, got %v", err)
+	}
+	if _, err := c.CancelOrder(context.Background(), &pb.CancelOrderRequest{Id: 1}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("want unauthenticated, got %v", err)
+	}
+}
+
+func TestGRPCCreateOrderChecksLines(t *testing.T) {
+	// lines are checked before the store or inventory are touched
+	s := &grpcServer{adminToken: "secret"}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer secret"))
+	for _, items := range [][]*pb.OrderLine{
+		nil,
+		{{ItemId: 1, Quantity: -3}},
+		{{ItemId: 0, Quantity: 1}},
+	} {
+		if _, err := s.CreateOrder(ctx, &pb.CreateOrderRequest{Items: items}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%v: want invalid argument, got %v", items, err)
+		}
+	}
+}
+
+func TestGRPCError(t *testing.T) {
+	cases := map[int]codes.Code{
+		http.StatusBadRequest:          codes.InvalidArgument,
+		http.StatusNotFound:            codes.NotFound,
+		http.StatusConflict:            codes.FailedPrecondition,
+		http.StatusServiceUnavailable:  codes.Unavailable,
+		http.StatusInternalServerError: codes.Internal,
+	}
+	for code, want := range cases {
+		if got := status.Code(grpcError(code, ErrInventoryUnavailable)); got != want {
+			t.Errorf("%d: got %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestOrderToPB(t *testing.T) {
+	o := &Order{ID: 7, CustomerID: 3, Status: OrderStatusCreated, Total: 24, Created: 100,
+		Items: []OrderItem{
+			{ItemID: 1, Name: "Шарф", Quantity: 2, Price: 10, Status: LineReserved, Serials: []string{"A1", "A2"},
+				Lots:        []LotAllocation{{LotID: 4, LotNumber: "L4", Quantity: 2}},
+				Adjustments: []Adjustment{{RuleID: 5, Name: "Sale", Kind: RulePercentage, Amount: -2}},
+				Tax:         &LineTax{Name: "VAT", Rate: 20, Amount: 3}},
+			{ItemID: 2, Name: "Кепка", Quantity: 1, Price: 4, Status: LineBackordered, BackorderID: 9, ExpectedAt: "2024-05-01"},
+		},
+		Charges:         []Charge{{Kind: "shipping", Name: "Standard", Amount: 4}},
+		ShippingAddress: &Address{Line1: "Main 1", City: "Riga", PostalCode: "LV-1010", Country: "LV"},
+		Refunds:         []Refund{{ID: 2, ReturnID: 6, Amount: 10, Created: 200}},
+		Payment:         &Payment{Provider: "stub", Reference: "p1", Status: PaymentCaptured, Amount: 24, Captured: 24},
+	}
+	p := orderToPB(o)
+	if p.Id != 7 || p.CustomerId != 3 || p.Total != 24 || p.CreatedUnix != 100 || len(p.Items) != 2 || len(p.Charges) != 1 {
+		t.Fatalf("unexpected order %+v", p)
+	}
+	if l := p.Items[1]; l.BackorderId != 9 || l.ExpectedAt != "2024-05-01" || l.Status != LineBackordered {
+		t.Fatalf("unexpected line %+v", l)
+	}
+	if len(p.Items[0].Serials) != 2 || p.Charges[0].Amount != 4 {
+		t.Fatalf("unexpected order %+v", p)
+	}
+	if l := p.Items[0]; len(l.Lots) != 1 || l.Lots[0].LotNumber != "L4" || len(l.Adjustments) != 1 || l.Adjustments[0].RuleId != 5 || l.Tax.Amount != 3 {
+		t.Fatalf("unexpected line %+v", l)
+	}
+	if p.ShippingAddress.City != "Riga" || len(p.Refunds) != 1 || p.Refunds[0].ReturnId != 6 || p.Payment.Captured != 24 {
+		t.Fatalf("unexpected order %+v", p)
+	}
+}
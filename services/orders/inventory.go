@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"inventoryshop/pkg/inventory"
+)
+
+// ErrInventoryUnavailable is reported, as 503, when calls to inventory are not
+// made because it is failing or already has as many calls as it may take
+var ErrInventoryUnavailable = errors.New("inventory unavailable, try again later")
+
+// newInventoryClient returns the client orders calls inventory through. Reads are
+// retried with jittered backoff, every call goes through one circuit breaker and
+// the number of calls in flight is limited. Settings come from the environment:
+//
+//	INVENTORY_GRPC_ADDR         call inventory's gRPC API at this address, e.g. inventory:9001, instead of REST
+//	INVENTORY_TIMEOUT           how long a REST call may take (5s)
+//	INVENTORY_RETRIES           attempts per read (3)
+//	INVENTORY_RETRY_BASE        first backoff (100ms), doubling up to INVENTORY_RETRY_MAX (1s)
+//	INVENTORY_BREAKER_FAILURES  consecutive failures that open the breaker (5)
+//	INVENTORY_BREAKER_OPEN_FOR  how long it stays open before a trial call (30s)
+//	INVENTORY_MAX_CONCURRENT    calls in flight (32), each waiting up to INVENTORY_QUEUE_WAIT (200ms) for a slot
+func newInventoryClient(url string) (*inventory.Client, error) {
+	opts := []inventory.Option{
+		inventory.WithHTTPClient(&http.Client{Timeout: envDuration("INVENTORY_TIMEOUT", 5*time.Second)}),
+		inventory.WithRetry(inventory.RetryPolicy{
+			MaxAttempts: envInt("INVENTORY_RETRIES", 3),
+			BaseDelay:   envDuration("INVENTORY_RETRY_BASE", 100*time.Millisecond),
+			MaxDelay:    envDuration("INVENTORY_RETRY_MAX", time.Second),
+		}),
+		inventory.WithBreaker(inventory.NewBreaker(inventory.BreakerConfig{
+			Failures: envInt("INVENTORY_BREAKER_FAILURES", 5),
+			OpenFor:  envDuration("INVENTORY_BREAKER_OPEN_FOR", 30*time.Second),
+		})),
+		inventory.WithBulkhead(envInt("INVENTORY_MAX_CONCURRENT", 32), envDuration("INVENTORY_QUEUE_WAIT", 200*time.Millisecond)),
+	}
+	addr := os.Getenv("INVENTORY_GRPC_ADDR")
+	if addr == "" {
+		return inventory.New(url, opts...), nil
+	}
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return inventory.NewGRPC(addr, conn, opts...), nil
+}
+
+func envInt(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+// inventoryError is the status and error to report for a failed call to
+// inventory: 503 if it was not made, otherwise 502 with msg
+func inventoryError(err error, msg string) (int, error) {
+	if inventory.Unavailable(err) {
+		return http.StatusServiceUnavailable, ErrInventoryUnavailable
+	}
+	return http.StatusBadGateway, errors.New(msg)
+}
+
+// writeInventoryError responds to a failed call to inventory
+func writeInventoryError(w http.ResponseWriter, err error) {
+	code, err := inventoryError(err, "failed to reach inventory")
+	if code == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "5")
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// statusHandler serves /status: the state of the calls to inventory, with the
+// circuit breaker and the calls in flight
+func statusHandler(inv *inventory.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		st := inv.Status()
+		code := http.StatusOK
+		if st.Breaker != nil && st.Breaker.State == inventory.BreakerOpen {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, map[string]interface{}{"inventory": st})
+	}
+}
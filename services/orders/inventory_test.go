@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"inventoryshop/pkg/inventory"
+)
+
+func TestInventoryBreaker(t *testing.T) {
+	calls := 0
+	inv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer inv.Close()
+	client := inventory.New(inv.URL, inventory.WithBreaker(inventory.NewBreaker(inventory.BreakerConfig{Failures: 1, OpenFor: time.Minute})))
+	ctx := context.Background()
+
+	_, err := client.GetItem(ctx, 1)
+	if code, _ := inventoryError(err, "failed to reach inventory"); code != http.StatusBadGateway {
+		t.Fatalf("want 502 while inventory fails, got %d", code)
+	}
+	_, err = client.GetItem(ctx, 1)
+	code, err := inventoryError(err, "failed to reach inventory")
+	if code != http.StatusServiceUnavailable || !errors.Is(err, ErrInventoryUnavailable) || calls != 1 {
+		t.Fatalf("want 503 without calling inventory, got %d %v after %d calls", code, err, calls)
+	}
+
+	rec := httptest.NewRecorder()
+	statusHandler(client)(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var body struct {
+		Inventory inventory.Status `json:"inventory"`
+	}
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusServiceUnavailable || body.Inventory.Breaker == nil || body.Inventory.Breaker.State != inventory.BreakerOpen {
+		t.Fatalf("status %d %+v", rec.Code, body.Inventory)
+	}
+
+	rec = httptest.NewRecorder()
+	writeInventoryError(rec, inventory.ErrBulkheadFull)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("want 503 with Retry-After for a full bulkhead, got %d", rec.Code)
+	}
+}
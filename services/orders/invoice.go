@@ -0,0 +1,379 @@
+package main
+
+import (
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// DejaVu covers Cyrillic, which the PDF core fonts do not
+var (
+	//go:embed fonts/DejaVuSansCondensed.ttf
+	invoiceFont []byte
+	//go:embed fonts/DejaVuSansCondensed-Bold.ttf
+	invoiceFontBold []byte
+)
+
+var (
+	ErrInvalidSeller  = errors.New("invalid seller details")
+	ErrNotInvoiceable = errors.New("order cannot be invoiced in its current status")
+)
+
+// Seller is who issues the invoices, read from the file named by ORDERS_SELLER
+type Seller struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// TaxID is the ИНН, KPP the КПП of Russian companies
+	TaxID   string `json:"tax_id"`
+	KPP     string `json:"kpp,omitempty"`
+	Bank    string `json:"bank,omitempty"`
+	Account string `json:"account,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Phone   string `json:"phone,omitempty"`
+	// InvoicePrefix goes before invoice numbers, e.g. "INV-"
+	InvoicePrefix string `json:"invoice_prefix,omitempty"`
+}
+
+// loadSeller reads seller details from a JSON file
+func loadSeller(path string) (*Seller, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Seller
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSeller, err)
+	}
+	if strings.TrimSpace(s.Name) == "" || strings.TrimSpace(s.TaxID) == "" {
+		return nil, fmt.Errorf("%w: name and tax_id are required", ErrInvalidSeller)
+	}
+	return &s, nil
+}
+
+// Invoice is an order's invoice. Numbers run in sequence without gaps, and an
+// order keeps the number it was first issued.
+type Invoice struct {
+	Number string
+	Issued int64
+	Seller Seller
+	Buyer  *Customer
+	Order  *Order
+}
+
+// invoiceRow is a line of an invoice's table, goods or a charge
+type invoiceRow struct {
+	No       int
+	Name     string
+	Quantity int
+	Price    float64
+	Discount float64
+	Amount   float64
+	TaxRate  string
+	Tax      float64
+}
+
+type invoiceTotals struct {
+	Discount    float64
+	Tax         float64
+	TaxIncluded bool
+	Total       float64
+	Refunded    float64
+}
+
+// rows lays out the order's lines, after discounts, then its charges
+func (inv *Invoice) rows() ([]invoiceRow, invoiceTotals) {
+	o := inv.Order
+	rows := make([]invoiceRow, 0, len(o.Items)+len(o.Charges))
+	totals := invoiceTotals{Tax: o.Tax, Total: o.Total}
+	taxOf := func(t *LineTax) (string, float64) {
+		if t == nil {
+			return "без НДС", 0
+		}
+		totals.TaxIncluded = totals.TaxIncluded || t.Inclusive
+		return fmt.Sprintf("%g%%", t.Rate), t.Amount
+	}
+	for _, it := range o.Items {
+		row := invoiceRow{No: len(rows) + 1, Name: it.Name, Quantity: it.Quantity, Price: it.Price, Amount: it.lineTotal()}
+		for _, a := range it.Adjustments {
+			row.Discount -= a.Amount
+		}
+		row.Discount = roundCents(row.Discount)
+		totals.Discount += row.Discount
+		row.TaxRate, row.Tax = taxOf(it.Tax)
+		rows = append(rows, row)
+	}
+	for _, c := range o.Charges {
+		row := invoiceRow{No: len(rows) + 1, Name: "Доставка: " + c.Name, Quantity: 1, Price: c.Amount, Amount: c.Amount}
+		row.TaxRate, row.Tax = taxOf(c.Tax)
+		rows = append(rows, row)
+	}
+	for _, f := range o.Refunds {
+		totals.Refunded += f.Amount
+	}
+	totals.Discount, totals.Refunded = roundCents(totals.Discount), roundCents(totals.Refunded)
+	return rows, totals
+}
+
+func (inv *Invoice) date() string {
+	return time.Unix(inv.Issued, 0).UTC().Format("02.01.2006")
+}
+
+func (inv *Invoice) buyerLines() []string {
+	var lines []string
+	if b := inv.Buyer; b != nil {
+		lines = append(lines, b.Name, b.Email)
+	}
+	if a := inv.Order.ShippingAddress; a != nil {
+		lines = append(lines, formatAddress(*a))
+	}
+	return lines
+}
+
+func (s Seller) lines() []string {
+	lines := []string{s.Name}
+	tax := "ИНН " + s.TaxID
+	if s.KPP != "" {
+		tax += ", КПП " + s.KPP
+	}
+	lines = append(lines, tax)
+	for _, l := range []string{s.Address, s.Bank, s.Account, s.Email, s.Phone} {
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+func formatAddress(a Address) string {
+	parts := []string{a.PostalCode, a.Country, a.Region, a.City, a.Line1, a.Line2}
+	nonEmpty := parts[:0]
+	for _, p := range parts {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	return strings.Join(nonEmpty, ", ")
+}
+
+func money(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
+
+var invoiceTemplate = template.Must(template.New("invoice").Funcs(template.FuncMap{"money": money}).Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<title>Счёт № {{.Inv.Number}}</title>
+<style>
+body { font-family: "DejaVu Sans", Arial, sans-serif; font-size: 13px; margin: 32px; }
+table { border-collapse: collapse; width: 100%; margin: 16px 0; }
+th, td { border: 1px solid #999; padding: 4px 6px; }
+td.n { text-align: right; white-space: nowrap; }
+.parties { display: flex; gap: 48px; }
+.totals td { border: none; }
+</style>
+</head>
+<body>
+<h1>Счёт № {{.Inv.Number}} от {{.Inv.Date}}</h1>
+<p>Заказ № {{.Inv.Order.ID}}</p>
+<div class="parties">
+<div><strong>Продавец</strong>{{range .Seller}}<br>{{.}}{{end}}</div>
+<div><strong>Покупатель</strong>{{range .Buyer}}<br>{{.}}{{end}}</div>
+</div>
+<table>
+<tr><th>№</th><th>Наименование</th><th>Кол-во</th><th>Цена</th><th>Скидка</th><th>Сумма</th><th>Ставка НДС</th><th>НДС</th></tr>
+{{range .Rows}}<tr><td class="n">{{.No}}</td><td>{{.Name}}</td><td class="n">{{.Quantity}}</td><td class="n">{{money .Price}}</td><td class="n">{{money .Discount}}</td><td class="n">{{money .Amount}}</td><td class="n">{{.TaxRate}}</td><td class="n">{{money .Tax}}</td></tr>
+{{end}}</table>
+<table class="totals">
+{{if .Totals.Discount}}<tr><td class="n">Скидка:</td><td class="n">{{money .Totals.Discount}}</td></tr>{{end}}
+<tr><td class="n">{{if .Totals.TaxIncluded}}В том числе НДС:{{else}}НДС:{{end}}</td><td class="n">{{money .Totals.Tax}}</td></tr>
+<tr><td class="n"><strong>Итого к оплате:</strong></td><td class="n"><strong>{{money .Totals.Total}}</strong></td></tr>
+{{if .Totals.Refunded}}<tr><td class="n">Возвращено:</td><td class="n">{{money .Totals.Refunded}}</td></tr>{{end}}
+</table>
+</body>
+</html>
+`))
+
+// renderInvoiceHTML writes a printable HTML invoice
+func renderInvoiceHTML(w io.Writer, inv *Invoice) error {
+	rows, totals := inv.rows()
+	return invoiceTemplate.Execute(w, map[string]interface{}{
+		"Inv": struct {
+			Number, Date string
+			Order        *Order
+		}{inv.Number, inv.date(), inv.Order},
+		"Seller": inv.Seller.lines(),
+		"Buyer":  inv.buyerLines(),
+		"Rows":   rows,
+		"Totals": totals,
+	})
+}
+
+// renderInvoicePDF writes an A4 PDF invoice
+func renderInvoicePDF(w io.Writer, inv *Invoice) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.AddUTF8FontFromBytes("DejaVu", "", invoiceFont)
+	pdf.AddUTF8FontFromBytes("DejaVu", "B", invoiceFontBold)
+	pdf.SetTitle("Счёт № "+inv.Number, true)
+	pdf.SetCreationDate(time.Unix(inv.Issued, 0))
+	pdf.SetMargins(10, 12, 10)
+	pdf.SetAutoPageBreak(true, 12)
+	pdf.AddPage()
+
+	pdf.SetFont("DejaVu", "B", 15)
+	pdf.CellFormat(0, 8, fmt.Sprintf("Счёт № %s от %s", inv.Number, inv.date()), "", 1, "L", false, 0, "")
+	pdf.SetFont("DejaVu", "", 9)
+	pdf.CellFormat(0, 5, fmt.Sprintf("Заказ № %d", inv.Order.ID), "", 1, "L", false, 0, "")
+	pdf.Ln(3)
+
+	// seller and buyer side by side
+	top := pdf.GetY()
+	party := func(x float64, title string, lines []string) float64 {
+		pdf.SetXY(x, top)
+		pdf.SetFont("DejaVu", "B", 10)
+		pdf.CellFormat(90, 5, title, "", 2, "L", false, 0, "")
+		pdf.SetFont("DejaVu", "", 9)
+		for _, l := range lines {
+			pdf.MultiCell(90, 4.5, l, "", "L", false)
+			pdf.SetX(x)
+		}
+		return pdf.GetY()
+	}
+	bottom := party(10, "Продавец", inv.Seller.lines())
+	if y := party(110, "Покупатель", inv.buyerLines()); y > bottom {
+		bottom = y
+	}
+	pdf.SetXY(10, bottom+4)
+
+	widths := []float64{8, 68, 14, 20, 18, 22, 18, 22}
+	headers := []string{"№", "Наименование", "Кол-во", "Цена", "Скидка", "Сумма", "Ставка", "НДС"}
+	pdf.SetFont("DejaVu", "B", 8)
+	pdf.SetFillColor(235, 235, 235)
+	for i, h := range headers {
+		pdf.CellFormat(widths[i], 6, h, "1", 0, "C", true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	rows, totals := inv.rows()
+	pdf.SetFont("DejaVu", "", 8)
+	const lineH = 4.5
+	for _, r := range rows {
+		name := pdf.SplitText(r.Name, widths[1]-2)
+		h := lineH * float64(len(name))
+		if pdf.GetY()+h > 285 {
+			pdf.AddPage()
+		}
+		x, y := pdf.GetXY()
+		cells := []string{fmt.Sprint(r.No), "", fmt.Sprint(r.Quantity), money(r.Price), money(r.Discount), money(r.Amount), r.TaxRate, money(r.Tax)}
+		for i, c := range cells {
+			align := "R"
+			if i == 1 {
+				pdf.Rect(x, y, widths[i], h, "D")
+				pdf.SetXY(x, y)
+				pdf.MultiCell(widths[i], lineH, strings.Join(name, "\n"), "", "L", false)
+				pdf.SetXY(x+widths[i], y)
+			} else {
+				pdf.CellFormat(widths[i], h, c, "1", 0, align, false, 0, "")
+			}
+			x += widths[i]
+		}
+		pdf.SetXY(10, y+h)
+	}
+
+	pdf.Ln(3)
+	total := func(label, value string, bold bool) {
+		style := ""
+		if bold {
+			style = "B"
+		}
+		pdf.SetFont("DejaVu", style, 9)
+		pdf.CellFormat(150, 5, label, "", 0, "R", false, 0, "")
+		pdf.CellFormat(40, 5, value, "", 1, "R", false, 0, "")
+	}
+	if totals.Discount != 0 {
+		total("Скидка:", money(totals.Discount), false)
+	}
+	if totals.TaxIncluded {
+		total("В том числе НДС:", money(totals.Tax), false)
+	} else {
+		total("НДС:", money(totals.Tax), false)
+	}
+	total("Итого к оплате:", money(totals.Total), true)
+	if totals.Refunded != 0 {
+		total("Возвращено:", money(totals.Refunded), false)
+	}
+	return pdf.Output(w)
+}
+
+// IssueInvoice gives an order its invoice number, the next in sequence the
+// first time it is asked for and the same one after that, whatever the order's
+// status. Only created orders are given a new number; others fail with
+// ErrNotInvoiceable.
+func (s *OrderStore) IssueInvoice(orderID int) (int, int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	var number int
+	var issued int64
+	err = tx.QueryRow("SELECT number, issued_unix FROM invoices WHERE order_id=$1", orderID).Scan(&number, &issued)
+	if err == nil {
+		return number, issued, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, err
+	}
+	// the order row is locked so it cannot be cancelled while its number is issued
+	var status string
+	err = tx.QueryRow("SELECT status FROM orders WHERE id=$1 FOR SHARE", orderID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if status != OrderStatusCreated {
+		return 0, 0, ErrNotInvoiceable
+	}
+	// the counter row lock keeps numbers gapless where a sequence would not
+	if err := tx.QueryRow("UPDATE invoice_counter SET last=last+1 WHERE id=1 RETURNING last").Scan(&number); err != nil {
+		return 0, 0, err
+	}
+	issued = nowUnix()
+	res, err := tx.Exec("INSERT INTO invoices (order_id, number, issued_unix) VALUES ($1,$2,$3) ON CONFLICT (order_id) DO NOTHING", orderID, number, issued)
+	if err != nil {
+		return 0, 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// issued concurrently; the rollback gives the number back
+		tx.Rollback()
+		return s.IssueInvoice(orderID)
+	}
+	return number, issued, tx.Commit()
+}
+
+// orderInvoice builds the invoice of an order that has been placed and paid
+// for, or that was invoiced before whatever has happened to it since
+func orderInvoice(store *OrderStore, seller *Seller, ord *Order) (*Invoice, error) {
+	number, issued, err := store.IssueInvoice(ord.ID)
+	if err != nil {
+		return nil, err
+	}
+	inv := &Invoice{Number: fmt.Sprintf("%s%06d", seller.InvoicePrefix, number), Issued: issued, Seller: *seller, Order: ord}
+	if ord.CustomerID != 0 {
+		if c, err := store.GetCustomer(ord.CustomerID); err == nil {
+			inv.Buyer = c
+		}
+	}
+	return inv, nil
+}
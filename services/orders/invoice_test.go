@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testInvoice() *Invoice {
+	return &Invoice{
+		Number: "INV-000007",
+		Issued: 1700000000,
+		Seller: Seller{Name: "ООО «Ромашка»", TaxID: "7701234567", KPP: "770101001", Address: "Москва, ул. Ленина, 1"},
+		Buyer:  &Customer{Name: "Иван Петров", Email: "ivan@example.ru"},
+		Order: &Order{ID: 42, Status: OrderStatusCreated, Tax: 20, Total: 130, Items: []OrderItem{
+			{ItemID: 1, Name: "Чайник электрический с очень длинным названием, которое не помещается в одну строку таблицы", Quantity: 2, Price: 60,
+				Adjustments: []Adjustment{{RuleID: 1, Name: "Скидка", Amount: -10}}, Tax: &LineTax{Rate: 20, Amount: 18.33, Inclusive: true}},
+		}, Charges: []Charge{{Kind: ChargeShipping, Name: "Почта России", Amount: 20, Tax: &LineTax{Rate: 20, Amount: 3.33, Inclusive: true}}}},
+	}
+}
+
+func TestInvoice_Rows(t *testing.T) {
+	rows, totals := testInvoice().rows()
+	if len(rows) != 2 || rows[0].Amount != 110 || rows[0].Discount != 10 || rows[1].Name != "Доставка: Почта России" {
+		t.Fatalf("unexpected rows %+v", rows)
+	}
+	if !totals.TaxIncluded || totals.Discount != 10 || totals.Total != 130 {
+		t.Fatalf("unexpected totals %+v", totals)
+	}
+}
+
+func TestRenderInvoice(t *testing.T) {
+	var html bytes.Buffer
+	if err := renderInvoiceHTML(&html, testInvoice()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"Счёт № INV-000007 от 14.11.2023", "ООО «Ромашка»", "ИНН 7701234567, КПП 770101001", "Иван Петров", "В том числе НДС:", "130.00"} {
+		if !strings.Contains(html.String(), want) {
+			t.Fatalf("expected HTML invoice to contain %q", want)
+		}
+	}
+
+	var pdf bytes.Buffer
+	if err := renderInvoicePDF(&pdf, testInvoice()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Cyrillic needs the embedded TrueType font and its unicode map
+	for _, want := range []string{"%PDF-", "/FontFile2", "/ToUnicode"} {
+		if !bytes.Contains(pdf.Bytes(), []byte(want)) {
+			t.Fatalf("expected PDF invoice to contain %q", want)
+		}
+	}
+}
+
+func TestIssueInvoice(t *testing.T) {
+	s := NewOrderStoreInMemory()
+	first := s.Create([]OrderItem{{ItemID: 1, Quantity: 1, Price: 5}}, 5)
+	second := s.Create([]OrderItem{{ItemID: 1, Quantity: 1, Price: 5}}, 5)
+	never := s.Create([]OrderItem{{ItemID: 1, Quantity: 1, Price: 5}}, 5)
+	a, _, _ := s.IssueInvoice(first.ID)
+	b, _, _ := s.IssueInvoice(second.ID)
+	again, _, _ := s.IssueInvoice(first.ID)
+	if a != 1 || b != 2 || again != 1 {
+		t.Fatalf("expected sequential numbers kept per order, got %d %d %d", a, b, again)
+	}
+
+	// a cancelled order keeps its number but is not given a new one
+	s.SetStatus(first.ID, OrderStatusCreated, OrderStatusCancelled)
+	s.SetStatus(never.ID, OrderStatusCreated, OrderStatusCancelled)
+	if n, _, err := s.IssueInvoice(first.ID); err != nil || n != 1 {
+		t.Fatalf("expected the cancelled order's invoice again, got %d %v", n, err)
+	}
+	if _, _, err := s.IssueInvoice(never.ID); err != ErrNotInvoiceable {
+		t.Fatalf("expected ErrNotInvoiceable, got %v", err)
+	}
+}
+
+func TestLoadSeller(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "seller.json")
+	os.WriteFile(path, []byte(`{"name": "ООО «Ромашка»"}`), 0o644)
+	if _, err := loadSeller(path); err == nil {
+		t.Fatal("expected seller without tax id to be refused")
+	}
+	os.WriteFile(path, []byte(`{"name": "ООО «Ромашка»", "tax_id": "7701234567", "invoice_prefix": "INV-"}`), 0o644)
+	if s, err := loadSeller(path); err != nil || s.InvoicePrefix != "INV-" {
+		t.Fatalf("unexpected seller %+v %v", s, err)
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"time"
 
 	_ "github.com/lib/pq"
+
+	"inventoryshop/pkg/events"
 )
 
 func main() {
@@ -14,6 +19,10 @@ func main() {
 	if p := os.Getenv("ORDERS_PORT"); p != "" {
 		port = p
 	}
+	grpcPort := "9002"
+	if p := os.Getenv("ORDERS_GRPC_PORT"); p != "" {
+		grpcPort = p
+	}
 	invURL := "http://inventory:8001"
 	if u := os.Getenv("INVENTORY_URL"); u != "" {
 		invURL = u
@@ -33,7 +42,67 @@ func main() {
 	}
 
 	store := NewOrderStore(db)
-	router := NewRouter(store, invURL)
-	log.Printf("Orders service listening on :%s (inventory: %s)", port, invURL)
+	inv, err := newInventoryClient(invURL)
+	if err != nil {
+		log.Fatalf("failed to set up inventory client: %v", err)
+	}
+	// pick up backorders fulfilled in inventory
+	go watchBackorders(context.Background(), inv, store, time.Minute)
+	// staff authenticate with the admin token; without one nobody is staff
+	adminToken := os.Getenv("ORDERS_ADMIN_TOKEN")
+	if adminToken == "" {
+		log.Printf("WARNING: ORDERS_ADMIN_TOKEN is not set, staff endpoints are unreachable until it is")
+	}
+	// tax rules, e.g. tax_rules.json; without them no tax is charged
+	var tax *TaxConfig
+	if path := os.Getenv("ORDERS_TAX_RULES"); path != "" {
+		if tax, err = loadTaxConfig(path); err != nil {
+			log.Fatalf("failed to load tax rules: %v", err)
+		}
+	}
+	// payments; without a provider orders are placed without taking payment
+	var pay PaymentProvider
+	switch provider := os.Getenv("ORDERS_PAYMENT_PROVIDER"); provider {
+	case "":
+	case "fake":
+		fake := NewFakeProvider("http://localhost:"+port+"/payments/webhook", os.Getenv("ORDERS_PAYMENT_WEBHOOK_SECRET"))
+		if d, err := time.ParseDuration(os.Getenv("ORDERS_PAYMENT_FAKE_LATENCY")); err == nil {
+			fake.Latency = d
+		}
+		pay = fake
+	default:
+		log.Fatalf("unknown payment provider %q", provider)
+	}
+	// seller details for invoices, e.g. seller.json; without them no invoices are issued
+	var seller *Seller
+	if path := os.Getenv("ORDERS_SELLER"); path != "" {
+		if seller, err = loadSeller(path); err != nil {
+			log.Fatalf("failed to load seller details: %v", err)
+		}
+	}
+	// domain events go from the outbox to webhook subscribers and, if
+	// ORDERS_EVENTS_FILE is set, to a JSON lines file; once published, to the
+	// in-process bus
+	bus := events.NewBus()
+	hooks := events.NewDispatcher(store)
+	sink := events.MultiSink{hooks}
+	if path := os.Getenv("ORDERS_EVENTS_FILE"); path != "" {
+		sink = append(sink, events.NewFileSink(path))
+	}
+	relay := events.NewRelay(store, sink)
+	relay.Live = bus
+	go relay.Run(context.Background())
+	go hooks.Run(context.Background())
+	// the gRPC API serves the same store next to REST
+	lis, err := net.Listen("tcp", ":"+grpcPort)
+	if err != nil {
+		log.Fatalf("failed to listen on :%s: %v", grpcPort, err)
+	}
+	go func() {
+		log.Printf("Orders gRPC listening on :%s", grpcPort)
+		log.Fatal(NewGRPCServer(store, inv, adminToken, tax, pay).Serve(lis))
+	}()
+	router := NewRouter(store, inv, adminToken, tax, pay, seller, bus)
+	log.Printf("Orders service listening on :%s (inventory: %s via %s)", port, inv.BaseURL(), inv.Status().Transport)
 	log.Fatal(http.ListenAndServe(":"+port, router))
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"inventoryshop/pkg/events"
+)
+
+func TestOpenAPI_Served(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewRouter(nil, nil, "", nil, nil, nil, events.NewBus()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	var doc struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("get document: %d %v", rec.Code, err)
+	}
+	for _, p := range []string{"/orders", "/carts/{id}/lines/{item_id}", "/returns/{id}/approve", "/replenishment/suggestions"} {
+		if _, ok := doc.Paths[p]; !ok {
+			t.Errorf("%s not described", p)
+		}
+	}
+}
+
+func TestOpenAPI_ValidatesRequests(t *testing.T) {
+	// invalid requests never reach the handlers, so no store is needed
+	router := NewRouter(nil, nil, "", nil, nil, nil, events.NewBus())
+	cases := []struct {
+		method, target, body string
+		fields               []string
+	}{
+		{http.MethodPost, "/orders", `{"items": [{"id": 1, "quantity": 0}, {"quantity": 1}]}`,
+			[]string{"items[0].quantity", "items[1].id"}},
+		{http.MethodPost, "/orders", `{"items": [], "shipping_address": {"line1": "Тверская, 1"}}`,
+			[]string{"items", "shipping_address.city", "shipping_address.postal_code", "shipping_address.country"}},
+		{http.MethodPost, "/carts/abc/lines", `{"item_id": "1", "quantity": 1}`, []string{"item_id"}},
+		{http.MethodPut, "/carts/abc/lines/x", `{"quantity": -1}`, []string{"item_id", "quantity"}},
+		{http.MethodPost, "/customers", `{"name": "Анна", "email": "anna"}`, []string{"email"}},
+		{http.MethodPost, "/orders/1/returns", `{"lines": [{"item_id": 1, "quantity": 1, "reason": "changed_mind"}]}`,
+			[]string{"lines[0].reason"}},
+		{http.MethodPost, "/pricing/rules", `{"name": "Sale", "kind": "percentage", "percent": 150}`, []string{"percent"}},
+		{http.MethodGet, "/replenishment/suggestions?window_days=0", "", []string{"window_days"}},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(c.method, c.target, strings.NewReader(c.body)))
+		var res struct {
+			Error   string `json:"error"`
+			Details []struct {
+				Field string `json:"field"`
+			} `json:"details"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil || rec.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: want 400, got %d %v", c.method, c.target, rec.Code, err)
+			continue
+		}
+		var fields []string
+		for _, d := range res.Details {
+			fields = append(fields, d.Field)
+		}
+		if strings.Join(fields, " ") != strings.Join(c.fields, " ") {
+			t.Errorf("%s %s: want errors for %v, got %v", c.method, c.target, c.fields, fields)
+		}
+	}
+}
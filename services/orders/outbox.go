@@ -0,0 +1,71 @@
+package main
+
+import (
+	"inventoryshop/pkg/events"
+)
+
+// Domain event types written to the outbox
+const (
+	EventOrderCreated   = "OrderCreated"
+	EventOrderCancelled = "OrderCancelled"
+	// EventOrderStatusChanged is written on every status change, along with
+	// OrderCreated or OrderCancelled where those apply
+	EventOrderStatusChanged = "OrderStatusChanged"
+)
+
+// eventTypes are all the event types this service publishes
+var eventTypes = []string{EventOrderCreated, EventOrderCancelled, EventOrderStatusChanged}
+
+// eventSource names this service in published events
+const eventSource = "orders"
+
+// OrderEvent is the payload of order events
+type OrderEvent struct {
+	OrderID    int    `json:"order_id"`
+	CustomerID int    `json:"customer_id,omitempty"`
+	Status     string `json:"status"`
+	// PreviousStatus is set on OrderStatusChanged
+	PreviousStatus string           `json:"previous_status,omitempty"`
+	Total          float64          `json:"total"`
+	Items          []OrderEventLine `json:"items"`
+}
+
+// OrderEventLine is an item and quantity of an order event
+type OrderEventLine struct {
+	ItemID   int `json:"item_id"`
+	Quantity int `json:"quantity"`
+}
+
+func orderEvent(o *Order) OrderEvent {
+	e := OrderEvent{OrderID: o.ID, CustomerID: o.CustomerID, Status: o.Status, Total: o.Total, Items: make([]OrderEventLine, len(o.Items))}
+	for i, it := range o.Items {
+		e.Items[i] = OrderEventLine{ItemID: it.ItemID, Quantity: it.Quantity}
+	}
+	return e
+}
+
+// statusEventType is the type of event, if any, of an order entering a status
+func statusEventType(status string) string {
+	switch status {
+	case OrderStatusCreated:
+		return EventOrderCreated
+	case OrderStatusCancelled:
+		return EventOrderCancelled
+	}
+	return ""
+}
+
+// statusChangeEvents are the events of an order moving to its status from another
+func statusChangeEvents(o *Order, from string) []events.Event {
+	changed := orderEvent(o)
+	changed.PreviousStatus = from
+	list := []events.Event{newEvent(EventOrderStatusChanged, o.ID, changed)}
+	if typ := statusEventType(o.Status); typ != "" {
+		list = append(list, newEvent(typ, o.ID, orderEvent(o)))
+	}
+	return list
+}
+
+func newEvent(typ string, aggregateID int, payload interface{}) events.Event {
+	return events.New(eventSource, typ, aggregateID, payload)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"inventoryshop/pkg/events"
+)
+
+func TestOutbox_OrderEvents(t *testing.T) {
+	s := NewOrderStoreInMemory()
+	placed := s.CreateOrder(Order{CustomerID: 3, Total: 20, Items: []OrderItem{{ItemID: 1, Quantity: 2, Price: 10}}})
+	pending := s.CreateOrder(Order{Status: OrderStatusPendingPayment, Total: 5, Items: []OrderItem{{ItemID: 2, Quantity: 1, Price: 5}}})
+	s.SetStatus(placed.ID, OrderStatusCreated, OrderStatusCancelled)
+
+	bus := events.NewBus()
+	ch, cancel := bus.Subscribe(10)
+	defer cancel()
+	if err := events.NewRelay(s, bus).Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// an order waiting on payment is only created once paid for
+	s.SetStatus(pending.ID, OrderStatusPendingPayment, OrderStatusCreated)
+	if err := events.NewRelay(s, bus).Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []struct {
+		typ   string
+		order int
+	}{{EventOrderCreated, placed.ID}, {EventOrderStatusChanged, placed.ID}, {EventOrderCancelled, placed.ID},
+		{EventOrderStatusChanged, pending.ID}, {EventOrderCreated, pending.ID}}
+	for i, w := range want {
+		e := <-ch
+		var p OrderEvent
+		json.Unmarshal(e.Payload, &p)
+		if e.Type != w.typ || e.AggregateID != w.order || p.OrderID != w.order || len(p.Items) != 1 {
+			t.Fatalf("unexpected event %d: %+v %+v", i, e, p)
+		}
+	}
+	select {
+	case e := <-ch:
+		t.Fatalf("unexpected extra event %+v", e)
+	default:
+	}
+}
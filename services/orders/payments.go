@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"inventoryshop/pkg/inventory"
+)
+
+// Payment statuses, as reported by the provider
+const (
+	PaymentPending    = "pending"
+	PaymentAuthorized = "authorized"
+	PaymentCaptured   = "captured"
+	PaymentVoided     = "voided"
+	PaymentRefunded   = "refunded"
+	PaymentDeclined   = "declined"
+)
+
+var (
+	ErrPaymentDeclined    = errors.New("payment declined")
+	ErrPaymentUnavailable = errors.New("payment provider unavailable")
+	ErrPaymentState       = errors.New("payment does not allow this in its current state")
+	ErrInvalidWebhook     = errors.New("invalid payment webhook")
+)
+
+// PaymentProvider takes payment for orders. A declined payment is a result with
+// status declined, not an error; errors mean the provider could not be reached
+// or the payment's state does not allow the call. A pending result is settled
+// later by a callback to /payments/webhook.
+type PaymentProvider interface {
+	Name() string
+	Authorize(ctx context.Context, orderID int, amount float64, token string) (PaymentResult, error)
+	Capture(ctx context.Context, reference string, amount float64) (PaymentResult, error)
+	Void(ctx context.Context, reference string) (PaymentResult, error)
+	Refund(ctx context.Context, reference string, amount float64) (PaymentResult, error)
+	// ParseWebhook verifies a callback from the provider and decodes it
+	ParseWebhook(r *http.Request) (PaymentResult, error)
+}
+
+// PaymentResult is the state of a payment after a provider call or callback
+type PaymentResult struct {
+	Reference string `json:"reference"`
+	Status    string `json:"status"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Payment is an order's payment with its provider
+type Payment struct {
+	Provider  string  `json:"provider"`
+	Reference string  `json:"reference"`
+	Status    string  `json:"status"`
+	Amount    float64 `json:"amount"`
+	Captured  float64 `json:"captured"`
+	Refunded  float64 `json:"refunded"`
+	Message   string  `json:"message,omitempty"`
+	Updated   int64   `json:"updated_unix"`
+}
+
+// SavePayment stores the payment of an order
+func (s *OrderStore) SavePayment(orderID int, p Payment) error {
+	_, err := s.db.Exec(`INSERT INTO payments (order_id, provider, reference, status, amount, captured, refunded, message, updated_unix)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+	ON CONFLICT (order_id) DO UPDATE SET reference=EXCLUDED.reference, status=EXCLUDED.status, captured=EXCLUDED.captured,
+		refunded=EXCLUDED.refunded, message=EXCLUDED.message, updated_unix=EXCLUDED.updated_unix`,
+		orderID, p.Provider, p.Reference, p.Status, p.Amount, p.Captured, p.Refunded, p.Message, p.Updated)
+	return err
+}
+
+// loadPayment reads the payment of an order, nil if it has none
+func (s *OrderStore) loadPayment(orderID int) (*Payment, error) {
+	var p Payment
+	err := s.db.QueryRow("SELECT provider, reference, status, amount, captured, refunded, message, updated_unix FROM payments WHERE order_id=$1", orderID).
+		Scan(&p.Provider, &p.Reference, &p.Status, &p.Amount, &p.Captured, &p.Refunded, &p.Message, &p.Updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// PaymentOrder finds the order paid for by a provider's payment reference
+func (s *OrderStore) PaymentOrder(reference string) (int, error) {
+	var id int
+	err := s.db.QueryRow("SELECT order_id FROM payments WHERE reference=$1 AND reference<>''", reference).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return id, err
+}
+
+// authorizePayment takes payment for an order just placed in pending_payment
+// and returns it as it stands after: created once authorized, still pending if
+// the provider settles later, or cancelled with its stock given back
+func authorizePayment(ctx context.Context, inv *inventory.Client, store *OrderStore, pay PaymentProvider, ord *Order, token string) (*Order, int, error) {
+	res, err := pay.Authorize(ctx, ord.ID, ord.Total, token)
+	if err != nil {
+		log.Printf("authorize payment for order %d: %v", ord.ID, err)
+		res = PaymentResult{Status: PaymentDeclined, Message: ErrPaymentUnavailable.Error()}
+	}
+	p := Payment{Provider: pay.Name(), Reference: res.Reference, Status: res.Status, Amount: ord.Total, Message: res.Message, Updated: nowUnix()}
+	if err := store.SavePayment(ord.ID, p); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	ord, serr := settlePayment(ctx, inv, store, pay, ord.ID, res)
+	switch {
+	case serr != nil:
+		return nil, http.StatusInternalServerError, serr
+	case err != nil:
+		return nil, http.StatusBadGateway, ErrPaymentUnavailable
+	case res.Status == PaymentDeclined:
+		return nil, http.StatusPaymentRequired, ErrPaymentDeclined
+	}
+	return ord, http.StatusCreated, nil
+}
+
+// settlePayment moves an order waiting on payment on once the provider has
+// decided: authorized orders become created, declined ones are cancelled with
+// their stock and promo code uses given back. An authorization arriving for an
+// order cancelled meanwhile is voided.
+func settlePayment(ctx context.Context, inv *inventory.Client, store *OrderStore, pay PaymentProvider, orderID int, res PaymentResult) (*Order, error) {
+	switch res.Status {
+	case PaymentAuthorized, PaymentCaptured:
+		ord, err := store.SetStatus(orderID, OrderStatusPendingPayment, OrderStatusCreated)
+		if !errors.Is(err, ErrInvalidTransition) {
+			return ord, err
+		}
+		if ord, err = store.Get(orderID); err == nil && ord.Status == OrderStatusCancelled {
+			err = releasePayment(ctx, store, pay, ord)
+		}
+		return ord, err
+	case PaymentDeclined, PaymentVoided:
+		ord, err := store.SetStatus(orderID, OrderStatusPendingPayment, OrderStatusCancelled)
+		if errors.Is(err, ErrInvalidTransition) {
+			return store.Get(orderID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !restockOrder(ctx, inv, store, ord, "payment declined") {
+			log.Printf("order %d cancelled for payment but some items could not be restocked", ord.ID)
+		}
+		if err := store.ReleaseRules(ord.appliedRules()); err != nil {
+			log.Printf("release pricing rules of order %d: %v", ord.ID, err)
+		}
+		return ord, nil
+	}
+	return store.Get(orderID)
+}
+
+// capturePayment collects an authorized payment, less anything refunded before
+func capturePayment(ctx context.Context, store *OrderStore, pay PaymentProvider, ord *Order) error {
+	p := ord.Payment
+	if pay == nil || p == nil || p.Status != PaymentAuthorized {
+		return ErrPaymentState
+	}
+	amount := p.Amount
+	for _, f := range ord.Refunds {
+		amount -= f.Amount
+	}
+	amount = roundCents(amount)
+	if amount < 0 {
+		amount = 0
+	}
+	res, err := pay.Capture(ctx, p.Reference, amount)
+	if err != nil {
+		return err
+	}
+	p.Status, p.Captured, p.Message, p.Updated = res.Status, amount, res.Message, nowUnix()
+	return store.SavePayment(ord.ID, *p)
+}
+
+// paymentStore keeps the payments of orders
+type paymentStore interface {
+	SavePayment(orderID int, p Payment) error
+}
+
+// refundPayment gives back money through the provider. Only captured money is
+// refunded; an uncaptured payment is captured less the order's refunds instead.
+func refundPayment(ctx context.Context, store paymentStore, pay PaymentProvider, ord *Order, amount float64) error {
+	p := ord.Payment
+	if pay == nil || p == nil || p.Status != PaymentCaptured {
+		return nil
+	}
+	if left := roundCents(p.Captured - p.Refunded); amount > left {
+		amount = left
+	}
+	if amount <= 0 {
+		return nil
+	}
+	res, err := pay.Refund(ctx, p.Reference, amount)
+	if err != nil {
+		return err
+	}
+	p.Refunded = roundCents(p.Refunded + amount)
+	p.Status, p.Message, p.Updated = res.Status, res.Message, nowUnix()
+	return store.SavePayment(ord.ID, *p)
+}
+
+// releasePayment voids the payment of a cancelled order, or refunds what was captured
+// and is not refunded yet
+func releasePayment(ctx context.Context, store paymentStore, pay PaymentProvider, ord *Order) error {
+	p := ord.Payment
+	if pay == nil || p == nil {
+		return nil
+	}
+	switch p.Status {
+	case PaymentPending, PaymentAuthorized:
+		res, err := pay.Void(ctx, p.Reference)
+		if err != nil {
+			return err
+		}
+		p.Status, p.Message, p.Updated = res.Status, res.Message, nowUnix()
+		return store.SavePayment(ord.ID, *p)
+	case PaymentCaptured:
+		return refundPayment(ctx, store, pay, ord, p.Captured)
+	}
+	return nil
+}
+
+// paymentsWebhook serves /payments/webhook, where the provider reports the
+// outcome of payments it could not decide at once
+func paymentsWebhook(store *OrderStore, inv *inventory.Client, pay PaymentProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if pay == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		res, err := pay.ParseWebhook(r)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			return
+		}
+		orderID, err := store.PaymentOrder(res.Reference)
+		if err == nil {
+			var ord *Order
+			if ord, err = store.Get(orderID); err == nil && ord.Payment != nil {
+				p := ord.Payment
+				p.Status, p.Message, p.Updated = res.Status, res.Message, nowUnix()
+				err = store.SavePayment(orderID, *p)
+			}
+		}
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, ErrNotFound) {
+				code = http.StatusNotFound
+			}
+			writeJSON(w, code, map[string]string{"error": err.Error()})
+			return
+		}
+		if _, err := settlePayment(r.Context(), inv, store, pay, orderID, res); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFakeProvider(t *testing.T) {
+	ctx := context.Background()
+	f := NewFakeProvider("", "secret")
+
+	res, err := f.Authorize(ctx, 1, 100, FakeTokenDecline)
+	if err != nil || res.Status != PaymentDeclined {
+		t.Fatalf("expected declined payment, got %+v %v", res, err)
+	}
+	res, err = f.Authorize(ctx, 2, 100, "tok_visa")
+	if err != nil || res.Status != PaymentAuthorized {
+		t.Fatalf("expected authorized payment, got %+v %v", res, err)
+	}
+	if _, err := f.Refund(ctx, res.Reference, 10); err != ErrPaymentState {
+		t.Fatalf("expected refund before capture to fail, got %v", err)
+	}
+	if _, err := f.Capture(ctx, res.Reference, 150); err != ErrPaymentState {
+		t.Fatalf("expected capture over the authorized amount to fail, got %v", err)
+	}
+	if c, err := f.Capture(ctx, res.Reference, 100); err != nil || c.Status != PaymentCaptured {
+		t.Fatalf("expected captured payment, got %+v %v", c, err)
+	}
+	if r, err := f.Refund(ctx, res.Reference, 40); err != nil || r.Status != PaymentCaptured {
+		t.Fatalf("expected partial refund to leave the payment captured, got %+v %v", r, err)
+	}
+	if r, err := f.Refund(ctx, res.Reference, 60); err != nil || r.Status != PaymentRefunded {
+		t.Fatalf("expected full refund, got %+v %v", r, err)
+	}
+
+	f.Latency = 50 * time.Millisecond
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := f.Authorize(short, 3, 100, ""); err == nil {
+		t.Fatal("expected a slow provider to time out")
+	}
+}
+
+func TestFakeProvider_Webhook(t *testing.T) {
+	f := NewFakeProvider("", "secret")
+	f.AsyncDelay = 10 * time.Millisecond
+	got := make(chan PaymentResult, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := f.ParseWebhook(r)
+		if err != nil {
+			t.Errorf("unexpected webhook error: %v", err)
+		}
+		got <- res
+	}))
+	defer srv.Close()
+	f.WebhookURL = srv.URL
+
+	res, _ := f.Authorize(context.Background(), 1, 20, FakeTokenAsyncDecline)
+	if res.Status != PaymentPending {
+		t.Fatalf("expected pending payment, got %+v", res)
+	}
+	select {
+	case ev := <-got:
+		if ev.Reference != res.Reference || ev.Status != PaymentDeclined {
+			t.Fatalf("unexpected webhook event %+v", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+
+	forged := httptest.NewRequest(http.MethodPost, "/payments/webhook", nil)
+	forged.Header.Set(fakeSignatureHeader, "00")
+	if _, err := f.ParseWebhook(forged); err != ErrInvalidWebhook {
+		t.Fatalf("expected forged webhook to be refused, got %v", err)
+	}
+}
+
+func TestOrder_AppliedRules(t *testing.T) {
+	o := &Order{Items: []OrderItem{
+		{Adjustments: []Adjustment{{RuleID: 2}, {RuleID: 5}}},
+		{Adjustments: []Adjustment{{RuleID: 5}}},
+	}}
+	if ids := o.appliedRules(); len(ids) != 2 || ids[0] != 2 || ids[1] != 5 {
+		t.Fatalf("expected rules 2 and 5, got %v", ids)
+	}
+}
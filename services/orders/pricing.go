@@ -0,0 +1,442 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Pricing rule kinds, in the order they are applied to a line
+const (
+	RuleTiered     = "tiered"
+	RuleBuyXGetY   = "buy_x_get_y"
+	RulePercentage = "percentage"
+	RuleFixed      = "fixed"
+)
+
+var ruleOrder = map[string]int{RuleTiered: 0, RuleBuyXGetY: 1, RulePercentage: 2, RuleFixed: 3}
+
+var (
+	ErrInvalidRule      = errors.New("invalid pricing rule")
+	ErrInvalidPromoCode = errors.New("promo code is invalid or expired")
+	ErrPromoExhausted   = errors.New("promo code has been used up")
+	ErrPromoCodeExists  = errors.New("an active rule already uses this promo code")
+)
+
+// PricingRule adjusts order line prices. ItemID limits a rule to one item; zero
+// means every line (for a fixed amount: the order, spread over its lines). A rule
+// with a Code only applies to orders that give that promo code.
+//
+//   - tiered: the unit price of the highest tier the line quantity reaches
+//   - buy_x_get_y: of every BuyQuantity+GetQuantity units, GetQuantity are free
+//   - percentage: Percent off the line
+//   - fixed: Amount off the line, or off the order when ItemID is zero
+type PricingRule struct {
+	ID          int         `json:"id"`
+	Name        string      `json:"name"`
+	Kind        string      `json:"kind"`
+	ItemID      int         `json:"item_id,omitempty"`
+	Percent     float64     `json:"percent,omitempty"`
+	Amount      float64     `json:"amount,omitempty"`
+	BuyQuantity int         `json:"buy_quantity,omitempty"`
+	GetQuantity int         `json:"get_quantity,omitempty"`
+	Tiers       []PriceTier `json:"tiers,omitempty"`
+	Code        string      `json:"code,omitempty"`
+	UsageLimit  int         `json:"usage_limit,omitempty"`
+	Used        int         `json:"used"`
+	StartsAt    int64       `json:"starts_unix,omitempty"`
+	ExpiresAt   int64       `json:"expires_unix,omitempty"`
+	Active      bool        `json:"active"`
+}
+
+// PriceTier is the unit price from a minimum line quantity on
+type PriceTier struct {
+	MinQuantity int     `json:"min_quantity"`
+	Price       float64 `json:"price"`
+}
+
+// Adjustment is what a pricing rule changed on an order line; discounts are negative
+type Adjustment struct {
+	RuleID int     `json:"rule_id"`
+	Name   string  `json:"name"`
+	Kind   string  `json:"kind"`
+	Amount float64 `json:"amount"`
+}
+
+func validatePricingRule(r PricingRule) error {
+	if strings.TrimSpace(r.Name) == "" || r.UsageLimit < 0 || (r.ExpiresAt != 0 && r.ExpiresAt <= r.StartsAt) {
+		return ErrInvalidRule
+	}
+	switch r.Kind {
+	case RulePercentage:
+		if r.Percent <= 0 || r.Percent > 100 {
+			return ErrInvalidRule
+		}
+	case RuleFixed:
+		if r.Amount <= 0 {
+			return ErrInvalidRule
+		}
+	case RuleBuyXGetY:
+		if r.BuyQuantity <= 0 || r.GetQuantity <= 0 {
+			return ErrInvalidRule
+		}
+	case RuleTiered:
+		if r.ItemID == 0 || len(r.Tiers) == 0 {
+			return ErrInvalidRule
+		}
+		for _, t := range r.Tiers {
+			if t.MinQuantity <= 0 || t.Price < 0 {
+				return ErrInvalidRule
+			}
+		}
+	default:
+		return ErrInvalidRule
+	}
+	return nil
+}
+
+// live reports whether a rule can be used at time now
+func (r PricingRule) live(now int64) bool {
+	return r.Active && (r.StartsAt == 0 || now >= r.StartsAt) && (r.ExpiresAt == 0 || now < r.ExpiresAt) &&
+		(r.UsageLimit == 0 || r.Used < r.UsageLimit)
+}
+
+// usableRules picks the rules an order may use: live automatic rules plus the
+// promo code's, in the order they apply. An unknown or expired code is an error.
+func usableRules(rules []PricingRule, code string, now int64) ([]PricingRule, error) {
+	code = strings.TrimSpace(code)
+	found := code == ""
+	res := make([]PricingRule, 0, len(rules))
+	for _, r := range rules {
+		if !r.live(now) {
+			continue
+		}
+		if r.Code != "" {
+			if !strings.EqualFold(r.Code, code) {
+				continue
+			}
+			found = true
+		}
+		res = append(res, r)
+	}
+	if !found {
+		return nil, ErrInvalidPromoCode
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if ruleOrder[res[i].Kind] != ruleOrder[res[j].Kind] {
+			return ruleOrder[res[i].Kind] < ruleOrder[res[j].Kind]
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res, nil
+}
+
+// applyPricing sets the adjustments of each line from the usable rules and
+// returns the ids of the rules that changed anything
+func applyPricing(lines []OrderItem, rules []PricingRule, code string, now int64) ([]int, error) {
+	usable, err := usableRules(rules, code, now)
+	if err != nil {
+		return nil, err
+	}
+	for i := range lines {
+		lines[i].Adjustments = nil
+	}
+	applied := make([]int, 0)
+	for _, r := range usable {
+		if r.apply(lines) {
+			applied = append(applied, r.ID)
+		}
+	}
+	return applied, nil
+}
+
+// apply adds the rule's adjustments to the lines it covers, never taking a line
+// below zero, and reports whether it changed anything
+func (r PricingRule) apply(lines []OrderItem) bool {
+	changed := false
+	adjust := func(it *OrderItem, amount float64) {
+		amount = roundCents(math.Max(amount, -it.lineTotal()))
+		if amount == 0 {
+			return
+		}
+		it.Adjustments = append(it.Adjustments, Adjustment{RuleID: r.ID, Name: r.Name, Kind: r.Kind, Amount: amount})
+		changed = true
+	}
+
+	if r.Kind == RuleFixed && r.ItemID == 0 {
+		// spread over the lines in proportion to their totals, the last line
+		// taking what rounding leaves over
+		var base float64
+		for _, it := range lines {
+			base += it.lineTotal()
+		}
+		if base <= 0 {
+			return false
+		}
+		left := math.Min(r.Amount, base)
+		last := -1
+		for i := range lines {
+			if lines[i].lineTotal() > 0 {
+				last = i
+			}
+		}
+		total := left
+		for i := range lines {
+			t := lines[i].lineTotal()
+			if t <= 0 {
+				continue
+			}
+			share := roundCents(total * t / base)
+			if i == last {
+				share = roundCents(left)
+			}
+			left -= share
+			adjust(&lines[i], -share)
+		}
+		return changed
+	}
+
+	for i := range lines {
+		it := &lines[i]
+		if (r.ItemID != 0 && it.ItemID != r.ItemID) || it.Quantity <= 0 {
+			continue
+		}
+		switch r.Kind {
+		case RuleTiered:
+			price, ok := tierPrice(r.Tiers, it.Quantity)
+			if ok {
+				adjust(it, (price-it.Price)*float64(it.Quantity))
+			}
+		case RuleBuyXGetY:
+			free := it.Quantity / (r.BuyQuantity + r.GetQuantity) * r.GetQuantity
+			if free > 0 {
+				adjust(it, -it.lineTotal()/float64(it.Quantity)*float64(free))
+			}
+		case RulePercentage:
+			adjust(it, -it.lineTotal()*r.Percent/100)
+		case RuleFixed:
+			adjust(it, -r.Amount)
+		}
+	}
+	return changed
+}
+
+// tierPrice returns the unit price of the highest tier qty reaches
+func tierPrice(tiers []PriceTier, qty int) (float64, bool) {
+	best := -1
+	for i, t := range tiers {
+		if qty >= t.MinQuantity && (best < 0 || t.MinQuantity > tiers[best].MinQuantity) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	return tiers[best].Price, true
+}
+
+// lineTotal is what a line costs after its adjustments so far
+func (it OrderItem) lineTotal() float64 {
+	total := float64(it.Quantity) * it.Price
+	for _, a := range it.Adjustments {
+		total += a.Amount
+	}
+	return total
+}
+
+// orderTotal sums the lines after their adjustments
+func orderTotal(lines []OrderItem) float64 {
+	var total float64
+	for _, it := range lines {
+		total += it.lineTotal()
+	}
+	return roundCents(total)
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+const ruleColumns = "id, name, kind, item_id, percent, amount, buy_quantity, get_quantity, tiers, code, usage_limit, used, starts_unix, expires_unix, active"
+
+func scanRule(row rowScanner) (*PricingRule, error) {
+	var r PricingRule
+	var tiers []byte
+	err := row.Scan(&r.ID, &r.Name, &r.Kind, &r.ItemID, &r.Percent, &r.Amount, &r.BuyQuantity, &r.GetQuantity, &tiers,
+		&r.Code, &r.UsageLimit, &r.Used, &r.StartsAt, &r.ExpiresAt, &r.Active)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if len(tiers) > 0 {
+		json.Unmarshal(tiers, &r.Tiers)
+	}
+	return &r, nil
+}
+
+func (s *OrderStore) CreatePricingRule(r PricingRule) (*PricingRule, error) {
+	var tiers []byte
+	if len(r.Tiers) > 0 {
+		tiers, _ = json.Marshal(r.Tiers)
+	}
+	r.Active, r.Used = true, 0
+	err := s.db.QueryRow(`INSERT INTO pricing_rules (name, kind, item_id, percent, amount, buy_quantity, get_quantity, tiers, code, usage_limit, starts_unix, expires_unix)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12) ON CONFLICT DO NOTHING RETURNING id`,
+		r.Name, r.Kind, r.ItemID, r.Percent, r.Amount, r.BuyQuantity, r.GetQuantity, tiers, r.Code, r.UsageLimit, r.StartsAt, r.ExpiresAt).Scan(&r.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPromoCodeExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// PricingRules lists all rules, active or not
+func (s *OrderStore) PricingRules() ([]PricingRule, error) {
+	rows, err := s.db.Query("SELECT " + ruleColumns + " FROM pricing_rules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]PricingRule, 0)
+	for rows.Next() {
+		r, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *r)
+	}
+	return res, rows.Err()
+}
+
+func (s *OrderStore) GetPricingRule(id int) (*PricingRule, error) {
+	return scanRule(s.db.QueryRow("SELECT "+ruleColumns+" FROM pricing_rules WHERE id=$1", id))
+}
+
+// DeactivatePricingRule stops a rule applying to new orders; orders keep their adjustments
+func (s *OrderStore) DeactivatePricingRule(id int) (*PricingRule, error) {
+	if _, err := s.db.Exec("UPDATE pricing_rules SET active=FALSE WHERE id=$1", id); err != nil {
+		return nil, err
+	}
+	return s.GetPricingRule(id)
+}
+
+// RedeemRules counts one use of each rule, all or none; ErrPromoExhausted if a
+// rule reached its usage limit meanwhile
+func (s *OrderStore) RedeemRules(ids []int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, id := range ids {
+		res, err := tx.Exec("UPDATE pricing_rules SET used=used+1 WHERE id=$1 AND (usage_limit=0 OR used<usage_limit)", id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrPromoExhausted
+		}
+	}
+	return tx.Commit()
+}
+
+// ReleaseRules gives back the uses RedeemRules took, for an order that fell through
+func (s *OrderStore) ReleaseRules(ids []int) error {
+	for _, id := range ids {
+		if _, err := s.db.Exec("UPDATE pricing_rules SET used=used-1 WHERE id=$1 AND used>0", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appliedRules are the ids of the pricing rules that adjusted an order's lines
+func (o *Order) appliedRules() []int {
+	seen := make(map[int]bool)
+	var ids []int
+	for _, it := range o.Items {
+		for _, a := range it.Adjustments {
+			if !seen[a.RuleID] {
+				seen[a.RuleID] = true
+				ids = append(ids, a.RuleID)
+			}
+		}
+	}
+	return ids
+}
+
+// pricingHandler serves /pricing/rules and /pricing/rules/{id}; DELETE deactivates a rule
+func pricingHandler(store *OrderStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/pricing/rules"), "/")
+		if path == "" {
+			switch r.Method {
+			case http.MethodGet:
+				list, err := store.PricingRules()
+				if err != nil {
+					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+					return
+				}
+				writeJSON(w, http.StatusOK, list)
+			case http.MethodPost:
+				var req PricingRule
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+					return
+				}
+				req.Code = strings.TrimSpace(req.Code)
+				if err := validatePricingRule(req); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+					return
+				}
+				rule, err := store.CreatePricingRule(req)
+				if err != nil {
+					code := http.StatusInternalServerError
+					if errors.Is(err, ErrPromoCodeExists) {
+						code = http.StatusConflict
+					}
+					writeJSON(w, code, map[string]string{"error": err.Error()})
+					return
+				}
+				writeJSON(w, http.StatusCreated, rule)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
+		id, err := strconv.Atoi(path)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid id"})
+			return
+		}
+		var rule *PricingRule
+		switch r.Method {
+		case http.MethodGet:
+			rule, err = store.GetPricingRule(id)
+		case http.MethodDelete:
+			rule, err = store.DeactivatePricingRule(id)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, ErrNotFound) {
+				code = http.StatusNotFound
+			}
+			writeJSON(w, code, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, rule)
+	}
+}
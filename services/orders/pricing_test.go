@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestApplyPricing(t *testing.T) {
+	rules := []PricingRule{
+		{ID: 1, Name: "10% off", Kind: RulePercentage, Percent: 10, Code: "TEN", Active: true},
+		{ID: 2, Name: "bulk widgets", Kind: RuleTiered, ItemID: 1, Tiers: []PriceTier{{MinQuantity: 5, Price: 8}, {MinQuantity: 10, Price: 7}}, Active: true},
+		{ID: 3, Name: "3 for 2 gadgets", Kind: RuleBuyXGetY, ItemID: 2, BuyQuantity: 2, GetQuantity: 1, Active: true},
+		{ID: 4, Name: "5 off the order", Kind: RuleFixed, Amount: 5, Active: true},
+		{ID: 5, Name: "expired", Kind: RulePercentage, Percent: 50, ExpiresAt: 100, Active: true},
+	}
+	lines := []OrderItem{
+		{ItemID: 1, Quantity: 5, Price: 10},
+		{ItemID: 2, Quantity: 7, Price: 3},
+	}
+	applied, err := applyPricing(lines, rules, "", 1000)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(applied) != 3 {
+		t.Fatalf("expected rules 2, 3 and 4 applied, got %v", applied)
+	}
+	// widgets 5 at the tier price 8 = 40; gadgets 7 with 2 free = 15; then 5 off
+	// spread 40:15 over the lines
+	if got := orderTotal(lines); !almostEqualFloat(got, 50) {
+		t.Fatalf("expected total 50, got %v (%+v)", got, lines)
+	}
+	if len(lines[0].Adjustments) != 2 || !almostEqualFloat(lines[0].Adjustments[0].Amount, -10) {
+		t.Fatalf("expected tier then order discount on widgets, got %+v", lines[0].Adjustments)
+	}
+	if !almostEqualFloat(lines[0].Adjustments[1].Amount+lines[1].Adjustments[1].Amount, -5) {
+		t.Fatalf("expected the order discount to add up to 5, got %+v", lines)
+	}
+
+	if _, err := applyPricing(lines, rules, "ten", 1000); err != nil {
+		t.Fatalf("expected promo codes to match case-insensitively, got %v", err)
+	}
+	if got := orderTotal(lines); !almostEqualFloat(got, 44.5) {
+		t.Fatalf("expected 10%% off before the fixed discount, got %v", got)
+	}
+	if _, err := applyPricing(lines, rules, "NOPE", 1000); err != ErrInvalidPromoCode {
+		t.Fatalf("expected ErrInvalidPromoCode, got %v", err)
+	}
+	rules[0].UsageLimit, rules[0].Used = 1, 1
+	if _, err := applyPricing(lines, rules, "TEN", 1000); err != ErrInvalidPromoCode {
+		t.Fatalf("expected used up promo code to be refused, got %v", err)
+	}
+}
+
+func TestPricingRule_NeverBelowZero(t *testing.T) {
+	rules := []PricingRule{{ID: 1, Name: "big", Kind: RuleFixed, ItemID: 1, Amount: 100, Active: true}}
+	lines := []OrderItem{{ItemID: 1, Quantity: 1, Price: 30}}
+	applyPricing(lines, rules, "", 0)
+	if got := orderTotal(lines); got != 0 {
+		t.Fatalf("expected line capped at zero, got %v", got)
+	}
+	if err := validatePricingRule(PricingRule{Name: "x", Kind: RuleTiered, Tiers: []PriceTier{{MinQuantity: 2, Price: 1}}}); err != ErrInvalidRule {
+		t.Fatalf("expected tiered rule without an item to be invalid, got %v", err)
+	}
+}
+
+func TestOrderStore_RedeemRules(t *testing.T) {
+	s := NewOrderStoreInMemory()
+	r, _ := s.CreatePricingRule(PricingRule{Name: "once", Kind: RulePercentage, Percent: 5, Code: "ONCE", UsageLimit: 1})
+	if _, err := s.CreatePricingRule(PricingRule{Name: "again", Kind: RuleFixed, Amount: 1, Code: "once"}); err != ErrPromoCodeExists {
+		t.Fatalf("expected ErrPromoCodeExists, got %v", err)
+	}
+	if err := s.RedeemRules([]int{r.ID}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.RedeemRules([]int{r.ID}); err != ErrPromoExhausted {
+		t.Fatalf("expected ErrPromoExhausted, got %v", err)
+	}
+	if r, _ = s.DeactivatePricingRule(r.ID); r.Active || r.Used != 1 {
+		t.Fatalf("expected inactive rule used once, got %+v", r)
+	}
+}
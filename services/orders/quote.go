@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"inventoryshop/pkg/inventory"
+)
+
+// WarnShippingUnavailable is an order problem: the shipping method cannot carry
+// the order, so it is quoted without shipping
+const WarnShippingUnavailable = "shipping_unavailable"
+
+// OrderQuote is what an order would come to if it were placed now, with the
+// problems that would stop it. Lines with backorders are priced and noted but
+// do not stop it; items missing from inventory are left out.
+type OrderQuote struct {
+	Items     []OrderItem   `json:"items"`
+	Charges   []Charge      `json:"charges,omitempty"`
+	TaxRegion string        `json:"tax_region,omitempty"`
+	Tax       float64       `json:"tax"`
+	Total     float64       `json:"total"`
+	Problems  []CartWarning `json:"problems"`
+	OK        bool          `json:"ok"`
+}
+
+// quoteOrder prices an order the way placeOrder would, checking stock without
+// taking any and without redeeming promo codes. Mistakes in the request itself,
+// like an unknown promo code or address, are errors as they are for placeOrder.
+func quoteOrder(ctx context.Context, inv *inventory.Client, store *OrderStore, tax *TaxConfig, req orderRequest) (*OrderQuote, int, error) {
+	rules, err := store.PricingRules()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if _, err := usableRules(rules, req.PromoCode, nowUnix()); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	d, err := resolveDelivery(store, tax, req)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	q := &OrderQuote{Items: make([]OrderItem, 0, len(req.Items)), TaxRegion: d.region, Problems: make([]CartWarning, 0)}
+	categories := make(map[int]string)
+	var weight float64
+	req.Items = mergeLines(req.Items)
+	items, err := inv.GetItems(ctx, lineIDs(req.Items))
+	if err != nil {
+		code, err := inventoryError(err, "failed to reach inventory")
+		return nil, code, err
+	}
+	for _, l := range req.Items {
+		it := items[l.ID]
+		if it == nil {
+			q.Problems = append(q.Problems, CartWarning{ItemID: l.ID, Code: WarnUnavailable, Message: fmt.Sprintf("item %d not found in inventory", l.ID)})
+			continue
+		}
+		line := OrderItem{ItemID: l.ID, Name: it.Name, Quantity: l.Quantity, Price: it.Price, Status: LineReserved}
+		if w := stockWarning(it, l.Quantity); w != nil {
+			q.Problems = append(q.Problems, *w)
+			if w.Code == WarnBackorder {
+				line.Status = lineStatus(it.BackorderPolicy)
+				line.ExpectedAt = it.AvailableAt
+			}
+		}
+		categories[l.ID] = it.Category
+		weight += float64(l.Quantity) * billableWeight(it)
+		q.Items = append(q.Items, line)
+	}
+
+	_, charges, taxAmount, err := priceOrder(q.Items, rules, req.PromoCode, d, weight, categories, tax)
+	if errors.Is(err, ErrShippingUnavailable) {
+		q.Problems = append(q.Problems, CartWarning{Code: WarnShippingUnavailable, Message: err.Error()})
+		d.method = nil
+		_, charges, taxAmount, err = priceOrder(q.Items, rules, req.PromoCode, d, weight, categories, tax)
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	q.Charges, q.Tax, q.Total = charges, taxAmount, tax.grossTotal(q.Items, charges, taxAmount)
+	q.OK = len(q.Items) > 0
+	for _, p := range q.Problems {
+		if p.Code != WarnBackorder {
+			q.OK = false
+		}
+	}
+	return q, http.StatusOK, nil
+}
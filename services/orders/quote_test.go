@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestPriceOrder(t *testing.T) {
+	rules := []PricingRule{{ID: 1, Name: "10% off", Kind: RulePercentage, Percent: 10, Code: "TEN", Active: true}}
+	tax := &TaxConfig{PricesIncludeTax: true, Rules: []TaxRule{{Region: "RU", Rate: 20}}}
+	post := &ShippingMethod{ID: 1, Name: "post", Kind: ShipWeight, FreeOver: 100,
+		WeightRates: []WeightRate{{UpToKg: 5, Price: 12}}}
+	d := delivery{region: "RU", method: post}
+	lines := []OrderItem{{ItemID: 1, Quantity: 2, Price: 55}}
+
+	// 110 less 10% is under the free shipping threshold
+	applied, charges, taxAmount, err := priceOrder(lines, rules, "ten", d, 2, nil, tax)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(applied) != 1 || len(charges) != 1 || charges[0].Amount != 12 {
+		t.Fatalf("expected promo applied and shipping charged, got %v %+v", applied, charges)
+	}
+	// inclusive 20% of 99 goods and 12 shipping
+	if !almostEqualFloat(taxAmount, 16.5+2) {
+		t.Fatalf("expected tax 18.5, got %v", taxAmount)
+	}
+	if got := tax.grossTotal(lines, charges, taxAmount); !almostEqualFloat(got, 111) {
+		t.Fatalf("expected total 111, got %v", got)
+	}
+
+	if _, charges, _, _ = priceOrder(lines, rules, "", d, 2, nil, tax); len(charges) != 1 || charges[0].Amount != 0 {
+		t.Fatalf("expected free shipping without the promo, got %+v", charges)
+	}
+	if _, _, _, err := priceOrder(lines, rules, "", d, 6, nil, tax); err != ErrShippingUnavailable {
+		t.Fatalf("expected ErrShippingUnavailable over the heaviest band, got %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"inventoryshop/pkg/inventory"
+)
+
+// ReplenishmentParams controls how reorder suggestions are computed.
+// All values are in days.
+type ReplenishmentParams struct {
+	WindowDays   int `json:"window_days"`
+	LeadTimeDays int `json:"lead_time_days"`
+	SafetyDays   int `json:"safety_days"`
+	CoverDays    int `json:"cover_days"`
+}
+
+func defaultReplenishmentParams() ReplenishmentParams {
+	return ReplenishmentParams{WindowDays: 30, LeadTimeDays: 7, SafetyDays: 3, CoverDays: 30}
+}
+
+// Suggestion is a proposed purchase order line for one item
+type Suggestion struct {
+	ItemID            int     `json:"item_id"`
+	Name              string  `json:"name"`
+	OnHand            int     `json:"on_hand"`
+	Sold              int     `json:"sold"`
+	DailyVelocity     float64 `json:"daily_velocity"`
+	DaysOfCover       float64 `json:"days_of_cover"`
+	ReorderPoint      int     `json:"reorder_point"`
+	SuggestedQuantity int     `json:"suggested_quantity"`
+}
+
+// suggestReplenishment computes reorder suggestions from stock levels and units sold
+// during the window. An item is reordered once stock falls to the demand expected over
+// lead time plus safety days; the order brings it up to cover CoverDays more on top.
+// Only items with a positive suggested quantity are returned, lowest cover first.
+func suggestReplenishment(items []inventory.Item, sold map[int]int, p ReplenishmentParams) []Suggestion {
+	res := make([]Suggestion, 0)
+	for _, it := range items {
+		qty := sold[it.ID]
+		if qty <= 0 {
+			continue
+		}
+		velocity := float64(qty) / float64(p.WindowDays)
+		cover := float64(it.Quantity) / velocity
+		reorderPoint := int(math.Ceil(velocity * float64(p.LeadTimeDays+p.SafetyDays)))
+		if it.Quantity > reorderPoint {
+			continue
+		}
+		target := int(math.Ceil(velocity * float64(p.LeadTimeDays+p.SafetyDays+p.CoverDays)))
+		suggested := target - it.Quantity
+		if suggested <= 0 {
+			continue
+		}
+		res = append(res, Suggestion{
+			ItemID:            it.ID,
+			Name:              it.Name,
+			OnHand:            it.Quantity,
+			Sold:              qty,
+			DailyVelocity:     velocity,
+			DaysOfCover:       cover,
+			ReorderPoint:      reorderPoint,
+			SuggestedQuantity: suggested,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].DaysOfCover != res[j].DaysOfCover {
+			return res[i].DaysOfCover < res[j].DaysOfCover
+		}
+		return res[i].ItemID < res[j].ItemID
+	})
+	return res
+}
+
+// expandBundleSales moves units sold as part of bundles onto the bundles' components,
+// since bundles hold no stock of their own to reorder
+func expandBundleSales(items []inventory.Item, sold map[int]int) map[int]int {
+	res := make(map[int]int, len(sold))
+	for id, qty := range sold {
+		res[id] += qty
+	}
+	for _, it := range items {
+		if len(it.Components) == 0 {
+			continue
+		}
+		for _, c := range it.Components {
+			res[c.ItemID] += sold[it.ID] * c.Quantity
+		}
+		delete(res, it.ID)
+	}
+	return res
+}
+
+// parseReplenishmentParams reads overrides from the query string, e.g. ?window_days=14
+func parseReplenishmentParams(r *http.Request) (ReplenishmentParams, error) {
+	p := defaultReplenishmentParams()
+	q := r.URL.Query()
+	fields := []struct {
+		name string
+		dst  *int
+		min  int
+	}{
+		{"window_days", &p.WindowDays, 1},
+		{"lead_time_days", &p.LeadTimeDays, 0},
+		{"safety_days", &p.SafetyDays, 0},
+		{"cover_days", &p.CoverDays, 0},
+	}
+	for _, f := range fields {
+		v := q.Get(f.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < f.min {
+			return p, fmt.Errorf("invalid %s", f.name)
+		}
+		*f.dst = n
+	}
+	return p, nil
+}
+
+func replenishmentHandler(store *OrderStore, inv *inventory.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		p, err := parseReplenishmentParams(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		since := time.Now().AddDate(0, 0, -p.WindowDays).Unix()
+		sold, err := store.SoldSince(since)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load order history"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+		defer cancel()
+		items, err := inv.ListItems(ctx)
+		if err != nil {
+			writeInventoryError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"params":      p,
+			"suggestions": suggestReplenishment(items, expandBundleSales(items, sold), p),
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"inventoryshop/pkg/inventory"
+)
+
+func TestSuggestReplenishment(t *testing.T) {
+	items := []inventory.Item{
+		{ID: 1, Name: "fast", Quantity: 5},
+		{ID: 2, Name: "slow", Quantity: 100},
+		{ID: 3, Name: "unsold", Quantity: 0},
+	}
+	// 30 units over 30 days -> 1/day; 60 units over 30 days -> 2/day
+	sold := map[int]int{1: 30, 2: 60}
+	p := ReplenishmentParams{WindowDays: 30, LeadTimeDays: 7, SafetyDays: 3, CoverDays: 20}
+
+	got := suggestReplenishment(items, sold, p)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 suggestion, got %d: %+v", len(got), got)
+	}
+	s := got[0]
+	if s.ItemID != 1 {
+		t.Fatalf("expected item 1, got %d", s.ItemID)
+	}
+	if s.ReorderPoint != 10 {
+		t.Fatalf("expected reorder point 10, got %d", s.ReorderPoint)
+	}
+	// target 1/day * (7+3+20) = 30, minus 5 on hand
+	if s.SuggestedQuantity != 25 {
+		t.Fatalf("expected suggested quantity 25, got %d", s.SuggestedQuantity)
+	}
+	if !almostEqualFloat(s.DaysOfCover, 5) {
+		t.Fatalf("expected 5 days of cover, got %v", s.DaysOfCover)
+	}
+}
+
+func TestExpandBundleSales(t *testing.T) {
+	items := []inventory.Item{
+		{ID: 1, Name: "hoodie"},
+		{ID: 2, Name: "tee"},
+		{ID: 3, Name: "hoodie + 2 tees", Components: []inventory.Component{{ItemID: 1, Quantity: 1}, {ItemID: 2, Quantity: 2}}},
+	}
+	got := expandBundleSales(items, map[int]int{1: 4, 3: 5})
+	if got[1] != 9 || got[2] != 10 {
+		t.Fatalf("expected 9 hoodies and 10 tees, got %v", got)
+	}
+	if _, ok := got[3]; ok {
+		t.Fatalf("expected bundle sales to be moved onto components, got %v", got)
+	}
+}
+
+func TestOrderStore_SoldSince(t *testing.T) {
+	s := NewOrderStoreInMemory()
+	s.Create([]OrderItem{{ItemID: 1, Quantity: 2}, {ItemID: 2, Quantity: 1}}, 0)
+	s.Create([]OrderItem{{ItemID: 1, Quantity: 3}}, 0)
+	old := s.Create([]OrderItem{{ItemID: 1, Quantity: 100}}, 0)
+	old.Created = 1
+
+	sold, err := s.SoldSince(2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sold[1] != 5 || sold[2] != 1 {
+		t.Fatalf("unexpected sold map: %v", sold)
+	}
+}
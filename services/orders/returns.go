@@ -0,0 +1,595 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"inventoryshop/pkg/inventory"
+)
+
+// Return statuses. A requested return is approved, once the goods are back and
+// inspected, or rejected.
+const (
+	ReturnRequested = "requested"
+	ReturnApproved  = "approved"
+	ReturnRejected  = "rejected"
+)
+
+// Inspection outcomes of a returned line
+const (
+	OutcomeRestock  = "restock"
+	OutcomeWriteOff = "write_off"
+)
+
+// returnReasons are the reason codes a return line may give
+var returnReasons = map[string]bool{
+	"damaged":          true,
+	"defective":        true,
+	"wrong_item":       true,
+	"not_as_described": true,
+	"no_longer_needed": true,
+	"other":            true,
+}
+
+var (
+	ErrInvalidReturn  = errors.New("invalid return")
+	ErrReturnQuantity = errors.New("return quantity exceeds what is left to return")
+	ErrReturnClosed   = errors.New("return has already been decided")
+)
+
+// Return is a return authorization (RMA) for some of an order's lines.
+// RefundOwed is set on approval until the payment is refunded.
+type Return struct {
+	ID         int          `json:"id"`
+	OrderID    int          `json:"order_id"`
+	Status     string       `json:"status"`
+	Lines      []ReturnLine `json:"lines"`
+	Note       string       `json:"note,omitempty"`
+	Refund     float64      `json:"refund"`
+	RefundOwed bool         `json:"refund_owed,omitempty"`
+	Created    int64        `json:"created_unix"`
+	Updated    int64        `json:"updated_unix"`
+}
+
+// ReturnLine returns a quantity of the order's line for an item. Serial tracked
+// lines name the serials coming back. Outcome and Refund are set on approval,
+// and RestockOwed until restocked units are back in inventory.
+type ReturnLine struct {
+	ItemID      int      `json:"item_id"`
+	Quantity    int      `json:"quantity"`
+	Reason      string   `json:"reason"`
+	Serials     []string `json:"serials,omitempty"`
+	Outcome     string   `json:"outcome,omitempty"`
+	Refund      float64  `json:"refund,omitempty"`
+	RestockOwed bool     `json:"restock_owed,omitempty"`
+}
+
+// Refund is money owed back on an order for an approved return
+type Refund struct {
+	ID       int     `json:"id"`
+	ReturnID int     `json:"return_id"`
+	Amount   float64 `json:"amount"`
+	Created  int64   `json:"created_unix"`
+}
+
+// returnableLine is the order's line for an item that stock was taken for;
+// lines still waiting on a backorder cannot be returned
+func returnableLine(o *Order, itemID int) *OrderItem {
+	for i := range o.Items {
+		if it := &o.Items[i]; it.ItemID == itemID && !it.pending() {
+			return it
+		}
+	}
+	return nil
+}
+
+// validateReturn checks requested return lines against the order and what its
+// earlier returns, not rejected, already took back
+func validateReturn(o *Order, lines []ReturnLine, earlier []Return) error {
+	if o.Status == OrderStatusCancelled || len(lines) == 0 {
+		return ErrInvalidReturn
+	}
+	returned := make(map[int]int)
+	serials := make(map[string]bool)
+	for _, r := range earlier {
+		if r.Status == ReturnRejected {
+			continue
+		}
+		for _, l := range r.Lines {
+			returned[l.ItemID] += l.Quantity
+			for _, sn := range l.Serials {
+				serials[sn] = true
+			}
+		}
+	}
+	for _, l := range lines {
+		line := returnableLine(o, l.ItemID)
+		if line == nil || l.Quantity <= 0 || !returnReasons[l.Reason] {
+			return ErrInvalidReturn
+		}
+		returned[l.ItemID] += l.Quantity
+		if returned[l.ItemID] > line.Quantity {
+			return ErrReturnQuantity
+		}
+		if len(line.Serials) == 0 {
+			if len(l.Serials) > 0 {
+				return ErrInvalidReturn
+			}
+			continue
+		}
+		if len(l.Serials) != l.Quantity {
+			return ErrInvalidReturn
+		}
+		for _, sn := range l.Serials {
+			if serials[sn] || !contains(line.Serials, sn) {
+				return ErrInvalidReturn
+			}
+			serials[sn] = true
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// approve records the inspection outcome of each line, keyed by item id, and
+// works out the refund: what the customer paid for the units, after discounts
+// and with any tax added on top
+func (r *Return) approve(o *Order, outcomes map[int]string) error {
+	if r.Status != ReturnRequested {
+		return ErrReturnClosed
+	}
+	r.Refund = 0
+	for i := range r.Lines {
+		l := &r.Lines[i]
+		l.Outcome = outcomes[l.ItemID]
+		if l.Outcome != OutcomeRestock && l.Outcome != OutcomeWriteOff {
+			return ErrInvalidReturn
+		}
+		line := returnableLine(o, l.ItemID)
+		if line == nil || line.Quantity == 0 {
+			return ErrInvalidReturn
+		}
+		paid := line.lineTotal()
+		if line.Tax != nil && !line.Tax.Inclusive {
+			paid += line.Tax.Amount
+		}
+		l.Refund = roundCents(paid * float64(l.Quantity) / float64(line.Quantity))
+		l.RestockOwed = l.Outcome == OutcomeRestock
+		r.Refund += l.Refund
+	}
+	r.Refund = roundCents(r.Refund)
+	r.RefundOwed = r.Refund > 0
+	r.Status = ReturnApproved
+	return nil
+}
+
+// owed reports whether an approved return still has units to restock or a
+// refund to pay
+func (r *Return) owed() bool {
+	if r.Status != ReturnApproved {
+		return false
+	}
+	for _, l := range r.Lines {
+		if l.RestockOwed {
+			return true
+		}
+	}
+	return r.RefundOwed
+}
+
+// returnedBefore is how many units of an item the order's other approved
+// returns took back
+func returnedBefore(approved []Return, self, itemID int) int {
+	n := 0
+	for _, r := range approved {
+		if r.ID == self || r.Status != ReturnApproved {
+			continue
+		}
+		for _, l := range r.Lines {
+			if l.ItemID == itemID {
+				n += l.Quantity
+			}
+		}
+	}
+	return n
+}
+
+// returnStock is the stock a restocked return line gives back: its share of the
+// line's cost, the most recently allocated lots and, for a bundle, each
+// component's share. Returns take units from the end of the line's
+// allocations, so the before units earlier returns took back are skipped.
+func returnStock(line OrderItem, l ReturnLine, before int) reserved {
+	share := func(n int) float64 { return float64(n) / float64(line.Quantity) }
+	r := reserved{id: line.ItemID, qty: l.Quantity, lots: lastLots(line.Lots, before, l.Quantity), serials: l.Serials,
+		cost: line.Cost * share(l.Quantity)}
+	for _, c := range line.Components {
+		skip := c.Quantity * before / line.Quantity
+		n := c.Quantity * l.Quantity / line.Quantity
+		comp := ComponentAllocation{ItemID: c.ItemID, Quantity: n, Lots: lastLots(c.Lots, skip, n), Cost: c.Cost * share(l.Quantity)}
+		if end := len(c.Serials) - skip; end >= n {
+			comp.Serials = c.Serials[end-n : end]
+		}
+		r.components = append(r.components, comp)
+	}
+	return r
+}
+
+// lastLots takes n units from the end of a line's lot allocations, after
+// skipping the last skip units
+func lastLots(lots []LotAllocation, skip, n int) []LotAllocation {
+	var res []LotAllocation
+	for i := len(lots) - 1; i >= 0 && n > 0; i-- {
+		l := lots[i]
+		if l.Quantity <= skip {
+			skip -= l.Quantity
+			continue
+		}
+		l.Quantity -= skip
+		skip = 0
+		if l.Quantity > n {
+			l.Quantity = n
+		}
+		n -= l.Quantity
+		res = append(res, l)
+	}
+	return res
+}
+
+const returnColumns = "id, order_id, status, lines, note, refund, refund_owed, created_unix, updated_unix"
+
+func scanReturn(row rowScanner) (*Return, error) {
+	var r Return
+	var lines []byte
+	if err := row.Scan(&r.ID, &r.OrderID, &r.Status, &lines, &r.Note, &r.Refund, &r.RefundOwed, &r.Created, &r.Updated); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	json.Unmarshal(lines, &r.Lines)
+	return &r, nil
+}
+
+// CreateReturn requests a return of some of an order's lines. The order row is
+// locked so concurrent requests cannot return the same units twice.
+func (s *OrderStore) CreateReturn(o *Order, r Return) (*Return, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("SELECT id FROM orders WHERE id=$1 FOR UPDATE", o.ID); err != nil {
+		return nil, err
+	}
+	earlier, err := queryReturns(tx, "WHERE order_id=$1", o.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateReturn(o, r.Lines, earlier); err != nil {
+		return nil, err
+	}
+	r.OrderID, r.Status, r.Refund = o.ID, ReturnRequested, 0
+	r.Created = nowUnix()
+	r.Updated = r.Created
+	lines, _ := json.Marshal(r.Lines)
+	err = tx.QueryRow("INSERT INTO returns (order_id, status, lines, note, created_unix, updated_unix) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id",
+		r.OrderID, r.Status, lines, r.Note, r.Created, r.Updated).Scan(&r.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &r, tx.Commit()
+}
+
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func queryReturns(q querier, where string, args ...interface{}) ([]Return, error) {
+	rows, err := q.Query("SELECT "+returnColumns+" FROM returns "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]Return, 0)
+	for rows.Next() {
+		r, err := scanReturn(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *r)
+	}
+	return res, rows.Err()
+}
+
+func (s *OrderStore) GetReturn(id int) (*Return, error) {
+	return scanReturn(s.db.QueryRow("SELECT "+returnColumns+" FROM returns WHERE id=$1", id))
+}
+
+// Returns lists the returns of an order, or of all orders if orderID is 0,
+// optionally only those with a status
+func (s *OrderStore) Returns(orderID int, status string) ([]Return, error) {
+	return queryReturns(s.db, "WHERE ($1=0 OR order_id=$1) AND ($2='' OR status=$2)", orderID, status)
+}
+
+// DecideReturn approves a requested return with the given inspection outcomes,
+// recording its refund on the order, or rejects it if outcomes is nil
+func (s *OrderStore) DecideReturn(id int, o *Order, outcomes map[int]string, note string) (*Return, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	r, err := scanReturn(tx.QueryRow("SELECT "+returnColumns+" FROM returns WHERE id=$1 FOR UPDATE", id))
+	if err != nil {
+		return nil, err
+	}
+	if err := decide(r, o, outcomes, note); err != nil {
+		return nil, err
+	}
+	lines, _ := json.Marshal(r.Lines)
+	_, err = tx.Exec("UPDATE returns SET status=$1, lines=$2, note=$3, refund=$4, refund_owed=$5, updated_unix=$6 WHERE id=$7",
+		r.Status, lines, r.Note, r.Refund, r.RefundOwed, r.Updated, r.ID)
+	if err != nil {
+		return nil, err
+	}
+	if r.Status == ReturnApproved {
+		_, err = tx.Exec("INSERT INTO refunds (order_id, return_id, amount, created_unix) VALUES ($1,$2,$3,$4)", r.OrderID, r.ID, r.Refund, r.Updated)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, tx.Commit()
+}
+
+// SaveReturnProgress stores which of an approved return's restocks and refund
+// are still owed
+func (s *OrderStore) SaveReturnProgress(r *Return) error {
+	lines, _ := json.Marshal(r.Lines)
+	_, err := s.db.Exec("UPDATE returns SET lines=$1, refund_owed=$2 WHERE id=$3", lines, r.RefundOwed, r.ID)
+	return err
+}
+
+// decide applies a decision to a return; nil outcomes reject it
+func decide(r *Return, o *Order, outcomes map[int]string, note string) error {
+	if outcomes == nil {
+		if r.Status != ReturnRequested {
+			return ErrReturnClosed
+		}
+		r.Status = ReturnRejected
+	} else if err := r.approve(o, outcomes); err != nil {
+		return err
+	}
+	if note != "" {
+		r.Note = note
+	}
+	r.Updated = nowUnix()
+	return nil
+}
+
+func (s *OrderStore) loadRefunds(orderID int) ([]Refund, error) {
+	rows, err := s.db.Query("SELECT id, return_id, amount, created_unix FROM refunds WHERE order_id=$1 ORDER BY id", orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Refund
+	for rows.Next() {
+		var f Refund
+		if err := rows.Scan(&f.ID, &f.ReturnID, &f.Amount, &f.Created); err != nil {
+			return nil, err
+		}
+		res = append(res, f)
+	}
+	return res, rows.Err()
+}
+
+func returnErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidReturn), errors.Is(err, ErrReturnQuantity):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrReturnClosed):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// orderReturns serves /orders/{id}/returns for a caller allowed to see the order:
+// GET lists its returns, POST requests one with
+// {"lines": [{"item_id": 1, "quantity": 1, "reason": "damaged"}], "note": "..."}
+func orderReturns(w http.ResponseWriter, r *http.Request, store *OrderStore, ord *Order) {
+	switch r.Method {
+	case http.MethodGet:
+		list, err := store.Returns(ord.ID, "")
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		var req Return
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+			return
+		}
+		ret, err := store.CreateReturn(ord, Return{Lines: req.Lines, Note: strings.TrimSpace(req.Note)})
+		if err != nil {
+			writeJSON(w, returnErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusCreated, ret)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// returnsHandler serves /returns (staff, ?status= to filter), /returns/{id} and
+// the staff decisions /returns/{id}/approve, with the inspection outcome of each
+// line {"lines": [{"item_id": 1, "outcome": "restock"}]}, and /returns/{id}/reject.
+// Approving puts restocked units back in inventory and refunds the payment; if
+// either fails, approving the return again retries what is still owed.
+func returnsHandler(store *OrderStore, inv *inventory.Client, adminToken string, pay PaymentProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		who, ok := identify(store, adminToken, r)
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/returns"), "/")
+		if path == "" {
+			if !who.staff {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+				return
+			}
+			if r.Method != http.MethodGet {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			list, err := store.Returns(0, r.URL.Query().Get("status"))
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, list)
+			return
+		}
+
+		parts := strings.Split(path, "/")
+		id, err := strconv.Atoi(parts[0])
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid id"})
+			return
+		}
+		ret, err := store.GetReturn(id)
+		var ord *Order
+		if err == nil {
+			ord, err = store.Get(ret.OrderID)
+		}
+		// other customers' returns are reported as missing rather than forbidden
+		if err != nil || !who.canSee(ord) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+			return
+		}
+
+		if len(parts) == 1 {
+			if r.Method != http.MethodGet {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			writeJSON(w, http.StatusOK, ret)
+			return
+		}
+		if len(parts) != 2 || (parts[1] != "approve" && parts[1] != "reject") || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !who.staff {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+			return
+		}
+		var req Return
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+			return
+		}
+		var outcomes map[int]string
+		if parts[1] == "approve" {
+			outcomes = make(map[int]string, len(req.Lines))
+			for _, l := range req.Lines {
+				outcomes[l.ItemID] = l.Outcome
+			}
+		}
+		decided, err := store.DecideReturn(id, ord, outcomes, strings.TrimSpace(req.Note))
+		// approving a return again finishes a restock or refund that failed
+		if errors.Is(err, ErrReturnClosed) && outcomes != nil && ret.owed() {
+			decided, err = ret, nil
+		}
+		if err != nil {
+			writeJSON(w, returnErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		ret = decided
+
+		ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+		defer cancel()
+		restocked, refundErr := settleReturn(ctx, inv, store, pay, ord, ret)
+		if refundErr != nil {
+			log.Printf("refund payment for return %d: %v", ret.ID, refundErr)
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": "return approved but the payment could not be refunded; approve it again to retry"})
+			return
+		}
+		if !restocked {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": "return approved but some items could not be restocked; approve it again to retry"})
+			return
+		}
+		writeJSON(w, http.StatusOK, ret)
+	}
+}
+
+// returnStore is what settling a return needs of the store
+type returnStore interface {
+	paymentStore
+	Returns(orderID int, status string) ([]Return, error)
+	SaveReturnProgress(r *Return) error
+}
+
+// settleReturn puts the restocked units of an approved return that are still
+// owed back in inventory, leaving out the lots and serials earlier returns gave
+// back, and refunds the payment if that is still owed; written off units stay
+// out of stock. Each step done is saved, so that settling again only does what
+// is left. It reports whether every unit went back, and the refund's error.
+func settleReturn(ctx context.Context, inv *inventory.Client, store returnStore, pay PaymentProvider, ord *Order, ret *Return) (bool, error) {
+	restocked := true
+	approved, err := store.Returns(ord.ID, ReturnApproved)
+	if err != nil {
+		log.Printf("restock failed for return %d: %v", ret.ID, err)
+		restocked = false
+	}
+	for i := range ret.Lines {
+		l := &ret.Lines[i]
+		if !l.RestockOwed || err != nil {
+			continue
+		}
+		line := returnableLine(ord, l.ItemID)
+		stock := returnStock(*line, *l, returnedBefore(approved, ret.ID, l.ItemID))
+		if rerr := restockInventory(ctx, inv, stock, "return", fmt.Sprintf("return %d", ret.ID)); rerr != nil {
+			log.Printf("restock failed for return %d item %d: %v", ret.ID, l.ItemID, rerr)
+			restocked = false
+			continue
+		}
+		l.RestockOwed = false
+		if serr := store.SaveReturnProgress(ret); serr != nil {
+			log.Printf("save return %d: %v", ret.ID, serr)
+			restocked = false
+		}
+	}
+	if !ret.RefundOwed {
+		return restocked, nil
+	}
+	if err := refundPayment(ctx, store, pay, ord, ret.Refund); err != nil {
+		return restocked, err
+	}
+	ret.RefundOwed = false
+	if err := store.SaveReturnProgress(ret); err != nil {
+		log.Printf("save return %d: %v", ret.ID, err)
+	}
+	return restocked, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"inventoryshop/pkg/inventory"
+)
+
+func TestOrderStore_Returns(t *testing.T) {
+	s := NewOrderStoreInMemory()
+	ord := s.CreateOrder(Order{Items: []OrderItem{
+		{ItemID: 1, Name: "Widget", Quantity: 4, Price: 10, Cost: 24, Tax: &LineTax{Rate: 20, Amount: 8}},
+		{ItemID: 2, Name: "Phone", Quantity: 2, Price: 100, Serials: []string{"SN1", "SN2"}},
+		{ItemID: 3, Name: "Later", Quantity: 1, Price: 5, BackorderID: 7, Status: LineBackordered},
+	}})
+
+	bad := [][]ReturnLine{
+		{{ItemID: 1, Quantity: 5, Reason: "damaged"}},
+		{{ItemID: 1, Quantity: 1, Reason: "because"}},
+		{{ItemID: 2, Quantity: 1, Reason: "defective", Serials: []string{"SN9"}}},
+		{{ItemID: 3, Quantity: 1, Reason: "other"}},
+	}
+	for _, lines := range bad {
+		if _, err := s.CreateReturn(ord, Return{Lines: lines}); err == nil {
+			t.Fatalf("expected return %+v to be refused", lines)
+		}
+	}
+
+	r, err := s.CreateReturn(ord, Return{Lines: []ReturnLine{
+		{ItemID: 1, Quantity: 3, Reason: "damaged"},
+		{ItemID: 2, Quantity: 1, Reason: "defective", Serials: []string{"SN2"}},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.CreateReturn(ord, Return{Lines: []ReturnLine{{ItemID: 1, Quantity: 2, Reason: "other"}}}); err != ErrReturnQuantity {
+		t.Fatalf("expected ErrReturnQuantity, got %v", err)
+	}
+
+	if _, err := s.DecideReturn(r.ID, ord, map[int]string{1: OutcomeRestock}, ""); err != ErrInvalidReturn {
+		t.Fatalf("expected every line to need an outcome, got %v", err)
+	}
+	r, err = s.DecideReturn(r.ID, ord, map[int]string{1: OutcomeRestock, 2: OutcomeWriteOff}, "box crushed")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 3 of 4 widgets with tax added on top, and one phone
+	if r.Status != ReturnApproved || !almostEqualFloat(r.Lines[0].Refund, 36) || !almostEqualFloat(r.Refund, 136) {
+		t.Fatalf("unexpected approved return %+v", r)
+	}
+	if _, err := s.DecideReturn(r.ID, ord, nil, ""); err != ErrReturnClosed {
+		t.Fatalf("expected ErrReturnClosed, got %v", err)
+	}
+	if got, _ := s.Get(ord.ID); len(got.Refunds) != 1 || got.Refunds[0].Amount != 136 {
+		t.Fatalf("expected refund recorded on the order, got %+v", got.Refunds)
+	}
+
+	stock := returnStock(ord.Items[0], r.Lines[0], 0)
+	if stock.qty != 3 || !almostEqualFloat(stock.cost, 18) {
+		t.Fatalf("expected 3 units back at their share of cost, got %+v", stock)
+	}
+}
+
+func TestLastLots(t *testing.T) {
+	lots := []LotAllocation{{LotID: 1, Quantity: 2}, {LotID: 2, Quantity: 3}}
+	got := lastLots(lots, 0, 4)
+	if len(got) != 2 || got[0].LotID != 2 || got[0].Quantity != 3 || got[1].Quantity != 1 {
+		t.Fatalf("unexpected lots %+v", got)
+	}
+	got = lastLots(lots, 2, 2)
+	if len(got) != 2 || got[0].LotID != 2 || got[0].Quantity != 1 || got[1].LotID != 1 || got[1].Quantity != 1 {
+		t.Fatalf("unexpected lots after skipping %+v", got)
+	}
+}
+
+func TestReturnStock_PartialReturns(t *testing.T) {
+	// a line of 4 from two lots, and a bundle of 2 with one serial tracked component each
+	line := OrderItem{ItemID: 1, Quantity: 4, Cost: 40,
+		Lots: []LotAllocation{{LotID: 1, Quantity: 2}, {LotID: 2, Quantity: 2}}}
+	bundle := OrderItem{ItemID: 5, Quantity: 2, Components: []ComponentAllocation{
+		{ItemID: 6, Quantity: 2, Serials: []string{"A", "B"}},
+	}}
+	first := ReturnLine{ItemID: 1, Quantity: 2}
+	second := ReturnLine{ItemID: 1, Quantity: 2}
+	approved := []Return{
+		{ID: 1, Status: ReturnApproved, Lines: []ReturnLine{first, {ItemID: 5, Quantity: 1}}},
+		{ID: 2, Status: ReturnApproved, Lines: []ReturnLine{second, {ItemID: 5, Quantity: 1}}},
+		{ID: 3, Status: ReturnRejected, Lines: []ReturnLine{{ItemID: 1, Quantity: 1}}},
+	}
+
+	if got := returnStock(line, first, returnedBefore(approved[:1], 1, 1)).lots; len(got) != 1 || got[0].LotID != 2 || got[0].Quantity != 2 {
+		t.Fatalf("first return: unexpected lots %+v", got)
+	}
+	before := returnedBefore(approved, 2, 1)
+	if before != 2 {
+		t.Fatalf("want 2 units returned before, got %d", before)
+	}
+	if got := returnStock(line, second, before).lots; len(got) != 1 || got[0].LotID != 1 || got[0].Quantity != 2 {
+		t.Fatalf("second return: want the other lot, got %+v", got)
+	}
+
+	one := ReturnLine{ItemID: 5, Quantity: 1}
+	a := returnStock(bundle, one, returnedBefore(approved[:1], 1, 5)).components[0].Serials
+	b := returnStock(bundle, one, returnedBefore(approved, 2, 5)).components[0].Serials
+	if len(a) != 1 || len(b) != 1 || a[0] != "B" || b[0] != "A" {
+		t.Fatalf("want each serial returned once, got %v and %v", a, b)
+	}
+}
+
+func TestSettleReturn_RetriesWhatIsOwed(t *testing.T) {
+	var mu sync.Mutex
+	down := true
+	restocked := 0
+	inv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var adj inventory.Adjustment
+		json.NewDecoder(r.Body).Decode(&adj)
+		restocked += adj.Delta
+		writeJSON(w, http.StatusOK, inventory.Adjusted{})
+	}))
+	defer inv.Close()
+	client := inventory.New(inv.URL)
+	pay := &flakyProvider{down: true}
+	s := NewOrderStoreInMemory()
+	ord := s.CreateOrder(Order{Items: []OrderItem{{ItemID: 1, Quantity: 2, Price: 10, Cost: 8}, {ItemID: 2, Quantity: 1, Price: 5}}})
+	s.SavePayment(ord.ID, Payment{Reference: "pay_1", Status: PaymentCaptured, Amount: 25, Captured: 25})
+	r, _ := s.CreateReturn(ord, Return{Lines: []ReturnLine{{ItemID: 1, Quantity: 1, Reason: "damaged"}, {ItemID: 2, Quantity: 1, Reason: "other"}}})
+	r, err := s.DecideReturn(r.ID, ord, map[int]string{1: OutcomeRestock, 2: OutcomeWriteOff}, "")
+	if err != nil || !r.RefundOwed || !r.Lines[0].RestockOwed || r.Lines[1].RestockOwed {
+		t.Fatalf("want the refund and the restocked line owed, got %+v %v", r, err)
+	}
+	ctx := context.Background()
+
+	if ok, err := settleReturn(ctx, client, s, pay, ord, r); ok || err == nil {
+		t.Fatalf("want restock and refund to fail, got %v %v", ok, err)
+	}
+	r, _ = s.GetReturn(r.ID)
+	if !r.owed() || ord.Payment.Refunded != 0 {
+		t.Fatalf("want the return still owed, got %+v", r)
+	}
+
+	pay.down = false
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	if ok, err := settleReturn(ctx, client, s, pay, ord, r); !ok || err != nil {
+		t.Fatalf("want the retry to settle the return, got %v %v", ok, err)
+	}
+	r, _ = s.GetReturn(r.ID)
+	if r.owed() || restocked != 1 || !almostEqualFloat(ord.Payment.Refunded, 15) {
+		t.Fatalf("want one unit restocked and 15 refunded, got %+v, %d restocked, %+v", r, restocked, ord.Payment)
+	}
+	if ok, err := settleReturn(ctx, client, s, pay, ord, r); !ok || err != nil || restocked != 1 || !almostEqualFloat(ord.Payment.Refunded, 15) {
+		t.Fatalf("want nothing done for a settled return, got %v %v", ok, err)
+	}
+}
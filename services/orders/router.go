@@ -4,152 +4,736 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
+
+	"inventoryshop/pkg/events"
+	"inventoryshop/pkg/inventory"
 )
 
-// reserved represents a reserved quantity for rollback
-type reserved struct{ id, qty int }
+// reserved represents a reserved quantity for rollback. A line still waiting on
+// a backorder has backorder set and nothing allocated yet.
+type reserved struct {
+	id, qty    int
+	lots       []LotAllocation
+	serials    []string
+	components []ComponentAllocation
+	cost       float64
+	backorder  int
+}
 
-func NewRouter(store *OrderStore, invURL string) http.Handler {
-	client := &http.Client{Timeout: 5 * time.Second}
+// NewRouter builds the orders API. Callers are identified by bearer token: the
+// admin token for staff, or a customer's own token; see identify. A nil tax
+// config charges no tax; without a payment provider orders are placed without
+// taking payment, and without seller details no invoices are issued. The bus
+// carries published events to the order feed.
+func NewRouter(store *OrderStore, inv *inventory.Client, adminToken string, tax *TaxConfig, pay PaymentProvider, seller *Seller, bus *events.Bus) http.Handler {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/orders", func(w http.ResponseWriter, r *http.Request) {
+		who, ok := identify(store, adminToken, r)
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
 		switch r.Method {
 		case http.MethodGet:
+			// customers only ever see their own orders
+			if !who.staff {
+				list, err := store.OrdersByCustomer(who.customerID)
+				if err != nil {
+					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+					return
+				}
+				writeJSON(w, http.StatusOK, list)
+				return
+			}
 			writeJSON(w, http.StatusOK, store.List())
 		case http.MethodPost:
-			var req struct {
-				Items []struct {
-					ID       int `json:"id"`
-					Quantity int `json:"quantity"`
-				} `json:"items"`
-			}
+			var req orderRequest
 			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
 				return
 			}
-
-			var reservedList []reserved
-			var orderItems []OrderItem
-			var total float64
+			if err := scopeOrderRequest(store, who, &req); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
 
 			ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
 			defer cancel()
-
-			for _, it := range req.Items {
-				// fetch item details to get price
-				getURL := fmt.Sprintf("%s/items/%d", strings.TrimRight(invURL, "/"), it.ID)
-				reqGet, _ := http.NewRequestWithContext(ctx, http.MethodGet, getURL, nil)
-				resp, err := client.Do(reqGet)
-				if err != nil {
-					rollbackInventory(ctx, client, invURL, reservedList)
-					writeJSON(w, http.StatusBadGateway, map[string]string{"error": "failed to reach inventory"})
-					return
-				}
-				if resp.StatusCode != http.StatusOK {
-					io.Copy(io.Discard, resp.Body)
-					resp.Body.Close()
-					rollbackInventory(ctx, client, invURL, reservedList)
-					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "item not found in inventory"})
-					return
-				}
-				var invItem struct {
-					ID       int     `json:"id"`
-					Name     string  `json:"name"`
-					Quantity int     `json:"quantity"`
-					Price    float64 `json:"price"`
-				}
-				if err := json.NewDecoder(resp.Body).Decode(&invItem); err != nil {
-					resp.Body.Close()
-					rollbackInventory(ctx, client, invURL, reservedList)
-					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "invalid inventory response"})
-					return
-				}
-				resp.Body.Close()
-
-				// send adjust request
-				adjustURL := fmt.Sprintf("%s/items/%d/adjust", strings.TrimRight(invURL, "/"), it.ID)
-				body, _ := json.Marshal(map[string]int{"delta": -it.Quantity})
-				reqAdj, _ := http.NewRequestWithContext(ctx, http.MethodPost, adjustURL, bytes.NewReader(body))
-				reqAdj.Header.Set("Content-Type", "application/json")
-				resp2, err := client.Do(reqAdj)
-				if err != nil {
-					rollbackInventory(ctx, client, invURL, reservedList)
-					writeJSON(w, http.StatusBadGateway, map[string]string{"error": "failed to adjust inventory"})
-					return
-				}
-				io.Copy(io.Discard, resp2.Body)
-				resp2.Body.Close()
-				if resp2.StatusCode != http.StatusOK {
-					rollbackInventory(ctx, client, invURL, reservedList)
-					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "insufficient stock or invalid adjust"})
-					return
-				}
-
-				// reserved ok
-				reservedList = append(reservedList, reserved{id: it.ID, qty: it.Quantity})
-				orderItems = append(orderItems, OrderItem{ItemID: it.ID, Name: invItem.Name, Quantity: it.Quantity, Price: invItem.Price})
-				total += float64(it.Quantity) * invItem.Price
+			ord, code, err := placeOrder(ctx, inv, store, tax, pay, req)
+			if err != nil {
+				writeJSON(w, code, map[string]string{"error": err.Error()})
+				return
 			}
-
-			ord := store.Create(orderItems, total)
 			writeJSON(w, http.StatusCreated, ord)
 		default:
 			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
 	})
 
+	mux.HandleFunc("/orders/feed", feedHandler(store, adminToken, store, bus))
+
+	// POST /orders/quote prices an order without placing it, for previews
+	mux.HandleFunc("/orders/quote", func(w http.ResponseWriter, r *http.Request) {
+		who, ok := identify(store, adminToken, r)
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req orderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+			return
+		}
+		if err := scopeOrderRequest(store, who, &req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+		defer cancel()
+		q, code, err := quoteOrder(ctx, inv, store, tax, req)
+		if err != nil {
+			writeJSON(w, code, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, code, q)
+	})
+
 	mux.HandleFunc("/orders/", func(w http.ResponseWriter, r *http.Request) {
+		who, ok := identify(store, adminToken, r)
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
 		path := strings.TrimPrefix(r.URL.Path, "/orders/")
 		if path == "" {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
-		idStr := strings.Split(path, "/")[0]
-		id, err := strconv.Atoi(idStr)
+		parts := strings.Split(path, "/")
+		id, err := strconv.Atoi(parts[0])
 		if err != nil {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid id"})
 			return
 		}
-		switch r.Method {
-		case http.MethodGet:
+
+		// other customers' orders are reported as missing rather than forbidden
+		if ord, err := store.Get(id); err == nil && !who.canSee(ord) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+			return
+		}
+
+		// path like {id}/returns
+		if len(parts) == 2 && parts[1] == "returns" {
+			ord, err := store.Get(id)
+			if err != nil {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+				return
+			}
+			orderReturns(w, r, store, ord)
+			return
+		}
+
+		if len(parts) == 1 {
+			switch r.Method {
+			case http.MethodGet:
+				ord, err := store.Get(id)
+				if err != nil {
+					writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+					return
+				}
+				// lines waiting on backorders show their latest state; the stored
+				// order is still served if inventory cannot be reached
+				if ord.Status != OrderStatusCancelled {
+					ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+					if err := syncBackorders(ctx, inv, store, ord); err != nil {
+						log.Printf("backorder sync for order %d: %v", ord.ID, err)
+					}
+					cancel()
+				}
+				writeJSON(w, http.StatusOK, ord)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
+		// path like {id}/cancel: mark the order cancelled, then put its stock back
+		if parts[1] == "cancel" && r.Method == http.MethodPost {
+			ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+			defer cancel()
+			ord, code, err := cancelOrder(ctx, inv, store, pay, id)
+			if err != nil {
+				writeJSON(w, code, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, ord)
+			return
+		}
+
+		// path like {id}/invoice: the order's invoice as PDF, or HTML with ?format=html
+		if parts[1] == "invoice" && r.Method == http.MethodGet {
+			if seller == nil {
+				writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "seller details are not configured"})
+				return
+			}
+			ord, err := store.Get(id)
+			if err != nil {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+				return
+			}
+			inv, err := orderInvoice(store, seller, ord)
+			if err != nil {
+				code := http.StatusInternalServerError
+				if errors.Is(err, ErrNotInvoiceable) {
+					code = http.StatusConflict
+				}
+				writeJSON(w, code, map[string]string{"error": err.Error()})
+				return
+			}
+			// render into memory so a failure can still be reported as an error
+			var buf bytes.Buffer
+			if r.URL.Query().Get("format") == "html" {
+				err = renderInvoiceHTML(&buf, inv)
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			} else {
+				err = renderInvoicePDF(&buf, inv)
+				w.Header().Set("Content-Type", "application/pdf")
+				w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"invoice-%s.pdf\"", inv.Number))
+			}
+			if err != nil {
+				w.Header().Del("Content-Disposition")
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			w.Write(buf.Bytes())
+			return
+		}
+
+		// path like {id}/capture: staff collect the order's authorized payment
+		if parts[1] == "capture" && r.Method == http.MethodPost {
+			if !who.staff {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+				return
+			}
 			ord, err := store.Get(id)
 			if err != nil {
 				writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
 				return
 			}
+			if ord.Status != OrderStatusCreated {
+				writeJSON(w, http.StatusConflict, map[string]string{"error": ErrPaymentState.Error()})
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+			defer cancel()
+			if err := capturePayment(ctx, store, pay, ord); err != nil {
+				code := http.StatusBadGateway
+				if errors.Is(err, ErrPaymentState) {
+					code = http.StatusConflict
+				}
+				writeJSON(w, code, map[string]string{"error": err.Error()})
+				return
+			}
 			writeJSON(w, http.StatusOK, ord)
-		default:
-			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
 		}
+
+		w.WriteHeader(http.StatusNotFound)
 	})
 
+	mux.HandleFunc("/carts", cartsHandler(store, inv, adminToken, tax, pay))
+	mux.HandleFunc("/carts/", cartsHandler(store, inv, adminToken, tax, pay))
+
+	mux.HandleFunc("/returns", returnsHandler(store, inv, adminToken, pay))
+	mux.HandleFunc("/returns/", returnsHandler(store, inv, adminToken, pay))
+
+	mux.HandleFunc("/customers", customersHandler(store, adminToken))
+	mux.HandleFunc("/customers/", customersHandler(store, adminToken))
+
+	// which order holds a serial number: /serials/{serial}
+	mux.HandleFunc("/serials/", staffOnly(store, adminToken, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		serial := strings.TrimPrefix(r.URL.Path, "/serials/")
+		if serial == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		orders, err := store.OrdersBySerial(serial)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		unit, err := inv.GetSerial(ctx, serial)
+		if err != nil && !errors.Is(err, inventory.ErrNotFound) {
+			writeInventoryError(w, err)
+			return
+		}
+		if unit == nil && len(orders) == 0 {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+			return
+		}
+
+		// the serial belongs to the newest order that was not cancelled, if any
+		var current *Order
+		previous := make([]int, 0)
+		for _, o := range orders {
+			if current == nil && o.Status != OrderStatusCancelled {
+				current = o
+				continue
+			}
+			previous = append(previous, o.ID)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"serial":          serial,
+			"inventory":       unit,
+			"order":           current,
+			"previous_orders": previous,
+		})
+	}))
+
+	mux.HandleFunc("/payments/webhook", paymentsWebhook(store, inv, pay))
+
+	mux.HandleFunc("/shipping/", shippingHandler(store, inv, adminToken))
+
+	mux.HandleFunc("/pricing/rules", staffOnly(store, adminToken, pricingHandler(store)))
+	mux.HandleFunc("/pricing/rules/", staffOnly(store, adminToken, pricingHandler(store)))
+
+	mux.HandleFunc("/webhooks", staffOnly(store, adminToken, events.WebhooksHandler(store, eventTypes)))
+	mux.HandleFunc("/webhooks/", staffOnly(store, adminToken, events.WebhooksHandler(store, eventTypes)))
+
+	mux.HandleFunc("/status", statusHandler(inv))
+
+	mux.HandleFunc("/replenishment/suggestions", staffOnly(store, adminToken, replenishmentHandler(store, inv)))
+
+	// the API description, which requests are also validated against
+	mux.Handle("/openapi.json", apiSpec)
+
 	// enable CORS and logging
-	return loggingMiddleware(corsMiddleware(mux))
+	return loggingMiddleware(corsMiddleware(apiSpec.Middleware(mux)))
+}
+
+// orderLine is a requested quantity of an inventory item
+type orderLine struct {
+	ID       int `json:"id"`
+	Quantity int `json:"quantity"`
+}
+
+// ErrInvalidLines is returned for an order without items, or with an item id or
+// quantity that is not positive
+var ErrInvalidLines = errors.New("order needs items with positive ids and quantities")
+
+// validateLines checks the requested lines; a negative quantity would put stock
+// back into inventory and make the order total negative
+func validateLines(lines []orderLine) error {
+	if len(lines) == 0 {
+		return ErrInvalidLines
+	}
+	for _, l := range lines {
+		if l.ID <= 0 || l.Quantity <= 0 {
+			return ErrInvalidLines
+		}
+	}
+	return nil
+}
+
+// mergeLines adds up lines for the same item, keeping the order items first appear in
+func mergeLines(lines []orderLine) []orderLine {
+	res := make([]orderLine, 0, len(lines))
+	at := make(map[int]int, len(lines))
+	for _, l := range lines {
+		if i, ok := at[l.ID]; ok {
+			res[i].Quantity += l.Quantity
+			continue
+		}
+		at[l.ID] = len(res)
+		res = append(res, l)
+	}
+	return res
+}
+
+// lineIDs are the item ids of the lines
+func lineIDs(lines []orderLine) []int {
+	ids := make([]int, len(lines))
+	for i, l := range lines {
+		ids[i] = l.ID
+	}
+	return ids
+}
+
+// orderRequest is an order to place. It ships to one of the customer's saved
+// addresses (AddressID) or to ShippingAddress, by ShippingMethodID if given.
+// Region is where it is delivered, for tax; it defaults to the shipping address,
+// then the customer's first address, then the tax config's default.
+type orderRequest struct {
+	CustomerID       int         `json:"customer_id"`
+	Items            []orderLine `json:"items"`
+	PromoCode        string      `json:"promo_code"`
+	Region           string      `json:"region"`
+	ShippingMethodID int         `json:"shipping_method_id"`
+	AddressID        int         `json:"address_id"`
+	ShippingAddress  *Address    `json:"shipping_address"`
+	// PaymentToken is the payment method from the provider's checkout, if any
+	PaymentToken string `json:"payment_token"`
+}
+
+// scopeOrderRequest sets whom an order is for: staff may order on behalf of a
+// customer, customers order for themselves
+func scopeOrderRequest(store *OrderStore, who caller, req *orderRequest) error {
+	if !who.staff {
+		req.CustomerID = who.customerID
+		return nil
+	}
+	if req.CustomerID != 0 {
+		if _, err := store.GetCustomer(req.CustomerID); err != nil {
+			return errors.New("unknown customer")
+		}
+	}
+	return nil
 }
 
-func rollbackInventory(ctx context.Context, client *http.Client, invURL string, reservedList []reserved) {
+// placeOrder takes the lines' stock from inventory, or backorders it where the
+// item allows, prices the lines with the pricing rules and promo code, quotes
+// shipping, adds tax and stores the order, then takes payment if there is a
+// provider. If anything fails, whatever was taken so far is given back and the
+// returned code is the HTTP status to report.
+func placeOrder(ctx context.Context, inv *inventory.Client, store *OrderStore, tax *TaxConfig, pay PaymentProvider, req orderRequest) (*Order, int, error) {
+	if err := validateLines(req.Items); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	// a bad promo code is refused before any stock is taken
+	rules, err := store.PricingRules()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if _, err := usableRules(rules, req.PromoCode, nowUnix()); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	d, err := resolveDelivery(store, tax, req)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	// details of all items, to price the lines, come in one lookup before any
+	// stock is taken
+	req.Items = mergeLines(req.Items)
+	invItems, err := inv.GetItems(ctx, lineIDs(req.Items))
+	if err != nil {
+		code, err := inventoryError(err, "failed to reach inventory")
+		return nil, code, err
+	}
+	for _, it := range req.Items {
+		if invItems[it.ID] == nil {
+			return nil, http.StatusBadRequest, errors.New("item not found in inventory")
+		}
+	}
+
+	var reservedList []reserved
+	var orderItems []OrderItem
+	categories := make(map[int]string)
+	var weight float64
+
+	for _, it := range req.Items {
+		invItem := invItems[it.ID]
+		categories[it.ID] = invItem.Category
+		weight += float64(it.Quantity) * billableWeight(invItem)
+
+		// items that accept backorders or pre-orders backorder the whole line when
+		// stock cannot cover it, and inventory fulfills it once enough stock is in;
+		// until then the stock there is stays free for other orders
+		backorder := invItem.BackorderPolicy != "" && invItem.Available < it.Quantity
+		if !backorder {
+			// the response carries the lots (first-expired-first-out) and serials the
+			// stock was taken from, or for a bundle what was taken from each
+			// component, and the cost of goods sold
+			adjusted, err := inv.Adjust(ctx, it.ID, inventory.Adjustment{Delta: -it.Quantity, Reason: "order"})
+			if err == nil {
+				// reserved ok
+				reservedList = append(reservedList, reserved{id: it.ID, qty: it.Quantity, lots: adjusted.Lots, serials: adjusted.Serials, components: adjusted.Components, cost: adjusted.Cost})
+				orderItems = append(orderItems, OrderItem{ItemID: it.ID, Name: invItem.Name, Quantity: it.Quantity, Price: invItem.Price, Cost: adjusted.Cost,
+					Lots: adjusted.Lots, Serials: adjusted.Serials, Components: adjusted.Components, Status: LineReserved})
+				continue
+			}
+			// stock may have gone since it was looked up, in which case items that
+			// accept backorders are backordered after all
+			if !errors.Is(err, inventory.ErrInsufficientStock) || invItem.BackorderPolicy == "" {
+				rollbackInventory(ctx, inv, reservedList)
+				var apiErr *inventory.APIError
+				switch {
+				case errors.Is(err, inventory.ErrInsufficientStock):
+					return nil, http.StatusBadRequest, errors.New("insufficient stock")
+				case errors.Is(err, inventory.ErrNotFound):
+					return nil, http.StatusBadRequest, errors.New("item not found in inventory")
+				case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest:
+					return nil, http.StatusBadRequest, errors.New("invalid adjust")
+				}
+				code, err := inventoryError(err, "failed to adjust inventory")
+				return nil, code, err
+			}
+		}
+
+		b, err := inv.CreateBackorder(ctx, it.ID, it.Quantity)
+		if err != nil {
+			rollbackInventory(ctx, inv, reservedList)
+			code, err := inventoryError(err, "failed to backorder item")
+			return nil, code, err
+		}
+		line := OrderItem{ItemID: it.ID, Name: invItem.Name, Quantity: it.Quantity, Price: invItem.Price,
+			Status: lineStatus(b.Kind), BackorderID: b.ID}
+		applyBackorder(&line, b)
+		res := reserved{id: it.ID, qty: it.Quantity, backorder: b.ID}
+		if !line.pending() {
+			res = reserved{id: it.ID, qty: it.Quantity, lots: line.Lots, serials: line.Serials, cost: line.Cost}
+		}
+		reservedList = append(reservedList, res)
+		orderItems = append(orderItems, line)
+	}
+
+	applied, charges, taxAmount, err := priceOrder(orderItems, rules, req.PromoCode, d, weight, categories, tax)
+	if err == nil {
+		err = store.RedeemRules(applied)
+	}
+	if err != nil {
+		rollbackInventory(ctx, inv, reservedList)
+		switch {
+		case errors.Is(err, ErrInvalidPromoCode), errors.Is(err, ErrShippingUnavailable):
+			return nil, http.StatusBadRequest, err
+		case errors.Is(err, ErrPromoExhausted):
+			return nil, http.StatusConflict, err
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	status := OrderStatusCreated
+	if pay != nil {
+		status = OrderStatusPendingPayment
+	}
+	ord := store.CreateOrder(Order{CustomerID: req.CustomerID, Items: orderItems, PromoCode: strings.TrimSpace(req.PromoCode),
+		TaxRegion: d.region, ShippingAddress: d.addr, Charges: charges, Tax: taxAmount, Total: tax.grossTotal(orderItems, charges, taxAmount),
+		Status: status})
+	if pay == nil {
+		return ord, http.StatusCreated, nil
+	}
+	return authorizePayment(ctx, inv, store, pay, ord, req.PaymentToken)
+}
+
+// delivery is where and how an order ships, resolved from its request
+type delivery struct {
+	addr   *Address
+	region string
+	method *ShippingMethod
+}
+
+// resolveDelivery finds the address, tax region and shipping method of an order;
+// errors are the caller's to fix
+func resolveDelivery(store *OrderStore, tax *TaxConfig, req orderRequest) (delivery, error) {
+	var d delivery
+	var err error
+	if req.AddressID != 0 || req.ShippingAddress != nil {
+		if d.addr, err = shippingAddress(store, req.CustomerID, req.AddressID, req.ShippingAddress); err != nil {
+			return d, err
+		}
+	}
+	d.region = normalizeRegion(req.Region)
+	if d.region == "" && d.addr != nil {
+		d.region = addressRegion(*d.addr)
+	}
+	if d.region == "" && req.CustomerID != 0 {
+		if c, err := store.GetCustomer(req.CustomerID); err == nil && len(c.Addresses) > 0 {
+			d.region = addressRegion(c.Addresses[0])
+		}
+	}
+	if d.region == "" && tax != nil {
+		d.region = tax.DefaultRegion
+	}
+	if req.ShippingMethodID != 0 {
+		if d.addr == nil {
+			return d, ErrAddressRequired
+		}
+		if d.method, err = store.GetShippingMethod(req.ShippingMethodID); err != nil || !d.method.Active {
+			return d, ErrInvalidShippingMethod
+		}
+		if !d.method.serves(d.region) {
+			return d, ErrShippingUnavailable
+		}
+	}
+	return d, nil
+}
+
+// priceOrder applies the pricing rules and promo code to an order's lines,
+// quotes shipping for the order's weight and works out tax on both. It returns
+// the rules applied, to be redeemed if the order is placed.
+func priceOrder(lines []OrderItem, rules []PricingRule, promoCode string, d delivery, weightKg float64, categories map[int]string, tax *TaxConfig) ([]int, []Charge, float64, error) {
+	applied, err := applyPricing(lines, rules, promoCode, nowUnix())
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	var charges []Charge
+	if d.method != nil {
+		// free shipping thresholds apply to the goods after discounts
+		price, ok := d.method.quote(weightKg, orderTotal(lines), d.region)
+		if !ok {
+			return nil, nil, 0, ErrShippingUnavailable
+		}
+		charges = []Charge{{Kind: ChargeShipping, Name: d.method.Name, MethodID: d.method.ID, Amount: price}}
+	}
+	taxAmount := roundCents(tax.applyTax(lines, categories, d.region) + tax.taxCharges(charges, d.region))
+	return applied, charges, taxAmount, nil
+}
+
+// cancelStore is what cancelling an order needs of the store
+type cancelStore interface {
+	paymentStore
+	Get(id int) (*Order, error)
+	SetStatus(id int, from, to string) (*Order, error)
+	SetRestocked(orderID, line int) error
+}
+
+// releaseOwed reports whether a cancelled order still has lines to give back to
+// inventory or a payment to void or refund
+func (o *Order) releaseOwed() bool {
+	if o.Status != OrderStatusCancelled {
+		return false
+	}
+	for _, it := range o.Items {
+		if it.RestockOwed {
+			return true
+		}
+	}
+	switch p := o.Payment; {
+	case p == nil:
+		return false
+	case p.Status == PaymentPending, p.Status == PaymentAuthorized:
+		return true
+	case p.Status == PaymentCaptured:
+		return roundCents(p.Captured-p.Refunded) > 0
+	}
+	return false
+}
+
+// cancelOrder cancels an order that is created or awaiting payment, then voids
+// or refunds its payment and gives its stock back. The returned code is the HTTP
+// status to report; the order is cancelled even if what follows fails, and
+// cancelling it again finishes what is still owed.
+func cancelOrder(ctx context.Context, inv *inventory.Client, store cancelStore, pay PaymentProvider, id int) (*Order, int, error) {
+	ord, err := store.SetStatus(id, OrderStatusCreated, OrderStatusCancelled)
+	if errors.Is(err, ErrInvalidTransition) {
+		ord, err = store.SetStatus(id, OrderStatusPendingPayment, OrderStatusCancelled)
+	}
+	if errors.Is(err, ErrInvalidTransition) {
+		if ord, err = store.Get(id); err == nil && !ord.releaseOwed() {
+			err = ErrInvalidTransition
+		}
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return nil, http.StatusNotFound, errors.New("not found")
+		case errors.Is(err, ErrInvalidTransition):
+			return nil, http.StatusConflict, errors.New("order cannot be cancelled")
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+	payErr := releasePayment(ctx, store, pay, ord)
+	if payErr != nil {
+		log.Printf("release payment of order %d: %v", ord.ID, payErr)
+	}
+	restocked := restockOrder(ctx, inv, store, ord, "cancel")
+	switch {
+	case payErr != nil && !restocked:
+		return nil, http.StatusBadGateway, errors.New("order cancelled but its payment could not be voided or refunded nor its items restocked; cancel it again to retry")
+	case payErr != nil:
+		return nil, http.StatusBadGateway, errors.New("order cancelled but its payment could not be voided or refunded; cancel it again to retry")
+	case !restocked:
+		return nil, http.StatusBadGateway, errors.New("order cancelled but some items could not be restocked; cancel it again to retry")
+	}
+	return ord, http.StatusOK, nil
+}
+
+func rollbackInventory(ctx context.Context, inv *inventory.Client, reservedList []reserved) {
 	for i := len(reservedList) - 1; i >= 0; i-- {
 		r := reservedList[i]
-		adjustURL := fmt.Sprintf("%s/items/%d/adjust", strings.TrimRight(invURL, "/"), r.id)
-		body, _ := json.Marshal(map[string]int{"delta": r.qty})
-		reqAdj, _ := http.NewRequestWithContext(ctx, http.MethodPost, adjustURL, bytes.NewReader(body))
-		reqAdj.Header.Set("Content-Type", "application/json")
-		resp, err := client.Do(reqAdj)
-		if err != nil {
+		if err := releaseInventory(ctx, inv, r, "rollback", ""); err != nil {
 			log.Printf("rollback failed for item %d: %v", r.id, err)
+		}
+	}
+}
+
+// restockOrder gives back the stock of a cancelled order's lines that is still
+// owed, recording each line that went back, and reports whether all of it did
+func restockOrder(ctx context.Context, inv *inventory.Client, store cancelStore, ord *Order, reason string) bool {
+	ok := true
+	for i := range ord.Items {
+		it := &ord.Items[i]
+		if !it.RestockOwed {
 			continue
 		}
-		io.Copy(io.Discard, resp.Body)
-		resp.Body.Close()
+		res := reserved{id: it.ItemID, qty: it.Quantity, lots: it.Lots, serials: it.Serials, components: it.Components, cost: it.Cost}
+		if it.pending() {
+			res = reserved{id: it.ItemID, qty: it.Quantity, backorder: it.BackorderID}
+		}
+		err := releaseInventory(ctx, inv, res, reason, fmt.Sprintf("order %d", ord.ID))
+		if err == nil {
+			err = store.SetRestocked(ord.ID, i)
+		}
+		if err != nil {
+			log.Printf("restock failed for order %d item %d: %v", ord.ID, it.ItemID, err)
+			ok = false
+			continue
+		}
+		it.RestockOwed = false
+	}
+	return ok
+}
+
+// releaseInventory gives reserved stock back. A line still waiting on a backorder
+// withdraws it instead, unless inventory fulfilled it meanwhile.
+func releaseInventory(ctx context.Context, inv *inventory.Client, r reserved, reason, reference string) error {
+	if r.backorder != 0 {
+		b, err := inv.CancelBackorder(ctx, r.backorder)
+		// a backorder withdrawn before has nothing left to give back
+		var apiErr *inventory.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b.Status != inventory.BackorderFulfilled {
+			return nil
+		}
+		r.lots, r.serials, r.cost = b.Lots, b.Serials, b.Cost
+	}
+	return restockInventory(ctx, inv, r, reason, reference)
+}
+
+// restockInventory returns reserved stock, including its lots, serials and bundle
+// components, to inventory at the unit cost it left with
+func restockInventory(ctx context.Context, inv *inventory.Client, r reserved, reason, reference string) error {
+	adj := inventory.Adjustment{Delta: r.qty, Reason: reason, Reference: reference, Lots: r.lots, Serials: r.serials, Components: r.components}
+	if r.qty > 0 {
+		unitCost := r.cost / float64(r.qty)
+		adj.UnitCost = &unitCost
 	}
+	_, err := inv.Adjust(ctx, r.id, adj)
+	return err
 }
 
 func loggingMiddleware(next http.Handler) http.Handler {
@@ -163,8 +747,8 @@ func loggingMiddleware(next http.Handler) http.Handler {
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"inventoryshop/pkg/inventory"
+)
+
+func TestMergeLines(t *testing.T) {
+	got := mergeLines([]orderLine{{ID: 2, Quantity: 1}, {ID: 1, Quantity: 3}, {ID: 2, Quantity: 4}})
+	if len(got) != 2 || got[0] != (orderLine{ID: 2, Quantity: 5}) || got[1] != (orderLine{ID: 1, Quantity: 3}) {
+		t.Fatalf("expected lines merged in first-seen order, got %+v", got)
+	}
+	if ids := lineIDs(got); len(ids) != 2 || ids[0] != 2 || ids[1] != 1 {
+		t.Fatalf("unexpected ids %v", ids)
+	}
+}
+
+// flakyProvider voids and refunds payments unless it is down
+type flakyProvider struct {
+	PaymentProvider
+	down bool
+}
+
+func (p *flakyProvider) Void(context.Context, string) (PaymentResult, error) {
+	if p.down {
+		return PaymentResult{}, ErrPaymentUnavailable
+	}
+	return PaymentResult{Status: PaymentVoided}, nil
+}
+
+func (p *flakyProvider) Refund(context.Context, string, float64) (PaymentResult, error) {
+	if p.down {
+		return PaymentResult{}, ErrPaymentUnavailable
+	}
+	return PaymentResult{Status: PaymentCaptured}, nil
+}
+
+func TestCancelOrder_RetryFinishesWhatIsOwed(t *testing.T) {
+	var mu sync.Mutex
+	down := map[int]bool{2: true}
+	restocked := make(map[int]int)
+	inv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		id, _ := strconv.Atoi(strings.Split(strings.TrimPrefix(r.URL.Path, "/items/"), "/")[0])
+		if down[id] {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var adj inventory.Adjustment
+		json.NewDecoder(r.Body).Decode(&adj)
+		restocked[id] += adj.Delta
+		writeJSON(w, http.StatusOK, inventory.Adjusted{})
+	}))
+	defer inv.Close()
+	client := inventory.New(inv.URL)
+	pay := &flakyProvider{down: true}
+	s := NewOrderStoreInMemory()
+	ord := s.Create([]OrderItem{{ItemID: 1, Quantity: 2, Price: 5}, {ItemID: 2, Quantity: 1, Price: 3}}, 13)
+	s.SavePayment(ord.ID, Payment{Reference: "pay_1", Status: PaymentAuthorized, Amount: 13})
+	ctx := context.Background()
+
+	if _, code, _ := cancelOrder(ctx, client, s, pay, ord.ID); code != http.StatusBadGateway {
+		t.Fatalf("want 502 while the provider and inventory fail, got %d", code)
+	}
+	got, _ := s.Get(ord.ID)
+	if got.Status != OrderStatusCancelled || got.Items[0].RestockOwed || !got.Items[1].RestockOwed || !got.releaseOwed() {
+		t.Fatalf("want the order cancelled with item 2 and the payment still owed, got %+v", got)
+	}
+
+	pay.down = false
+	mu.Lock()
+	down[2] = false
+	mu.Unlock()
+	got, code, err := cancelOrder(ctx, client, s, pay, ord.ID)
+	if code != http.StatusOK || err != nil {
+		t.Fatalf("want the retry to finish, got %d %v", code, err)
+	}
+	if got.Payment.Status != PaymentVoided || got.releaseOwed() || restocked[1] != 2 || restocked[2] != 1 {
+		t.Fatalf("want payment voided and each line restocked once, got %+v and %v", got.Payment, restocked)
+	}
+	if _, code, _ := cancelOrder(ctx, client, s, pay, ord.ID); code != http.StatusConflict {
+		t.Fatalf("want 409 once nothing is owed, got %d", code)
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"inventoryshop/pkg/inventory"
+)
+
+// Shipping method kinds
+const (
+	ShipFlat   = "flat"
+	ShipWeight = "weight"
+)
+
+// ChargeShipping is the kind of the charge line shipping adds to an order
+const ChargeShipping = "shipping"
+
+// volumetricDivisor turns a package's cm³ into kilograms charged for its size
+const volumetricDivisor = 5000
+
+var (
+	ErrInvalidShippingMethod = errors.New("invalid shipping method")
+	ErrShippingUnavailable   = errors.New("shipping method does not deliver this order to this address")
+	ErrAddressRequired       = errors.New("a shipping address is required")
+)
+
+// ShippingMethod prices delivering an order. A flat method costs Rate; a weight
+// method costs the first band the order's weight fits in, and cannot ship
+// anything heavier than its last band. Goods worth FreeOver or more ship free.
+// Regions limits where the method delivers, matched like tax regions; empty
+// means everywhere.
+type ShippingMethod struct {
+	ID          int          `json:"id"`
+	Name        string       `json:"name"`
+	Kind        string       `json:"kind"`
+	Rate        float64      `json:"rate,omitempty"`
+	WeightRates []WeightRate `json:"weight_rates,omitempty"`
+	FreeOver    float64      `json:"free_over,omitempty"`
+	Regions     []string     `json:"regions,omitempty"`
+	Active      bool         `json:"active"`
+}
+
+// WeightRate is the price of shipping up to a weight
+type WeightRate struct {
+	UpToKg float64 `json:"up_to_kg"`
+	Price  float64 `json:"price"`
+}
+
+// Charge is an order charge besides its lines, such as shipping
+type Charge struct {
+	Kind     string   `json:"kind"`
+	Name     string   `json:"name"`
+	MethodID int      `json:"method_id,omitempty"`
+	Amount   float64  `json:"amount"`
+	Tax      *LineTax `json:"tax,omitempty"`
+}
+
+// ShippingQuote is what a method would charge for an order
+type ShippingQuote struct {
+	MethodID int     `json:"method_id"`
+	Name     string  `json:"name"`
+	Price    float64 `json:"price"`
+	WeightKg float64 `json:"weight_kg"`
+}
+
+func validateShippingMethod(m ShippingMethod) error {
+	if strings.TrimSpace(m.Name) == "" || m.Rate < 0 || m.FreeOver < 0 {
+		return ErrInvalidShippingMethod
+	}
+	switch m.Kind {
+	case ShipFlat:
+	case ShipWeight:
+		if len(m.WeightRates) == 0 {
+			return ErrInvalidShippingMethod
+		}
+		for _, b := range m.WeightRates {
+			if b.UpToKg <= 0 || b.Price < 0 {
+				return ErrInvalidShippingMethod
+			}
+		}
+	default:
+		return ErrInvalidShippingMethod
+	}
+	return nil
+}
+
+// billableWeight is what a unit of an item weighs for shipping: its weight, or
+// its volumetric weight if the package is bulkier than it is heavy
+func billableWeight(it *inventory.Item) float64 {
+	return math.Max(it.WeightKg, it.LengthCm*it.WidthCm*it.HeightCm/volumetricDivisor)
+}
+
+// serves reports whether the method delivers to a region
+func (m ShippingMethod) serves(region string) bool {
+	if len(m.Regions) == 0 {
+		return true
+	}
+	region = normalizeRegion(region)
+	country := region
+	if i := strings.Index(region, "-"); i > 0 {
+		country = region[:i]
+	}
+	for _, r := range m.Regions {
+		if r = normalizeRegion(r); r == region || r == country {
+			return true
+		}
+	}
+	return false
+}
+
+// quote prices shipping goods of the given weight and value to a region; false
+// if the method does not deliver there or cannot carry the weight
+func (m ShippingMethod) quote(weightKg, goods float64, region string) (float64, bool) {
+	if !m.serves(region) {
+		return 0, false
+	}
+	price := m.Rate
+	if m.Kind == ShipWeight {
+		bands := append([]WeightRate(nil), m.WeightRates...)
+		sort.Slice(bands, func(i, j int) bool { return bands[i].UpToKg < bands[j].UpToKg })
+		found := false
+		for _, b := range bands {
+			if weightKg <= b.UpToKg {
+				price, found = b.Price, true
+				break
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	if m.FreeOver > 0 && goods >= m.FreeOver {
+		price = 0
+	}
+	return roundCents(price), true
+}
+
+// chargesTotal sums an order's charges
+func chargesTotal(charges []Charge) float64 {
+	var total float64
+	for _, c := range charges {
+		total += c.Amount
+	}
+	return roundCents(total)
+}
+
+// shippingAddress picks the address an order ships to: one of the customer's
+// saved addresses by id, or one given with the order
+func shippingAddress(store *OrderStore, customerID, addressID int, given *Address) (*Address, error) {
+	if addressID == 0 {
+		if given == nil || validateAddress(*given) != nil {
+			return nil, ErrInvalidAddress
+		}
+		a := *given
+		a.ID = 0
+		return &a, nil
+	}
+	if customerID == 0 {
+		return nil, ErrInvalidAddress
+	}
+	c, err := store.GetCustomer(customerID)
+	if err != nil {
+		return nil, ErrInvalidAddress
+	}
+	for _, a := range c.Addresses {
+		if a.ID == addressID {
+			return &a, nil
+		}
+	}
+	return nil, ErrInvalidAddress
+}
+
+const shippingColumns = "id, name, kind, rate, weight_rates, free_over, regions, active"
+
+func scanShippingMethod(row rowScanner) (*ShippingMethod, error) {
+	var m ShippingMethod
+	var rates, regions []byte
+	if err := row.Scan(&m.ID, &m.Name, &m.Kind, &m.Rate, &rates, &m.FreeOver, &regions, &m.Active); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if len(rates) > 0 {
+		json.Unmarshal(rates, &m.WeightRates)
+	}
+	if len(regions) > 0 {
+		json.Unmarshal(regions, &m.Regions)
+	}
+	return &m, nil
+}
+
+func (s *OrderStore) CreateShippingMethod(m ShippingMethod) (*ShippingMethod, error) {
+	var rates, regions []byte
+	if len(m.WeightRates) > 0 {
+		rates, _ = json.Marshal(m.WeightRates)
+	}
+	if len(m.Regions) > 0 {
+		regions, _ = json.Marshal(m.Regions)
+	}
+	m.Active = true
+	err := s.db.QueryRow("INSERT INTO shipping_methods (name, kind, rate, weight_rates, free_over, regions) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id",
+		m.Name, m.Kind, m.Rate, rates, m.FreeOver, regions).Scan(&m.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ShippingMethods lists all methods, active or not
+func (s *OrderStore) ShippingMethods() ([]ShippingMethod, error) {
+	rows, err := s.db.Query("SELECT " + shippingColumns + " FROM shipping_methods ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]ShippingMethod, 0)
+	for rows.Next() {
+		m, err := scanShippingMethod(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *m)
+	}
+	return res, rows.Err()
+}
+
+func (s *OrderStore) GetShippingMethod(id int) (*ShippingMethod, error) {
+	return scanShippingMethod(s.db.QueryRow("SELECT "+shippingColumns+" FROM shipping_methods WHERE id=$1", id))
+}
+
+// DeactivateShippingMethod withdraws a method from new orders
+func (s *OrderStore) DeactivateShippingMethod(id int) (*ShippingMethod, error) {
+	if _, err := s.db.Exec("UPDATE shipping_methods SET active=FALSE WHERE id=$1", id); err != nil {
+		return nil, err
+	}
+	return s.GetShippingMethod(id)
+}
+
+// insertChargesTx stores an order's charge lines
+func insertChargesTx(tx *sql.Tx, orderID int, charges []Charge) error {
+	for _, c := range charges {
+		var tax []byte
+		if c.Tax != nil {
+			tax, _ = json.Marshal(c.Tax)
+		}
+		_, err := tx.Exec("INSERT INTO order_charges (order_id, kind, name, method_id, amount, tax) VALUES ($1,$2,$3,$4,$5,$6)",
+			orderID, c.Kind, c.Name, c.MethodID, c.Amount, tax)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *OrderStore) loadCharges(orderID int) ([]Charge, error) {
+	rows, err := s.db.Query("SELECT kind, name, method_id, amount, tax FROM order_charges WHERE order_id=$1 ORDER BY id", orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Charge
+	for rows.Next() {
+		var c Charge
+		var tax []byte
+		if err := rows.Scan(&c.Kind, &c.Name, &c.MethodID, &c.Amount, &tax); err != nil {
+			return nil, err
+		}
+		if len(tax) > 0 {
+			json.Unmarshal(tax, &c.Tax)
+		}
+		res = append(res, c)
+	}
+	return res, rows.Err()
+}
+
+// shippingHandler serves /shipping/methods[/{id}], managed by staff and listed
+// for everyone, and /shipping/quote, which prices every method for a basket:
+// {"items": [{"id": 1, "quantity": 2}], "address_id": 3} or with "region" or
+// "shipping_address" instead of a saved address
+func shippingHandler(store *OrderStore, inv *inventory.Client, adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		who, ok := identify(store, adminToken, r)
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/shipping"), "/")
+
+		if path == "quote" {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			var req orderRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Items) == 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+				return
+			}
+			if !who.staff {
+				req.CustomerID = who.customerID
+			}
+			region := normalizeRegion(req.Region)
+			if region == "" && (req.AddressID != 0 || req.ShippingAddress != nil) {
+				a, err := shippingAddress(store, req.CustomerID, req.AddressID, req.ShippingAddress)
+				if err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+					return
+				}
+				region = addressRegion(*a)
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+			defer cancel()
+			items, err := inv.GetItems(ctx, lineIDs(req.Items))
+			if err != nil {
+				writeInventoryError(w, err)
+				return
+			}
+			var weight float64
+			lines := make([]OrderItem, 0, len(req.Items))
+			for _, l := range mergeLines(req.Items) {
+				it := items[l.ID]
+				if it == nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "item not found in inventory"})
+					return
+				}
+				weight += float64(l.Quantity) * billableWeight(it)
+				lines = append(lines, OrderItem{ItemID: l.ID, Quantity: l.Quantity, Price: it.Price})
+			}
+			// free shipping thresholds apply to the goods after discounts
+			rules, err := store.PricingRules()
+			if err == nil {
+				_, err = applyPricing(lines, rules, req.PromoCode, nowUnix())
+			}
+			if err != nil {
+				code := http.StatusInternalServerError
+				if errors.Is(err, ErrInvalidPromoCode) {
+					code = http.StatusBadRequest
+				}
+				writeJSON(w, code, map[string]string{"error": err.Error()})
+				return
+			}
+			methods, err := store.ShippingMethods()
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			quotes := make([]ShippingQuote, 0, len(methods))
+			for _, m := range methods {
+				if !m.Active {
+					continue
+				}
+				if price, ok := m.quote(weight, orderTotal(lines), region); ok {
+					quotes = append(quotes, ShippingQuote{MethodID: m.ID, Name: m.Name, Price: price, WeightKg: roundCents(weight)})
+				}
+			}
+			writeJSON(w, http.StatusOK, quotes)
+			return
+		}
+
+		if path == "methods" {
+			switch r.Method {
+			case http.MethodGet:
+				list, err := store.ShippingMethods()
+				if err != nil {
+					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+					return
+				}
+				if !who.staff {
+					active := make([]ShippingMethod, 0, len(list))
+					for _, m := range list {
+						if m.Active {
+							active = append(active, m)
+						}
+					}
+					list = active
+				}
+				writeJSON(w, http.StatusOK, list)
+			case http.MethodPost:
+				if !ok || !who.staff {
+					writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+					return
+				}
+				var req ShippingMethod
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+					return
+				}
+				if err := validateShippingMethod(req); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+					return
+				}
+				m, err := store.CreateShippingMethod(req)
+				if err != nil {
+					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+					return
+				}
+				writeJSON(w, http.StatusCreated, m)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
+		if !strings.HasPrefix(path, "methods/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(path, "methods/"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid id"})
+			return
+		}
+		var m *ShippingMethod
+		switch r.Method {
+		case http.MethodGet:
+			m, err = store.GetShippingMethod(id)
+		case http.MethodDelete:
+			if !ok || !who.staff {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+				return
+			}
+			m, err = store.DeactivateShippingMethod(id)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, ErrNotFound) {
+				code = http.StatusNotFound
+			}
+			writeJSON(w, code, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, m)
+	}
+}
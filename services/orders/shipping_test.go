@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"inventoryshop/pkg/inventory"
+)
+
+func TestShippingMethod_Quote(t *testing.T) {
+	flat := ShippingMethod{Name: "courier", Kind: ShipFlat, Rate: 300, FreeOver: 5000, Regions: []string{"RU-MOW", "ru-spe"}}
+	if price, ok := flat.quote(1, 1000, "RU-MOW"); !ok || price != 300 {
+		t.Fatalf("expected flat 300, got %v %v", price, ok)
+	}
+	if price, ok := flat.quote(1, 5000, "RU-SPE"); !ok || price != 0 {
+		t.Fatalf("expected free shipping over the threshold, got %v %v", price, ok)
+	}
+	if _, ok := flat.quote(1, 1000, "RU-KDA"); ok {
+		t.Fatal("expected courier not to deliver outside its regions")
+	}
+
+	post := ShippingMethod{Name: "post", Kind: ShipWeight, Regions: []string{"RU"},
+		WeightRates: []WeightRate{{UpToKg: 10, Price: 700}, {UpToKg: 1, Price: 250}}}
+	if price, ok := post.quote(0.5, 100, "RU-KDA"); !ok || price != 250 {
+		t.Fatalf("expected the lightest band to cover 0.5kg, got %v %v", price, ok)
+	}
+	if price, ok := post.quote(3, 100, "RU"); !ok || price != 700 {
+		t.Fatalf("expected 700 for 3kg, got %v %v", price, ok)
+	}
+	if _, ok := post.quote(12, 100, "RU"); ok {
+		t.Fatal("expected 12kg to be over the heaviest band")
+	}
+	if err := validateShippingMethod(ShippingMethod{Name: "x", Kind: ShipWeight}); err != ErrInvalidShippingMethod {
+		t.Fatalf("expected weight method without bands to be invalid, got %v", err)
+	}
+}
+
+func TestBillableWeight(t *testing.T) {
+	// a 40x30x20cm box is 4.8kg volumetric
+	if got := billableWeight(&inventory.Item{WeightKg: 1, LengthCm: 40, WidthCm: 30, HeightCm: 20}); !almostEqualFloat(got, 4.8) {
+		t.Fatalf("expected volumetric weight 4.8, got %v", got)
+	}
+	if got := billableWeight(&inventory.Item{WeightKg: 6, LengthCm: 40, WidthCm: 30, HeightCm: 20}); got != 6 {
+		t.Fatalf("expected actual weight 6, got %v", got)
+	}
+}
+
+func TestTaxConfig_TaxCharges(t *testing.T) {
+	c := &TaxConfig{Rules: []TaxRule{{Region: "RU", Rate: 20}}}
+	charges := []Charge{{Kind: ChargeShipping, Name: "post", Amount: 100}}
+	lines := []OrderItem{{ItemID: 1, Quantity: 1, Price: 50}}
+	tax := c.taxCharges(charges, "RU")
+	if !almostEqualFloat(tax, 20) || charges[0].Tax == nil {
+		t.Fatalf("expected shipping taxed at the standard rate, got %v %+v", tax, charges[0])
+	}
+	if got := c.grossTotal(lines, charges, tax); !almostEqualFloat(got, 170) {
+		t.Fatalf("expected 50 + 100 + 20 tax, got %v", got)
+	}
+}
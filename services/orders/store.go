@@ -2,30 +2,81 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	_ "github.com/lib/pq"
+	"log"
 	"time"
+
+	"inventoryshop/pkg/events"
+	"inventoryshop/pkg/inventory"
 )
 
 // OrderItem represents item in an order
 type OrderItem struct {
-	ItemID   int     `json:"item_id"`
-	Name     string  `json:"name"`
-	Quantity int     `json:"quantity"`
-	Price    float64 `json:"price"`
+	ItemID   int             `json:"item_id"`
+	Name     string          `json:"name"`
+	Quantity int             `json:"quantity"`
+	Price    float64         `json:"price"`
+	Cost     float64         `json:"cost"`
+	Lots     []LotAllocation `json:"lots,omitempty"`
+	Serials  []string        `json:"serials,omitempty"`
+	// Components is what a bundle line took from each of the bundle's components
+	Components []ComponentAllocation `json:"components,omitempty"`
+	// Status tells reserved lines from ones waiting on an inventory backorder
+	Status      string `json:"status"`
+	BackorderID int    `json:"backorder_id,omitempty"`
+	ExpectedAt  string `json:"expected_at,omitempty"`
+	// Adjustments are the pricing rules applied to the line, discounts negative
+	Adjustments []Adjustment `json:"adjustments,omitempty"`
+	Tax         *LineTax     `json:"tax,omitempty"`
+	// RestockOwed marks lines of a cancelled order not yet given back to inventory
+	RestockOwed bool `json:"restock_owed,omitempty"`
 }
 
+// ComponentAllocation records what a bundle line took from one component item
+type ComponentAllocation = inventory.ComponentAllocation
+
+// LotAllocation records how many units of an order line came from an inventory lot
+type LotAllocation = inventory.LotAllocation
+
+// Order statuses
+const (
+	OrderStatusCreated   = "created"
+	OrderStatusCancelled = "cancelled"
+	// OrderStatusPendingPayment orders wait for the payment provider to
+	// authorize payment before they become created
+	OrderStatusPendingPayment = "pending_payment"
+)
+
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrInvalidTransition = errors.New("invalid order status transition")
+)
+
 // Order represents a customer's order
 type Order struct {
-	ID      int         `json:"id"`
-	Items   []OrderItem `json:"items"`
-	Total   float64     `json:"total"`
-	Created int64       `json:"created_unix"`
+	ID         int         `json:"id"`
+	CustomerID int         `json:"customer_id,omitempty"`
+	Items      []OrderItem `json:"items"`
+	PromoCode  string      `json:"promo_code,omitempty"`
+	TaxRegion  string      `json:"tax_region,omitempty"`
+	// ShippingAddress is where the order is delivered, and Charges what delivery costs
+	ShippingAddress *Address `json:"shipping_address,omitempty"`
+	Charges         []Charge `json:"charges,omitempty"`
+	Refunds         []Refund `json:"refunds,omitempty"`
+	Payment         *Payment `json:"payment,omitempty"`
+	Tax             float64  `json:"tax"`
+	Total           float64  `json:"total"`
+	Status          string   `json:"status"`
+	Created         int64    `json:"created_unix"`
 }
 
 // OrderStore is a Postgres-backed store for orders
 type OrderStore struct {
 	db *sql.DB
+	*events.SQLOutbox
+	*events.SQLWebhooks
 }
 
 func NewOrderStore(db *sql.DB) *OrderStore {
@@ -44,88 +95,540 @@ func NewOrderStore(db *sql.DB) *OrderStore {
 		quantity INT NOT NULL,
 		price NUMERIC NOT NULL
 	);
+	CREATE TABLE IF NOT EXISTS order_item_lots (
+		id SERIAL PRIMARY KEY,
+		order_item_id INT REFERENCES order_items(id) ON DELETE CASCADE,
+		lot_id INT NOT NULL,
+		lot_number TEXT NOT NULL,
+		expires_at TEXT NOT NULL DEFAULT '',
+		quantity INT NOT NULL
+	);
+	ALTER TABLE order_items ADD COLUMN IF NOT EXISTS cost NUMERIC NOT NULL DEFAULT 0;
+	ALTER TABLE orders ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'created';
+	CREATE TABLE IF NOT EXISTS order_item_serials (
+		id SERIAL PRIMARY KEY,
+		order_item_id INT REFERENCES order_items(id) ON DELETE CASCADE,
+		serial TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS order_item_serials_serial ON order_item_serials (serial);
+	ALTER TABLE order_items ADD COLUMN IF NOT EXISTS components JSONB;
+	ALTER TABLE order_items ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'reserved';
+	ALTER TABLE order_items ADD COLUMN IF NOT EXISTS backorder_id INT NOT NULL DEFAULT 0;
+	ALTER TABLE order_items ADD COLUMN IF NOT EXISTS expected_at TEXT NOT NULL DEFAULT '';
+	CREATE TABLE IF NOT EXISTS customers (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		email TEXT NOT NULL UNIQUE,
+		phone TEXT NOT NULL DEFAULT '',
+		token_hash TEXT NOT NULL UNIQUE,
+		created_unix BIGINT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS customer_addresses (
+		id SERIAL PRIMARY KEY,
+		customer_id INT NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+		label TEXT NOT NULL DEFAULT '',
+		line1 TEXT NOT NULL,
+		line2 TEXT NOT NULL DEFAULT '',
+		city TEXT NOT NULL,
+		region TEXT NOT NULL DEFAULT '',
+		postal_code TEXT NOT NULL,
+		country TEXT NOT NULL
+	);
+	ALTER TABLE orders ADD COLUMN IF NOT EXISTS customer_id INT NOT NULL DEFAULT 0;
+	CREATE INDEX IF NOT EXISTS orders_customer ON orders (customer_id);
+	CREATE TABLE IF NOT EXISTS carts (
+		id TEXT PRIMARY KEY,
+		customer_id INT NOT NULL DEFAULT 0,
+		status TEXT NOT NULL DEFAULT 'open',
+		order_id INT NOT NULL DEFAULT 0,
+		created_unix BIGINT NOT NULL,
+		updated_unix BIGINT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS carts_customer ON carts (customer_id, status);
+	CREATE TABLE IF NOT EXISTS cart_lines (
+		id SERIAL PRIMARY KEY,
+		cart_id TEXT NOT NULL REFERENCES carts(id) ON DELETE CASCADE,
+		item_id INT NOT NULL,
+		name TEXT NOT NULL,
+		quantity INT NOT NULL,
+		price NUMERIC NOT NULL,
+		UNIQUE (cart_id, item_id)
+	);
+	CREATE TABLE IF NOT EXISTS pricing_rules (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		item_id INT NOT NULL DEFAULT 0,
+		percent NUMERIC NOT NULL DEFAULT 0,
+		amount NUMERIC NOT NULL DEFAULT 0,
+		buy_quantity INT NOT NULL DEFAULT 0,
+		get_quantity INT NOT NULL DEFAULT 0,
+		tiers JSONB,
+		code TEXT NOT NULL DEFAULT '',
+		usage_limit INT NOT NULL DEFAULT 0,
+		used INT NOT NULL DEFAULT 0,
+		starts_unix BIGINT NOT NULL DEFAULT 0,
+		expires_unix BIGINT NOT NULL DEFAULT 0,
+		active BOOLEAN NOT NULL DEFAULT TRUE
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS pricing_rules_code ON pricing_rules (lower(code)) WHERE code <> '' AND active;
+	ALTER TABLE order_items ADD COLUMN IF NOT EXISTS adjustments JSONB;
+	ALTER TABLE orders ADD COLUMN IF NOT EXISTS promo_code TEXT NOT NULL DEFAULT '';
+	ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax JSONB;
+	ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_region TEXT NOT NULL DEFAULT '';
+	ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax NUMERIC NOT NULL DEFAULT 0;
+	CREATE TABLE IF NOT EXISTS shipping_methods (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		rate NUMERIC NOT NULL DEFAULT 0,
+		weight_rates JSONB,
+		free_over NUMERIC NOT NULL DEFAULT 0,
+		regions JSONB,
+		active BOOLEAN NOT NULL DEFAULT TRUE
+	);
+	CREATE TABLE IF NOT EXISTS order_charges (
+		id SERIAL PRIMARY KEY,
+		order_id INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+		kind TEXT NOT NULL,
+		name TEXT NOT NULL,
+		method_id INT NOT NULL DEFAULT 0,
+		amount NUMERIC NOT NULL,
+		tax JSONB
+	);
+	ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_address JSONB;
+	CREATE TABLE IF NOT EXISTS returns (
+		id SERIAL PRIMARY KEY,
+		order_id INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+		status TEXT NOT NULL,
+		lines JSONB NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		refund NUMERIC NOT NULL DEFAULT 0,
+		created_unix BIGINT NOT NULL,
+		updated_unix BIGINT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS refunds (
+		id SERIAL PRIMARY KEY,
+		order_id INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+		return_id INT NOT NULL DEFAULT 0,
+		amount NUMERIC NOT NULL,
+		created_unix BIGINT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS payments (
+		order_id INT PRIMARY KEY REFERENCES orders(id) ON DELETE CASCADE,
+		provider TEXT NOT NULL,
+		reference TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		amount NUMERIC NOT NULL,
+		captured NUMERIC NOT NULL DEFAULT 0,
+		refunded NUMERIC NOT NULL DEFAULT 0,
+		message TEXT NOT NULL DEFAULT '',
+		updated_unix BIGINT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS payments_reference ON payments (reference);
+	CREATE TABLE IF NOT EXISTS invoices (
+		order_id INT PRIMARY KEY REFERENCES orders(id),
+		number INT NOT NULL UNIQUE,
+		issued_unix BIGINT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS invoice_counter (
+		id INT PRIMARY KEY,
+		last INT NOT NULL
+	);
+	INSERT INTO invoice_counter (id, last) VALUES (1, 0) ON CONFLICT (id) DO NOTHING;
+	ALTER TABLE order_items ADD COLUMN IF NOT EXISTS restock_owed BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE returns ADD COLUMN IF NOT EXISTS refund_owed BOOLEAN NOT NULL DEFAULT FALSE;
 	`)
 	if err != nil {
 		panic(err)
 	}
-	return &OrderStore{db: db}
+	if _, err := db.Exec(events.Schema); err != nil {
+		panic(err)
+	}
+	return &OrderStore{db: db, SQLOutbox: events.NewSQLOutbox(db, eventSource), SQLWebhooks: events.NewSQLWebhooks(db)}
+}
+
+const orderColumns = "id, customer_id, promo_code, tax_region, shipping_address, tax, total, status, created_unix"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOrder(row rowScanner) (*Order, error) {
+	var o Order
+	var addr []byte
+	if err := row.Scan(&o.ID, &o.CustomerID, &o.PromoCode, &o.TaxRegion, &addr, &o.Tax, &o.Total, &o.Status, &o.Created); err != nil {
+		return nil, err
+	}
+	if len(addr) > 0 {
+		json.Unmarshal(addr, &o.ShippingAddress)
+	}
+	return &o, nil
 }
 
 func (s *OrderStore) Create(items []OrderItem, total float64) *Order {
-	var orderID int
+	return s.CreateOrder(Order{Items: items, Total: total})
+}
+
+// CreateOrder inserts an order with its lines for o.CustomerID, if any; ID and
+// creation time are set by the store, and the status unless given
+func (s *OrderStore) CreateOrder(o Order) *Order {
+	if o.Status == "" {
+		o.Status = OrderStatusCreated
+	}
+	o.Created = nowUnix()
 	// transactional insert
 	tx, err := s.db.Begin()
 	if err != nil {
 		panic(err)
 	}
 	defer tx.Rollback()
-	if err := tx.QueryRow("INSERT INTO orders (total, created_unix) VALUES ($1, $2) RETURNING id", total, nowUnix()).Scan(&orderID); err != nil {
+	var addr []byte
+	if o.ShippingAddress != nil {
+		addr, _ = json.Marshal(o.ShippingAddress)
+	}
+	if err := tx.QueryRow("INSERT INTO orders (customer_id, promo_code, tax_region, shipping_address, tax, total, status, created_unix) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+		o.CustomerID, o.PromoCode, o.TaxRegion, addr, o.Tax, o.Total, o.Status, o.Created).Scan(&o.ID); err != nil {
 		panic(err)
 	}
-	for _, it := range items {
-		_, err := tx.Exec("INSERT INTO order_items (order_id, item_id, name, quantity, price) VALUES ($1,$2,$3,$4,$5)", orderID, it.ItemID, it.Name, it.Quantity, it.Price)
+	for _, it := range o.Items {
+		var lineID int
+		var components, adjustments, tax []byte
+		if len(it.Components) > 0 {
+			components, _ = json.Marshal(it.Components)
+		}
+		if len(it.Adjustments) > 0 {
+			adjustments, _ = json.Marshal(it.Adjustments)
+		}
+		if it.Tax != nil {
+			tax, _ = json.Marshal(it.Tax)
+		}
+		if it.Status == "" {
+			it.Status = LineReserved
+		}
+		err := tx.QueryRow(`INSERT INTO order_items (order_id, item_id, name, quantity, price, cost, components, status, backorder_id, expected_at, adjustments, tax)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12) RETURNING id`,
+			o.ID, it.ItemID, it.Name, it.Quantity, it.Price, it.Cost, components, it.Status, it.BackorderID, it.ExpectedAt, adjustments, tax).Scan(&lineID)
 		if err != nil {
 			panic(err)
 		}
+		if err := insertAllocationTx(tx, lineID, it); err != nil {
+			panic(err)
+		}
+	}
+	if err := insertChargesTx(tx, o.ID, o.Charges); err != nil {
+		panic(err)
+	}
+	if typ := statusEventType(o.Status); typ != "" {
+		if err := events.RecordTx(tx, newEvent(typ, o.ID, orderEvent(&o))); err != nil {
+			panic(err)
+		}
 	}
 	if err := tx.Commit(); err != nil {
 		panic(err)
 	}
-	return &Order{ID: orderID, Items: items, Total: total, Created: nowUnix()}
+	return &o
+}
+
+// insertAllocationTx stores the lots and serials an order line took
+func insertAllocationTx(tx *sql.Tx, lineID int, it OrderItem) error {
+	for _, l := range it.Lots {
+		_, err := tx.Exec("INSERT INTO order_item_lots (order_item_id, lot_id, lot_number, expires_at, quantity) VALUES ($1,$2,$3,$4,$5)", lineID, l.LotID, l.LotNumber, l.ExpiresAt, l.Quantity)
+		if err != nil {
+			return err
+		}
+	}
+	for _, sn := range it.Serials {
+		if _, err := tx.Exec("INSERT INTO order_item_serials (order_item_id, serial) VALUES ($1,$2)", lineID, sn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateLine stores the new state of an order line waiting on a backorder,
+// including what it took from stock once the backorder is fulfilled
+func (s *OrderStore) UpdateLine(orderID int, it OrderItem) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var lineID int
+	err = tx.QueryRow(`UPDATE order_items SET status=$1, expected_at=$2, cost=$3
+	WHERE order_id=$4 AND backorder_id=$5 AND status<>$6 RETURNING id`,
+		it.Status, it.ExpectedAt, it.Cost, orderID, it.BackorderID, LineReserved).Scan(&lineID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if it.Status == LineReserved {
+		if err := insertAllocationTx(tx, lineID, it); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// PendingOrders returns the ids of orders, not cancelled, with lines waiting on backorders
+func (s *OrderStore) PendingOrders() ([]int, error) {
+	rows, err := s.db.Query(`
+	SELECT DISTINCT o.id FROM orders o JOIN order_items oi ON oi.order_id = o.id
+	WHERE o.status <> 'cancelled' AND oi.backorder_id <> 0 AND oi.status <> 'reserved'
+	ORDER BY o.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		res = append(res, id)
+	}
+	return res, rows.Err()
 }
 
 func (s *OrderStore) Get(id int) (*Order, error) {
-	var o Order
-	row := s.db.QueryRow("SELECT id, total, created_unix FROM orders WHERE id=$1", id)
-	if err := row.Scan(&o.ID, &o.Total, &o.Created); err != nil {
+	o, err := scanOrder(s.db.QueryRow("SELECT "+orderColumns+" FROM orders WHERE id=$1", id))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.New("not found")
+			return nil, ErrNotFound
 		}
 		return nil, err
 	}
-	rows, err := s.db.Query("SELECT item_id, name, quantity, price FROM order_items WHERE order_id=$1", id)
+	if err := s.loadDetails(o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// loadDetails reads an order's lines, charges, refunds and payment
+func (s *OrderStore) loadDetails(o *Order) error {
+	var err error
+	if o.Items, err = s.loadItems(o.ID); err != nil {
+		return err
+	}
+	if o.Charges, err = s.loadCharges(o.ID); err != nil {
+		return err
+	}
+	if o.Refunds, err = s.loadRefunds(o.ID); err != nil {
+		return err
+	}
+	o.Payment, err = s.loadPayment(o.ID)
+	return err
+}
+
+// SetStatus moves an order from one status to another. It fails with
+// ErrInvalidTransition if the order is not currently in the from status.
+func (s *OrderStore) SetStatus(id int, from, to string) (*Order, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	o := Order{ID: id, Status: to}
+	err = tx.QueryRow("UPDATE orders SET status=$1 WHERE id=$2 AND status=$3 RETURNING customer_id, total", to, id, from).
+		Scan(&o.CustomerID, &o.Total)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := s.Get(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidTransition
+	}
 	if err != nil {
 		return nil, err
 	}
+	// the lines of a cancelled order are owed back to inventory until restocked
+	if to == OrderStatusCancelled {
+		if _, err := tx.Exec("UPDATE order_items SET restock_owed = TRUE WHERE order_id=$1", id); err != nil {
+			return nil, err
+		}
+	}
+	if err := loadEventLinesTx(tx, &o); err != nil {
+		return nil, err
+	}
+	for _, e := range statusChangeEvents(&o, from) {
+		if err := events.RecordTx(tx, e); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.Get(id)
+}
+
+// SetRestocked records that a line of a cancelled order, by its position, went
+// back to inventory
+func (s *OrderStore) SetRestocked(orderID, line int) error {
+	res, err := s.db.Exec(`UPDATE order_items SET restock_owed = FALSE
+	WHERE id = (SELECT id FROM order_items WHERE order_id=$1 ORDER BY id OFFSET $2 LIMIT 1)`, orderID, line)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// loadEventLinesTx reads the items and quantities of an order for its events
+func loadEventLinesTx(tx *sql.Tx, o *Order) error {
+	rows, err := tx.Query("SELECT item_id, quantity FROM order_items WHERE order_id=$1 ORDER BY id", o.ID)
+	if err != nil {
+		return err
+	}
 	defer rows.Close()
+	for rows.Next() {
+		var it OrderItem
+		if err := rows.Scan(&it.ItemID, &it.Quantity); err != nil {
+			return err
+		}
+		o.Items = append(o.Items, it)
+	}
+	return rows.Err()
+}
+
+// OrdersBySerial returns the orders a serial number was allocated to, newest first
+func (s *OrderStore) OrdersBySerial(serial string) ([]*Order, error) {
+	rows, err := s.db.Query(`
+	SELECT DISTINCT oi.order_id
+	FROM order_item_serials ois JOIN order_items oi ON oi.id = ois.order_item_id
+	WHERE ois.serial = $1
+	ORDER BY oi.order_id DESC
+	`, serial)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	res := make([]*Order, 0, len(ids))
+	for _, id := range ids {
+		o, err := s.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, o)
+	}
+	return res, nil
+}
+
+// loadItems reads the lines of an order together with their lot, serial and
+// bundle component allocations
+func (s *OrderStore) loadItems(orderID int) ([]OrderItem, error) {
+	rows, err := s.db.Query("SELECT id, item_id, name, quantity, price, cost, components, status, backorder_id, expected_at, adjustments, tax, restock_owed FROM order_items WHERE order_id=$1 ORDER BY id", orderID)
+	if err != nil {
+		return nil, err
+	}
 	items := make([]OrderItem, 0)
+	lineIDs := make([]int, 0)
 	for rows.Next() {
+		var lineID int
 		var it OrderItem
-		if err := rows.Scan(&it.ItemID, &it.Name, &it.Quantity, &it.Price); err != nil {
+		var components, adjustments, tax []byte
+		if err := rows.Scan(&lineID, &it.ItemID, &it.Name, &it.Quantity, &it.Price, &it.Cost, &components, &it.Status, &it.BackorderID, &it.ExpectedAt, &adjustments, &tax, &it.RestockOwed); err != nil {
 			continue
 		}
+		if len(components) > 0 {
+			json.Unmarshal(components, &it.Components)
+		}
+		if len(adjustments) > 0 {
+			json.Unmarshal(adjustments, &it.Adjustments)
+		}
+		if len(tax) > 0 {
+			json.Unmarshal(tax, &it.Tax)
+		}
 		items = append(items, it)
+		lineIDs = append(lineIDs, lineID)
 	}
-	o.Items = items
-	return &o, nil
+	rows.Close()
+	for i, lineID := range lineIDs {
+		serialRows, err := s.db.Query("SELECT serial FROM order_item_serials WHERE order_item_id=$1 ORDER BY id", lineID)
+		if err != nil {
+			return nil, err
+		}
+		for serialRows.Next() {
+			var sn string
+			if err := serialRows.Scan(&sn); err == nil {
+				items[i].Serials = append(items[i].Serials, sn)
+			}
+		}
+		serialRows.Close()
+		lotRows, err := s.db.Query("SELECT lot_id, lot_number, expires_at, quantity FROM order_item_lots WHERE order_item_id=$1 ORDER BY id", lineID)
+		if err != nil {
+			return nil, err
+		}
+		for lotRows.Next() {
+			var l LotAllocation
+			if err := lotRows.Scan(&l.LotID, &l.LotNumber, &l.ExpiresAt, &l.Quantity); err == nil {
+				items[i].Lots = append(items[i].Lots, l)
+			}
+		}
+		lotRows.Close()
+	}
+	return items, nil
 }
 
 func (s *OrderStore) List() []*Order {
-	rows, err := s.db.Query("SELECT id, total, created_unix FROM orders ORDER BY id DESC")
+	rows, err := s.db.Query("SELECT " + orderColumns + " FROM orders ORDER BY id DESC")
 	if err != nil {
 		return []*Order{}
 	}
 	defer rows.Close()
 	res := make([]*Order, 0)
 	for rows.Next() {
-		var o Order
-		if err := rows.Scan(&o.ID, &o.Total, &o.Created); err != nil {
+		o, err := scanOrder(rows)
+		if err != nil {
 			continue
 		}
-		// load items
-		itRows, err := s.db.Query("SELECT item_id, name, quantity, price FROM order_items WHERE order_id=$1", o.ID)
-		if err == nil {
-			for itRows.Next() {
-				var it OrderItem
-				if err := itRows.Scan(&it.ItemID, &it.Name, &it.Quantity, &it.Price); err == nil {
-					o.Items = append(o.Items, it)
-				}
-			}
-			itRows.Close()
+		res = append(res, o)
+	}
+	rows.Close()
+	// load items once the orders cursor is released
+	for _, o := range res {
+		if err := s.loadDetails(o); err != nil {
+			log.Printf("load order %d: %v", o.ID, err)
 		}
-		res = append(res, &o)
 	}
 	return res
 }
 
+// SoldSince returns the quantity sold per item in orders created at or after since,
+// ignoring cancelled orders
+func (s *OrderStore) SoldSince(since int64) (map[int]int, error) {
+	rows, err := s.db.Query(`
+	SELECT oi.item_id, SUM(oi.quantity)
+	FROM order_items oi JOIN orders o ON o.id = oi.order_id
+	WHERE o.created_unix >= $1 AND o.status <> 'cancelled'
+	GROUP BY oi.item_id
+	`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make(map[int]int)
+	for rows.Next() {
+		var id, qty int
+		if err := rows.Scan(&id, &qty); err != nil {
+			return nil, err
+		}
+		res[id] = qty
+	}
+	return res, rows.Err()
+}
+
 func nowUnix() int64 { return time.Now().Unix() }
@@ -1,30 +1,60 @@
 package main
 
 import (
-	"errors"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"inventoryshop/pkg/events"
 )
 
 // In-memory store for orders (used in tests)
 type OrderStoreInMemory struct {
-	mu     sync.Mutex
-	orders map[int]*Order
-	nextID int
+	mu        sync.Mutex
+	orders    map[int]*Order
+	nextID    int
+	customers []*Customer
+	tokens    map[string]int
+	carts     map[string]*Cart
+	rules     []PricingRule
+	methods   []ShippingMethod
+	returns   []Return
+	invoices  map[int][2]int64
+	// outbox of domain events, and the webhooks they are sent to
+	events.MemoryOutbox
+	events.MemoryWebhooks
 }
 
 func NewOrderStoreInMemory() *OrderStoreInMemory {
-	return &OrderStoreInMemory{orders: make(map[int]*Order), nextID: 1}
+	return &OrderStoreInMemory{orders: make(map[int]*Order), nextID: 1, tokens: make(map[string]int), carts: make(map[string]*Cart)}
 }
 
 func (s *OrderStoreInMemory) Create(items []OrderItem, total float64) *Order {
+	return s.CreateOrder(Order{Items: items, Total: total})
+}
+
+func (s *OrderStoreInMemory) CreateOrder(o Order) *Order {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	id := s.nextID
+	o.ID = s.nextID
 	s.nextID++
-	ord := &Order{ID: id, Items: items, Total: total, Created: time.Now().Unix()}
-	s.orders[id] = ord
-	return ord
+	if o.Status == "" {
+		o.Status = OrderStatusCreated
+	}
+	o.Created = time.Now().Unix()
+	o.Items = append([]OrderItem(nil), o.Items...)
+	o.Charges = append([]Charge(nil), o.Charges...)
+	for i := range o.Items {
+		if o.Items[i].Status == "" {
+			o.Items[i].Status = LineReserved
+		}
+	}
+	s.orders[o.ID] = &o
+	if typ := statusEventType(o.Status); typ != "" {
+		s.MemoryOutbox.Record(newEvent(typ, o.ID, orderEvent(&o)))
+	}
+	return &o
 }
 
 func (s *OrderStoreInMemory) Get(id int) (*Order, error) {
@@ -32,11 +62,98 @@ func (s *OrderStoreInMemory) Get(id int) (*Order, error) {
 	defer s.mu.Unlock()
 	o, ok := s.orders[id]
 	if !ok {
-		return nil, errors.New("not found")
+		return nil, ErrNotFound
 	}
 	return o, nil
 }
 
+func (s *OrderStoreInMemory) SetStatus(id int, from, to string) (*Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if o.Status != from {
+		return nil, ErrInvalidTransition
+	}
+	o.Status = to
+	if to == OrderStatusCancelled {
+		for i := range o.Items {
+			o.Items[i].RestockOwed = true
+		}
+	}
+	for _, e := range statusChangeEvents(o, from) {
+		s.MemoryOutbox.Record(e)
+	}
+	return o, nil
+}
+
+func (s *OrderStoreInMemory) SetRestocked(orderID, line int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[orderID]
+	if !ok || line >= len(o.Items) {
+		return ErrNotFound
+	}
+	o.Items[line].RestockOwed = false
+	return nil
+}
+
+func (s *OrderStoreInMemory) UpdateLine(orderID int, it OrderItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[orderID]
+	if !ok {
+		return ErrNotFound
+	}
+	for i := range o.Items {
+		if o.Items[i].pending() && o.Items[i].BackorderID == it.BackorderID {
+			o.Items[i] = it
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *OrderStoreInMemory) PendingOrders() ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]int, 0)
+	for _, o := range s.orders {
+		if o.Status == OrderStatusCancelled {
+			continue
+		}
+		for _, it := range o.Items {
+			if it.pending() {
+				res = append(res, o.ID)
+				break
+			}
+		}
+	}
+	sort.Ints(res)
+	return res, nil
+}
+
+func (s *OrderStoreInMemory) OrdersBySerial(serial string) ([]*Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*Order, 0)
+	for _, o := range s.orders {
+	lines:
+		for _, it := range o.Items {
+			for _, sn := range it.Serials {
+				if sn == serial {
+					res = append(res, o)
+					break lines
+				}
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID > res[j].ID })
+	return res, nil
+}
+
 func (s *OrderStoreInMemory) List() []*Order {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -46,3 +163,414 @@ func (s *OrderStoreInMemory) List() []*Order {
 	}
 	return res
 }
+
+func (s *OrderStoreInMemory) SoldSince(since int64) (map[int]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[int]int)
+	for _, o := range s.orders {
+		if o.Created < since || o.Status == OrderStatusCancelled {
+			continue
+		}
+		for _, it := range o.Items {
+			res[it.ItemID] += it.Quantity
+		}
+	}
+	return res, nil
+}
+
+func (s *OrderStoreInMemory) CreateCustomer(c Customer, tokenHash string) (*Customer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.customers {
+		if other.Email == c.Email {
+			return nil, ErrCustomerExists
+		}
+	}
+	c.ID = len(s.customers) + 1
+	c.Created = time.Now().Unix()
+	c.Addresses = append(make([]Address, 0), c.Addresses...)
+	for i := range c.Addresses {
+		c.Addresses[i].ID = s.nextAddressID()
+	}
+	s.customers = append(s.customers, &c)
+	s.tokens[tokenHash] = c.ID
+	return &c, nil
+}
+
+// nextAddressID numbers addresses across customers; caller must hold the lock
+func (s *OrderStoreInMemory) nextAddressID() int {
+	n := 1
+	for _, c := range s.customers {
+		n += len(c.Addresses)
+	}
+	return n
+}
+
+func (s *OrderStoreInMemory) AddAddress(customerID int, a Address) (*Customer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if customerID <= 0 || customerID > len(s.customers) {
+		return nil, ErrNotFound
+	}
+	a.ID = s.nextAddressID()
+	c := s.customers[customerID-1]
+	c.Addresses = append(c.Addresses, a)
+	return c, nil
+}
+
+func (s *OrderStoreInMemory) GetCustomer(id int) (*Customer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id <= 0 || id > len(s.customers) {
+		return nil, ErrNotFound
+	}
+	return s.customers[id-1], nil
+}
+
+func (s *OrderStoreInMemory) CustomerByToken(tokenHash string) (*Customer, error) {
+	s.mu.Lock()
+	id, ok := s.tokens[tokenHash]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s.GetCustomer(id)
+}
+
+func (s *OrderStoreInMemory) ListCustomers() ([]*Customer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append(make([]*Customer, 0, len(s.customers)), s.customers...), nil
+}
+
+func (s *OrderStoreInMemory) OrdersByCustomer(customerID int) ([]*Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*Order, 0)
+	for _, o := range s.orders {
+		if o.CustomerID == customerID {
+			res = append(res, o)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID > res[j].ID })
+	return res, nil
+}
+
+func (s *OrderStoreInMemory) CreateCart(c Cart) (*Cart, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.Status = CartOpen
+	c.Created = time.Now().Unix()
+	c.Updated = c.Created
+	c.Lines = make([]CartLine, 0)
+	s.carts[c.ID] = &c
+	return copyCart(&c), nil
+}
+
+// copyCart lets callers price a cart without touching the stored one
+func copyCart(c *Cart) *Cart {
+	cp := *c
+	cp.Lines = append(make([]CartLine, 0, len(c.Lines)), c.Lines...)
+	return &cp
+}
+
+func (s *OrderStoreInMemory) GetCart(id string) (*Cart, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.carts[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyCart(c), nil
+}
+
+func (s *OrderStoreInMemory) CartsByCustomer(customerID int) ([]*Cart, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*Cart, 0)
+	for _, c := range s.carts {
+		if c.CustomerID == customerID && c.Status == CartOpen {
+			res = append(res, copyCart(c))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Updated > res[j].Updated })
+	return res, nil
+}
+
+func (s *OrderStoreInMemory) SetCartLine(cartID string, l CartLine) (*Cart, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.carts[cartID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if c.Status != CartOpen {
+		return nil, ErrCartClosed
+	}
+	lines := make([]CartLine, 0, len(c.Lines)+1)
+	found := false
+	for _, old := range c.Lines {
+		if old.ItemID != l.ItemID {
+			lines = append(lines, old)
+			continue
+		}
+		found = true
+		if l.Quantity > 0 {
+			lines = append(lines, l)
+		}
+	}
+	if !found && l.Quantity > 0 {
+		lines = append(lines, l)
+	}
+	c.Lines = lines
+	c.Updated = time.Now().Unix()
+	return copyCart(c), nil
+}
+
+func (s *OrderStoreInMemory) SetCartPrices(cartID string, prices map[int]float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.carts[cartID]
+	if !ok {
+		return ErrNotFound
+	}
+	for i := range c.Lines {
+		if p, ok := prices[c.Lines[i].ItemID]; ok {
+			c.Lines[i].Price = p
+		}
+	}
+	return nil
+}
+
+func (s *OrderStoreInMemory) SetCartStatus(id, from, to string, orderID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.carts[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if c.Status != from {
+		return ErrCartClosed
+	}
+	c.Status, c.OrderID = to, orderID
+	c.Updated = time.Now().Unix()
+	return nil
+}
+
+func (s *OrderStoreInMemory) CreatePricingRule(r PricingRule) (*PricingRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.rules {
+		if r.Code != "" && other.Active && strings.EqualFold(other.Code, r.Code) {
+			return nil, ErrPromoCodeExists
+		}
+	}
+	r.ID = len(s.rules) + 1
+	r.Active, r.Used = true, 0
+	s.rules = append(s.rules, r)
+	return &r, nil
+}
+
+func (s *OrderStoreInMemory) PricingRules() ([]PricingRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append(make([]PricingRule, 0, len(s.rules)), s.rules...), nil
+}
+
+func (s *OrderStoreInMemory) GetPricingRule(id int) (*PricingRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id <= 0 || id > len(s.rules) {
+		return nil, ErrNotFound
+	}
+	r := s.rules[id-1]
+	return &r, nil
+}
+
+func (s *OrderStoreInMemory) DeactivatePricingRule(id int) (*PricingRule, error) {
+	s.mu.Lock()
+	if id > 0 && id <= len(s.rules) {
+		s.rules[id-1].Active = false
+	}
+	s.mu.Unlock()
+	return s.GetPricingRule(id)
+}
+
+func (s *OrderStoreInMemory) RedeemRules(ids []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		r := s.rules[id-1]
+		if r.UsageLimit > 0 && r.Used >= r.UsageLimit {
+			return ErrPromoExhausted
+		}
+	}
+	for _, id := range ids {
+		s.rules[id-1].Used++
+	}
+	return nil
+}
+
+func (s *OrderStoreInMemory) CreateShippingMethod(m ShippingMethod) (*ShippingMethod, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.ID = len(s.methods) + 1
+	m.Active = true
+	s.methods = append(s.methods, m)
+	return &m, nil
+}
+
+func (s *OrderStoreInMemory) ShippingMethods() ([]ShippingMethod, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append(make([]ShippingMethod, 0, len(s.methods)), s.methods...), nil
+}
+
+func (s *OrderStoreInMemory) GetShippingMethod(id int) (*ShippingMethod, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id <= 0 || id > len(s.methods) {
+		return nil, ErrNotFound
+	}
+	m := s.methods[id-1]
+	return &m, nil
+}
+
+func (s *OrderStoreInMemory) DeactivateShippingMethod(id int) (*ShippingMethod, error) {
+	s.mu.Lock()
+	if id > 0 && id <= len(s.methods) {
+		s.methods[id-1].Active = false
+	}
+	s.mu.Unlock()
+	return s.GetShippingMethod(id)
+}
+
+func (s *OrderStoreInMemory) CreateReturn(o *Order, r Return) (*Return, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var earlier []Return
+	for _, other := range s.returns {
+		if other.OrderID == o.ID {
+			earlier = append(earlier, other)
+		}
+	}
+	if err := validateReturn(o, r.Lines, earlier); err != nil {
+		return nil, err
+	}
+	r.ID = len(s.returns) + 1
+	r.OrderID, r.Status, r.Refund = o.ID, ReturnRequested, 0
+	r.Created = time.Now().Unix()
+	r.Updated = r.Created
+	r.Lines = append([]ReturnLine(nil), r.Lines...)
+	s.returns = append(s.returns, r)
+	return &r, nil
+}
+
+func (s *OrderStoreInMemory) GetReturn(id int) (*Return, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id <= 0 || id > len(s.returns) {
+		return nil, ErrNotFound
+	}
+	r := s.returns[id-1]
+	r.Lines = append([]ReturnLine(nil), r.Lines...)
+	return &r, nil
+}
+
+func (s *OrderStoreInMemory) Returns(orderID int, status string) ([]Return, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]Return, 0)
+	for _, r := range s.returns {
+		if (orderID == 0 || r.OrderID == orderID) && (status == "" || r.Status == status) {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+func (s *OrderStoreInMemory) DecideReturn(id int, o *Order, outcomes map[int]string, note string) (*Return, error) {
+	r, err := s.GetReturn(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.returns[id-1].Status != r.Status {
+		return nil, ErrReturnClosed
+	}
+	if err := decide(r, o, outcomes, note); err != nil {
+		return nil, err
+	}
+	s.returns[id-1] = *r
+	if ord, ok := s.orders[r.OrderID]; ok && r.Status == ReturnApproved {
+		ord.Refunds = append(ord.Refunds, Refund{ID: id, ReturnID: id, Amount: r.Refund, Created: r.Updated})
+	}
+	return r, nil
+}
+
+func (s *OrderStoreInMemory) SaveReturnProgress(r *Return) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.ID <= 0 || r.ID > len(s.returns) {
+		return ErrNotFound
+	}
+	s.returns[r.ID-1].Lines = append([]ReturnLine(nil), r.Lines...)
+	s.returns[r.ID-1].RefundOwed = r.RefundOwed
+	return nil
+}
+
+func (s *OrderStoreInMemory) ReleaseRules(ids []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		if id > 0 && id <= len(s.rules) && s.rules[id-1].Used > 0 {
+			s.rules[id-1].Used--
+		}
+	}
+	return nil
+}
+
+func (s *OrderStoreInMemory) SavePayment(orderID int, p Payment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[orderID]
+	if !ok {
+		return ErrNotFound
+	}
+	o.Payment = &p
+	return nil
+}
+
+func (s *OrderStoreInMemory) PaymentOrder(reference string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, o := range s.orders {
+		if o.Payment != nil && reference != "" && o.Payment.Reference == reference {
+			return id, nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+func (s *OrderStoreInMemory) IssueInvoice(orderID int) (int, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.invoices == nil {
+		s.invoices = make(map[int][2]int64)
+	}
+	if inv, ok := s.invoices[orderID]; ok {
+		return int(inv[0]), inv[1], nil
+	}
+	o, ok := s.orders[orderID]
+	if !ok {
+		return 0, 0, ErrNotFound
+	}
+	if o.Status != OrderStatusCreated {
+		return 0, 0, ErrNotInvoiceable
+	}
+	inv := [2]int64{int64(len(s.invoices) + 1), time.Now().Unix()}
+	s.invoices[orderID] = inv
+	return int(inv[0]), inv[1], nil
+}
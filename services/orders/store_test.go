@@ -56,3 +56,29 @@ func TestOrderStore_CreateGetList(t *testing.T) {
 		t.Fatalf("expected error getting non-existing order, got nil")
 	}
 }
+
+func TestOrderStore_StatusAndSerials(t *testing.T) {
+	s := NewOrderStoreInMemory()
+	first := s.Create([]OrderItem{{ItemID: 1, Quantity: 1, Serials: []string{"SN1"}}}, 0)
+	if first.Status != OrderStatusCreated {
+		t.Fatalf("expected status created, got %q", first.Status)
+	}
+	if _, err := s.SetStatus(first.ID, OrderStatusCreated, OrderStatusCancelled); err != nil {
+		t.Fatalf("unexpected error cancelling: %v", err)
+	}
+	if _, err := s.SetStatus(first.ID, OrderStatusCreated, OrderStatusCancelled); err != ErrInvalidTransition {
+		t.Fatalf("expected ErrInvalidTransition, got %v", err)
+	}
+	if _, err := s.SetStatus(999, OrderStatusCreated, OrderStatusCancelled); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	second := s.Create([]OrderItem{{ItemID: 1, Quantity: 2, Serials: []string{"SN2", "SN1"}}}, 0)
+	orders, _ := s.OrdersBySerial("SN1")
+	if len(orders) != 2 || orders[0].ID != second.ID || orders[1].ID != first.ID {
+		t.Fatalf("unexpected orders for serial: %+v", orders)
+	}
+	if orders, _ := s.OrdersBySerial("nope"); len(orders) != 0 {
+		t.Fatalf("expected no orders, got %d", len(orders))
+	}
+}
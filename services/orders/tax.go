@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TaxRule is the rate, in percent, for items of a category delivered to a region.
+// An empty category is the region's standard rate. Regions are country codes
+// like "RU", which also cover their subregions like "RU-MOW", or "*" for anywhere.
+type TaxRule struct {
+	Region   string  `json:"region"`
+	Category string  `json:"category,omitempty"`
+	Rate     float64 `json:"rate"`
+	Name     string  `json:"name,omitempty"`
+}
+
+// TaxConfig holds the tax rules, read from the file named by ORDERS_TAX_RULES.
+// With PricesIncludeTax the item prices already contain tax, as is usual for
+// retail prices in Russia; otherwise tax is added on top.
+type TaxConfig struct {
+	PricesIncludeTax bool      `json:"prices_include_tax"`
+	DefaultRegion    string    `json:"default_region"`
+	Rules            []TaxRule `json:"rules"`
+}
+
+// LineTax is the tax on an order line, worked out on the line after discounts
+type LineTax struct {
+	Name      string  `json:"name,omitempty"`
+	Rate      float64 `json:"rate"`
+	Amount    float64 `json:"amount"`
+	Inclusive bool    `json:"inclusive"`
+}
+
+var ErrInvalidTaxConfig = errors.New("invalid tax config")
+
+// loadTaxConfig reads tax rules from a JSON file
+func loadTaxConfig(path string) (*TaxConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c TaxConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTaxConfig, err)
+	}
+	c.DefaultRegion = normalizeRegion(c.DefaultRegion)
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		r.Region = normalizeRegion(r.Region)
+		r.Category = strings.ToLower(strings.TrimSpace(r.Category))
+		if r.Region == "" || r.Rate < 0 || r.Rate > 100 {
+			return nil, fmt.Errorf("%w: rule %d", ErrInvalidTaxConfig, i+1)
+		}
+	}
+	return &c, nil
+}
+
+func normalizeRegion(region string) string {
+	return strings.ToUpper(strings.TrimSpace(region))
+}
+
+// addressRegion is the tax region of an address, e.g. "RU" or "RU-MOW"
+func addressRegion(a Address) string {
+	if a.Region == "" {
+		return normalizeRegion(a.Country)
+	}
+	return normalizeRegion(a.Country + "-" + a.Region)
+}
+
+// rule finds the most specific rule for a region and category: the region
+// before its country before "*", and at each the category before the standard rate
+func (c *TaxConfig) rule(region, category string) (TaxRule, bool) {
+	region = normalizeRegion(region)
+	regions := []string{region}
+	if i := strings.Index(region, "-"); i > 0 {
+		regions = append(regions, region[:i])
+	}
+	regions = append(regions, "*")
+	for _, reg := range regions {
+		for _, cat := range []string{category, ""} {
+			for _, r := range c.Rules {
+				if r.Region == reg && r.Category == cat {
+					return r, true
+				}
+			}
+		}
+	}
+	return TaxRule{}, false
+}
+
+// taxOn works out the tax on an amount of a category, nil if untaxed
+func (c *TaxConfig) taxOn(base float64, region, category string) *LineTax {
+	r, ok := c.rule(region, category)
+	if !ok || r.Rate == 0 {
+		return nil
+	}
+	amount := base * r.Rate / 100
+	if c.PricesIncludeTax {
+		amount = base * r.Rate / (100 + r.Rate)
+	}
+	return &LineTax{Name: r.Name, Rate: r.Rate, Amount: roundCents(amount), Inclusive: c.PricesIncludeTax}
+}
+
+// applyTax sets the tax of each line from its item's category and returns the
+// order's tax. A nil config, or a line no rule covers, is untaxed.
+func (c *TaxConfig) applyTax(lines []OrderItem, categories map[int]string, region string) float64 {
+	if c == nil {
+		return 0
+	}
+	if region == "" {
+		region = c.DefaultRegion
+	}
+	var total float64
+	for i := range lines {
+		it := &lines[i]
+		it.Tax = c.taxOn(it.lineTotal(), region, categories[it.ItemID])
+		if it.Tax != nil {
+			total += it.Tax.Amount
+		}
+	}
+	return roundCents(total)
+}
+
+// taxCharges sets the tax of each charge, using the rules for the charge's
+// kind as category (e.g. "shipping"), and returns the charges' tax
+func (c *TaxConfig) taxCharges(charges []Charge, region string) float64 {
+	if c == nil {
+		return 0
+	}
+	if region == "" {
+		region = c.DefaultRegion
+	}
+	var total float64
+	for i := range charges {
+		ch := &charges[i]
+		ch.Tax = c.taxOn(ch.Amount, region, ch.Kind)
+		if ch.Tax != nil {
+			total += ch.Tax.Amount
+		}
+	}
+	return roundCents(total)
+}
+
+// grossTotal is what the order costs with its charges and tax: tax already
+// included in the prices is not added again
+func (c *TaxConfig) grossTotal(lines []OrderItem, charges []Charge, tax float64) float64 {
+	net := orderTotal(lines) + chargesTotal(charges)
+	if c == nil || c.PricesIncludeTax {
+		return roundCents(net)
+	}
+	return roundCents(net + tax)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTaxConfig_Rule(t *testing.T) {
+	c := &TaxConfig{Rules: []TaxRule{
+		{Region: "RU", Rate: 22},
+		{Region: "RU", Category: "food", Rate: 10},
+		{Region: "RU-MOW", Category: "books", Rate: 0},
+		{Region: "*", Rate: 5},
+	}}
+	cases := []struct {
+		region, category string
+		rate             float64
+	}{
+		{"RU", "", 22},
+		{"RU-SPE", "food", 10},
+		{"ru-mow", "books", 0},
+		{"RU-SPE", "books", 22},
+		{"KZ", "food", 5},
+	}
+	for _, tc := range cases {
+		r, ok := c.rule(tc.region, tc.category)
+		if !ok || r.Rate != tc.rate {
+			t.Fatalf("%s/%s: expected rate %v, got %+v", tc.region, tc.category, tc.rate, r)
+		}
+	}
+}
+
+func TestTaxConfig_ApplyTax(t *testing.T) {
+	rules := []TaxRule{{Region: "RU", Rate: 20, Name: "VAT"}, {Region: "RU", Category: "food", Rate: 10}}
+	lines := []OrderItem{
+		{ItemID: 1, Quantity: 1, Price: 120},
+		{ItemID: 2, Quantity: 2, Price: 60, Adjustments: []Adjustment{{Amount: -10}}},
+	}
+	categories := map[int]string{2: "food"}
+
+	inclusive := &TaxConfig{PricesIncludeTax: true, DefaultRegion: "RU", Rules: rules}
+	tax := inclusive.applyTax(lines, categories, "")
+	if !almostEqualFloat(tax, 30) || !almostEqualFloat(lines[1].Tax.Amount, 10) || !lines[0].Tax.Inclusive {
+		t.Fatalf("expected 20 + 10 tax included, got %v, %+v", tax, lines)
+	}
+	if got := inclusive.grossTotal(lines, nil, tax); !almostEqualFloat(got, 230) {
+		t.Fatalf("expected included tax not added again, got %v", got)
+	}
+
+	exclusive := &TaxConfig{DefaultRegion: "RU", Rules: rules}
+	tax = exclusive.applyTax(lines, categories, "RU-MOW")
+	if !almostEqualFloat(tax, 24+11) || !almostEqualFloat(exclusive.grossTotal(lines, nil, tax), 265) {
+		t.Fatalf("expected tax added on top, got %v", tax)
+	}
+
+	var none *TaxConfig
+	plain := []OrderItem{{ItemID: 1, Quantity: 1, Price: 120}}
+	if none.applyTax(plain, categories, "RU") != 0 || plain[0].Tax != nil || none.grossTotal(plain, nil, 0) != 120 {
+		t.Fatalf("expected no tax without a config")
+	}
+}
+
+func TestLoadTaxConfig(t *testing.T) {
+	c, err := loadTaxConfig("tax_rules.json")
+	if err != nil {
+		t.Fatalf("unexpected error loading the shipped rules: %v", err)
+	}
+	if r, _ := c.rule("RU", "food"); r.Rate != 10 {
+		t.Fatalf("expected reduced VAT for food, got %+v", r)
+	}
+	path := filepath.Join(t.TempDir(), "bad.json")
+	os.WriteFile(path, []byte(`{"rules": [{"region": "RU", "rate": 120}]}`), 0o644)
+	if _, err := loadTaxConfig(path); err == nil {
+		t.Fatalf("expected a rate over 100 to be rejected")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"inventoryshop/pkg/events"
+)
+
+func TestWebhooks_OrderEvents(t *testing.T) {
+	var mu sync.Mutex
+	var got []events.Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sig := r.Header.Get(events.WebhookSignatureHeader)
+		ts, _ := strconv.ParseInt(strings.TrimPrefix(strings.SplitN(sig, ",", 2)[0], "t="), 10, 64)
+		if sig != events.SignWebhook("erp", ts, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var e events.Event
+		json.Unmarshal(body, &e)
+		mu.Lock()
+		got = append(got, e)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s := NewOrderStoreInMemory()
+	hook, _ := s.CreateWebhook(events.Webhook{URL: srv.URL, EventTypes: []string{EventOrderCreated}, Secret: "erp"})
+	o := s.CreateOrder(Order{Total: 10, Items: []OrderItem{{ItemID: 1, Quantity: 1, Price: 10}}})
+	s.SetStatus(o.ID, OrderStatusCreated, OrderStatusCancelled)
+
+	d := events.NewDispatcher(s)
+	if err := events.NewRelay(s, d).Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n, err := d.DeliverDue(context.Background()); n != 1 || err != nil {
+		t.Fatalf("expected one delivery, got %d %v", n, err)
+	}
+	if len(got) != 1 || got[0].Type != EventOrderCreated || got[0].AggregateID != o.ID {
+		t.Fatalf("unexpected webhooks %+v", got)
+	}
+	list, _ := s.Deliveries(hook.ID, events.DeliveryDelivered)
+	if len(list) != 1 || list[0].Attempts != 1 || list[0].Delivered == 0 {
+		t.Fatalf("unexpected delivery log %+v", list)
+	}
+}